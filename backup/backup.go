@@ -34,27 +34,44 @@ func initializeFlags(cmd *cobra.Command) {
 func SetFlagDefaults(flagSet *pflag.FlagSet) {
 	flagSet.String(utils.BACKUP_DIR, "", "The absolute path of the directory to which all backup files will be written")
 	flagSet.Int(utils.COMPRESSION_LEVEL, 1, "Level of compression to use during data backup. Valid values are between 1 and 9.")
+	flagSet.String(utils.COMPRESSION_TYPE, "gzip", "Type of compression to use during data backup. Valid values are gzip, zstd, and lz4.")
 	flagSet.Bool(utils.DATA_ONLY, false, "Only back up data, do not back up metadata")
 	flagSet.String(utils.DBNAME, "", "The database to be backed up")
 	flagSet.Bool(utils.DEBUG, false, "Print verbose and debug log messages")
+	flagSet.String(utils.ENCRYPTION_KEY_CMD, "", "A command that prints the key with which to encrypt the backup, as 64 hexadecimal characters")
+	flagSet.String(utils.ENCRYPTION_KEY_FILE, "", "A file containing the key with which to encrypt the backup, as 64 hexadecimal characters")
 	flagSet.StringSlice(utils.EXCLUDE_SCHEMA, []string{}, "Back up all metadata except objects in the specified schema(s). --exclude-schema can be specified multiple times.")
 	flagSet.StringSlice(utils.EXCLUDE_RELATION, []string{}, "Back up all metadata except the specified table(s). --exclude-table can be specified multiple times.")
 	flagSet.String(utils.EXCLUDE_RELATION_FILE, "", "A file containing a list of fully-qualified tables to be excluded from the backup")
+	flagSet.StringArray(utils.EXCLUDE_RELATION_PATTERN, []string{}, "Back up all metadata except the tables whose fully-qualified names match the specified pattern. --exclude-table-pattern can be specified multiple times.")
+	flagSet.StringArray(utils.EXCLUDE_SCHEMA_PATTERN, []string{}, "Back up all metadata except objects in the schemas whose names match the specified pattern. --exclude-schema-pattern can be specified multiple times.")
 	flagSet.String(utils.FROM_TIMESTAMP, "", "A timestamp to use to base the current incremental backup off")
 	flagSet.Bool("help", false, "Help for gpbackup")
+	flagSet.String(utils.HOOK_CONFIG, "", "A configuration file listing executables to run before and after the backup, and on failure")
 	flagSet.StringSlice(utils.INCLUDE_SCHEMA, []string{}, "Back up only the specified schema(s). --include-schema can be specified multiple times.")
 	flagSet.StringArray(utils.INCLUDE_RELATION, []string{}, "Back up only the specified table(s). --include-table can be specified multiple times.")
 	flagSet.String(utils.INCLUDE_RELATION_FILE, "", "A file containing a list of fully-qualified tables to be included in the backup")
+	flagSet.StringArray(utils.INCLUDE_RELATION_PATTERN, []string{}, "Back up only the tables whose fully-qualified names match the specified pattern. --include-table-pattern can be specified multiple times.")
+	flagSet.StringArray(utils.INCLUDE_SCHEMA_PATTERN, []string{}, "Back up only the schemas whose names match the specified pattern. --include-schema-pattern can be specified multiple times.")
 	flagSet.Bool(utils.INCREMENTAL, false, "Only back up data for AO tables that have been modified since the last backup")
+	flagSet.Bool(utils.DIFFERENTIAL, false, "Only back up data for tables that have been modified since the last full backup")
 	flagSet.Int(utils.JOBS, 1, "The number of parallel connections to use when backing up data")
 	flagSet.Bool(utils.LEAF_PARTITION_DATA, false, "For partition tables, create one data file per leaf partition instead of one data file for the whole table")
+	flagSet.Int(utils.MAX_BANDWIDTH, 0, "The maximum number of bytes per second to write to the backup files on each segment")
 	flagSet.Bool(utils.METADATA_ONLY, false, "Only back up metadata, do not back up data")
+	flagSet.String(utils.METRICS_FILE, "", "Write metrics for the backup in the Prometheus text format to the specified file, for the node exporter's textfile collector")
+	flagSet.String(utils.METRICS_URL, "", "Push metrics for the backup to the Pushgateway at the specified URL")
 	flagSet.Bool(utils.NO_COMPRESSION, false, "Disable compression of data files")
+	flagSet.String(utils.PATTERN_TYPE, utils.GLOB_PATTERN, "The type of the patterns given to the pattern filter flags. Valid values are glob and regex.")
 	flagSet.String(utils.PLUGIN_CONFIG, "", "The configuration file to use for a plugin")
 	flagSet.Bool("version", false, "Print version number and exit")
 	flagSet.Bool(utils.QUIET, false, "Suppress non-warning, non-error log messages")
+	flagSet.Int(utils.RETAIN_DAYS, 0, "After a successful backup, delete backups of the same database to the same destination that are older than the specified number of days")
+	flagSet.Int(utils.RETAIN_FULL_BACKUPS, 0, "After a successful backup, delete backups of the same database to the same destination except for the specified number of most recent full backups")
+	flagSet.String(utils.RESUME, "", "Resume the failed backup with the specified timestamp, backing up data only for tables that were not completed")
 	flagSet.Bool(utils.SINGLE_DATA_FILE, false, "Back up all data to a single file instead of one per table")
 	flagSet.Bool(utils.VERBOSE, false, "Print verbose log messages")
+	flagSet.String(utils.VERIFY, "", "Verify that the backup with the specified timestamp is complete and readable instead of taking a new backup")
 	flagSet.Bool(utils.WITH_STATS, false, "Back up query plan statistics")
 }
 
@@ -78,10 +95,15 @@ func DoSetup() {
 	SetLoggerVerbosity()
 	utils.CheckGpexpandRunning(utils.BackupPreventedByGpexpandMessage)
 	timestamp := backup_history.CurrentTimestamp()
+	if resumeTimestamp := MustGetFlagString(utils.RESUME); resumeTimestamp != "" {
+		timestamp = resumeTimestamp
+	}
 	CreateBackupLockFile(timestamp)
+	ReadHeapIncrementalMetadataBeforeSnapshot()
 	InitializeConnectionPool()
 
 	gplog.Info("Starting backup of database %s", MustGetFlagString(utils.DBNAME))
+	ResolveFilterPatterns()
 	opts, err := options.NewOptions(cmdFlags)
 	gplog.FatalOnError(err)
 
@@ -101,7 +123,9 @@ func DoSetup() {
 	CreateBackupDirectoriesOnAllHosts()
 	globalTOC = &utils.TOC{}
 	globalTOC.InitializeMetadataEntryMap()
-	utils.InitializePipeThroughParameters(!MustGetFlagBool(utils.NO_COMPRESSION), MustGetFlagInt(utils.COMPRESSION_LEVEL))
+	utils.InitializePipeThroughParameters(!MustGetFlagBool(utils.NO_COMPRESSION), MustGetFlagString(utils.COMPRESSION_TYPE), MustGetFlagInt(utils.COMPRESSION_LEVEL))
+	utils.InitializeEncryptionKey(MustGetFlagString(utils.ENCRYPTION_KEY_FILE), MustGetFlagString(utils.ENCRYPTION_KEY_CMD))
+	utils.InitializeMaxBandwidth(int64(MustGetFlagInt(utils.MAX_BANDWIDTH)))
 
 	pluginConfigFlag := MustGetFlagString(utils.PLUGIN_CONFIG)
 
@@ -109,14 +133,24 @@ func DoSetup() {
 		pluginConfig, err = utils.ReadPluginConfig(pluginConfigFlag)
 		gplog.FatalOnError(err)
 	}
+	if hookConfigFlag := MustGetFlagString(utils.HOOK_CONFIG); hookConfigFlag != "" {
+		hookConfig, err = utils.ReadHookConfig(hookConfigFlag)
+		gplog.FatalOnError(err)
+	}
 
 	InitializeBackupReport(*opts)
+	if MustGetFlagString(utils.RESUME) != "" {
+		InitializeResume()
+	}
 
 	if pluginConfigFlag != "" {
 		backupReport.PluginVersion = pluginConfig.CheckPluginExistsOnAllHosts(globalCluster)
 		pluginConfig.CopyPluginConfigToAllHosts(globalCluster)
 		pluginConfig.SetupPluginForBackup(globalCluster, globalFPInfo)
 	}
+	// The post-backup or on-failure hook runs on exit once the pre-backup hook has been started
+	preHookRan = true
+	hookConfig.RunHook(globalCluster, utils.PRE_BACKUP_HOOK, globalFPInfo, MustGetFlagString(utils.DBNAME), "")
 }
 
 func DoBackup() {
@@ -124,7 +158,7 @@ func DoBackup() {
 
 	targetBackupTimestamp := ""
 	var targetBackupFPInfo backup_filepath.FilePathInfo
-	if MustGetFlagBool(utils.INCREMENTAL) {
+	if (MustGetFlagBool(utils.INCREMENTAL) || MustGetFlagBool(utils.DIFFERENTIAL)) && backupState == nil {
 		targetBackupTimestamp = GetTargetBackupTimestamp()
 		targetBackupFPInfo = backup_filepath.NewFilePathInfo(globalCluster, globalFPInfo.UserSpecifiedBackupDir,
 			targetBackupTimestamp, globalFPInfo.UserSpecifiedSegPrefix)
@@ -138,23 +172,34 @@ func DoBackup() {
 
 	gplog.Info("Gathering table state information")
 	metadataTables, dataTables := RetrieveAndProcessTables()
-	if !(MustGetFlagBool(utils.METADATA_ONLY) || MustGetFlagBool(utils.DATA_ONLY)) {
+	if !(MustGetFlagBool(utils.METADATA_ONLY) || MustGetFlagBool(utils.DATA_ONLY)) && backupState == nil {
 		BackupIncrementalMetadata()
 	}
 	CheckTablesContainData(dataTables)
 	metadataFilename := globalFPInfo.GetMetadataFilePath()
-	gplog.Info("Metadata will be written to %s", metadataFilename)
-	metadataFile := utils.NewFileWithByteCountFromFile(metadataFilename)
-
-	BackupSessionGUCs(metadataFile)
-	if !MustGetFlagBool(utils.DATA_ONLY) {
-		tableOnlyBackup := true
-		if len(MustGetFlagStringArray(utils.INCLUDE_RELATION)) == 0 {
-			tableOnlyBackup = false
-			backupGlobal(metadataFile)
+	if backupState != nil {
+		gplog.Info("Reusing metadata from %s", metadataFilename)
+	} else {
+		gplog.Info("Metadata will be written to %s", metadataFilename)
+		metadataFile := utils.NewFileWithByteCountFromFile(metadataFilename)
+
+		BackupSessionGUCs(metadataFile)
+		if !MustGetFlagBool(utils.DATA_ONLY) {
+			tableOnlyBackup := true
+			if len(MustGetFlagStringArray(utils.INCLUDE_RELATION)) == 0 {
+				tableOnlyBackup = false
+				backupReport.StartSection("global")
+				backupGlobal(metadataFile)
+				backupReport.FinishSection()
+			}
+			backupReport.StartSection("predata")
+			backupPredata(metadataFile, metadataTables, tableOnlyBackup)
+			backupReport.FinishSection()
+			backupReport.StartSection("postdata")
+			backupPostdata(metadataFile)
+			backupReport.FinishSection()
 		}
-		backupPredata(metadataFile, metadataTables, tableOnlyBackup)
-		backupPostdata(metadataFile)
+		metadataFile.Close()
 	}
 
 	/*
@@ -165,29 +210,49 @@ func DoBackup() {
 	if !backupReport.MetadataOnly {
 		backupSetTables := dataTables
 
-		targetBackupRestorePlan := make([]backup_history.RestorePlanEntry, 0)
-		if targetBackupTimestamp != "" {
-			gplog.Info("Basing incremental backup off of backup with timestamp = %s", targetBackupTimestamp)
+		if backupState != nil {
+			backupSetTables = GetTablesForResume(dataTables, backupState.Config.RestorePlan)
+			backupReport.RestorePlan = backupState.Config.RestorePlan
+		} else {
+			targetBackupRestorePlan := make([]backup_history.RestorePlanEntry, 0)
+			if targetBackupTimestamp != "" {
+				if MustGetFlagBool(utils.DIFFERENTIAL) {
+					gplog.Info("Basing differential backup off of full backup with timestamp = %s", targetBackupTimestamp)
+				} else {
+					gplog.Info("Basing incremental backup off of backup with timestamp = %s", targetBackupTimestamp)
+				}
 
-			targetBackupTOC := utils.NewTOC(targetBackupFPInfo.GetTOCFilePath())
-			targetBackupRestorePlan = backup_history.ReadConfigFile(targetBackupFPInfo.GetConfigFilePath()).RestorePlan
-			backupSetTables = FilterTablesForIncremental(targetBackupTOC, globalTOC, dataTables)
-		}
+				targetBackupTOC := utils.NewTOC(targetBackupFPInfo.GetTOCFilePath())
+				targetBackupRestorePlan = backup_history.ReadConfigFile(targetBackupFPInfo.GetConfigFilePath()).RestorePlan
+				backupSetTables = FilterTablesForIncremental(targetBackupTOC, globalTOC, dataTables)
+			}
 
-		backupReport.RestorePlan = PopulateRestorePlan(backupSetTables, targetBackupRestorePlan, dataTables)
+			backupReport.RestorePlan = PopulateRestorePlan(backupSetTables, targetBackupRestorePlan, dataTables)
+			if !MustGetFlagBool(utils.SINGLE_DATA_FILE) && !wasTerminated {
+				// All metadata has been written, so from here on a failed backup can be resumed
+				WriteBackupState(globalFPInfo.GetBackupStateFilePath(), &backupReport.BackupConfig, globalTOC)
+			}
+		}
 
+		backupReport.StartSection("data")
 		backupData(backupSetTables)
+		backupReport.FinishSection()
+		tableSizes = GetTableSizes(connectionPool, backupSetTables)
 	}
 
 	if MustGetFlagBool(utils.WITH_STATS) {
+		backupReport.StartSection("statistics")
 		backupStatistics(metadataTables)
+		backupReport.FinishSection()
 	}
 
-	globalTOC.WriteToFileAndMakeReadOnly(globalFPInfo.GetTOCFilePath())
 	for connNum := 0; connNum < connectionPool.NumConns; connNum++ {
 		connectionPool.MustCommit(connNum)
 	}
-	metadataFile.Close()
+	if !wasTerminated {
+		RecheckHeapIncrementalMetadata()
+	}
+	globalTOC.WriteToFileAndMakeReadOnly(globalFPInfo.GetTOCFilePath())
 	if MustGetFlagString(utils.PLUGIN_CONFIG) != "" {
 		pluginConfig.MustBackupFile(metadataFilename)
 		pluginConfig.MustBackupFile(globalFPInfo.GetTOCFilePath())
@@ -196,8 +261,12 @@ func DoBackup() {
 		}
 	}
 
+	RemoveBackupStateFiles()
+
 	err := backup_history.WriteBackupHistory(globalFPInfo.GetBackupHistoryFilePath(), &backupReport.BackupConfig)
 	gplog.FatalOnError(err)
+
+	PruneBackupsOutsideRetentionPolicy()
 }
 
 func backupGlobal(metadataFile *utils.FileWithByteCount) {
@@ -297,30 +366,61 @@ func backupPredata(metadataFile *utils.FileWithByteCount, tables []Table, tableO
 }
 
 func backupData(tables []Table) {
+	if utils.GetEncryptionKey() != nil {
+		if !MustGetFlagBool(utils.SINGLE_DATA_FILE) {
+			// The helper is used to encrypt data in the COPY commands
+			utils.VerifyHelperVersionOnSegments(version, globalCluster)
+		}
+		utils.CopyEncryptionKeyToSegments(globalCluster, globalFPInfo)
+	}
 	if MustGetFlagBool(utils.SINGLE_DATA_FILE) {
 		gplog.Verbose("Initializing pipes and gpbackup_helper on segments for single data file backup")
 		utils.VerifyHelperVersionOnSegments(version, globalCluster)
-		oidList := make([]string, 0, len(tables))
-		for _, table := range tables {
-			if !table.SkipDataBackup() {
-				oidList = append(oidList, fmt.Sprintf("%d", table.Oid))
-			}
-		}
-		utils.WriteOidListToSegments(oidList, globalCluster, globalFPInfo)
-		utils.CreateFirstSegmentPipeOnAllHosts(oidList[0], globalCluster, globalFPInfo)
-		compressStr := fmt.Sprintf(" --compression-level %d", MustGetFlagInt(utils.COMPRESSION_LEVEL))
+		tableShards = AssignTablesToShards(tables, MustGetFlagInt(utils.JOBS))
+		compressStr := fmt.Sprintf(" --compression-level %d --compression-type %s", MustGetFlagInt(utils.COMPRESSION_LEVEL), MustGetFlagString(utils.COMPRESSION_TYPE))
 		if MustGetFlagBool(utils.NO_COMPRESSION) {
 			compressStr = " --compression-level 0"
 		}
-		utils.StartAgent(globalCluster, globalFPInfo, "--backup-agent",
-			MustGetFlagString(utils.PLUGIN_CONFIG), compressStr)
+		shardOidLists := GetShardOidLists(tables, tableShards)
+		for shard, oidList := range shardOidLists {
+			shardFPInfo := globalFPInfo.ForShard(shard)
+			utils.WriteOidListToSegments(oidList, globalCluster, shardFPInfo)
+			utils.CreateFirstSegmentPipeOnAllHosts(oidList[0], globalCluster, shardFPInfo)
+			utils.StartAgent(globalCluster, shardFPInfo, "--backup-agent",
+				MustGetFlagString(utils.PLUGIN_CONFIG), compressStr, len(shardOidLists))
+		}
 	}
 	gplog.Info("Writing data to file")
-	rowsCopiedMaps := BackupDataForAllTables(tables)
+	tablesToBackUp := tables
+	if !MustGetFlagBool(utils.SINGLE_DATA_FILE) {
+		var err error
+		completedTablesFile, err = OpenCompletedTablesFile(globalFPInfo.GetCompletedTablesFilePath())
+		gplog.FatalOnError(err)
+		tablesToBackUp = FilterCompletedTables(tables, completedTables)
+	}
+	rowsCopiedMaps := BackupDataForAllTables(tablesToBackUp)
+	if completedTablesFile != nil {
+		err := completedTablesFile.Close()
+		gplog.FatalOnError(err)
+	}
+	// Tables completed before a backup was resumed are not backed up again, but still need TOC entries
+	rowsCopiedMaps = append(rowsCopiedMaps, completedTables)
 	AddTableDataEntriesToTOC(tables, rowsCopiedMaps)
+	if MustGetFlagBool(utils.SINGLE_DATA_FILE) && !wasTerminated {
+		for shard := range GetShardOidLists(tables, tableShards) {
+			utils.WaitForSegmentTOCs(globalCluster, globalFPInfo.ForShard(shard))
+		}
+	}
 	if MustGetFlagBool(utils.SINGLE_DATA_FILE) && MustGetFlagString(utils.PLUGIN_CONFIG) != "" {
 		pluginConfig.BackupSegmentTOCs(globalCluster, globalFPInfo)
 	}
+	if !MustGetFlagBool(utils.SINGLE_DATA_FILE) && MustGetFlagString(utils.PLUGIN_CONFIG) == "" && !wasTerminated {
+		WriteDataFileChecksumsOnAllHosts(tables)
+	}
+	if !wasTerminated {
+		// The agents have finished, so the segment TOC files hold the size of every table
+		SetDataEntrySizes(globalTOC.DataEntries, GetTableSegmentSizes(tables), len(globalCluster.ContentIDs)-1, MustGetFlagBool(utils.SINGLE_DATA_FILE))
+	}
 	if wasTerminated {
 		gplog.Info("Data backup incomplete")
 	} else {
@@ -372,7 +472,11 @@ func DoTeardown() {
 
 		errorCode := gplog.GetErrorCode()
 		if errorCode == 0 {
-			gplog.Info("Backup completed successfully")
+			if MustGetFlagString(utils.VERIFY) != "" {
+				gplog.Info("Backup verification completed successfully")
+			} else {
+				gplog.Info("Backup completed successfully")
+			}
 		}
 		os.Exit(errorCode)
 	}()
@@ -412,6 +516,7 @@ func DoTeardown() {
 			return
 		}
 		reportFilename := globalFPInfo.GetBackupReportFilePath()
+		jsonReportFilename := globalFPInfo.GetBackupJSONReportFilePath()
 		configFilename := globalFPInfo.GetConfigFilePath()
 
 		time.Sleep(time.Second) // We sleep for 1 second to ensure multiple backups do not start within the same second.
@@ -419,19 +524,44 @@ func DoTeardown() {
 		if backupReport != nil {
 			backupReport.ConstructBackupParamsString()
 			backup_history.WriteConfigFile(&backupReport.BackupConfig, configFilename)
-			backupReport.WriteBackupReportFile(reportFilename, globalFPInfo.Timestamp, objectCounts, errMsg)
-			utils.EmailReport(globalCluster, globalFPInfo.Timestamp, reportFilename, "gpbackup")
+			checksumFilename := globalFPInfo.GetChecksumFilePath()
+			err := utils.WriteChecksumFile(checksumFilename, []string{configFilename, globalFPInfo.GetMetadataFilePath(),
+				globalFPInfo.GetTOCFilePath(), globalFPInfo.GetStatisticsFilePath()})
+			if err != nil {
+				gplog.Error(fmt.Sprintf("Unable to write checksum file %s: %v", checksumFilename, err))
+			}
+			var dataEntries []utils.MasterDataEntry
+			if globalTOC != nil {
+				dataEntries = globalTOC.DataEntries
+			}
+			backupReport.WriteBackupReportFile(reportFilename, globalFPInfo.Timestamp, objectCounts, dataEntries, errMsg)
+			tables := GetTableReports(dataEntries, tableSizes)
+			jsonReport := backupReport.GetBackupJSONReport(globalFPInfo.Timestamp, objectCounts, tables, errMsg)
+			utils.WriteJSONReportFile(jsonReportFilename, jsonReport)
+			writeMetrics(jsonReport)
+			utils.SendNotifications(globalCluster, utils.Notification{Utility: "gpbackup", Timestamp: globalFPInfo.Timestamp,
+				ReportFilePath: reportFilename, JSONReportFilePath: jsonReportFilename, JSONReport: jsonReport})
 			if pluginConfig != nil {
 				err := pluginConfig.BackupFile(configFilename)
 				if err != nil {
 					gplog.Error(fmt.Sprintf("%v", err))
 					return
 				}
+				err = pluginConfig.BackupFile(checksumFilename)
+				if err != nil {
+					gplog.Error(fmt.Sprintf("%v", err))
+					return
+				}
 				err = pluginConfig.BackupFile(reportFilename)
 				if err != nil {
 					gplog.Error(fmt.Sprintf("%v", err))
 					return
 				}
+				err = pluginConfig.BackupFile(jsonReportFilename)
+				if err != nil {
+					gplog.Error(fmt.Sprintf("%v", err))
+					return
+				}
 			}
 		}
 		if pluginConfig != nil {
@@ -446,6 +576,8 @@ func DoCleanup() {
 		if err := recover(); err != nil {
 			gplog.Warn("Encountered error during cleanup: %v", err)
 		}
+		writeStatusMetrics()
+		RunPostHook()
 		gplog.Verbose("Cleanup complete")
 		CleanupGroup.Done()
 	}()
@@ -460,6 +592,9 @@ func DoCleanup() {
 			}
 			utils.CleanUpHelperFilesOnAllHosts(globalCluster, globalFPInfo)
 		}
+		if utils.GetEncryptionKey() != nil {
+			utils.RemoveEncryptionKeyFromSegments(globalCluster, globalFPInfo)
+		}
 	}
 	err := backupLockFile.Unlock()
 	if err != nil && backupLockFile != "" {
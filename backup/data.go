@@ -6,14 +6,19 @@ package backup
 
 import (
 	"fmt"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"sync"
 
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
 	"github.com/greenplum-db/gpbackup/utils"
 	"gopkg.in/cheggaaa/pb.v1"
+	"gopkg.in/yaml.v2"
 )
 
 var (
@@ -42,11 +47,64 @@ func AddTableDataEntriesToTOC(tables []Table, rowsCopiedMaps []map[uint32]int64)
 				}
 			}
 			attributes := ConstructTableAttributesList(table.ColumnDefs)
-			globalTOC.AddMasterDataEntry(table.Schema, table.Name, table.Oid, attributes, rowsCopied, table.PartitionLevelInfo.RootName)
+			globalTOC.AddMasterDataEntry(table.Schema, table.Name, table.Oid, attributes, rowsCopied, table.PartitionLevelInfo.RootName, tableShards[table.Oid])
+			globalTOC.DataEntries[len(globalTOC.DataEntries)-1].ElapsedSeconds = tableElapsedSeconds[table.Oid]
 		}
 	}
 }
 
+// Tables whose sizes were not gathered, such as those in a backup that failed, are reported without a size
+func GetTableReports(dataEntries []utils.MasterDataEntry, sizes map[uint32]int64) []utils.TableReport {
+	tables := make([]utils.TableReport, 0, len(dataEntries))
+	for _, entry := range dataEntries {
+		tables = append(tables, utils.TableReport{Schema: entry.Schema, Name: entry.Name, Oid: entry.Oid,
+			RowsCopied: entry.RowsCopied, SizeBytes: sizes[entry.Oid], ElapsedSeconds: entry.ElapsedSeconds,
+			CompressedBytes: entry.CompressedBytes, UncompressedBytes: entry.UncompressedBytes, SegmentSkew: entry.SegmentSkew})
+	}
+	return tables
+}
+
+/*
+ * A single data file backup writes one data file per job on each segment, so
+ * that each job streams its tables to its own helper.  The helpers read their
+ * tables in oid order, so the tables are dealt to the shards in turn, which
+ * keeps each shard in oid order and spreads consecutively created tables
+ * across the shards.  Tables without data are not assigned a shard.
+ */
+func AssignTablesToShards(tables []Table, numJobs int) map[uint32]int {
+	dataTables := make([]Table, 0)
+	for _, table := range tables {
+		if !table.SkipDataBackup() {
+			dataTables = append(dataTables, table)
+		}
+	}
+	numShards := numJobs
+	if len(dataTables) < numShards {
+		numShards = len(dataTables)
+	}
+	shards := make(map[uint32]int, len(dataTables))
+	for i, table := range dataTables {
+		shards[table.Oid] = i % numShards
+	}
+	return shards
+}
+
+// Returns the oids of the tables in each shard, in oid order
+func GetShardOidLists(tables []Table, shards map[uint32]int) [][]string {
+	oidLists := make([][]string, 0)
+	for _, table := range tables {
+		shard, ok := shards[table.Oid]
+		if !ok {
+			continue
+		}
+		for len(oidLists) <= shard {
+			oidLists = append(oidLists, make([]string, 0))
+		}
+		oidLists[shard] = append(oidLists[shard], fmt.Sprintf("%d", table.Oid))
+	}
+	return oidLists
+}
+
 type BackupProgressCounters struct {
 	NumRegTables   int64
 	TotalRegTables int64
@@ -67,8 +125,16 @@ func CopyTableOut(connectionPool *dbconn.DBConn, table Table, destinationToWrite
 		 */
 		checkPipeExistsCommand = fmt.Sprintf("(test -p \"%s\" || (echo \"Pipe not found %s\">&2; exit 1)) && ", destinationToWrite, destinationToWrite)
 		customPipeThroughCommand = "cat -"
-	} else if MustGetFlagString(utils.PLUGIN_CONFIG) != "" {
-		sendToDestinationCommand = fmt.Sprintf("| %s backup_data %s", pluginConfig.ExecutablePath, pluginConfig.ConfigPath)
+	} else {
+		if utils.GetEncryptionKey() != nil {
+			customPipeThroughCommand = fmt.Sprintf("%s | %s", customPipeThroughCommand, utils.GetEncryptionFilterCommand(false, globalFPInfo.GetSegmentEncryptionKeyFilePathForCopyCommand()))
+		}
+		if utils.GetMaxBandwidth() > 0 {
+			customPipeThroughCommand = fmt.Sprintf("%s | %s", customPipeThroughCommand, utils.GetThrottleFilterCommand(connectionPool.NumConns))
+		}
+		if MustGetFlagString(utils.PLUGIN_CONFIG) != "" {
+			sendToDestinationCommand = fmt.Sprintf("| %s backup_data %s", pluginConfig.ExecutablePath, pluginConfig.ConfigPath)
+		}
 	}
 
 	copyCommand := fmt.Sprintf("PROGRAM '%s%s %s %s'", checkPipeExistsCommand, customPipeThroughCommand, sendToDestinationCommand, destinationToWrite)
@@ -99,15 +165,29 @@ func BackupSingleTableData(table Table, rowsCopiedMap map[uint32]int64, counters
 
 		destinationToWrite := ""
 		if MustGetFlagBool(utils.SINGLE_DATA_FILE) {
-			destinationToWrite = fmt.Sprintf("%s_%d", globalFPInfo.GetSegmentPipePathForCopyCommand(), table.Oid)
+			shardFPInfo := globalFPInfo.ForShard(tableShards[table.Oid])
+			destinationToWrite = fmt.Sprintf("%s_%d", shardFPInfo.GetSegmentPipePathForCopyCommand(), table.Oid)
 		} else {
 			destinationToWrite = globalFPInfo.GetTableBackupFilePathForCopyCommand(table.Oid, utils.GetPipeThroughProgram().Extension, false)
 		}
+		start := operating.System.Now()
 		rowsCopied, err := CopyTableOut(connectionPool, table, destinationToWrite, whichConn)
 		if err != nil {
 			return err
 		}
 		rowsCopiedMap[table.Oid] = rowsCopied
+		counters.mutex.Lock()
+		if tableElapsedSeconds == nil {
+			tableElapsedSeconds = make(map[uint32]float64, 0)
+		}
+		tableElapsedSeconds[table.Oid] = operating.System.Now().Sub(start).Seconds()
+		counters.mutex.Unlock()
+		if completedTablesFile != nil {
+			err = completedTablesFile.MarkTableComplete(table.Oid, rowsCopied)
+			if err != nil {
+				return err
+			}
+		}
 		counters.ProgressBar.Increment()
 	}
 	return nil
@@ -128,8 +208,20 @@ func BackupDataForAllTables(tables []Table) []map[uint32]int64 {
 	 * We break when an interrupt is received and rely on
 	 * TerminateHangingCopySessions to kill any COPY statements
 	 * in progress if they don't finish on their own.
+	 *
+	 * Each helper in a single data file backup reads its tables in order, so
+	 * each connection backs up the tables of its own shard in order.
+	 * Otherwise, all connections take tables from the same queue.
 	 */
-	tasks := make(chan Table, len(tables))
+	singleDataFile := MustGetFlagBool(utils.SINGLE_DATA_FILE)
+	tasks := make([]chan Table, connectionPool.NumConns)
+	for connNum := range tasks {
+		if connNum == 0 || singleDataFile {
+			tasks[connNum] = make(chan Table, len(tables))
+		} else {
+			tasks[connNum] = tasks[0]
+		}
+	}
 	var workerPool sync.WaitGroup
 	var copyErr error
 	for connNum := 0; connNum < connectionPool.NumConns; connNum++ {
@@ -137,7 +229,7 @@ func BackupDataForAllTables(tables []Table) []map[uint32]int64 {
 		workerPool.Add(1)
 		go func(whichConn int) {
 			defer workerPool.Done()
-			for table := range tasks {
+			for table := range tasks[whichConn] {
 				if wasTerminated || copyErr != nil {
 					counters.ProgressBar.(*pb.ProgressBar).NotPrint = true
 					return
@@ -150,13 +242,21 @@ func BackupDataForAllTables(tables []Table) []map[uint32]int64 {
 		}(connNum)
 	}
 	for _, table := range tables {
-		tasks <- table
+		if singleDataFile {
+			tasks[tableShards[table.Oid]] <- table
+		} else {
+			tasks[0] <- table
+		}
+	}
+	for connNum := range tasks {
+		if connNum == 0 || singleDataFile {
+			close(tasks[connNum])
+		}
 	}
-	close(tasks)
 	workerPool.Wait()
 
 	var agentErr error
-	if MustGetFlagBool(utils.SINGLE_DATA_FILE) {
+	if singleDataFile {
 		agentErr = utils.CheckAgentErrorsOnSegments(globalCluster, globalFPInfo)
 	}
 
@@ -175,6 +275,133 @@ func BackupDataForAllTables(tables []Table) []map[uint32]int64 {
 	return rowsCopiedMaps
 }
 
+/*
+ * Returns the number of bytes of each table on each segment.  For a single data
+ * file backup, these are the uncompressed bytes recorded in the segment TOCs,
+ * so this must be called after WaitForSegmentTOCs; otherwise the size of each
+ * data file is read.  Sizes are not known for data sent to a plugin by COPY, so
+ * nothing is returned for such backups, nor for segments whose sizes could not
+ * be read.
+ */
+func GetTableSegmentSizes(tables []Table) map[uint32]map[int]uint64 {
+	sizes := make(map[uint32]map[int]uint64, 0)
+	addSize := func(oid uint32, contentID int, size uint64) {
+		if sizes[oid] == nil {
+			sizes[oid] = make(map[int]uint64, 0)
+		}
+		sizes[oid][contentID] += size
+	}
+	if MustGetFlagBool(utils.SINGLE_DATA_FILE) {
+		for shard := range GetShardOidLists(tables, tableShards) {
+			shardFPInfo := globalFPInfo.ForShard(shard)
+			remoteOutput := globalCluster.GenerateAndExecuteCommand("Reading table sizes from segment TOC files", func(contentID int) string {
+				return fmt.Sprintf(`cat "%s"`, shardFPInfo.GetSegmentTOCFilePath(contentID))
+			}, cluster.ON_SEGMENTS)
+			for contentID, stdout := range remoteOutput.Stdouts {
+				segmentTOC := &utils.SegmentTOC{}
+				if remoteOutput.Errors[contentID] != nil || yaml.Unmarshal([]byte(stdout), segmentTOC) != nil {
+					gplog.Verbose("Unable to read table sizes from segment TOC file %s", shardFPInfo.GetSegmentTOCFilePath(contentID))
+					continue
+				}
+				for oid, entry := range segmentTOC.DataEntries {
+					addSize(uint32(oid), contentID, entry.EndByte-entry.StartByte)
+				}
+			}
+		}
+	} else if MustGetFlagString(utils.PLUGIN_CONFIG) == "" {
+		remoteOutput := globalCluster.GenerateAndExecuteCommand("Reading sizes of data files", func(contentID int) string {
+			return fmt.Sprintf("find %s -maxdepth 1 -name '%s_[0-9]*' -exec wc -c {} +", globalFPInfo.GetDirForContent(contentID),
+				filepath.Base(globalFPInfo.GetTableBackupFilePath(contentID, 0, "", true)))
+		}, cluster.ON_SEGMENTS)
+		for contentID, stdout := range remoteOutput.Stdouts {
+			if remoteOutput.Errors[contentID] != nil {
+				gplog.Verbose("Unable to read sizes of data files on segment %d", contentID)
+				continue
+			}
+			for oid, size := range ParseDataFileSizes(stdout, filepath.Base(globalFPInfo.GetTableBackupFilePath(contentID, 0, "", true))) {
+				addSize(oid, contentID, size)
+			}
+		}
+	}
+	return sizes
+}
+
+// Parses the output of wc -c for the data files whose names start with prefix, which is followed by _<oid>
+func ParseDataFileSizes(output string, prefix string) map[uint32]uint64 {
+	sizes := make(map[uint32]uint64, 0)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !strings.HasPrefix(filepath.Base(fields[1]), prefix+"_") {
+			continue
+		}
+		size, sizeErr := strconv.ParseUint(fields[0], 10, 64)
+		oidStr := strings.TrimPrefix(filepath.Base(fields[1]), prefix+"_")
+		if end := strings.IndexFunc(oidStr, func(r rune) bool { return r < '0' || r > '9' }); end != -1 {
+			oidStr = oidStr[:end]
+		}
+		oid, oidErr := strconv.ParseUint(oidStr, 10, 32)
+		if sizeErr == nil && oidErr == nil {
+			sizes[uint32(oid)] = size
+		}
+	}
+	return sizes
+}
+
+/*
+ * The skew of a table is computed over all segments, including those on which
+ * it has no data.  The sizes are uncompressed for a single data file backup.
+ */
+func SetDataEntrySizes(dataEntries []utils.MasterDataEntry, sizes map[uint32]map[int]uint64, numSegments int, uncompressed bool) {
+	for i, entry := range dataEntries {
+		segmentSizes, ok := sizes[entry.Oid]
+		if !ok {
+			continue
+		}
+		var total, largest uint64
+		for _, size := range segmentSizes {
+			total += size
+			if size > largest {
+				largest = size
+			}
+		}
+		if uncompressed {
+			dataEntries[i].UncompressedBytes = total
+		} else {
+			dataEntries[i].CompressedBytes = total
+		}
+		if total > 0 && numSegments > 0 {
+			dataEntries[i].SegmentSkew = float64(largest) / (float64(total) / float64(numSegments))
+		}
+	}
+}
+
+/*
+ * Data files are checksummed once all COPYs have finished rather than as they
+ * are written, so that the COPY commands themselves are unchanged.  Single data
+ * file backups are checksummed by the helper instead, and data written through
+ * a plugin never lands on the segments, so neither is handled here.  Files are
+ * listed relative to the backup directory, so that the backup can be moved.
+ */
+func WriteDataFileChecksumsOnAllHosts(tables []Table) {
+	hasDataFiles := false
+	for _, table := range tables {
+		if !table.SkipDataBackup() {
+			hasDataFiles = true
+			break
+		}
+	}
+	if !hasDataFiles {
+		return
+	}
+	remoteOutput := globalCluster.GenerateAndExecuteCommand("Writing checksums for data files", func(contentID int) string {
+		dataFilePrefix := globalFPInfo.GetTableBackupFilePath(contentID, 0, "", true)
+		return fmt.Sprintf("(cd %s && sha256sum %s_[0-9]*) > %s", filepath.Dir(dataFilePrefix), filepath.Base(dataFilePrefix), globalFPInfo.GetSegmentChecksumFilePath(contentID))
+	}, cluster.ON_SEGMENTS)
+	globalCluster.CheckClusterError(remoteOutput, "Unable to write checksums for data files", func(contentID int) string {
+		return fmt.Sprintf("Unable to write checksum file %s", globalFPInfo.GetSegmentChecksumFilePath(contentID))
+	})
+}
+
 func printDataBackupWarnings(numExtTables int64) {
 	if numExtTables > 0 {
 		gplog.Info("Skipped data backup of %d external/foreign table(s).", numExtTables)
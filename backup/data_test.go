@@ -1,9 +1,15 @@
 package backup_test
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"regexp"
+	"time"
 
+	"github.com/greenplum-db/gp-common-go-libs/operating"
 	"github.com/greenplum-db/gpbackup/backup"
+	"github.com/greenplum-db/gpbackup/backup_filepath"
 	"github.com/greenplum-db/gpbackup/backup_history"
 	"github.com/greenplum-db/gpbackup/utils"
 
@@ -55,6 +61,22 @@ var _ = Describe("backup/data tests", func() {
 			expectedDataEntries := []utils.MasterDataEntry{{Schema: "public", Name: "table", Oid: 1, AttributeString: "(a)"}}
 			Expect(toc.DataEntries).To(Equal(expectedDataEntries))
 		})
+		It("adds the shard of a table in a single data file backup to the TOC", func() {
+			backup.SetTableShards(map[uint32]int{1: 2})
+			defer backup.SetTableShards(nil)
+			tables := []backup.Table{table}
+			backup.AddTableDataEntriesToTOC(tables, rowsCopiedMaps)
+			expectedDataEntries := []utils.MasterDataEntry{{Schema: "public", Name: "table", Oid: 1, AttributeString: "(a)", Shard: 2}}
+			Expect(toc.DataEntries).To(Equal(expectedDataEntries))
+		})
+		It("adds the time taken to back up a table to the TOC", func() {
+			backup.SetTableElapsedSeconds(map[uint32]float64{1: 2.5})
+			defer backup.SetTableElapsedSeconds(nil)
+			tables := []backup.Table{table}
+			backup.AddTableDataEntriesToTOC(tables, rowsCopiedMaps)
+			expectedDataEntries := []utils.MasterDataEntry{{Schema: "public", Name: "table", Oid: 1, AttributeString: "(a)", ElapsedSeconds: 2.5}}
+			Expect(toc.DataEntries).To(Equal(expectedDataEntries))
+		})
 		It("does not add an entry for an external table to the TOC", func() {
 			table.IsExternal = true
 			tables := []backup.Table{table}
@@ -69,6 +91,79 @@ var _ = Describe("backup/data tests", func() {
 			Expect(toc.DataEntries).To(BeNil())
 		})
 	})
+	Describe("ParseDataFileSizes", func() {
+		It("returns the size of each data file by oid", func() {
+			output := `   1024 /data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_16384.gz
+     20 /data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_16385.zst
+   1044 total
+`
+			Expect(backup.ParseDataFileSizes(output, "gpbackup_0_20170101010101")).To(Equal(map[uint32]uint64{16384: 1024, 16385: 20}))
+		})
+		It("ignores files for other segments and backups", func() {
+			output := "10 /data/gpseg0/gpbackup_1_20170101010101_16384\n10 /data/gpseg0/gpbackup_0_20170101010101_checksums\n"
+			Expect(backup.ParseDataFileSizes(output, "gpbackup_0_20170101010101")).To(BeEmpty())
+		})
+	})
+	Describe("SetDataEntrySizes", func() {
+		It("sums the bytes of each table across segments and computes the skew", func() {
+			dataEntries := []utils.MasterDataEntry{{Oid: 1}, {Oid: 2}, {Oid: 3}}
+			sizes := map[uint32]map[int]uint64{
+				1: {0: 100, 1: 100, 2: 100, 3: 100},
+				2: {0: 300, 1: 100},
+			}
+			backup.SetDataEntrySizes(dataEntries, sizes, 4, false)
+			Expect(dataEntries).To(Equal([]utils.MasterDataEntry{
+				{Oid: 1, CompressedBytes: 400, SegmentSkew: 1},
+				{Oid: 2, CompressedBytes: 400, SegmentSkew: 3},
+				{Oid: 3},
+			}))
+		})
+		It("records uncompressed sizes for a single data file backup", func() {
+			dataEntries := []utils.MasterDataEntry{{Oid: 1}}
+			sizes := map[uint32]map[int]uint64{1: {0: 300, 1: 100}}
+			backup.SetDataEntrySizes(dataEntries, sizes, 2, true)
+			Expect(dataEntries).To(Equal([]utils.MasterDataEntry{{Oid: 1, UncompressedBytes: 400, SegmentSkew: 1.5}}))
+		})
+	})
+	Describe("GetTableReports", func() {
+		It("reports the rows copied and size of each table with a data entry", func() {
+			dataEntries := []utils.MasterDataEntry{
+				{Schema: "public", Name: "foo", Oid: 1, RowsCopied: 10},
+				{Schema: "public", Name: "bar", Oid: 2, RowsCopied: 20},
+			}
+			tables := backup.GetTableReports(dataEntries, map[uint32]int64{1: 32768})
+			Expect(tables).To(Equal([]utils.TableReport{
+				{Schema: "public", Name: "foo", Oid: 1, RowsCopied: 10, SizeBytes: 32768},
+				{Schema: "public", Name: "bar", Oid: 2, RowsCopied: 20},
+			}))
+		})
+	})
+	Describe("AssignTablesToShards", func() {
+		var tables []backup.Table
+		BeforeEach(func() {
+			tables = []backup.Table{
+				{Relation: backup.Relation{Oid: 1, Schema: "public", Name: "table1"}},
+				{Relation: backup.Relation{Oid: 2, Schema: "public", Name: "ext_table"}, TableDefinition: backup.TableDefinition{IsExternal: true}},
+				{Relation: backup.Relation{Oid: 3, Schema: "public", Name: "table3"}},
+				{Relation: backup.Relation{Oid: 4, Schema: "public", Name: "table4"}},
+			}
+		})
+		It("deals tables with data to the shards in turn", func() {
+			shards := backup.AssignTablesToShards(tables, 2)
+			Expect(shards).To(Equal(map[uint32]int{1: 0, 3: 1, 4: 0}))
+			Expect(backup.GetShardOidLists(tables, shards)).To(Equal([][]string{{"1", "4"}, {"3"}}))
+		})
+		It("uses no more shards than there are tables with data", func() {
+			shards := backup.AssignTablesToShards(tables, 8)
+			Expect(shards).To(Equal(map[uint32]int{1: 0, 3: 1, 4: 2}))
+			Expect(backup.GetShardOidLists(tables, shards)).To(Equal([][]string{{"1"}, {"3"}, {"4"}}))
+		})
+		It("assigns every table to shard 0 with one job", func() {
+			shards := backup.AssignTablesToShards(tables, 1)
+			Expect(shards).To(Equal(map[uint32]int{1: 0, 3: 0, 4: 0}))
+			Expect(backup.GetShardOidLists(tables, shards)).To(Equal([][]string{{"1", "3", "4"}}))
+		})
+	})
 	Describe("CopyTableOut", func() {
 		testTable := backup.Table{Relation: backup.Relation{SchemaOid: 2345, Oid: 3456, Schema: "public", Name: "foo"}}
 		It("will back up a table to its own file with compression", func() {
@@ -117,6 +212,39 @@ var _ = Describe("backup/data tests", func() {
 
 			Expect(err).ShouldNot(HaveOccurred())
 		})
+		It("will back up a table to its own encrypted file", func() {
+			operating.System.Getenv = func(key string) string {
+				return "/usr/local/gpdb"
+			}
+			defer func() { operating.System = operating.InitializeSystemFunctions() }()
+			utils.SetEncryptionKey(make([]byte, 32))
+			defer utils.SetEncryptionKey(nil)
+			backup.SetFPInfo(backup_filepath.FilePathInfo{PID: 5678})
+			utils.SetPipeThroughProgram(utils.PipeThroughProgram{Name: "gzip", OutputCommand: "gzip -c -8", InputCommand: "gzip -d -c", Extension: ".gz"})
+			execStr := regexp.QuoteMeta("COPY public.foo TO PROGRAM 'gzip -c -8 | /usr/local/gpdb/bin/gpbackup_helper --encrypt --encryption-key-file <SEG_DATA_DIR>/gpbackup_<SEGID>_encryption_key_5678 > <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz' WITH CSV DELIMITER ',' ON SEGMENT IGNORE EXTERNAL PARTITIONS;")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz"
+
+			_, err := backup.CopyTableOut(connectionPool, testTable, filename, defaultConnNum)
+
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("will back up a table to its own file at no more than the maximum bandwidth", func() {
+			operating.System.Getenv = func(key string) string {
+				return "/usr/local/gpdb"
+			}
+			defer func() { operating.System = operating.InitializeSystemFunctions() }()
+			utils.InitializeMaxBandwidth(1048576)
+			defer utils.InitializeMaxBandwidth(0)
+			utils.SetPipeThroughProgram(utils.PipeThroughProgram{Name: "gzip", OutputCommand: "gzip -c -8", InputCommand: "gzip -d -c", Extension: ".gz"})
+			execStr := regexp.QuoteMeta("COPY public.foo TO PROGRAM 'gzip -c -8 | /usr/local/gpdb/bin/gpbackup_helper --throttle --max-bandwidth 1048576 > <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz' WITH CSV DELIMITER ',' ON SEGMENT IGNORE EXTERNAL PARTITIONS;")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz"
+
+			_, err := backup.CopyTableOut(connectionPool, testTable, filename, defaultConnNum)
+
+			Expect(err).ShouldNot(HaveOccurred())
+		})
 		It("will back up a table to a single file", func() {
 			_ = cmdFlags.Set(utils.SINGLE_DATA_FILE, "true")
 			execStr := regexp.QuoteMeta(`COPY public.foo TO PROGRAM '(test -p "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456" || (echo "Pipe not found <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456">&2; exit 1)) && cat - > <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456' WITH CSV DELIMITER ',' ON SEGMENT IGNORE EXTERNAL PARTITIONS;`)
@@ -159,6 +287,37 @@ var _ = Describe("backup/data tests", func() {
 			Expect(rowsCopiedMap[0]).To(Equal(int64(10)))
 			Expect(counters.NumRegTables).To(Equal(int64(1)))
 		})
+		It("records the time taken to back up a table", func() {
+			start := time.Date(2017, 1, 1, 1, 1, 1, 0, time.Local)
+			calls := 0
+			operating.System.Now = func() time.Time {
+				calls++
+				return start.Add(time.Duration(calls-1) * 1500 * time.Millisecond)
+			}
+			defer func() { operating.System = operating.InitializeSystemFunctions() }()
+			defer backup.SetTableElapsedSeconds(nil)
+			toc := &utils.TOC{}
+			backup.SetTOC(toc)
+
+			mock.ExpectExec("COPY (.*)").WillReturnResult(sqlmock.NewResult(0, 10))
+			err := backup.BackupSingleTableData(testTable, rowsCopiedMap, &counters, 0)
+			Expect(err).ShouldNot(HaveOccurred())
+			backup.AddTableDataEntriesToTOC([]backup.Table{testTable}, []map[uint32]int64{rowsCopiedMap})
+			Expect(toc.DataEntries[0].ElapsedSeconds).To(Equal(1.5))
+		})
+		It("backs up a single regular table to the pipe for its shard with single data file", func() {
+			_ = cmdFlags.Set(utils.SINGLE_DATA_FILE, "true")
+			backup.SetTableShards(map[uint32]int{0: 1})
+			defer backup.SetTableShards(nil)
+
+			backupFile := fmt.Sprintf("<SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_(.*)_shard1_%d", testTable.Oid)
+			copyCmd := fmt.Sprintf(copyFmtStr, backupFile)
+			mock.ExpectExec(copyCmd).WillReturnResult(sqlmock.NewResult(0, 10))
+			err := backup.BackupSingleTableData(testTable, rowsCopiedMap, &counters, 0)
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(rowsCopiedMap[0]).To(Equal(int64(10)))
+		})
 		It("backs up a single regular table without a single data file", func() {
 			_ = cmdFlags.Set(utils.SINGLE_DATA_FILE, "false")
 
@@ -171,6 +330,24 @@ var _ = Describe("backup/data tests", func() {
 			Expect(rowsCopiedMap[0]).To(Equal(int64(10)))
 			Expect(counters.NumRegTables).To(Equal(int64(1)))
 		})
+		It("records a table as completed once its data is backed up", func() {
+			tempDir, _ := ioutil.TempDir("", "resume")
+			defer os.RemoveAll(tempDir)
+			completedFilename := filepath.Join(tempDir, "gpbackup_20170101010101_completed_tables")
+			completedFile, _ := backup.OpenCompletedTablesFile(completedFilename)
+			backup.SetCompletedTablesFile(completedFile)
+			defer backup.SetCompletedTablesFile(nil)
+
+			backupFile := fmt.Sprintf("<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_%d", testTable.Oid)
+			copyCmd := fmt.Sprintf(copyFmtStr, backupFile)
+			mock.ExpectExec(copyCmd).WillReturnResult(sqlmock.NewResult(0, 10))
+			err := backup.BackupSingleTableData(testTable, rowsCopiedMap, &counters, 0)
+			_ = completedFile.Close()
+
+			Expect(err).ShouldNot(HaveOccurred())
+			completedTables, _ := backup.ReadCompletedTables(completedFilename)
+			Expect(completedTables).To(Equal(map[uint32]int64{testTable.Oid: 10}))
+		})
 		It("backs up a single external table", func() {
 			_ = cmdFlags.Set(utils.LEAF_PARTITION_DATA, "false")
 			testTable.IsExternal = true
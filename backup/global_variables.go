@@ -21,16 +21,26 @@ import (
  * Non-flag variables
  */
 var (
-	backupReport   *utils.Report
-	connectionPool *dbconn.DBConn
-	globalCluster  *cluster.Cluster
-	globalFPInfo   backup_filepath.FilePathInfo
-	globalTOC      *utils.TOC
-	objectCounts   map[string]int
-	pluginConfig   *utils.PluginConfig
-	version        string
-	wasTerminated  bool
-	backupLockFile lockfile.Lockfile
+	backupReport        *utils.Report
+	backupState         *BackupState
+	completedTables     map[uint32]int64
+	completedTablesFile *CompletedTablesFile
+	connectionPool      *dbconn.DBConn
+	globalCluster       *cluster.Cluster
+	globalFPInfo        backup_filepath.FilePathInfo
+	globalTOC           *utils.TOC
+	heapEntriesAtStart  map[string]utils.HeapEntry
+	hookConfig          utils.HookConfig
+	metricsWritten      bool
+	objectCounts        map[string]int
+	pluginConfig        *utils.PluginConfig
+	preHookRan          bool
+	tableElapsedSeconds map[uint32]float64
+	tableShards         map[uint32]int
+	tableSizes          map[uint32]int64
+	version             string
+	wasTerminated       bool
+	backupLockFile      lockfile.Lockfile
 
 	/*
 	 * Used for synchronizing DoCleanup.  In DoInit() we increment the group
@@ -61,6 +71,10 @@ func SetCluster(cluster *cluster.Cluster) {
 	globalCluster = cluster
 }
 
+func SetCompletedTablesFile(file *CompletedTablesFile) {
+	completedTablesFile = file
+}
+
 func SetFPInfo(fpInfo backup_filepath.FilePathInfo) {
 	globalFPInfo = fpInfo
 }
@@ -69,6 +83,14 @@ func SetPluginConfig(config *utils.PluginConfig) {
 	pluginConfig = config
 }
 
+func SetHookConfig(config utils.HookConfig) {
+	hookConfig = config
+}
+
+func SetPreHookRan(ran bool) {
+	preHookRan = ran
+}
+
 func SetReport(report *utils.Report) {
 	backupReport = report
 }
@@ -77,6 +99,14 @@ func GetReport() *utils.Report {
 	return backupReport
 }
 
+func SetTableElapsedSeconds(elapsedSeconds map[uint32]float64) {
+	tableElapsedSeconds = elapsedSeconds
+}
+
+func SetTableShards(shards map[uint32]int) {
+	tableShards = shards
+}
+
 func SetTOC(toc *utils.TOC) {
 	globalTOC = toc
 }
@@ -85,6 +115,10 @@ func SetVersion(v string) {
 	version = v
 }
 
+func SetWasTerminated(terminated bool) {
+	wasTerminated = terminated
+}
+
 // Util functions to enable ease of access to global flag values
 
 func MustGetFlagString(flagName string) string {
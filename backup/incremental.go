@@ -13,7 +13,11 @@ func FilterTablesForIncremental(lastBackupTOC, currentTOC *utils.TOC, tables []T
 	for _, table := range tables {
 		currentAOEntry, isAOTable := currentTOC.IncrementalMetadata.AO[table.FQN()]
 		if !isAOTable {
-			filteredTables = append(filteredTables, table)
+			currentHeapEntry, isHeapTable := currentTOC.IncrementalMetadata.Heap[table.FQN()]
+			previousHeapEntry, wasHeapTable := lastBackupTOC.IncrementalMetadata.Heap[table.FQN()]
+			if !isHeapTable || !wasHeapTable || HeapTableChanged(previousHeapEntry, currentHeapEntry) {
+				filteredTables = append(filteredTables, table)
+			}
 			continue
 		}
 		previousAOEntry := lastBackupTOC.IncrementalMetadata.AO[table.FQN()]
@@ -26,6 +30,67 @@ func FilterTablesForIncremental(lastBackupTOC, currentTOC *utils.TOC, tables []T
 	return filteredTables
 }
 
+/*
+ * A heap table is treated as changed unless every signal shows that it is
+ * unchanged on every segment.  A change in the statistics reset timestamp
+ * means that the tuple counters are not comparable, as does an empty reset
+ * timestamp, so the table must be treated as changed in either case.
+ */
+func HeapTableChanged(previousEntry utils.HeapEntry, currentEntry utils.HeapEntry) bool {
+	if previousEntry.ChangedDuringBackup || previousEntry.LastDDLTimestamp != currentEntry.LastDDLTimestamp ||
+		len(currentEntry.Segments) == 0 || len(previousEntry.Segments) != len(currentEntry.Segments) {
+		return true
+	}
+	for contentID, currentSegEntry := range currentEntry.Segments {
+		previousSegEntry, ok := previousEntry.Segments[contentID]
+		if !ok || currentSegEntry.StatsResetTimestamp == "" ||
+			previousSegEntry.StatsResetTimestamp != currentSegEntry.StatsResetTimestamp ||
+			previousSegEntry.Relfilenode != currentSegEntry.Relfilenode ||
+			previousSegEntry.Size != currentSegEntry.Size ||
+			previousSegEntry.TuplesModified != currentSegEntry.TuplesModified {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * The recorded entry of each heap table is the one read before the backup
+ * transaction took its snapshot, as a write committed after the snapshot but
+ * before the table was read within the transaction would otherwise move the
+ * entry without being included in the backed-up data.  A table without an
+ * entry from before the snapshot, such as one created in the meantime, is
+ * marked so that the next incremental backup includes it.
+ */
+func GetHeapEntriesFromBeforeSnapshot(entriesBeforeSnapshot map[string]utils.HeapEntry, entries map[string]utils.HeapEntry) map[string]utils.HeapEntry {
+	recordedEntries := make(map[string]utils.HeapEntry, len(entries))
+	for heapTableFQN, entry := range entries {
+		if entryBeforeSnapshot, ok := entriesBeforeSnapshot[heapTableFQN]; ok {
+			recordedEntries[heapTableFQN] = entryBeforeSnapshot
+		} else {
+			entry.ChangedDuringBackup = true
+			recordedEntries[heapTableFQN] = entry
+		}
+	}
+	return recordedEntries
+}
+
+/*
+ * Heap table entries are read before the data is backed up and are recorded
+ * unchanged, but any table whose entry has moved by the time the data has been
+ * read is marked so that the next incremental backup includes it, as the write
+ * that moved it may or may not have been included in the backed-up data.
+ */
+func MarkHeapTablesChangedDuringBackup(entriesBeforeData map[string]utils.HeapEntry, entriesAfterData map[string]utils.HeapEntry) {
+	for heapTableFQN, entryBeforeData := range entriesBeforeData {
+		entryAfterData, ok := entriesAfterData[heapTableFQN]
+		if !ok || HeapTableChanged(entryBeforeData, entryAfterData) {
+			entryBeforeData.ChangedDuringBackup = true
+			entriesBeforeData[heapTableFQN] = entryBeforeData
+		}
+	}
+}
+
 func GetTargetBackupTimestamp() string {
 	targetTimestamp := ""
 	if fromTimestamp := MustGetFlagString(utils.FROM_TIMESTAMP); fromTimestamp != "" {
@@ -55,8 +120,19 @@ func GetLatestMatchingBackupTimestamp() string {
 	return latestMatchingBackupHistoryEntry.Timestamp
 }
 
+/*
+ * An incremental backup is based on the latest matching backup of any kind,
+ * while a differential backup is based on the latest matching full backup so
+ * that its restore plan only contains the full backup and itself.
+ */
 func GetLatestMatchingBackupConfig(history *backup_history.History, currentBackupConfig *backup_history.BackupConfig) *backup_history.BackupConfig {
 	for _, backupConfig := range history.BackupConfigs {
+		if backupConfig.Deleted {
+			continue
+		}
+		if currentBackupConfig.Differential && backupConfig.Incremental {
+			continue
+		}
 		if MatchesIncrementalFlags(&backupConfig, currentBackupConfig) {
 			return &backupConfig
 		}
@@ -72,6 +148,8 @@ func MatchesIncrementalFlags(backupConfig *backup_history.BackupConfig, currentB
 		backupConfig.Plugin == currentBackupConfig.Plugin &&
 		backupConfig.SingleDataFile == MustGetFlagBool(utils.SINGLE_DATA_FILE) &&
 		backupConfig.Compressed == currentBackupConfig.Compressed &&
+		backupConfig.GetCompressionType() == currentBackupConfig.GetCompressionType() &&
+		backupConfig.Encrypted == currentBackupConfig.Encrypted &&
 		// Expanding of the include list happens before this now so we must compare again current backup config
 		utils.NewIncludeSet(backupConfig.IncludeRelations).Equals(utils.NewIncludeSet(currentBackupConfig.IncludeRelations)) &&
 		utils.NewIncludeSet(backupConfig.IncludeSchemas).Equals(utils.NewIncludeSet(MustGetFlagStringSlice(utils.INCLUDE_SCHEMA))) &&
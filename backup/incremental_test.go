@@ -76,6 +76,123 @@ var _ = Describe("backup/incremental tests", func() {
 		})
 	})
 
+	Describe("FilterTablesForIncremental with heap tables", func() {
+		heapEntry := utils.HeapEntry{
+			LastDDLTimestamp: "00000",
+			Segments: map[int]utils.HeapSegmentEntry{
+				0: {Relfilenode: 16384, Size: 32768, TuplesModified: 10, StatsResetTimestamp: "2019-01-01 00:00:00"},
+				1: {Relfilenode: 16384, Size: 32768, TuplesModified: 12, StatsResetTimestamp: "2019-01-01 00:00:00"},
+			},
+		}
+		tblHeap := backup.Table{Relation: backup.Relation{Schema: "public", Name: "heap"}}
+		filterHeapTable := func(previousEntry utils.HeapEntry, currentEntry utils.HeapEntry) []backup.Table {
+			prevTOC := utils.TOC{IncrementalMetadata: utils.IncrementalEntries{Heap: map[string]utils.HeapEntry{"public.heap": previousEntry}}}
+			currTOC := utils.TOC{IncrementalMetadata: utils.IncrementalEntries{Heap: map[string]utils.HeapEntry{"public.heap": currentEntry}}}
+			return backup.FilterTablesForIncremental(&prevTOC, &currTOC, []backup.Table{tblHeap})
+		}
+		changeSegment := func(change func(*utils.HeapSegmentEntry)) utils.HeapEntry {
+			changedEntry := utils.HeapEntry{LastDDLTimestamp: heapEntry.LastDDLTimestamp, Segments: map[int]utils.HeapSegmentEntry{}}
+			for contentID, segEntry := range heapEntry.Segments {
+				changedEntry.Segments[contentID] = segEntry
+			}
+			segEntry := changedEntry.Segments[1]
+			change(&segEntry)
+			changedEntry.Segments[1] = segEntry
+			return changedEntry
+		}
+
+		It("Should NOT include the unmodified heap table", func() {
+			Expect(filterHeapTable(heapEntry, heapEntry)).To(BeEmpty())
+		})
+		It("Should include the heap table if it has no entry in the previous backup", func() {
+			prevTOC := utils.TOC{}
+			currTOC := utils.TOC{IncrementalMetadata: utils.IncrementalEntries{Heap: map[string]utils.HeapEntry{"public.heap": heapEntry}}}
+			Expect(backup.FilterTablesForIncremental(&prevTOC, &currTOC, []backup.Table{tblHeap})).To(ConsistOf(tblHeap))
+		})
+		It("Should include the heap table having a modified last DDL timestamp", func() {
+			changedEntry := changeSegment(func(*utils.HeapSegmentEntry) {})
+			changedEntry.LastDDLTimestamp = "00001"
+			Expect(filterHeapTable(heapEntry, changedEntry)).To(ConsistOf(tblHeap))
+		})
+		It("Should include the heap table having a modified tuple count on one segment", func() {
+			Expect(filterHeapTable(heapEntry, changeSegment(func(segEntry *utils.HeapSegmentEntry) { segEntry.TuplesModified++ }))).To(ConsistOf(tblHeap))
+		})
+		It("Should include the heap table having a modified size on one segment", func() {
+			Expect(filterHeapTable(heapEntry, changeSegment(func(segEntry *utils.HeapSegmentEntry) { segEntry.Size += 8192 }))).To(ConsistOf(tblHeap))
+		})
+		It("Should include the heap table having a modified relfilenode on one segment", func() {
+			Expect(filterHeapTable(heapEntry, changeSegment(func(segEntry *utils.HeapSegmentEntry) { segEntry.Relfilenode = 16390 }))).To(ConsistOf(tblHeap))
+		})
+		It("Should include the heap table if statistics were reset on one segment", func() {
+			Expect(filterHeapTable(heapEntry, changeSegment(func(segEntry *utils.HeapSegmentEntry) { segEntry.StatsResetTimestamp = "2019-02-01 00:00:00" }))).To(ConsistOf(tblHeap))
+		})
+		It("Should include the heap table if statistics are unavailable on one segment", func() {
+			unreliableEntry := changeSegment(func(segEntry *utils.HeapSegmentEntry) { segEntry.StatsResetTimestamp = "" })
+			Expect(filterHeapTable(unreliableEntry, unreliableEntry)).To(ConsistOf(tblHeap))
+		})
+		It("Should include the heap table if the number of segments has changed", func() {
+			changedEntry := changeSegment(func(*utils.HeapSegmentEntry) {})
+			changedEntry.Segments[2] = changedEntry.Segments[1]
+			Expect(filterHeapTable(heapEntry, changedEntry)).To(ConsistOf(tblHeap))
+		})
+		It("Should include the heap table if it was marked as changed during the previous backup", func() {
+			markedEntry := changeSegment(func(*utils.HeapSegmentEntry) {})
+			markedEntry.ChangedDuringBackup = true
+			Expect(filterHeapTable(markedEntry, heapEntry)).To(ConsistOf(tblHeap))
+		})
+		Context("GetHeapEntriesFromBeforeSnapshot", func() {
+			It("includes a heap table written to between the snapshot and the read within the backup in the next incremental backup", func() {
+				entryAfterWrite := changeSegment(func(segEntry *utils.HeapSegmentEntry) { segEntry.TuplesModified++ })
+				recordedEntries := backup.GetHeapEntriesFromBeforeSnapshot(map[string]utils.HeapEntry{"public.heap": heapEntry}, map[string]utils.HeapEntry{"public.heap": entryAfterWrite})
+				// The data is read after the write, so nothing moves while it is backed up
+				backup.MarkHeapTablesChangedDuringBackup(recordedEntries, map[string]utils.HeapEntry{"public.heap": entryAfterWrite})
+
+				Expect(recordedEntries["public.heap"].ChangedDuringBackup).To(BeTrue())
+				Expect(filterHeapTable(recordedEntries["public.heap"], entryAfterWrite)).To(ConsistOf(tblHeap))
+			})
+			It("does not mark a heap table that was not written to after the entries were first read", func() {
+				recordedEntries := backup.GetHeapEntriesFromBeforeSnapshot(map[string]utils.HeapEntry{"public.heap": heapEntry}, map[string]utils.HeapEntry{"public.heap": heapEntry})
+				backup.MarkHeapTablesChangedDuringBackup(recordedEntries, map[string]utils.HeapEntry{"public.heap": heapEntry})
+
+				Expect(recordedEntries["public.heap"].ChangedDuringBackup).To(BeFalse())
+			})
+			It("marks a heap table that has no entry from before the snapshot", func() {
+				recordedEntries := backup.GetHeapEntriesFromBeforeSnapshot(map[string]utils.HeapEntry{}, map[string]utils.HeapEntry{"public.heap": heapEntry})
+
+				Expect(recordedEntries["public.heap"].ChangedDuringBackup).To(BeTrue())
+			})
+			It("only records the heap tables included in the backup", func() {
+				recordedEntries := backup.GetHeapEntriesFromBeforeSnapshot(map[string]utils.HeapEntry{"public.heap": heapEntry, "public.other": heapEntry}, map[string]utils.HeapEntry{"public.heap": heapEntry})
+
+				Expect(recordedEntries).To(HaveLen(1))
+				Expect(recordedEntries).To(HaveKey("public.heap"))
+			})
+		})
+		Context("MarkHeapTablesChangedDuringBackup", func() {
+			It("does not mark a heap table that was not written to during the backup", func() {
+				entriesBeforeData := map[string]utils.HeapEntry{"public.heap": heapEntry}
+				backup.MarkHeapTablesChangedDuringBackup(entriesBeforeData, map[string]utils.HeapEntry{"public.heap": heapEntry})
+				Expect(entriesBeforeData["public.heap"].ChangedDuringBackup).To(BeFalse())
+			})
+			It("includes a heap table written to during the backup in the next incremental backup", func() {
+				entryAfterWrite := changeSegment(func(segEntry *utils.HeapSegmentEntry) { segEntry.TuplesModified++ })
+				entriesBeforeData := map[string]utils.HeapEntry{"public.heap": heapEntry}
+				backup.MarkHeapTablesChangedDuringBackup(entriesBeforeData, map[string]utils.HeapEntry{"public.heap": entryAfterWrite})
+
+				recordedEntry := entriesBeforeData["public.heap"]
+				Expect(recordedEntry.ChangedDuringBackup).To(BeTrue())
+				Expect(recordedEntry.Segments[1].TuplesModified).To(Equal(heapEntry.Segments[1].TuplesModified))
+				// No further writes happen before the next incremental backup
+				Expect(filterHeapTable(recordedEntry, entryAfterWrite)).To(ConsistOf(tblHeap))
+			})
+			It("marks a heap table that was dropped during the backup", func() {
+				entriesBeforeData := map[string]utils.HeapEntry{"public.heap": heapEntry}
+				backup.MarkHeapTablesChangedDuringBackup(entriesBeforeData, map[string]utils.HeapEntry{})
+				Expect(entriesBeforeData["public.heap"].ChangedDuringBackup).To(BeTrue())
+			})
+		})
+	})
+
 	Describe("GetLatestMatchingBackupConfig", func() {
 		history := backup_history.History{BackupConfigs: []backup_history.BackupConfig{
 			{DatabaseName: "test2", Timestamp: "timestamp4"},
@@ -90,6 +207,40 @@ var _ = Describe("backup/incremental tests", func() {
 
 			structmatcher.ExpectStructsToMatch(history.BackupConfigs[1], latestBackupHistoryEntry)
 		})
+		It("should skip backups that have been deleted", func() {
+			historyWithDeleted := backup_history.History{BackupConfigs: []backup_history.BackupConfig{
+				{DatabaseName: "test1", Timestamp: "timestamp3", Deleted: true},
+				{DatabaseName: "test1", Timestamp: "timestamp1"},
+			}}
+			currentBackupConfig := backup_history.BackupConfig{DatabaseName: "test1"}
+
+			latestBackupHistoryEntry := backup.GetLatestMatchingBackupConfig(&historyWithDeleted, &currentBackupConfig)
+
+			structmatcher.ExpectStructsToMatch(historyWithDeleted.BackupConfigs[1], latestBackupHistoryEntry)
+		})
+		It("should return the latest full backup for a differential backup", func() {
+			historyWithIncremental := backup_history.History{BackupConfigs: []backup_history.BackupConfig{
+				{DatabaseName: "test1", Timestamp: "timestamp3", Incremental: true},
+				{DatabaseName: "test1", Timestamp: "timestamp2", Incremental: true, Differential: true},
+				{DatabaseName: "test1", Timestamp: "timestamp1"},
+			}}
+			currentBackupConfig := backup_history.BackupConfig{DatabaseName: "test1", Incremental: true, Differential: true}
+
+			latestBackupHistoryEntry := backup.GetLatestMatchingBackupConfig(&historyWithIncremental, &currentBackupConfig)
+
+			structmatcher.ExpectStructsToMatch(historyWithIncremental.BackupConfigs[2], latestBackupHistoryEntry)
+		})
+		It("should return the latest backup of any kind for an incremental backup", func() {
+			historyWithDifferential := backup_history.History{BackupConfigs: []backup_history.BackupConfig{
+				{DatabaseName: "test1", Timestamp: "timestamp2", Incremental: true, Differential: true},
+				{DatabaseName: "test1", Timestamp: "timestamp1"},
+			}}
+			currentBackupConfig := backup_history.BackupConfig{DatabaseName: "test1", Incremental: true}
+
+			latestBackupHistoryEntry := backup.GetLatestMatchingBackupConfig(&historyWithDifferential, &currentBackupConfig)
+
+			structmatcher.ExpectStructsToMatch(historyWithDifferential.BackupConfigs[0], latestBackupHistoryEntry)
+		})
 		It("should return nil with no matching Dbname", func() {
 			currentBackupConfig := backup_history.BackupConfig{DatabaseName: "test3"}
 
@@ -12,7 +12,7 @@ func GetAOIncrementalMetadata(connectionPool *dbconn.DBConn) map[string]utils.AO
 	gplog.Verbose("Querying table row mod counts")
 	var modCounts = getAllModCounts(connectionPool)
 	gplog.Verbose("Querying last DDL modification timestamp for tables")
-	var lastDDLTimestamps = getLastDDLTimestamps(connectionPool)
+	var lastDDLTimestamps = getLastDDLTimestamps(connectionPool, "'ao', 'co'", relationAndSchemaFilterClause())
 	aoTableEntries := make(map[string]utils.AOEntry)
 	for aoTableFQN := range modCounts {
 		aoTableEntries[aoTableFQN] = utils.AOEntry{
@@ -99,7 +99,7 @@ func getModCount(connectionPool *dbconn.DBConn, aosegtablefqn string) int64 {
 	return results[0].Modcount
 }
 
-func getLastDDLTimestamps(connectionPool *dbconn.DBConn) map[string]string {
+func getLastDDLTimestamps(connectionPool *dbconn.DBConn, relStorages string, filterClause string) map[string]string {
 	query := fmt.Sprintf(`
 	SELECT
 		quote_ident(aoschema) || '.' || quote_ident(aorelname) as aotablefqn,
@@ -117,7 +117,7 @@ func getLastDDLTimestamps(connectionPool *dbconn.DBConn) map[string]string {
 			ON
 				c.relnamespace = n.oid
 			WHERE
-				c.relstorage IN ('ao', 'co')
+				c.relstorage IN (%s)
 			AND
 				%s
 		) aotables
@@ -135,7 +135,7 @@ func getLastDDLTimestamps(connectionPool *dbconn.DBConn) map[string]string {
 		) lastop
 	ON
 		aotables.aooid = lastop.objid
-`, relationAndSchemaFilterClause())
+`, relStorages, filterClause)
 
 	var results []struct {
 		AOTableFQN       string
@@ -149,3 +149,143 @@ func getLastDDLTimestamps(connectionPool *dbconn.DBConn) map[string]string {
 	}
 	return resultMap
 }
+
+/*
+ * The statistics collector does not record when its counters are reset
+ * before GPDB 6, so heap tables are only tracked from GPDB 6 onwards and are
+ * otherwise always treated as changed.
+ */
+func GetHeapIncrementalMetadata(connectionPool *dbconn.DBConn) map[string]utils.HeapEntry {
+	return getHeapIncrementalMetadata(connectionPool, relationAndSchemaFilterClause())
+}
+
+/*
+ * The filter flags cannot be resolved into a filter clause without querying
+ * the database, so this returns the entries of every user heap table instead.
+ */
+func GetHeapIncrementalMetadataForAllTables(connectionPool *dbconn.DBConn) map[string]utils.HeapEntry {
+	return getHeapIncrementalMetadata(connectionPool, systemSchemaFilterClause("n"))
+}
+
+func getHeapIncrementalMetadata(connectionPool *dbconn.DBConn, filterClause string) map[string]utils.HeapEntry {
+	heapTableEntries := make(map[string]utils.HeapEntry)
+	if connectionPool.Version.Before("6") {
+		gplog.Verbose("Heap tables are always included in incremental backups before GPDB 6")
+		return heapTableEntries
+	}
+	gplog.Verbose("Querying statistics reset timestamps on segments")
+	var statsResetTimestamps = getStatsResetTimestamps(connectionPool)
+	gplog.Verbose("Querying heap table file and statistics information on segments")
+	var segmentEntries = getHeapSegmentEntries(connectionPool, statsResetTimestamps, filterClause)
+	gplog.Verbose("Querying last DDL modification timestamp for heap tables")
+	var lastDDLTimestamps = getLastDDLTimestamps(connectionPool, "'h'", filterClause)
+	for heapTableFQN, segments := range segmentEntries {
+		heapTableEntries[heapTableFQN] = utils.HeapEntry{
+			LastDDLTimestamp: lastDDLTimestamps[heapTableFQN],
+			Segments:         segments,
+		}
+	}
+
+	return heapTableEntries
+}
+
+/*
+ * The timestamp is left empty for a segment whose counters cannot be trusted,
+ * either because statistics collection is disabled or because the collector
+ * has no entry for the database.
+ */
+func getStatsResetTimestamps(connectionPool *dbconn.DBConn) map[int]string {
+	query := `
+	SELECT
+		gp_segment_id AS contentid,
+		CASE
+			WHEN current_setting('track_counts') = 'on'
+			THEN coalesce(pg_stat_get_db_stat_reset_time(oid)::text, '')
+			ELSE ''
+		END AS statsresettimestamp
+	FROM
+		gp_dist_random('pg_database')
+	WHERE
+		datname = current_database()
+`
+	var results []struct {
+		ContentID           int
+		StatsResetTimestamp string
+	}
+	err := connectionPool.Select(&results, query)
+	gplog.FatalOnError(err)
+	resultMap := make(map[int]string)
+	for _, result := range results {
+		resultMap[result.ContentID] = result.StatsResetTimestamp
+	}
+	return resultMap
+}
+
+/*
+ * Tables with inheritance children, such as partitioned tables, are excluded,
+ * as their own files and counters do not reflect changes to their children.
+ */
+func getHeapSegmentEntries(connectionPool *dbconn.DBConn, statsResetTimestamps map[int]string, filterClause string) map[string]map[int]utils.HeapSegmentEntry {
+	query := fmt.Sprintf(`
+	SELECT
+		quote_ident(n.nspname) || '.' || quote_ident(c.relname) AS heaptablefqn,
+		seg.contentid,
+		seg.relfilenode,
+		seg.size,
+		seg.tuplesmodified
+	FROM
+		pg_class c
+	JOIN
+		pg_namespace n
+	ON
+		c.relnamespace = n.oid
+	JOIN
+		(
+			SELECT
+				gp_segment_id AS contentid,
+				oid,
+				relfilenode,
+				pg_relation_size(oid) AS size,
+				pg_stat_get_tuples_inserted(oid) + pg_stat_get_tuples_updated(oid) + pg_stat_get_tuples_deleted(oid) AS tuplesmodified
+			FROM
+				gp_dist_random('pg_class')
+			WHERE
+				relkind = 'r'
+			AND
+				relstorage = 'h'
+		) seg
+	ON
+		c.oid = seg.oid
+	WHERE
+		c.relkind = 'r'
+	AND
+		c.relstorage = 'h'
+	AND
+		NOT EXISTS (SELECT 1 FROM pg_inherits i WHERE i.inhparent = c.oid)
+	AND
+		%s
+`, filterClause)
+
+	var results []struct {
+		HeapTableFQN   string
+		ContentID      int
+		Relfilenode    uint32
+		Size           int64
+		TuplesModified int64
+	}
+	err := connectionPool.Select(&results, query)
+	gplog.FatalOnError(err)
+	resultMap := make(map[string]map[int]utils.HeapSegmentEntry)
+	for _, result := range results {
+		if resultMap[result.HeapTableFQN] == nil {
+			resultMap[result.HeapTableFQN] = make(map[int]utils.HeapSegmentEntry)
+		}
+		resultMap[result.HeapTableFQN][result.ContentID] = utils.HeapSegmentEntry{
+			Relfilenode:         result.Relfilenode,
+			Size:                result.Size,
+			TuplesModified:      result.TuplesModified,
+			StatsResetTimestamp: statsResetTimestamps[result.ContentID],
+		}
+	}
+	return resultMap
+}
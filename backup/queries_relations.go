@@ -45,6 +45,81 @@ WHERE quote_ident(n.nspname) || '.' || quote_ident(c.relname) IN (%s)`, relList)
 	return dbconn.MustSelectStringSlice(connectionPool, query)
 }
 
+/*
+ * Returns the quoted fully-qualified names of the tables that the table pattern
+ * flags may match, along with the unquoted name of each.  Intermediate
+ * partition tables cannot be filtered on, so they are not returned.
+ */
+func GetFilterableTableNames(connectionPool *dbconn.DBConn) ([]string, map[string]string) {
+	query := fmt.Sprintf(`
+SELECT
+	quote_ident(n.nspname) || '.' || quote_ident(c.relname) AS quotedname,
+	n.nspname || '.' || c.relname AS name
+FROM pg_class c
+JOIN pg_namespace n ON c.relnamespace = n.oid
+WHERE %s
+AND c.relkind = 'r'
+AND c.oid NOT IN (
+	SELECT
+		r.parchildrelid
+	FROM pg_partition p
+	JOIN pg_partition_rule r ON p.oid = r.paroid
+	JOIN (
+		SELECT
+			parrelid,
+			max(parlevel) AS pl
+		FROM pg_partition
+		GROUP BY parrelid
+	) AS levels ON p.parrelid = levels.parrelid
+	WHERE p.parlevel < levels.pl
+)
+AND %s
+ORDER BY n.nspname, c.relname`, SchemaFilterClause("n"), ExtensionFilterClause("c"))
+
+	results := make([]struct {
+		QuotedName string
+		Name       string
+	}, 0)
+	err := connectionPool.Select(&results, query)
+	gplog.FatalOnError(err)
+	quotedNames := make([]string, 0, len(results))
+	unquotedNames := make(map[string]string, len(results))
+	for _, result := range results {
+		quotedNames = append(quotedNames, result.QuotedName)
+		unquotedNames[result.QuotedName] = result.Name
+	}
+	return quotedNames, unquotedNames
+}
+
+// Potentially expensive query, as the size of each table is gathered from every segment
+func GetTableSizes(connectionPool *dbconn.DBConn, tables []Table) map[uint32]int64 {
+	sizes := make(map[uint32]int64, len(tables))
+	if len(tables) == 0 {
+		return sizes
+	}
+	oids := make([]string, len(tables))
+	for i, table := range tables {
+		oids[i] = fmt.Sprintf("%d", table.Oid)
+	}
+	query := fmt.Sprintf(`
+SELECT
+	oid,
+	pg_relation_size(oid) AS size
+FROM pg_class
+WHERE oid IN (%s)`, strings.Join(oids, ", "))
+
+	results := make([]struct {
+		Oid  uint32
+		Size int64
+	}, 0)
+	err := connectionPool.Select(&results, query)
+	gplog.FatalOnError(err)
+	for _, result := range results {
+		sizes[result.Oid] = result.Size
+	}
+	return sizes
+}
+
 func GetIncludedUserTableRelations(connectionPool *dbconn.DBConn, includedRelationsQuoted []string) []Relation {
 	if len(MustGetFlagStringArray(utils.INCLUDE_RELATION)) > 0 {
 		return GetUserTableRelationsWithIncludeFiltering(connectionPool, includedRelationsQuoted)
@@ -190,7 +190,23 @@ func SchemaFilterClause(namespace string) string {
 	if len(MustGetFlagStringSlice(utils.EXCLUDE_SCHEMA)) > 0 {
 		schemaFilterClauseStr = fmt.Sprintf("\nAND %s.nspname NOT IN (%s)", namespace, utils.SliceToQuotedString(MustGetFlagStringSlice(utils.EXCLUDE_SCHEMA)))
 	}
-	return fmt.Sprintf(`%s.nspname NOT LIKE 'pg_temp_%%' AND %s.nspname NOT LIKE 'pg_toast%%' AND %s.nspname NOT IN ('gp_toolkit', 'information_schema', 'pg_aoseg', 'pg_bitmapindex', 'pg_catalog') %s`, namespace, namespace, namespace, schemaFilterClauseStr)
+	return fmt.Sprintf(`%s %s`, systemSchemaFilterClause(namespace), schemaFilterClauseStr)
+}
+
+// The system schemas, which are never backed up, formatted for use in a WHERE clause
+func systemSchemaFilterClause(namespace string) string {
+	return fmt.Sprintf(`%s.nspname NOT LIKE 'pg_temp_%%' AND %s.nspname NOT LIKE 'pg_toast%%' AND %s.nspname NOT IN ('gp_toolkit', 'information_schema', 'pg_aoseg', 'pg_bitmapindex', 'pg_catalog')`, namespace, namespace, namespace)
+}
+
+// The names of the schemas that the schema pattern flags may match
+func GetSchemaNames(connectionPool *dbconn.DBConn) []string {
+	query := fmt.Sprintf(`
+SELECT
+	n.nspname AS string
+FROM pg_namespace n
+WHERE %s
+ORDER BY n.nspname`, systemSchemaFilterClause("n"))
+	return dbconn.MustSelectStringSlice(connectionPool, query)
 }
 
 func ExtensionFilterClause(namespace string) string {
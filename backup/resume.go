@@ -0,0 +1,208 @@
+package backup
+
+/*
+ * This file contains structs and functions for recording the progress of a
+ * backup so that it can be resumed with --resume after a failure.
+ *
+ * Once all metadata has been written, the backup config and the TOC are saved
+ * to a state file, and the oid and row count of each table are appended to a
+ * completed tables file as its data is backed up.  A resumed backup reuses the
+ * metadata file and the TOC from the state file and only backs up data for
+ * tables that are not listed as completed.
+ */
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/iohelper"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/backup_history"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+type BackupState struct {
+	Config backup_history.BackupConfig
+	TOC    utils.TOC
+}
+
+// The state file contains the TOC, so it is encrypted along with the other metadata files
+func WriteBackupState(filename string, config *backup_history.BackupConfig, toc *utils.TOC) {
+	stateFile := utils.MustOpenMetadataFileForWriting(filename)
+	stateContents, err := yaml.Marshal(BackupState{Config: *config, TOC: *toc})
+	gplog.FatalOnError(err)
+	utils.MustPrintBytes(stateFile, stateContents)
+	err = stateFile.Close()
+	gplog.FatalOnError(err)
+}
+
+func ReadBackupState(filename string) (*BackupState, error) {
+	contents, err := utils.ReadMetadataFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	state := &BackupState{}
+	err = yaml.Unmarshal(contents, state)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to parse backup state file %s", filename)
+	}
+	return state, nil
+}
+
+type CompletedTablesFile struct {
+	file  io.WriteCloser
+	mutex sync.Mutex
+}
+
+func OpenCompletedTablesFile(filename string) (*CompletedTablesFile, error) {
+	file, err := operating.System.OpenFileWrite(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to open completed tables file %s", filename)
+	}
+	return &CompletedTablesFile{file: file}, nil
+}
+
+// Each table is written as a single line, so that a table is either recorded in full or not at all
+func (completedFile *CompletedTablesFile) MarkTableComplete(oid uint32, rowsCopied int64) error {
+	completedFile.mutex.Lock()
+	defer completedFile.mutex.Unlock()
+	_, err := fmt.Fprintf(completedFile.file, "%d %d\n", oid, rowsCopied)
+	return err
+}
+
+func (completedFile *CompletedTablesFile) Close() error {
+	return completedFile.file.Close()
+}
+
+/*
+ * Returns the number of rows copied for each completed table, keyed by oid.
+ * Lines that cannot be parsed, such as a partial line written as the backup
+ * failed, are skipped.
+ */
+func ReadCompletedTables(filename string) (map[uint32]int64, error) {
+	completedTables := make(map[uint32]int64, 0)
+	if !iohelper.FileExistsAndIsReadable(filename) {
+		return completedTables, nil
+	}
+	lines, err := iohelper.ReadLinesFromFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		oid, oidErr := strconv.ParseUint(fields[0], 10, 32)
+		rowsCopied, rowsErr := strconv.ParseInt(fields[1], 10, 64)
+		if oidErr != nil || rowsErr != nil {
+			continue
+		}
+		completedTables[uint32(oid)] = rowsCopied
+	}
+	return completedTables, nil
+}
+
+func MatchesResumeFlags(stateConfig *backup_history.BackupConfig, currentConfig *backup_history.BackupConfig) bool {
+	return stateConfig.BackupDir == currentConfig.BackupDir &&
+		stateConfig.DatabaseName == currentConfig.DatabaseName &&
+		stateConfig.Plugin == currentConfig.Plugin &&
+		stateConfig.Compressed == currentConfig.Compressed &&
+		stateConfig.GetCompressionType() == currentConfig.GetCompressionType() &&
+		stateConfig.Encrypted == currentConfig.Encrypted &&
+		stateConfig.DataOnly == currentConfig.DataOnly &&
+		stateConfig.Incremental == currentConfig.Incremental &&
+		stateConfig.Differential == currentConfig.Differential &&
+		stateConfig.LeafPartitionData == currentConfig.LeafPartitionData &&
+		stateConfig.SingleDataFile == currentConfig.SingleDataFile &&
+		stateConfig.WithStatistics == currentConfig.WithStatistics &&
+		utils.NewIncludeSet(stateConfig.IncludeRelations).Equals(utils.NewIncludeSet(currentConfig.IncludeRelations)) &&
+		utils.NewIncludeSet(stateConfig.IncludeSchemas).Equals(utils.NewIncludeSet(currentConfig.IncludeSchemas)) &&
+		utils.NewIncludeSet(stateConfig.ExcludeRelations).Equals(utils.NewIncludeSet(currentConfig.ExcludeRelations)) &&
+		utils.NewIncludeSet(stateConfig.ExcludeSchemas).Equals(utils.NewIncludeSet(currentConfig.ExcludeSchemas))
+}
+
+func InitializeResume() {
+	timestamp := globalFPInfo.Timestamp
+	stateFilename := globalFPInfo.GetBackupStateFilePath()
+	if !iohelper.FileExistsAndIsReadable(stateFilename) {
+		gplog.Fatal(errors.Errorf("Backup %s cannot be resumed, as state file %s was not found.  Only backups that fail while backing up data to multiple data files can be resumed.", timestamp, stateFilename), "")
+	}
+	state, err := ReadBackupState(stateFilename)
+	gplog.FatalOnError(err)
+	if !MatchesResumeFlags(&state.Config, &backupReport.BackupConfig) {
+		gplog.Fatal(errors.Errorf("The flags of the backup with timestamp = %s do not match those of the current one.  "+
+			"The same flags must be used to resume a backup.", timestamp), "")
+	}
+	completedTables, err = ReadCompletedTables(globalFPInfo.GetCompletedTablesFilePath())
+	gplog.FatalOnError(err)
+
+	// These files are read-only if they were written before the failure, and will be written again
+	for _, filename := range []string{globalFPInfo.GetConfigFilePath(), globalFPInfo.GetBackupReportFilePath(),
+		globalFPInfo.GetBackupJSONReportFilePath(), globalFPInfo.GetChecksumFilePath(), globalFPInfo.GetTOCFilePath(),
+		globalFPInfo.GetStatisticsFilePath()} {
+		err = operating.System.Remove(filename)
+		if err != nil && !os.IsNotExist(err) {
+			gplog.Fatal(err, "Unable to remove file %s from the previous attempt", filename)
+		}
+	}
+
+	backupState = state
+	globalTOC = &state.TOC
+	globalTOC.InitializeMetadataEntryMap()
+	gplog.Info("Resuming backup %s with data for %d table(s) already backed up", timestamp, len(completedTables))
+	gplog.Warn("Tables backed up after resuming will reflect a later point in time than tables backed up before the failure")
+}
+
+/*
+ * The tables to back up are taken from the restore plan saved in the state
+ * file, so that a resumed incremental backup covers the same tables as the
+ * original attempt.
+ */
+func GetTablesForResume(tables []Table, restorePlan []backup_history.RestorePlanEntry) []Table {
+	tableFQNs := make(map[string]bool, 0)
+	for _, restorePlanEntry := range restorePlan {
+		if restorePlanEntry.Timestamp == globalFPInfo.Timestamp {
+			for _, fqn := range restorePlanEntry.TableFQNs {
+				tableFQNs[fqn] = true
+			}
+		}
+	}
+	resumeTables := make([]Table, 0)
+	for _, table := range tables {
+		if tableFQNs[table.FQN()] {
+			resumeTables = append(resumeTables, table)
+			delete(tableFQNs, table.FQN())
+		}
+	}
+	for fqn := range tableFQNs {
+		gplog.Warn("Table %s no longer exists and will not be included in the resumed backup", fqn)
+	}
+	return resumeTables
+}
+
+func FilterCompletedTables(tables []Table, completedTables map[uint32]int64) []Table {
+	remainingTables := make([]Table, 0)
+	for _, table := range tables {
+		if _, ok := completedTables[table.Oid]; !ok {
+			remainingTables = append(remainingTables, table)
+		}
+	}
+	return remainingTables
+}
+
+func RemoveBackupStateFiles() {
+	for _, filename := range []string{globalFPInfo.GetBackupStateFilePath(), globalFPInfo.GetCompletedTablesFilePath()} {
+		err := operating.System.Remove(filename)
+		if err != nil && !os.IsNotExist(err) {
+			gplog.Warn("Unable to remove file %s: %v", filename, err)
+		}
+	}
+}
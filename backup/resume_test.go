@@ -0,0 +1,132 @@
+package backup_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/backup"
+	"github.com/greenplum-db/gpbackup/backup_filepath"
+	"github.com/greenplum-db/gpbackup/backup_history"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("backup/resume tests", func() {
+	var tempDir string
+	BeforeEach(func() {
+		operating.System = operating.InitializeSystemFunctions()
+		tempDir, _ = ioutil.TempDir("", "resume")
+	})
+	AfterEach(func() {
+		_ = os.RemoveAll(tempDir)
+	})
+	Describe("WriteBackupState and ReadBackupState", func() {
+		It("round-trips the backup config and TOC", func() {
+			stateFilename := filepath.Join(tempDir, "gpbackup_20170101010101_state.yaml")
+			config := backup_history.BackupConfig{
+				Timestamp:   "20170101010101",
+				RestorePlan: []backup_history.RestorePlanEntry{{Timestamp: "20170101010101", TableFQNs: []string{"public.foo"}}},
+			}
+			toc := utils.TOC{PredataEntries: []utils.MetadataEntry{{Schema: "public", Name: "foo", ObjectType: "TABLE", StartByte: 0, EndByte: 20}}}
+
+			backup.WriteBackupState(stateFilename, &config, &toc)
+			state, err := backup.ReadBackupState(stateFilename)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(state.Config.Timestamp).To(Equal("20170101010101"))
+			Expect(state.Config.RestorePlan).To(Equal(config.RestorePlan))
+			Expect(state.TOC.PredataEntries).To(Equal(toc.PredataEntries))
+		})
+	})
+	Describe("CompletedTablesFile", func() {
+		It("appends completed tables to an existing file", func() {
+			completedFilename := filepath.Join(tempDir, "gpbackup_20170101010101_completed_tables")
+			_ = ioutil.WriteFile(completedFilename, []byte("1234 10\n"), 0644)
+
+			completedFile, err := backup.OpenCompletedTablesFile(completedFilename)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(completedFile.MarkTableComplete(5678, 20)).To(Succeed())
+			Expect(completedFile.Close()).To(Succeed())
+
+			completedTables, err := backup.ReadCompletedTables(completedFilename)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(completedTables).To(Equal(map[uint32]int64{1234: 10, 5678: 20}))
+		})
+	})
+	Describe("ReadCompletedTables", func() {
+		It("returns no tables if the file does not exist", func() {
+			completedTables, err := backup.ReadCompletedTables(filepath.Join(tempDir, "nonexistent"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(completedTables).To(BeEmpty())
+		})
+		It("skips lines that cannot be parsed", func() {
+			completedFilename := filepath.Join(tempDir, "gpbackup_20170101010101_completed_tables")
+			_ = ioutil.WriteFile(completedFilename, []byte("1234 10\nnot a table\n5678"), 0644)
+			completedTables, err := backup.ReadCompletedTables(completedFilename)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(completedTables).To(Equal(map[uint32]int64{1234: 10}))
+		})
+	})
+	Describe("MatchesResumeFlags", func() {
+		stateConfig := backup_history.BackupConfig{
+			BackupDir:       "/tmp",
+			DatabaseName:    "testdb",
+			Compressed:      true,
+			CompressionType: "gzip",
+			IncludeSchemas:  []string{"public", "foo"},
+		}
+		It("matches a config with the same flags", func() {
+			currentConfig := stateConfig
+			currentConfig.Timestamp = "20170101010102"
+			currentConfig.IncludeSchemas = []string{"foo", "public"}
+			Expect(backup.MatchesResumeFlags(&stateConfig, &currentConfig)).To(BeTrue())
+		})
+		It("does not match a config with a different compression type", func() {
+			currentConfig := stateConfig
+			currentConfig.CompressionType = "zstd"
+			Expect(backup.MatchesResumeFlags(&stateConfig, &currentConfig)).To(BeFalse())
+		})
+		It("does not match a config with different filters", func() {
+			currentConfig := stateConfig
+			currentConfig.IncludeSchemas = []string{"public"}
+			Expect(backup.MatchesResumeFlags(&stateConfig, &currentConfig)).To(BeFalse())
+		})
+	})
+	Describe("GetTablesForResume", func() {
+		tableFoo := backup.Table{Relation: backup.Relation{Oid: 1, Schema: "public", Name: "foo"}}
+		tableBar := backup.Table{Relation: backup.Relation{Oid: 2, Schema: "public", Name: "bar"}}
+		tableBaz := backup.Table{Relation: backup.Relation{Oid: 3, Schema: "public", Name: "baz"}}
+		BeforeEach(func() {
+			backup.SetFPInfo(backup_filepath.FilePathInfo{Timestamp: "20170101010101"})
+		})
+		It("returns the tables in the restore plan entry for the resumed backup", func() {
+			restorePlan := []backup_history.RestorePlanEntry{
+				{Timestamp: "20160101010101", TableFQNs: []string{"public.foo"}},
+				{Timestamp: "20170101010101", TableFQNs: []string{"public.bar", "public.baz"}},
+			}
+			tables := backup.GetTablesForResume([]backup.Table{tableFoo, tableBar, tableBaz}, restorePlan)
+			Expect(tables).To(Equal([]backup.Table{tableBar, tableBaz}))
+		})
+		It("skips tables that no longer exist", func() {
+			restorePlan := []backup_history.RestorePlanEntry{
+				{Timestamp: "20170101010101", TableFQNs: []string{"public.foo", "public.dropped"}},
+			}
+			tables := backup.GetTablesForResume([]backup.Table{tableFoo, tableBar}, restorePlan)
+			Expect(tables).To(Equal([]backup.Table{tableFoo}))
+			Expect(logfile).To(gbytes.Say("Table public.dropped no longer exists and will not be included in the resumed backup"))
+		})
+	})
+	Describe("FilterCompletedTables", func() {
+		It("removes tables that have already been backed up", func() {
+			tableFoo := backup.Table{Relation: backup.Relation{Oid: 1, Schema: "public", Name: "foo"}}
+			tableBar := backup.Table{Relation: backup.Relation{Oid: 2, Schema: "public", Name: "bar"}}
+			tables := backup.FilterCompletedTables([]backup.Table{tableFoo, tableBar}, map[uint32]int64{1: 10})
+			Expect(tables).To(Equal([]backup.Table{tableBar}))
+		})
+	})
+})
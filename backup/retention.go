@@ -0,0 +1,69 @@
+package backup
+
+import (
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/backup_filepath"
+	"github.com/greenplum-db/gpbackup/backup_history"
+	"github.com/greenplum-db/gpbackup/utils"
+)
+
+/*
+ * The current backup has already succeeded by the time we prune, so failures
+ * here are logged as errors rather than failing the backup.
+ */
+func PruneBackupsOutsideRetentionPolicy() {
+	policy := backup_history.RetentionPolicy{
+		FullBackups: MustGetFlagInt(utils.RETAIN_FULL_BACKUPS),
+		Days:        MustGetFlagInt(utils.RETAIN_DAYS),
+	}
+	if !policy.IsSet() {
+		return
+	}
+	historyFilePath := globalFPInfo.GetBackupHistoryFilePath()
+	history, err := backup_history.NewHistory(historyFilePath)
+	if err != nil {
+		gplog.Error("Unable to read history file %s to apply retention policy: %v", historyFilePath, err)
+		return
+	}
+	backupsToPrune := history.GetBackupsToPrune(&backupReport.BackupConfig, policy, operating.System.Now())
+	if len(backupsToPrune) == 0 {
+		gplog.Verbose("No backups found outside of the retention policy")
+		return
+	}
+
+	gplog.Info("Deleting %d backup(s) outside of the retention policy", len(backupsToPrune))
+	/*
+	 * Backups are ordered newest first, so incremental backups are always
+	 * deleted before the backups they depend on.  We stop at the first failure
+	 * so that a remaining backup never loses part of its restore plan.
+	 */
+	for _, backupConfig := range backupsToPrune {
+		if !pruneBackup(historyFilePath, backupConfig.Timestamp) {
+			gplog.Error("Stopping deletion of backups outside of the retention policy")
+			return
+		}
+	}
+	gplog.Info("Backups outside of the retention policy deleted")
+}
+
+func pruneBackup(historyFilePath string, timestamp string) bool {
+	gplog.Verbose("Deleting backup %s", timestamp)
+	if pluginConfig != nil {
+		err := pluginConfig.DeleteBackup(timestamp)
+		if err != nil {
+			gplog.Error(err.Error())
+			return false
+		}
+	}
+	fpInfo := backup_filepath.NewFilePathInfo(globalCluster, globalFPInfo.UserSpecifiedBackupDir, timestamp, globalFPInfo.UserSpecifiedSegPrefix)
+	if !backup_filepath.DeleteBackupDirectoriesOnAllHosts(globalCluster, fpInfo, true) {
+		return false
+	}
+	err := backup_history.MarkBackupDeleted(historyFilePath, timestamp)
+	if err != nil {
+		gplog.Error("Unable to mark backup %s as deleted in history file %s: %v", timestamp, historyFilePath, err)
+		return false
+	}
+	return true
+}
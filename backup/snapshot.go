@@ -0,0 +1,42 @@
+package backup
+
+/*
+ * This file contains functions for writing the metadata of a live database
+ * without taking a backup, so that it can be compared against the metadata
+ * of existing backups.
+ */
+
+import (
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/spf13/pflag"
+)
+
+/*
+ * The metadata is written to metadataFilename by the same functions, and with
+ * the same session GUCs, as the metadata of an unfiltered backup of the
+ * database, and the TOC for it is returned.  No backup directories, history
+ * entries, or data files are created.
+ */
+func WriteDatabaseMetadata(dbName string, metadataFilename string) *utils.TOC {
+	cmdFlags = pflag.NewFlagSet("gpbackup", pflag.ContinueOnError)
+	SetFlagDefaults(cmdFlags)
+	err := cmdFlags.Set(utils.DBNAME, dbName)
+	gplog.FatalOnError(err)
+	objectCounts = make(map[string]int, 0)
+	globalTOC = &utils.TOC{}
+	globalTOC.InitializeMetadataEntryMap()
+
+	InitializeConnectionPool()
+	defer connectionPool.Close()
+	gplog.Info("Gathering metadata of database %s", dbName)
+	metadataTables, _ := RetrieveAndProcessTables()
+	metadataFile := utils.NewFileWithByteCountFromFile(metadataFilename)
+	BackupSessionGUCs(metadataFile)
+	backupGlobal(metadataFile)
+	backupPredata(metadataFile, metadataTables, false)
+	backupPostdata(metadataFile)
+	metadataFile.Close()
+	connectionPool.MustCommit(0)
+	return globalTOC
+}
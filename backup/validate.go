@@ -97,22 +97,52 @@ func DBValidate(conn *dbconn.DBConn, tableList []string, excludeSet bool) {
 }
 
 func ValidateFlagCombinations(flags *pflag.FlagSet) {
+	if flags.Changed(utils.VERIFY) {
+		ValidateVerifyFlagCombinations(flags)
+		return
+	}
 	utils.CheckExclusiveFlags(flags, utils.DEBUG, utils.QUIET, utils.VERBOSE)
-	utils.CheckExclusiveFlags(flags, utils.DATA_ONLY, utils.METADATA_ONLY, utils.INCREMENTAL)
-	utils.CheckExclusiveFlags(flags, utils.INCLUDE_SCHEMA, utils.INCLUDE_RELATION, utils.INCLUDE_RELATION_FILE)
-	utils.CheckExclusiveFlags(flags, utils.EXCLUDE_SCHEMA, utils.INCLUDE_SCHEMA)
-	utils.CheckExclusiveFlags(flags, utils.EXCLUDE_SCHEMA, utils.EXCLUDE_RELATION, utils.INCLUDE_RELATION, utils.EXCLUDE_RELATION_FILE, utils.INCLUDE_RELATION_FILE)
+	utils.CheckExclusiveFlags(flags, utils.DATA_ONLY, utils.METADATA_ONLY, utils.INCREMENTAL, utils.DIFFERENTIAL)
+	utils.CheckExclusiveFilterFlags(flags)
 	utils.CheckExclusiveFlags(flags, utils.EXCLUDE_RELATION, utils.EXCLUDE_RELATION_FILE, utils.LEAF_PARTITION_DATA)
-	utils.CheckExclusiveFlags(flags, utils.JOBS, utils.METADATA_ONLY, utils.SINGLE_DATA_FILE)
+	utils.CheckExclusiveFlags(flags, utils.EXCLUDE_RELATION_PATTERN, utils.LEAF_PARTITION_DATA)
+	utils.CheckExclusiveFlags(flags, utils.JOBS, utils.METADATA_ONLY)
+	utils.CheckExclusiveFlags(flags, utils.METADATA_ONLY, utils.SINGLE_DATA_FILE)
 	utils.CheckExclusiveFlags(flags, utils.METADATA_ONLY, utils.LEAF_PARTITION_DATA)
 	utils.CheckExclusiveFlags(flags, utils.NO_COMPRESSION, utils.COMPRESSION_LEVEL)
+	utils.CheckExclusiveFlags(flags, utils.NO_COMPRESSION, utils.COMPRESSION_TYPE)
 	utils.CheckExclusiveFlags(flags, utils.PLUGIN_CONFIG, utils.BACKUP_DIR)
-	if MustGetFlagString(utils.FROM_TIMESTAMP) != "" && !MustGetFlagBool(utils.INCREMENTAL) {
-		gplog.Fatal(errors.Errorf("--from-timestamp must be specified with --incremental"), "")
+	utils.CheckExclusiveFlags(flags, utils.ENCRYPTION_KEY_FILE, utils.ENCRYPTION_KEY_CMD)
+	utils.CheckExclusiveFlags(flags, utils.RESUME, utils.SINGLE_DATA_FILE)
+	utils.CheckExclusiveFlags(flags, utils.RESUME, utils.METADATA_ONLY)
+	if MustGetFlagString(utils.FROM_TIMESTAMP) != "" && !MustGetFlagBool(utils.INCREMENTAL) && !MustGetFlagBool(utils.DIFFERENTIAL) {
+		gplog.Fatal(errors.Errorf("--from-timestamp must be specified with --incremental or --differential"), "")
 	}
 	if MustGetFlagBool(utils.INCREMENTAL) && !MustGetFlagBool(utils.LEAF_PARTITION_DATA) {
 		gplog.Fatal(errors.Errorf("--leaf-partition-data must be specified with --incremental"), "")
 	}
+	if MustGetFlagBool(utils.DIFFERENTIAL) && !MustGetFlagBool(utils.LEAF_PARTITION_DATA) {
+		gplog.Fatal(errors.Errorf("--leaf-partition-data must be specified with --differential"), "")
+	}
+	if MustGetFlagBool(utils.SINGLE_DATA_FILE) && MustGetFlagInt(utils.JOBS) > 1 && MustGetFlagString(utils.PLUGIN_CONFIG) != "" {
+		gplog.Fatal(errors.Errorf("--jobs cannot be used with --single-data-file when backing up using a plugin"), "")
+	}
+}
+
+/*
+ * --verify only reads an existing backup set, so flags that control what is
+ * backed up or how it is written are not meaningful alongside it.
+ */
+func ValidateVerifyFlagCombinations(flags *pflag.FlagSet) {
+	utils.CheckExclusiveFlags(flags, utils.DEBUG, utils.QUIET, utils.VERBOSE)
+	utils.CheckExclusiveFlags(flags, utils.PLUGIN_CONFIG, utils.BACKUP_DIR)
+	utils.CheckExclusiveFlags(flags, utils.ENCRYPTION_KEY_FILE, utils.ENCRYPTION_KEY_CMD)
+	allowedFlags := utils.NewSet([]string{utils.BACKUP_DIR, utils.DBNAME, utils.DEBUG, utils.ENCRYPTION_KEY_CMD, utils.ENCRYPTION_KEY_FILE, utils.PLUGIN_CONFIG, utils.QUIET, utils.VERBOSE, utils.VERIFY})
+	flags.Visit(func(flag *pflag.Flag) {
+		if !allowedFlags.MatchesFilter(flag.Name) {
+			gplog.Fatal(errors.Errorf("--%s cannot be used with --verify", flag.Name), "")
+		}
+	})
 }
 
 func ValidateFlagValues() {
@@ -120,11 +150,31 @@ func ValidateFlagValues() {
 	gplog.FatalOnError(err)
 	err = utils.ValidateFullPath(MustGetFlagString(utils.PLUGIN_CONFIG))
 	gplog.FatalOnError(err)
+	err = utils.ValidateFullPath(MustGetFlagString(utils.HOOK_CONFIG))
+	gplog.FatalOnError(err)
+	err = utils.ValidatePatternFlags(cmdFlags)
+	gplog.FatalOnError(err)
 	ValidateCompressionLevel(MustGetFlagInt(utils.COMPRESSION_LEVEL))
+	_, err = utils.NewCompressionProgram(MustGetFlagString(utils.COMPRESSION_TYPE), MustGetFlagInt(utils.COMPRESSION_LEVEL))
+	gplog.FatalOnError(err)
+	// These flags default to 0 to mean that no limit or policy is set, so 0 may not be given explicitly
+	for _, numericFlag := range []string{utils.RETAIN_DAYS, utils.RETAIN_FULL_BACKUPS, utils.MAX_BANDWIDTH} {
+		if MustGetFlagInt(numericFlag) < 0 || (cmdFlags.Changed(numericFlag) && MustGetFlagInt(numericFlag) == 0) {
+			gplog.Fatal(errors.Errorf("--%s must be a positive number", numericFlag), "")
+		}
+	}
+	if MustGetFlagString(utils.VERIFY) != "" && !backup_filepath.IsValidTimestamp(MustGetFlagString(utils.VERIFY)) {
+		gplog.Fatal(errors.Errorf("Timestamp %s is invalid.  Timestamps must be in the format YYYYMMDDHHMMSS.",
+			MustGetFlagString(utils.VERIFY)), "")
+	}
 	if MustGetFlagString(utils.FROM_TIMESTAMP) != "" && !backup_filepath.IsValidTimestamp(MustGetFlagString(utils.FROM_TIMESTAMP)) {
 		gplog.Fatal(errors.Errorf("Timestamp %s is invalid.  Timestamps must be in the format YYYYMMDDHHMMSS.",
 			MustGetFlagString(utils.FROM_TIMESTAMP)), "")
 	}
+	if MustGetFlagString(utils.RESUME) != "" && !backup_filepath.IsValidTimestamp(MustGetFlagString(utils.RESUME)) {
+		gplog.Fatal(errors.Errorf("Timestamp %s is invalid.  Timestamps must be in the format YYYYMMDDHHMMSS.",
+			MustGetFlagString(utils.RESUME)), "")
+	}
 }
 
 func ValidateCompressionLevel(compressionLevel int) {
@@ -147,4 +197,8 @@ func ValidateFromTimestamp(fromTimestamp string) {
 			"that of the current one. Please refer to the report to view the flags supplied for the"+
 			"previous backup.", fromTimestampFPInfo.Timestamp), "")
 	}
+	if backupReport.Differential && fromBackupConfig.Incremental {
+		gplog.Fatal(errors.Errorf("The backup with timestamp = %s is not a full backup.  A differential backup "+
+			"must be based on a full backup.", fromTimestampFPInfo.Timestamp), "")
+	}
 }
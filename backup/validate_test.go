@@ -193,4 +193,26 @@ var _ = Describe("backup/validate tests", func() {
 			backup.ValidateCompressionLevel(compressLevel)
 		})
 	})
+	Describe("ValidateFlagValues", func() {
+		It("passes if the retention flags are given positive numbers", func() {
+			_ = cmdFlags.Set(utils.RETAIN_DAYS, "7")
+			_ = cmdFlags.Set(utils.RETAIN_FULL_BACKUPS, "2")
+			backup.ValidateFlagValues()
+		})
+		It("panics if a retention flag is given a negative number", func() {
+			_ = cmdFlags.Set(utils.RETAIN_DAYS, "-1")
+			defer testhelper.ShouldPanicWithMessage("--retain-days must be a positive number")
+			backup.ValidateFlagValues()
+		})
+		It("panics if a retention flag is given 0", func() {
+			_ = cmdFlags.Set(utils.RETAIN_FULL_BACKUPS, "0")
+			defer testhelper.ShouldPanicWithMessage("--retain-full-backups must be a positive number")
+			backup.ValidateFlagValues()
+		})
+		It("panics if --max-bandwidth is given 0", func() {
+			_ = cmdFlags.Set(utils.MAX_BANDWIDTH, "0")
+			defer testhelper.ShouldPanicWithMessage("--max-bandwidth must be a positive number")
+			backup.ValidateFlagValues()
+		})
+	})
 })
@@ -0,0 +1,474 @@
+package backup
+
+/*
+ * This file contains functions for checking that an existing backup set is
+ * complete and readable without restoring it.
+ */
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/iohelper"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/backup_filepath"
+	"github.com/greenplum-db/gpbackup/backup_history"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+/*
+ * The result of checking the data for one table on one segment.  An empty Err
+ * means the check passed.
+ */
+type DataFileResult struct {
+	ContentID int
+	Timestamp string
+	Oid       uint32
+	Table     string
+	Err       string
+}
+
+func DoVerify() {
+	timestamp := MustGetFlagString(utils.VERIFY)
+	SetLoggerVerbosity()
+	gplog.Info("Verifying backup with timestamp %s", timestamp)
+
+	// We only need the connection to find the segment configuration
+	connectionPool = dbconn.NewDBConnFromEnvironment(MustGetFlagString(utils.DBNAME))
+	connectionPool.MustConnect(1)
+	utils.ValidateGPDBVersionCompatibility(connectionPool)
+	segConfig := cluster.MustGetSegmentConfiguration(connectionPool)
+	globalCluster = cluster.NewCluster(segConfig)
+
+	fpInfo := getFPInfoForVerify(timestamp)
+	if MustGetFlagString(utils.PLUGIN_CONFIG) != "" {
+		setupPluginForVerify(fpInfo)
+		defer func() {
+			pluginConfig.CleanupPluginForRestore(globalCluster, fpInfo)
+			pluginConfig.DeletePluginConfigWhenEncrypting(globalCluster)
+		}()
+	}
+	if !iohelper.FileExistsAndIsReadable(fpInfo.GetConfigFilePath()) {
+		gplog.Fatal(errors.Errorf("Config file %s not found for backup %s", fpInfo.GetConfigFilePath(), timestamp), "")
+	}
+	backupConfig := backup_history.ReadConfigFile(fpInfo.GetConfigFilePath())
+	utils.InitializePipeThroughParameters(backupConfig.Compressed, backupConfig.GetCompressionType(), 0)
+	utils.InitializeEncryptionKey(MustGetFlagString(utils.ENCRYPTION_KEY_FILE), MustGetFlagString(utils.ENCRYPTION_KEY_CMD))
+	if backupConfig.Encrypted && utils.GetEncryptionKey() == nil {
+		gplog.Fatal(errors.Errorf("Backup %s is encrypted. The --%s or --%s flag must be used to verify it.", timestamp, utils.ENCRYPTION_KEY_FILE, utils.ENCRYPTION_KEY_CMD), "")
+	}
+
+	gplog.Info("Verifying metadata file")
+	metadataErrors := verifyMetadataFile(fpInfo)
+
+	dataResults := make([]DataFileResult, 0)
+	if !backupConfig.MetadataOnly {
+		if utils.GetEncryptionKey() != nil {
+			utils.CopyEncryptionKeyToSegments(globalCluster, fpInfo)
+			defer utils.RemoveEncryptionKeyFromSegments(globalCluster, fpInfo)
+		}
+		restorePlan := backupConfig.RestorePlan
+		if len(restorePlan) == 0 {
+			// Backups taken before restore plans were recorded contain all of their own data
+			restorePlan = []backup_history.RestorePlanEntry{{Timestamp: timestamp}}
+		}
+		for _, restorePlanEntry := range restorePlan {
+			gplog.Info("Verifying data files for timestamp %s", restorePlanEntry.Timestamp)
+			entryFPInfo := fpInfo
+			if restorePlanEntry.Timestamp != timestamp {
+				entryFPInfo = getFPInfoForVerify(restorePlanEntry.Timestamp)
+			}
+			dataEntries := getDataEntriesForVerify(entryFPInfo, restorePlanEntry, backupConfig.SingleDataFile)
+			dataResults = append(dataResults, VerifyDataFiles(entryFPInfo, dataEntries, backupConfig)...)
+		}
+	}
+
+	if !PrintVerifyResults(operating.System.Stdout, metadataErrors, dataResults) {
+		gplog.Fatal(errors.Errorf("Backup %s failed verification", timestamp), "")
+	}
+}
+
+func getFPInfoForVerify(timestamp string) backup_filepath.FilePathInfo {
+	backupDir := MustGetFlagString(utils.BACKUP_DIR)
+	segPrefix := backup_filepath.ParseSegPrefix(backupDir, timestamp)
+	return backup_filepath.NewFilePathInfo(globalCluster, backupDir, timestamp, segPrefix)
+}
+
+func setupPluginForVerify(fpInfo backup_filepath.FilePathInfo) {
+	var err error
+	pluginConfig, err = utils.ReadPluginConfig(MustGetFlagString(utils.PLUGIN_CONFIG))
+	gplog.FatalOnError(err)
+	pluginConfig.CheckPluginExistsOnAllHosts(globalCluster)
+
+	historicalPluginVersion := ""
+	if iohelper.FileExistsAndIsReadable(fpInfo.GetBackupHistoryFilePath()) {
+		history, err := backup_history.NewHistory(fpInfo.GetBackupHistoryFilePath())
+		gplog.FatalOnError(err)
+		if backupConfig := history.FindBackupConfig(fpInfo.Timestamp); backupConfig != nil {
+			historicalPluginVersion = backupConfig.PluginVersion
+		}
+	}
+	pluginConfig.SetBackupPluginVersion(fpInfo.Timestamp, historicalPluginVersion)
+	pluginConfig.CopyPluginConfigToAllHosts(globalCluster)
+	pluginConfig.SetupPluginForRestore(globalCluster, fpInfo)
+
+	pluginConfig.MustRestoreFile(fpInfo.GetConfigFilePath())
+	pluginConfig.MustRestoreFile(fpInfo.GetMetadataFilePath())
+}
+
+func getDataEntriesForVerify(fpInfo backup_filepath.FilePathInfo, restorePlanEntry backup_history.RestorePlanEntry, singleDataFile bool) []utils.MasterDataEntry {
+	if pluginConfig != nil {
+		pluginConfig.MustRestoreFile(fpInfo.GetTOCFilePath())
+		if singleDataFile {
+			pluginConfig.RestoreSegmentTOCs(globalCluster, fpInfo)
+		}
+	}
+	toc := utils.NewTOC(fpInfo.GetTOCFilePath())
+	if restorePlanEntry.TableFQNs == nil {
+		return toc.DataEntries
+	}
+	return toc.GetDataEntriesMatching([]string{}, []string{}, []string{}, []string{}, restorePlanEntry.TableFQNs)
+}
+
+func verifyMetadataFile(fpInfo backup_filepath.FilePathInfo) []string {
+	metadataFilename := fpInfo.GetMetadataFilePath()
+	var metadataFileSize uint64
+	if utils.GetEncryptionKey() != nil {
+		// TOC byte offsets refer to the decrypted metadata
+		contents, err := utils.ReadMetadataFile(metadataFilename)
+		if err != nil {
+			return []string{fmt.Sprintf("Metadata file %s could not be read: %v", metadataFilename, err)}
+		}
+		metadataFileSize = uint64(len(contents))
+	} else {
+		metadataFileInfo, err := operating.System.Stat(metadataFilename)
+		if err != nil {
+			return []string{fmt.Sprintf("Metadata file %s could not be read: %v", metadataFilename, err)}
+		}
+		metadataFileSize = uint64(metadataFileInfo.Size())
+	}
+	toc := utils.NewTOC(fpInfo.GetTOCFilePath())
+	return VerifyMetadataByteOffsets(toc, metadataFileSize)
+}
+
+/*
+ * Every global, pre-data, and post-data TOC entry points into metadata.sql, so
+ * an entry outside the file means the file was truncated or does not belong
+ * to this TOC.
+ */
+func VerifyMetadataByteOffsets(toc *utils.TOC, metadataFileSize uint64) []string {
+	metadataErrors := make([]string, 0)
+	for _, entries := range [][]utils.MetadataEntry{toc.GlobalEntries, toc.PredataEntries, toc.PostdataEntries} {
+		for _, entry := range entries {
+			if entry.StartByte > entry.EndByte || entry.EndByte > metadataFileSize {
+				metadataErrors = append(metadataErrors, fmt.Sprintf("%s %s: byte range %d-%d is not within the metadata file (%d bytes)",
+					entry.ObjectType, utils.MakeFQN(entry.Schema, entry.Name), entry.StartByte, entry.EndByte, metadataFileSize))
+			}
+		}
+	}
+	return metadataErrors
+}
+
+func VerifyDataFiles(fpInfo backup_filepath.FilePathInfo, dataEntries []utils.MasterDataEntry, backupConfig *backup_history.BackupConfig) []DataFileResult {
+	if len(dataEntries) == 0 {
+		gplog.Verbose("No data files to verify for timestamp %s", fpInfo.Timestamp)
+		return []DataFileResult{}
+	}
+	if backupConfig.SingleDataFile {
+		results := make([]DataFileResult, 0)
+		for shard, shardEntries := range utils.GetDataEntriesByShard(dataEntries) {
+			if len(shardEntries) > 0 {
+				results = append(results, verifySingleDataFiles(fpInfo.ForShard(shard), shardEntries, backupConfig.Compressed)...)
+			}
+		}
+		return results
+	}
+	return verifyTableDataFiles(fpInfo, dataEntries, backupConfig.Compressed)
+}
+
+func verifyTableDataFiles(fpInfo backup_filepath.FilePathInfo, dataEntries []utils.MasterDataEntry, compressed bool) []DataFileResult {
+	remoteOutput := globalCluster.GenerateAndExecuteCommand("Verifying data files", func(contentID int) string {
+		return GetDataFileVerifyScript(fpInfo, contentID, dataEntries, compressed)
+	}, cluster.ON_SEGMENTS)
+
+	results := make([]DataFileResult, 0)
+	for _, contentID := range getSortedSegmentContentIDs() {
+		errorsByOid := ParseDataFileVerifyOutput(remoteOutput.Stdouts[contentID])
+		for _, entry := range dataEntries {
+			errStr, checked := errorsByOid[entry.Oid]
+			if !checked {
+				errStr = fmt.Sprintf("data file could not be checked: %s", getRemoteErrorString(remoteOutput, contentID))
+			}
+			results = append(results, newDataFileResult(fpInfo, contentID, entry, errStr))
+		}
+	}
+	return results
+}
+
+/*
+ * Single data file backups are checked by reading each segment TOC back to the
+ * master and comparing its byte ranges against the size of the data stream.
+ * Backups taken with multiple jobs are checked one shard at a time.
+ */
+func verifySingleDataFiles(fpInfo backup_filepath.FilePathInfo, dataEntries []utils.MasterDataEntry, compressed bool) []DataFileResult {
+	tocOutput := globalCluster.GenerateAndExecuteCommand("Reading segment TOC files", func(contentID int) string {
+		return fmt.Sprintf("cat %s", fpInfo.GetSegmentTOCFilePath(contentID))
+	}, cluster.ON_SEGMENTS)
+	sizeOutput := globalCluster.GenerateAndExecuteCommand("Verifying data files", func(contentID int) string {
+		return GetSingleDataFileSizeScript(fpInfo, contentID, compressed)
+	}, cluster.ON_SEGMENTS)
+
+	results := make([]DataFileResult, 0)
+	for _, contentID := range getSortedSegmentContentIDs() {
+		errorsByOid := make(map[uint32]string, 0)
+		segmentErr := ""
+		segmentTOC := &utils.SegmentTOC{}
+		dataSize, sizeErr := strconv.ParseUint(strings.TrimSpace(sizeOutput.Stdouts[contentID]), 10, 64)
+		if tocOutput.Errors[contentID] != nil {
+			segmentErr = fmt.Sprintf("segment TOC file %s could not be read: %s", fpInfo.GetSegmentTOCFilePath(contentID), getRemoteErrorString(tocOutput, contentID))
+		} else if err := yaml.Unmarshal([]byte(tocOutput.Stdouts[contentID]), segmentTOC); err != nil {
+			segmentErr = fmt.Sprintf("segment TOC file %s could not be parsed: %v", fpInfo.GetSegmentTOCFilePath(contentID), err)
+		} else if sizeOutput.Errors[contentID] != nil || sizeErr != nil {
+			segmentErr = fmt.Sprintf("data file %s could not be read: %s", fpInfo.GetTableBackupFilePath(contentID, 0, getDataFileExtension(compressed), true), getRemoteErrorString(sizeOutput, contentID))
+		} else {
+			errorsByOid = VerifySegmentTOC(segmentTOC, dataEntries, dataSize)
+		}
+		for _, entry := range dataEntries {
+			errStr := errorsByOid[entry.Oid]
+			if segmentErr != "" {
+				errStr = segmentErr
+			}
+			results = append(results, newDataFileResult(fpInfo, contentID, entry, errStr))
+		}
+	}
+	return results
+}
+
+/*
+ * The helper writes table data back to back, so the byte ranges in a segment
+ * TOC must start at 0, follow one another without gaps or overlaps, and end
+ * within the decompressed data file.  Returns an error string for each table
+ * whose range fails these checks.
+ */
+func VerifySegmentTOC(segmentTOC *utils.SegmentTOC, dataEntries []utils.MasterDataEntry, dataSize uint64) map[uint32]string {
+	errorsByOid := make(map[uint32]string, 0)
+	oids := make([]uint, 0)
+	for oid := range segmentTOC.DataEntries {
+		oids = append(oids, oid)
+	}
+	sort.Slice(oids, func(i int, j int) bool {
+		return segmentTOC.DataEntries[oids[i]].StartByte < segmentTOC.DataEntries[oids[j]].StartByte
+	})
+
+	var expectedStart uint64
+	for _, oid := range oids {
+		entry := segmentTOC.DataEntries[oid]
+		if entry.StartByte != expectedStart {
+			errorsByOid[uint32(oid)] = fmt.Sprintf("byte range %d-%d is not contiguous with the previous range ending at %d", entry.StartByte, entry.EndByte, expectedStart)
+		} else if entry.EndByte < entry.StartByte {
+			errorsByOid[uint32(oid)] = fmt.Sprintf("byte range %d-%d ends before it starts", entry.StartByte, entry.EndByte)
+		} else if entry.EndByte > dataSize {
+			errorsByOid[uint32(oid)] = fmt.Sprintf("byte range %d-%d is not within the data file (%d bytes)", entry.StartByte, entry.EndByte, dataSize)
+		}
+		expectedStart = entry.EndByte
+	}
+
+	for _, entry := range dataEntries {
+		if _, ok := segmentTOC.DataEntries[uint(entry.Oid)]; !ok {
+			errorsByOid[entry.Oid] = "table not found in segment TOC"
+		}
+	}
+	return errorsByOid
+}
+
+/*
+ * Builds a script that prints one line per table of the form "<oid> ok" or
+ * "<oid> <error>", so that one missing or corrupt file does not prevent the
+ * remaining files on the segment from being checked.
+ */
+func GetDataFileVerifyScript(fpInfo backup_filepath.FilePathInfo, contentID int, dataEntries []utils.MasterDataEntry, compressed bool) string {
+	readFailureStr := "data file could not be read"
+	if pluginConfig != nil {
+		readFailureStr = "data file could not be restored using plugin"
+	}
+	if utils.GetEncryptionKey() != nil {
+		readFailureStr += " or decrypted"
+	}
+	if compressed {
+		readFailureStr += " or decompressed"
+	}
+	readCheck := fmt.Sprintf(`%s > /dev/null 2>&1; then echo "$1 ok"; else echo "$1 %s"; fi`, getDataReadCommand(fpInfo, contentID, "$2", compressed), readFailureStr)
+	checkStr := fmt.Sprintf("if %s", readCheck)
+	if pluginConfig == nil {
+		// Files can only be checked for existence when they are stored on the segments
+		checkStr = fmt.Sprintf(`if [ ! -f $2 ]; then echo "$1 data file not found"; elif %s`, readCheck)
+	}
+
+	commands := append(getVerifyScriptPreamble(), fmt.Sprintf("check() { %s; }", checkStr))
+	extension := getDataFileExtension(compressed)
+	for _, entry := range dataEntries {
+		commands = append(commands, fmt.Sprintf("check %d %s", entry.Oid, fpInfo.GetTableBackupFilePath(contentID, entry.Oid, extension, false)))
+	}
+	return strings.Join(commands, "; ")
+}
+
+// Prints the number of bytes in the decompressed data stream for a single data file backup
+func GetSingleDataFileSizeScript(fpInfo backup_filepath.FilePathInfo, contentID int, compressed bool) string {
+	dataFilename := fpInfo.GetTableBackupFilePath(contentID, 0, getDataFileExtension(compressed), true)
+	commands := append(getVerifyScriptPreamble(), fmt.Sprintf("%s | wc -c", getDataReadCommand(fpInfo, contentID, dataFilename, compressed)))
+	return strings.Join(commands, "; ")
+}
+
+func getVerifyScriptPreamble() []string {
+	commands := []string{"set -o pipefail"}
+	if pluginConfig != nil {
+		commands = append(commands, fmt.Sprintf("source %s/greenplum_path.sh", operating.System.Getenv("GPHOME")))
+	}
+	return commands
+}
+
+func getDataReadCommand(fpInfo backup_filepath.FilePathInfo, contentID int, dataFilename string, compressed bool) string {
+	readCommand := fmt.Sprintf("cat %s", dataFilename)
+	if pluginConfig != nil {
+		readCommand = fmt.Sprintf("%s restore_data %s %s", pluginConfig.ExecutablePath, pluginConfig.ConfigPath, dataFilename)
+	}
+	if utils.GetEncryptionKey() != nil {
+		readCommand += fmt.Sprintf(" | %s", utils.GetEncryptionFilterCommand(true, fpInfo.GetSegmentEncryptionKeyFilePath(contentID)))
+	}
+	if compressed {
+		readCommand += fmt.Sprintf(" | %s", utils.GetPipeThroughProgram().InputCommand)
+	}
+	return readCommand
+}
+
+func getDataFileExtension(compressed bool) string {
+	if compressed {
+		return utils.GetPipeThroughProgram().Extension
+	}
+	return ""
+}
+
+func ParseDataFileVerifyOutput(output string) map[uint32]string {
+	errorsByOid := make(map[uint32]string, 0)
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		oid, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			continue
+		}
+		if fields[1] == "ok" {
+			errorsByOid[uint32(oid)] = ""
+		} else {
+			errorsByOid[uint32(oid)] = fields[1]
+		}
+	}
+	return errorsByOid
+}
+
+func getRemoteErrorString(remoteOutput *cluster.RemoteOutput, contentID int) string {
+	if errStr := strings.TrimSpace(remoteOutput.Stderrs[contentID]); errStr != "" {
+		return errStr
+	}
+	if remoteOutput.Errors[contentID] != nil {
+		return remoteOutput.Errors[contentID].Error()
+	}
+	return "no output from segment"
+}
+
+func getSortedSegmentContentIDs() []int {
+	contentIDs := make([]int, 0)
+	for contentID := range globalCluster.Segments {
+		if contentID != -1 {
+			contentIDs = append(contentIDs, contentID)
+		}
+	}
+	sort.Ints(contentIDs)
+	return contentIDs
+}
+
+func newDataFileResult(fpInfo backup_filepath.FilePathInfo, contentID int, entry utils.MasterDataEntry, errStr string) DataFileResult {
+	return DataFileResult{
+		ContentID: contentID,
+		Timestamp: fpInfo.Timestamp,
+		Oid:       entry.Oid,
+		Table:     utils.MakeFQN(entry.Schema, entry.Name),
+		Err:       errStr,
+	}
+}
+
+/*
+ * Prints a summary of the metadata check, a per-segment summary, and a
+ * per-table summary listing the segments on which each failing table failed.
+ * Returns whether every check passed.
+ */
+func PrintVerifyResults(output io.Writer, metadataErrors []string, dataResults []DataFileResult) bool {
+	passed := len(metadataErrors) == 0
+	if passed {
+		utils.MustPrintf(output, "Metadata: Passed\n")
+	} else {
+		utils.MustPrintf(output, "Metadata: Failed\n")
+		for _, metadataError := range metadataErrors {
+			utils.MustPrintf(output, "  %s\n", metadataError)
+		}
+	}
+	if len(dataResults) == 0 {
+		return passed
+	}
+
+	type tableKey struct {
+		timestamp string
+		table     string
+	}
+	segmentCounts := make(map[int][]int, 0)
+	contentIDs := make([]int, 0)
+	tableErrors := make(map[tableKey][]string, 0)
+	tables := make([]tableKey, 0)
+	for _, result := range dataResults {
+		if _, ok := segmentCounts[result.ContentID]; !ok {
+			segmentCounts[result.ContentID] = []int{0, 0}
+			contentIDs = append(contentIDs, result.ContentID)
+		}
+		key := tableKey{result.Timestamp, result.Table}
+		if _, ok := tableErrors[key]; !ok {
+			tableErrors[key] = make([]string, 0)
+			tables = append(tables, key)
+		}
+		if result.Err == "" {
+			segmentCounts[result.ContentID][0]++
+		} else {
+			segmentCounts[result.ContentID][1]++
+			tableErrors[key] = append(tableErrors[key], fmt.Sprintf("segment %d: %s", result.ContentID, result.Err))
+			passed = false
+		}
+	}
+	sort.Ints(contentIDs)
+
+	writer := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	utils.MustPrintf(writer, "\nSEGMENT\tTABLES\tPASSED\tFAILED\n")
+	for _, contentID := range contentIDs {
+		counts := segmentCounts[contentID]
+		utils.MustPrintf(writer, "%d\t%d\t%d\t%d\n", contentID, counts[0]+counts[1], counts[0], counts[1])
+	}
+	utils.MustPrintf(writer, "\nTABLE\tTIMESTAMP\tSTATUS\n")
+	for _, key := range tables {
+		status := "Passed"
+		if len(tableErrors[key]) > 0 {
+			status = fmt.Sprintf("Failed (%s)", strings.Join(tableErrors[key], "; "))
+		}
+		utils.MustPrintf(writer, "%s\t%s\t%s\n", key.table, key.timestamp, status)
+	}
+	_ = writer.Flush()
+	return passed
+}
@@ -0,0 +1,204 @@
+package backup_test
+
+import (
+	"errors"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/backup"
+	"github.com/greenplum-db/gpbackup/backup_filepath"
+	"github.com/greenplum-db/gpbackup/backup_history"
+	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/utils"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("backup/verify tests", func() {
+	var (
+		testCluster  *cluster.Cluster
+		testExecutor *testhelper.TestExecutor
+		fpInfo       backup_filepath.FilePathInfo
+		dataEntries  []utils.MasterDataEntry
+	)
+	BeforeEach(func() {
+		testExecutor = &testhelper.TestExecutor{ClusterOutput: &cluster.RemoteOutput{}}
+		testCluster = testutils.SetDefaultSegmentConfiguration()
+		testCluster.Executor = testExecutor
+		backup.SetCluster(testCluster)
+		backup.SetPluginConfig(nil)
+		utils.SetPipeThroughProgram(utils.PipeThroughProgram{Name: "gzip", OutputCommand: "gzip -c -1", InputCommand: "gzip -d -c", Extension: ".gz"})
+		fpInfo = backup_filepath.NewFilePathInfo(testCluster, "", "20170101010101", "")
+		dataEntries = []utils.MasterDataEntry{
+			{Schema: "public", Name: "foo", Oid: 16384},
+			{Schema: "public", Name: "bar", Oid: 16385},
+		}
+	})
+	Describe("VerifyMetadataByteOffsets", func() {
+		It("passes if every entry is within the metadata file", func() {
+			toc := &utils.TOC{
+				GlobalEntries:   []utils.MetadataEntry{{Name: "testdb", ObjectType: "DATABASE", StartByte: 0, EndByte: 10}},
+				PredataEntries:  []utils.MetadataEntry{{Schema: "public", Name: "foo", ObjectType: "TABLE", StartByte: 10, EndByte: 50}},
+				PostdataEntries: []utils.MetadataEntry{{Schema: "public", Name: "foo_idx", ObjectType: "INDEX", StartByte: 50, EndByte: 80}},
+			}
+			Expect(backup.VerifyMetadataByteOffsets(toc, 80)).To(BeEmpty())
+		})
+		It("reports entries that extend past the end of the metadata file", func() {
+			toc := &utils.TOC{
+				PredataEntries:  []utils.MetadataEntry{{Schema: "public", Name: "foo", ObjectType: "TABLE", StartByte: 10, EndByte: 50}},
+				PostdataEntries: []utils.MetadataEntry{{Schema: "public", Name: "foo_idx", ObjectType: "INDEX", StartByte: 50, EndByte: 80}},
+			}
+			Expect(backup.VerifyMetadataByteOffsets(toc, 60)).To(Equal([]string{"INDEX public.foo_idx: byte range 50-80 is not within the metadata file (60 bytes)"}))
+		})
+		It("reports entries that end before they start", func() {
+			toc := &utils.TOC{
+				PredataEntries: []utils.MetadataEntry{{Schema: "public", Name: "foo", ObjectType: "TABLE", StartByte: 50, EndByte: 10}},
+			}
+			Expect(backup.VerifyMetadataByteOffsets(toc, 60)).To(HaveLen(1))
+		})
+	})
+	Describe("VerifySegmentTOC", func() {
+		var segmentTOC *utils.SegmentTOC
+		BeforeEach(func() {
+			segmentTOC = &utils.SegmentTOC{DataEntries: map[uint]utils.SegmentDataEntry{
+				16384: {StartByte: 0, EndByte: 100},
+				16385: {StartByte: 100, EndByte: 250},
+			}}
+		})
+		It("passes if the byte ranges are contiguous and within the data file", func() {
+			Expect(backup.VerifySegmentTOC(segmentTOC, dataEntries, 250)).To(BeEmpty())
+		})
+		It("reports a byte range that extends past the end of the data file", func() {
+			Expect(backup.VerifySegmentTOC(segmentTOC, dataEntries, 200)).To(Equal(map[uint32]string{
+				16385: "byte range 100-250 is not within the data file (200 bytes)",
+			}))
+		})
+		It("reports a byte range that leaves a gap after the previous range", func() {
+			segmentTOC.DataEntries[16385] = utils.SegmentDataEntry{StartByte: 120, EndByte: 250}
+			Expect(backup.VerifySegmentTOC(segmentTOC, dataEntries, 250)).To(Equal(map[uint32]string{
+				16385: "byte range 120-250 is not contiguous with the previous range ending at 100",
+			}))
+		})
+		It("reports a byte range that does not start at the beginning of the data file", func() {
+			segmentTOC.DataEntries[16384] = utils.SegmentDataEntry{StartByte: 10, EndByte: 100}
+			Expect(backup.VerifySegmentTOC(segmentTOC, dataEntries, 250)).To(Equal(map[uint32]string{
+				16384: "byte range 10-100 is not contiguous with the previous range ending at 0",
+			}))
+		})
+		It("reports a table that is missing from the segment TOC", func() {
+			delete(segmentTOC.DataEntries, 16385)
+			Expect(backup.VerifySegmentTOC(segmentTOC, dataEntries, 100)).To(Equal(map[uint32]string{
+				16385: "table not found in segment TOC",
+			}))
+		})
+	})
+	Describe("GetDataFileVerifyScript", func() {
+		It("checks that each compressed data file exists and can be decompressed", func() {
+			script := backup.GetDataFileVerifyScript(fpInfo, 0, dataEntries, true)
+			Expect(script).To(Equal(`set -o pipefail; check() { if [ ! -f $2 ]; then echo "$1 data file not found"; elif cat $2 | gzip -d -c > /dev/null 2>&1; then echo "$1 ok"; else echo "$1 data file could not be read or decompressed"; fi; }; ` +
+				`check 16384 gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_16384.gz; ` +
+				`check 16385 gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_16385.gz`))
+		})
+		It("decompresses data files using the compression type of the backup", func() {
+			utils.SetPipeThroughProgram(utils.PipeThroughProgram{Name: "zstd", OutputCommand: "zstd --compress -1 -c -q", InputCommand: "zstd --decompress -c -q", Extension: ".zst"})
+			script := backup.GetDataFileVerifyScript(fpInfo, 0, dataEntries[:1], true)
+			Expect(script).To(ContainSubstring(`elif cat $2 | zstd --decompress -c -q > /dev/null 2>&1; then`))
+			Expect(script).To(HaveSuffix(`check 16384 gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_16384.zst`))
+		})
+		It("checks uncompressed data files without decompressing them", func() {
+			script := backup.GetDataFileVerifyScript(fpInfo, 1, dataEntries[:1], false)
+			Expect(script).To(Equal(`set -o pipefail; check() { if [ ! -f $2 ]; then echo "$1 data file not found"; elif cat $2 > /dev/null 2>&1; then echo "$1 ok"; else echo "$1 data file could not be read"; fi; }; ` +
+				`check 16384 gpseg1/backups/20170101/20170101010101/gpbackup_1_20170101010101_16384`))
+		})
+		It("streams each data file through the plugin", func() {
+			backup.SetPluginConfig(&utils.PluginConfig{ExecutablePath: "/tmp/plugin.sh", ConfigPath: "/tmp/plugin_config.yaml"})
+			script := backup.GetDataFileVerifyScript(fpInfo, 0, dataEntries[:1], true)
+			Expect(script).To(ContainSubstring(`check() { if /tmp/plugin.sh restore_data /tmp/plugin_config.yaml $2 | gzip -d -c > /dev/null 2>&1; then echo "$1 ok"; else echo "$1 data file could not be restored using plugin or decompressed"; fi; }`))
+			Expect(script).To(ContainSubstring("/greenplum_path.sh; "))
+		})
+	})
+	Describe("GetSingleDataFileSizeScript", func() {
+		It("counts the bytes in the decompressed data file", func() {
+			Expect(backup.GetSingleDataFileSizeScript(fpInfo, 0, true)).To(Equal("set -o pipefail; cat gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101.gz | gzip -d -c | wc -c"))
+		})
+	})
+	Describe("ParseDataFileVerifyOutput", func() {
+		It("parses passing and failing results for each table", func() {
+			output := "16384 ok\n16385 data file not found\nunexpected output\n"
+			Expect(backup.ParseDataFileVerifyOutput(output)).To(Equal(map[uint32]string{
+				16384: "",
+				16385: "data file not found",
+			}))
+		})
+	})
+	Describe("VerifyDataFiles", func() {
+		It("reports a result for each table on each segment", func() {
+			testExecutor.ClusterOutput = &cluster.RemoteOutput{
+				Stdouts: map[int]string{0: "16384 ok\n16385 ok\n", 1: "16384 ok\n16385 data file not found\n"},
+			}
+			results := backup.VerifyDataFiles(fpInfo, dataEntries, &backup_history.BackupConfig{Compressed: true})
+			Expect(testExecutor.NumExecutions).To(Equal(1))
+			Expect(results).To(Equal([]backup.DataFileResult{
+				{ContentID: 0, Timestamp: "20170101010101", Oid: 16384, Table: "public.foo", Err: ""},
+				{ContentID: 0, Timestamp: "20170101010101", Oid: 16385, Table: "public.bar", Err: ""},
+				{ContentID: 1, Timestamp: "20170101010101", Oid: 16384, Table: "public.foo", Err: ""},
+				{ContentID: 1, Timestamp: "20170101010101", Oid: 16385, Table: "public.bar", Err: "data file not found"},
+			}))
+		})
+		It("fails every table on a segment that could not be checked", func() {
+			testExecutor.ClusterOutput = &cluster.RemoteOutput{
+				NumErrors: 1,
+				Stdouts:   map[int]string{0: "16384 ok\n16385 ok\n", 1: ""},
+				Stderrs:   map[int]string{1: "ssh: connect to host sdw2 port 22: Connection refused"},
+				Errors:    map[int]error{1: errors.New("exit status 255")},
+			}
+			results := backup.VerifyDataFiles(fpInfo, dataEntries, &backup_history.BackupConfig{Compressed: true})
+			Expect(results[2].Err).To(Equal("data file could not be checked: ssh: connect to host sdw2 port 22: Connection refused"))
+			Expect(results[3].Err).To(Equal("data file could not be checked: ssh: connect to host sdw2 port 22: Connection refused"))
+		})
+		It("checks segment TOC byte ranges for single data file backups", func() {
+			segmentTOC := "dataentries:\n  16384:\n    startbyte: 0\n    endbyte: 100\n  16385:\n    startbyte: 100\n    endbyte: 250\n"
+			testExecutor.ClusterOutputs = []*cluster.RemoteOutput{
+				{Stdouts: map[int]string{0: segmentTOC, 1: segmentTOC}},
+				{Stdouts: map[int]string{0: "250\n", 1: "200\n"}},
+			}
+			results := backup.VerifyDataFiles(fpInfo, dataEntries, &backup_history.BackupConfig{Compressed: true, SingleDataFile: true})
+			Expect(testExecutor.NumExecutions).To(Equal(2))
+			Expect(results[0].Err).To(Equal(""))
+			Expect(results[1].Err).To(Equal(""))
+			Expect(results[2].Err).To(Equal(""))
+			Expect(results[3].Err).To(Equal("byte range 100-250 is not within the data file (200 bytes)"))
+		})
+	})
+	Describe("PrintVerifyResults", func() {
+		It("prints a passing summary", func() {
+			output := gbytes.NewBuffer()
+			results := []backup.DataFileResult{
+				{ContentID: 0, Timestamp: "20170101010101", Oid: 16384, Table: "public.foo"},
+				{ContentID: 1, Timestamp: "20170101010101", Oid: 16384, Table: "public.foo"},
+			}
+			Expect(backup.PrintVerifyResults(output, []string{}, results)).To(BeTrue())
+			Expect(string(output.Contents())).To(Equal(`Metadata: Passed
+
+SEGMENT  TABLES  PASSED  FAILED
+0        1       1       0
+1        1       1       0
+
+TABLE       TIMESTAMP       STATUS
+public.foo  20170101010101  Passed
+`))
+		})
+		It("prints the segments on which each table failed", func() {
+			output := gbytes.NewBuffer()
+			results := []backup.DataFileResult{
+				{ContentID: 0, Timestamp: "20170101010101", Oid: 16384, Table: "public.foo", Err: "data file not found"},
+				{ContentID: 1, Timestamp: "20170101010101", Oid: 16384, Table: "public.foo"},
+			}
+			metadataErrors := []string{"TABLE public.foo: byte range 10-50 is not within the metadata file (20 bytes)"}
+			Expect(backup.PrintVerifyResults(output, metadataErrors, results)).To(BeFalse())
+			Expect(string(output.Contents())).To(ContainSubstring("Metadata: Failed\n  TABLE public.foo: byte range 10-50 is not within the metadata file (20 bytes)\n"))
+			Expect(string(output.Contents())).To(ContainSubstring("public.foo  20170101010101  Failed (segment 0: data file not found)\n"))
+		})
+	})
+})
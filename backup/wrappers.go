@@ -66,28 +66,44 @@ func SetSessionGUCs(connNum int) {
 }
 
 func NewBackupConfig(dbName string, dbVersion string, backupVersion string, plugin string, timestamp string, opts options.Options) *backup_history.BackupConfig {
+	compressionType := ""
+	if !MustGetFlagBool(utils.NO_COMPRESSION) {
+		compressionType = MustGetFlagString(utils.COMPRESSION_TYPE)
+	}
 	backupConfig := backup_history.BackupConfig{
-		BackupDir:             MustGetFlagString(utils.BACKUP_DIR),
-		BackupVersion:         backupVersion,
-		Compressed:            !MustGetFlagBool(utils.NO_COMPRESSION),
-		DatabaseName:          dbName,
-		DatabaseVersion:       dbVersion,
-		DataOnly:              MustGetFlagBool(utils.DATA_ONLY),
-		ExcludeRelations:      MustGetFlagStringSlice(utils.EXCLUDE_RELATION),
-		ExcludeSchemaFiltered: len(MustGetFlagStringSlice(utils.EXCLUDE_SCHEMA)) > 0,
-		ExcludeSchemas:        MustGetFlagStringSlice(utils.EXCLUDE_SCHEMA),
-		ExcludeTableFiltered:  len(MustGetFlagStringSlice(utils.EXCLUDE_RELATION)) > 0,
-		IncludeRelations:      opts.GetOriginalIncludedTables(),
-		IncludeSchemaFiltered: len(MustGetFlagStringSlice(utils.INCLUDE_SCHEMA)) > 0,
-		IncludeSchemas:        MustGetFlagStringSlice(utils.INCLUDE_SCHEMA),
-		IncludeTableFiltered:  len(MustGetFlagStringArray(utils.INCLUDE_RELATION)) > 0,
-		Incremental:           MustGetFlagBool(utils.INCREMENTAL),
-		LeafPartitionData:     MustGetFlagBool(utils.LEAF_PARTITION_DATA),
-		MetadataOnly:          MustGetFlagBool(utils.METADATA_ONLY),
-		Plugin:                plugin,
-		SingleDataFile:        MustGetFlagBool(utils.SINGLE_DATA_FILE),
-		Timestamp:             timestamp,
-		WithStatistics:        MustGetFlagBool(utils.WITH_STATS),
+		BackupDir:               MustGetFlagString(utils.BACKUP_DIR),
+		BackupVersion:           backupVersion,
+		Checksummed:             true,
+		Compressed:              !MustGetFlagBool(utils.NO_COMPRESSION),
+		CompressionType:         compressionType,
+		DatabaseName:            dbName,
+		DatabaseVersion:         dbVersion,
+		DataOnly:                MustGetFlagBool(utils.DATA_ONLY),
+		Encrypted:               MustGetFlagString(utils.ENCRYPTION_KEY_FILE) != "" || MustGetFlagString(utils.ENCRYPTION_KEY_CMD) != "",
+		ExcludeRelations:        MustGetFlagStringSlice(utils.EXCLUDE_RELATION),
+		ExcludeRelationPatterns: MustGetFlagStringArray(utils.EXCLUDE_RELATION_PATTERN),
+		ExcludeSchemaFiltered:   len(MustGetFlagStringSlice(utils.EXCLUDE_SCHEMA)) > 0,
+		ExcludeSchemas:          MustGetFlagStringSlice(utils.EXCLUDE_SCHEMA),
+		ExcludeSchemaPatterns:   MustGetFlagStringArray(utils.EXCLUDE_SCHEMA_PATTERN),
+		ExcludeTableFiltered:    len(MustGetFlagStringSlice(utils.EXCLUDE_RELATION)) > 0,
+		IncludeRelations:        opts.GetOriginalIncludedTables(),
+		IncludeRelationPatterns: MustGetFlagStringArray(utils.INCLUDE_RELATION_PATTERN),
+		IncludeSchemaFiltered:   len(MustGetFlagStringSlice(utils.INCLUDE_SCHEMA)) > 0,
+		IncludeSchemas:          MustGetFlagStringSlice(utils.INCLUDE_SCHEMA),
+		IncludeSchemaPatterns:   MustGetFlagStringArray(utils.INCLUDE_SCHEMA_PATTERN),
+		IncludeTableFiltered:    len(MustGetFlagStringArray(utils.INCLUDE_RELATION)) > 0,
+		Differential:            MustGetFlagBool(utils.DIFFERENTIAL),
+		Incremental:             MustGetFlagBool(utils.INCREMENTAL) || MustGetFlagBool(utils.DIFFERENTIAL),
+		LeafPartitionData:       MustGetFlagBool(utils.LEAF_PARTITION_DATA),
+		MetadataOnly:            MustGetFlagBool(utils.METADATA_ONLY),
+		Plugin:                  plugin,
+		SingleDataFile:          MustGetFlagBool(utils.SINGLE_DATA_FILE),
+		Timestamp:               timestamp,
+		WithStatistics:          MustGetFlagBool(utils.WITH_STATS),
+	}
+	if len(backupConfig.IncludeSchemaPatterns) > 0 || len(backupConfig.ExcludeSchemaPatterns) > 0 ||
+		len(backupConfig.IncludeRelationPatterns) > 0 || len(backupConfig.ExcludeRelationPatterns) > 0 {
+		backupConfig.PatternType = MustGetFlagString(utils.PATTERN_TYPE)
 	}
 
 	return &backupConfig
@@ -101,6 +117,8 @@ func InitializeBackupReport(opts options.Options) {
 	}
 	config := NewBackupConfig(escapedDBName, connectionPool.Version.VersionString, version,
 		plugin, globalFPInfo.Timestamp, opts)
+	// Recorded so that the backup can be restored onto a cluster with a different number of segments
+	config.SegmentCount = len(globalCluster.ContentIDs) - 1
 
 	isFilteredBackup := config.IncludeTableFiltered || config.IncludeSchemaFiltered ||
 		config.ExcludeTableFiltered || config.ExcludeSchemaFiltered
@@ -118,6 +136,34 @@ func InitializeBackupReport(opts options.Options) {
 	backupReport.ConstructBackupParamsString()
 }
 
+// The data of a backup to a plugin is not kept on the segments, so its size is not reported
+func writeMetrics(jsonReport *utils.JSONReport) {
+	metricsFile := MustGetFlagString(utils.METRICS_FILE)
+	metricsURL := MustGetFlagString(utils.METRICS_URL)
+	if metricsFile == "" && metricsURL == "" {
+		return
+	}
+	var segmentBytes map[int]int64
+	if !backupReport.MetadataOnly && backupReport.Plugin == "" && globalCluster != nil {
+		segmentBytes = utils.GetBackupSizeOnSegments(globalCluster, globalFPInfo)
+	}
+	utils.WriteMetrics(jsonReport, segmentBytes, metricsFile, metricsURL)
+	metricsWritten = true
+}
+
+// Records the exit code of a backup whose metrics were not written with its report
+func writeStatusMetrics() {
+	if metricsWritten {
+		return
+	}
+	metricsWritten = true
+	exitCode := gplog.GetErrorCode()
+	if wasTerminated {
+		exitCode = 2
+	}
+	utils.WriteStatusMetrics("gpbackup", MustGetFlagString(utils.DBNAME), exitCode, MustGetFlagString(utils.METRICS_FILE), MustGetFlagString(utils.METRICS_URL))
+}
+
 func InitializeFilterLists() {
 	if MustGetFlagString(utils.EXCLUDE_RELATION_FILE) != "" {
 		excludeRelations := iohelper.MustReadLinesFromFile(MustGetFlagString(utils.EXCLUDE_RELATION_FILE))
@@ -126,6 +172,35 @@ func InitializeFilterLists() {
 	}
 }
 
+/*
+ * The patterns are resolved against the catalog into the lists of names used
+ * by the exact-name filter flags, so that the rest of the backup, and the
+ * lists recorded in the config file that later incremental backups are
+ * matched against, are the same as if each name had been given individually.
+ * Schemas are resolved first, so that tables outside of the included schemas
+ * are not matched.
+ */
+func ResolveFilterPatterns() {
+	if len(MustGetFlagStringArray(utils.INCLUDE_SCHEMA_PATTERN)) > 0 || len(MustGetFlagStringArray(utils.EXCLUDE_SCHEMA_PATTERN)) > 0 {
+		schemaNames := GetSchemaNames(connectionPool)
+		includeSchemas := utils.ResolvePatternFlag(cmdFlags, utils.INCLUDE_SCHEMA_PATTERN, schemaNames, "schema", false)
+		gplog.FatalOnError(utils.AppendToFlag(cmdFlags, utils.INCLUDE_SCHEMA, includeSchemas))
+		excludeSchemas := utils.ResolvePatternFlag(cmdFlags, utils.EXCLUDE_SCHEMA_PATTERN, schemaNames, "schema", true)
+		gplog.FatalOnError(utils.AppendToFlag(cmdFlags, utils.EXCLUDE_SCHEMA, excludeSchemas))
+	}
+	if len(MustGetFlagStringArray(utils.INCLUDE_RELATION_PATTERN)) > 0 || len(MustGetFlagStringArray(utils.EXCLUDE_RELATION_PATTERN)) > 0 {
+		tableNames, unquotedTableNames := GetFilterableTableNames(connectionPool)
+		// Included tables are given to --include-table unquoted, while excluded tables are given quoted
+		includeTables := make([]string, 0)
+		for _, table := range utils.ResolvePatternFlag(cmdFlags, utils.INCLUDE_RELATION_PATTERN, tableNames, "table", false) {
+			includeTables = append(includeTables, unquotedTableNames[table])
+		}
+		gplog.FatalOnError(utils.AppendToFlag(cmdFlags, utils.INCLUDE_RELATION, includeTables))
+		excludeTables := utils.ResolvePatternFlag(cmdFlags, utils.EXCLUDE_RELATION_PATTERN, tableNames, "table", true)
+		gplog.FatalOnError(utils.AppendToFlag(cmdFlags, utils.EXCLUDE_RELATION, excludeTables))
+	}
+}
+
 func CreateBackupLockFile(timestamp string) {
 	var err error
 	timestampLockFile := fmt.Sprintf("/tmp/%s.lck", timestamp)
@@ -628,4 +703,54 @@ func BackupStatistics(statisticsFile *utils.FileWithByteCount, tables []Table) {
 func BackupIncrementalMetadata() {
 	aoTableEntries := GetAOIncrementalMetadata(connectionPool)
 	globalTOC.IncrementalMetadata.AO = aoTableEntries
+	heapTableEntries := GetHeapIncrementalMetadata(connectionPool)
+	globalTOC.IncrementalMetadata.Heap = GetHeapEntriesFromBeforeSnapshot(heapEntriesAtStart, heapTableEntries)
+}
+
+/*
+ * The heap table entries recorded for the backup are read on a connection of
+ * their own before the first query of the backup transaction, so that they
+ * cannot include writes that its snapshot does not.
+ */
+func ReadHeapIncrementalMetadataBeforeSnapshot() {
+	if MustGetFlagBool(utils.METADATA_ONLY) || MustGetFlagBool(utils.DATA_ONLY) || MustGetFlagString(utils.RESUME) != "" {
+		return
+	}
+	gplog.Verbose("Reading heap table information before the backup transaction starts")
+	heapConnection := dbconn.NewDBConnFromEnvironment(MustGetFlagString(utils.DBNAME))
+	heapConnection.MustConnect(1)
+	defer heapConnection.Close()
+	heapEntriesAtStart = GetHeapIncrementalMetadataForAllTables(heapConnection)
+}
+
+/*
+ * The statistics counters and file sizes of heap tables are not transactional,
+ * so they are read again once the data has been backed up to find the tables
+ * written to in the meantime.  This must be done outside of the transaction in
+ * which they were first read, as statistics are cached for its duration.
+ */
+func RecheckHeapIncrementalMetadata() {
+	if len(globalTOC.IncrementalMetadata.Heap) == 0 {
+		return
+	}
+	gplog.Verbose("Checking for heap tables modified during the backup")
+	heapTableEntries := GetHeapIncrementalMetadata(connectionPool)
+	MarkHeapTablesChangedDuringBackup(globalTOC.IncrementalMetadata.Heap, heapTableEntries)
+}
+
+// A canceled backup counts as failed, and the hook is run at most once
+func RunPostHook() {
+	if !preHookRan {
+		return
+	}
+	preHookRan = false
+	status := utils.GetExitStatus()
+	if wasTerminated {
+		status = "failure"
+	}
+	hookName := utils.POST_BACKUP_HOOK
+	if status == "failure" {
+		hookName = utils.ON_FAILURE_HOOK
+	}
+	hookConfig.RunHook(globalCluster, hookName, globalFPInfo, MustGetFlagString(utils.DBNAME), status)
 }
@@ -0,0 +1,58 @@
+package backup_test
+
+import (
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/backup"
+	"github.com/greenplum-db/gpbackup/backup_filepath"
+	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("backup/wrappers tests", func() {
+	Describe("RunPostHook", func() {
+		var testExecutor *testhelper.TestExecutor
+		BeforeEach(func() {
+			testCluster := testutils.SetDefaultSegmentConfiguration()
+			testExecutor = &testhelper.TestExecutor{ClusterOutput: &cluster.RemoteOutput{}}
+			testCluster.Executor = testExecutor
+			backup.SetCluster(testCluster)
+			backup.SetFPInfo(backup_filepath.NewFilePathInfo(testCluster, "", "20170101010101", "gpseg"))
+			backup.SetHookConfig(utils.HookConfig{
+				utils.POST_BACKUP_HOOK: {ExecutablePath: "/home/gpadmin/resume_etl.sh"},
+				utils.ON_FAILURE_HOOK:  {ExecutablePath: "/home/gpadmin/alert.sh"},
+			})
+			backup.SetPreHookRan(true)
+			gplog.SetErrorCode(0)
+		})
+		AfterEach(func() {
+			backup.SetHookConfig(nil)
+			backup.SetPreHookRan(false)
+			backup.SetWasTerminated(false)
+		})
+		It("runs the post-backup hook with the status of the backup", func() {
+			gplog.SetErrorCode(1)
+			backup.RunPostHook()
+			Expect(testExecutor.LocalCommands).To(HaveLen(1))
+			Expect(testExecutor.LocalCommands[0]).To(ContainSubstring("GPBACKUP_HOOK='post-backup' GPBACKUP_SCOPE='master' GPBACKUP_STATUS='success_with_errors'"))
+		})
+		It("runs the on-failure hook once when the backup is canceled", func() {
+			backup.SetWasTerminated(true)
+			backup.RunPostHook()
+			backup.RunPostHook()
+			Expect(testExecutor.LocalCommands).To(HaveLen(1))
+			Expect(testExecutor.LocalCommands[0]).To(ContainSubstring("GPBACKUP_HOOK='on-failure' GPBACKUP_SCOPE='master' GPBACKUP_STATUS='failure'"))
+			Expect(testExecutor.LocalCommands[0]).To(HaveSuffix("/home/gpadmin/alert.sh"))
+		})
+		It("runs no hook if the pre-backup hook was not started", func() {
+			backup.SetPreHookRan(false)
+			gplog.SetErrorCode(2)
+			backup.RunPostHook()
+			Expect(testExecutor.NumExecutions).To(Equal(0))
+		})
+	})
+})
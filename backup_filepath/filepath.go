@@ -20,9 +20,21 @@ import (
 	"github.com/greenplum-db/gp-common-go-libs/operating"
 )
 
+/*
+ * Single data file backups taken with multiple jobs write one data file per
+ * job on each segment.  Shard is the index of the data file that the paths
+ * for the segment data file, segment TOC, pipes and helper files refer to;
+ * shard 0 uses the same paths as a backup with a single data file.
+ *
+ * SourceSegmentCount is the number of segments in the cluster the backup was
+ * taken on, which is only needed when restoring onto a cluster with a
+ * different number of segments; 0 means the same number as this cluster.
+ */
 type FilePathInfo struct {
 	PID                    int
 	SegDirMap              map[int]string
+	Shard                  int
+	SourceSegmentCount     int
 	Timestamp              string
 	UserSpecifiedBackupDir string
 	UserSpecifiedSegPrefix string
@@ -63,6 +75,41 @@ func (backupFPInfo *FilePathInfo) GetDirForContent(contentID int) string {
 	return path.Join(backupFPInfo.SegDirMap[contentID], "backups", backupFPInfo.Timestamp[0:8], backupFPInfo.Timestamp)
 }
 
+func (backupFPInfo *FilePathInfo) ForShard(shard int) FilePathInfo {
+	shardFPInfo := *backupFPInfo
+	shardFPInfo.Shard = shard
+	return shardFPInfo
+}
+
+func (backupFPInfo *FilePathInfo) HasDifferentSegmentCount() bool {
+	return backupFPInfo.SourceSegmentCount != 0 && backupFPInfo.SourceSegmentCount != len(backupFPInfo.SegDirMap)-1
+}
+
+/*
+ * When restoring onto a cluster with a different number of segments, the
+ * backup files of backup segment i are read by segment i modulo the number of
+ * segments in this cluster, so a segment may read the files of several backup
+ * segments or of none.  Returns the backup segments read by the given segment.
+ */
+func (backupFPInfo *FilePathInfo) GetSourceContentIDs(contentID int) []int {
+	if contentID == -1 || !backupFPInfo.HasDifferentSegmentCount() {
+		return []int{contentID}
+	}
+	clusterSegmentCount := len(backupFPInfo.SegDirMap) - 1
+	sourceContentIDs := make([]int, 0)
+	for sourceContentID := contentID; sourceContentID < backupFPInfo.SourceSegmentCount; sourceContentID += clusterSegmentCount {
+		sourceContentIDs = append(sourceContentIDs, sourceContentID)
+	}
+	return sourceContentIDs
+}
+
+func (backupFPInfo *FilePathInfo) getShardSuffix() string {
+	if backupFPInfo.Shard == 0 {
+		return ""
+	}
+	return fmt.Sprintf("_shard%d", backupFPInfo.Shard)
+}
+
 func (backupFPInfo *FilePathInfo) replaceCopyFormatStringsInPath(templateFilePath string, contentID int) string {
 	filePath := strings.Replace(templateFilePath, "<SEG_DATA_DIR>", backupFPInfo.SegDirMap[contentID], -1)
 	return strings.Replace(filePath, "<SEGID>", strconv.Itoa(contentID), -1)
@@ -74,7 +121,7 @@ func (backupFPInfo *FilePathInfo) GetSegmentPipeFilePath(contentID int) string {
 }
 
 func (backupFPInfo *FilePathInfo) GetSegmentPipePathForCopyCommand() string {
-	return fmt.Sprintf("<SEG_DATA_DIR>/gpbackup_<SEGID>_%s_pipe_%d", backupFPInfo.Timestamp, backupFPInfo.PID)
+	return fmt.Sprintf("<SEG_DATA_DIR>/gpbackup_<SEGID>_%s_pipe_%d%s", backupFPInfo.Timestamp, backupFPInfo.PID, backupFPInfo.getShardSuffix())
 }
 
 func (backupFPInfo *FilePathInfo) GetTableBackupFilePath(contentID int, tableOid uint32, extension string, singleDataFile bool) string {
@@ -86,6 +133,8 @@ func (backupFPInfo *FilePathInfo) GetTableBackupFilePathForCopyCommand(tableOid
 	backupFilePath := fmt.Sprintf("gpbackup_<SEGID>_%s", backupFPInfo.Timestamp)
 	if !singleDataFile {
 		backupFilePath += fmt.Sprintf("_%d", tableOid)
+	} else {
+		backupFilePath += backupFPInfo.getShardSuffix()
 	}
 
 	backupFilePath += extension
@@ -97,11 +146,15 @@ func (backupFPInfo *FilePathInfo) GetTableBackupFilePathForCopyCommand(tableOid
 }
 
 var metadataFilenameMap = map[string]string{
+	"backup state":      "state.yaml",
+	"checksums":         "checksums",
+	"completed tables":  "completed_tables",
 	"config":            "config.yaml",
 	"metadata":          "metadata.sql",
 	"statistics":        "statistics.sql",
 	"table of contents": "toc.yaml",
 	"report":            "report",
+	"json report":       "report.json",
 }
 
 func (backupFPInfo *FilePathInfo) GetBackupFilePath(filetype string) string {
@@ -129,20 +182,71 @@ func (backupFPInfo *FilePathInfo) GetBackupReportFilePath() string {
 	return backupFPInfo.GetBackupFilePath("report")
 }
 
+func (backupFPInfo *FilePathInfo) GetBackupJSONReportFilePath() string {
+	return backupFPInfo.GetBackupFilePath("json report")
+}
+
 func (backupFPInfo *FilePathInfo) GetRestoreReportFilePath(restoreTimestamp string) string {
 	return path.Join(backupFPInfo.GetDirForContent(-1), fmt.Sprintf("gprestore_%s_%s_report", backupFPInfo.Timestamp, restoreTimestamp))
 }
 
+func (backupFPInfo *FilePathInfo) GetRestoreJSONReportFilePath(restoreTimestamp string) string {
+	return path.Join(backupFPInfo.GetDirForContent(-1), fmt.Sprintf("gprestore_%s_%s_report.json", backupFPInfo.Timestamp, restoreTimestamp))
+}
+
+func (backupFPInfo *FilePathInfo) GetRestoreStateFilePath() string {
+	return path.Join(backupFPInfo.GetDirForContent(-1), fmt.Sprintf("gprestore_%s_state", backupFPInfo.Timestamp))
+}
+
 func (backupFPInfo *FilePathInfo) GetConfigFilePath() string {
 	return backupFPInfo.GetBackupFilePath("config")
 }
 
+/*
+ * The state and completed tables files record the progress of a backup so that
+ * it can be resumed after a failure, and are removed once the backup succeeds.
+ */
+func (backupFPInfo *FilePathInfo) GetBackupStateFilePath() string {
+	return backupFPInfo.GetBackupFilePath("backup state")
+}
+
+func (backupFPInfo *FilePathInfo) GetCompletedTablesFilePath() string {
+	return backupFPInfo.GetBackupFilePath("completed tables")
+}
+
+func (backupFPInfo *FilePathInfo) GetChecksumFilePath() string {
+	return backupFPInfo.GetBackupFilePath("checksums")
+}
+
+func (backupFPInfo *FilePathInfo) GetSegmentChecksumFilePath(contentID int) string {
+	templateFilePath := backupFPInfo.GetSegmentChecksumFilePathForCopyCommand()
+	return backupFPInfo.replaceCopyFormatStringsInPath(templateFilePath, contentID)
+}
+
+// The checksum file for each segment sits alongside the data files, and lists one checksum per data file
+func (backupFPInfo *FilePathInfo) GetSegmentChecksumFilePathForCopyCommand() string {
+	return backupFPInfo.GetTableBackupFilePathForCopyCommand(0, "_checksums", true)
+}
+
 func (backupFPInfo *FilePathInfo) GetSegmentTOCFilePath(contentID int) string {
-	return fmt.Sprintf("%s/gpbackup_%d_%s_toc.yaml", backupFPInfo.GetDirForContent(contentID), contentID, backupFPInfo.Timestamp)
+	return fmt.Sprintf("%s/gpbackup_%d_%s%s_toc.yaml", backupFPInfo.GetDirForContent(contentID), contentID, backupFPInfo.Timestamp, backupFPInfo.getShardSuffix())
 }
 
 func (backupFPInfo *FilePathInfo) GetSegmentHelperFilePath(contentID int, suffix string) string {
-	return path.Join(backupFPInfo.SegDirMap[contentID], fmt.Sprintf("gpbackup_%d_%s_%s_%d", contentID, backupFPInfo.Timestamp, suffix, backupFPInfo.PID))
+	return path.Join(backupFPInfo.SegDirMap[contentID], fmt.Sprintf("gpbackup_%d_%s_%s_%d%s", contentID, backupFPInfo.Timestamp, suffix, backupFPInfo.PID, backupFPInfo.getShardSuffix()))
+}
+
+func (backupFPInfo *FilePathInfo) GetSegmentEncryptionKeyFilePath(contentID int) string {
+	templateFilePath := backupFPInfo.GetSegmentEncryptionKeyFilePathForCopyCommand()
+	return backupFPInfo.replaceCopyFormatStringsInPath(templateFilePath, contentID)
+}
+
+/*
+ * The encryption key file is named by process rather than by timestamp, as an
+ * incremental restore reads data files from backups with several timestamps.
+ */
+func (backupFPInfo *FilePathInfo) GetSegmentEncryptionKeyFilePathForCopyCommand() string {
+	return fmt.Sprintf("<SEG_DATA_DIR>/gpbackup_<SEGID>_encryption_key_%d", backupFPInfo.PID)
 }
 
 func (backupFPInfo *FilePathInfo) GetHelperLogPath() string {
@@ -151,6 +255,23 @@ func (backupFPInfo *FilePathInfo) GetHelperLogPath() string {
 	return fmt.Sprintf("%s/gpAdminLogs/gpbackup_helper_%s.log", homeDir, backupFPInfo.Timestamp[0:8])
 }
 
+/*
+ * Plugin backups still leave metadata files on the master and segment TOC
+ * files on the segments, so this applies to plugin backups as well.  The
+ * parent date directory is removed only if no other backups remain in it.
+ * Returns whether the directories were deleted on all hosts.
+ */
+func DeleteBackupDirectoriesOnAllHosts(c *cluster.Cluster, backupFPInfo FilePathInfo, noFatal bool) bool {
+	remoteOutput := c.GenerateAndExecuteCommand("Deleting backup directories", func(contentID int) string {
+		backupDir := backupFPInfo.GetDirForContent(contentID)
+		return fmt.Sprintf("rm -rf %s && (rmdir %s 2>/dev/null || true)", backupDir, path.Dir(backupDir))
+	}, cluster.ON_SEGMENTS_AND_MASTER)
+	c.CheckClusterError(remoteOutput, "Unable to delete backup directories", func(contentID int) string {
+		return fmt.Sprintf("Unable to delete backup directory %s", backupFPInfo.GetDirForContent(contentID))
+	}, noFatal)
+	return remoteOutput.NumErrors == 0
+}
+
 /*
  * Helper functions
  */
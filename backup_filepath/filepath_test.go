@@ -82,6 +82,32 @@ var _ = Describe("filepath tests", func() {
 			Expect(fpInfo.GetTableBackupFilePathForCopyCommand(1234, ".gzip", true)).To(Equal("/foo/bar/gpseg<SEGID>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101.gzip"))
 		})
 	})
+	Describe("GetSegmentEncryptionKeyFilePath", func() {
+		It("returns the encryption key file path for copy command", func() {
+			fpInfo := backup_filepath.NewFilePathInfo(c, "", "20170101010101", "gpseg")
+			fpInfo.PID = 5678
+			Expect(fpInfo.GetSegmentEncryptionKeyFilePathForCopyCommand()).To(Equal("<SEG_DATA_DIR>/gpbackup_<SEGID>_encryption_key_5678"))
+		})
+		It("returns the encryption key file path in the segment data directory", func() {
+			c.Segments[0] = cluster.SegConfig{DataDir: segDirOne}
+			fpInfo := backup_filepath.NewFilePathInfo(c, "/foo/bar", "20170101010101", "gpseg")
+			fpInfo.PID = 5678
+			Expect(fpInfo.GetSegmentEncryptionKeyFilePath(0)).To(Equal("/data/gpseg0/gpbackup_0_encryption_key_5678"))
+		})
+	})
+	Describe("GetBackupStateFilePath and GetCompletedTablesFilePath", func() {
+		It("returns the state and completed tables file paths", func() {
+			fpInfo := backup_filepath.NewFilePathInfo(c, "", "20170101010101", "gpseg")
+			Expect(fpInfo.GetBackupStateFilePath()).To(Equal("/data/gpseg-1/backups/20170101/20170101010101/gpbackup_20170101010101_state.yaml"))
+			Expect(fpInfo.GetCompletedTablesFilePath()).To(Equal("/data/gpseg-1/backups/20170101/20170101010101/gpbackup_20170101010101_completed_tables"))
+		})
+	})
+	Describe("GetRestoreStateFilePath", func() {
+		It("returns the restore state file path", func() {
+			fpInfo := backup_filepath.NewFilePathInfo(c, "", "20170101010101", "gpseg")
+			Expect(fpInfo.GetRestoreStateFilePath()).To(Equal("/data/gpseg-1/backups/20170101/20170101010101/gprestore_20170101010101_state"))
+		})
+	})
 	Describe("GetReportFilePath", func() {
 		It("returns report file path", func() {
 			fpInfo := backup_filepath.NewFilePathInfo(c, "", "20170101010101", "gpseg")
@@ -92,6 +118,16 @@ var _ = Describe("filepath tests", func() {
 			Expect(fpInfo.GetBackupReportFilePath()).To(Equal("/foo/bar/gpseg-1/backups/20170101/20170101010101/gpbackup_20170101010101_report"))
 		})
 	})
+	Describe("GetJSONReportFilePath", func() {
+		It("returns the backup JSON report file path", func() {
+			fpInfo := backup_filepath.NewFilePathInfo(c, "", "20170101010101", "gpseg")
+			Expect(fpInfo.GetBackupJSONReportFilePath()).To(Equal("/data/gpseg-1/backups/20170101/20170101010101/gpbackup_20170101010101_report.json"))
+		})
+		It("returns the restore JSON report file path", func() {
+			fpInfo := backup_filepath.NewFilePathInfo(c, "", "20170101010101", "gpseg")
+			Expect(fpInfo.GetRestoreJSONReportFilePath("20170102010101")).To(Equal("/data/gpseg-1/backups/20170101/20170101010101/gprestore_20170101010101_20170102010101_report.json"))
+		})
+	})
 	Describe("GetTableBackupFilePath", func() {
 		It("returns table file path", func() {
 			fpInfo := backup_filepath.NewFilePathInfo(c, "", "20170101010101", "gpseg")
@@ -110,6 +146,106 @@ var _ = Describe("filepath tests", func() {
 			Expect(fpInfo.GetTableBackupFilePath(-1, 1234, "", true)).To(Equal("/foo/bar/gpseg-1/backups/20170101/20170101010101/gpbackup_-1_20170101010101"))
 		})
 	})
+	Describe("Checksum file paths", func() {
+		It("returns the checksum file path on the master", func() {
+			fpInfo := backup_filepath.NewFilePathInfo(c, "", "20170101010101", "gpseg")
+			Expect(fpInfo.GetChecksumFilePath()).To(Equal("/data/gpseg-1/backups/20170101/20170101010101/gpbackup_20170101010101_checksums"))
+		})
+		It("returns the segment checksum file path for copy command", func() {
+			fpInfo := backup_filepath.NewFilePathInfo(c, "/foo/bar", "20170101010101", "gpseg")
+			Expect(fpInfo.GetSegmentChecksumFilePathForCopyCommand()).To(Equal("/foo/bar/gpseg<SEGID>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_checksums"))
+		})
+		It("returns the segment checksum file path", func() {
+			fpInfo := backup_filepath.NewFilePathInfo(c, "", "20170101010101", "gpseg")
+			Expect(fpInfo.GetSegmentChecksumFilePath(-1)).To(Equal("/data/gpseg-1/backups/20170101/20170101010101/gpbackup_-1_20170101010101_checksums"))
+		})
+	})
+	Describe("ForShard", func() {
+		It("uses the unsharded paths for shard 0", func() {
+			c.Segments[0] = cluster.SegConfig{DataDir: segDirOne}
+			fpInfo := backup_filepath.NewFilePathInfo(c, "", "20170101010101", "gpseg")
+			fpInfo.PID = 1234
+			shardFPInfo := fpInfo.ForShard(0)
+			Expect(shardFPInfo.GetTableBackupFilePath(0, 0, ".gz", true)).To(Equal("/data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101.gz"))
+			Expect(shardFPInfo.GetSegmentTOCFilePath(0)).To(Equal("/data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_toc.yaml"))
+			Expect(shardFPInfo.GetSegmentPipeFilePath(0)).To(Equal("/data/gpseg0/gpbackup_0_20170101010101_pipe_1234"))
+			Expect(shardFPInfo.GetSegmentHelperFilePath(0, "oid")).To(Equal("/data/gpseg0/gpbackup_0_20170101010101_oid_1234"))
+		})
+		It("adds the shard to the paths for the data file, segment TOC, pipes and helper files", func() {
+			c.Segments[0] = cluster.SegConfig{DataDir: segDirOne}
+			fpInfo := backup_filepath.NewFilePathInfo(c, "", "20170101010101", "gpseg")
+			fpInfo.PID = 1234
+			shardFPInfo := fpInfo.ForShard(2)
+			Expect(shardFPInfo.GetTableBackupFilePath(0, 0, ".gz", true)).To(Equal("/data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_shard2.gz"))
+			Expect(shardFPInfo.GetTableBackupFilePath(0, 1234, ".gz", false)).To(Equal("/data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_1234.gz"))
+			Expect(shardFPInfo.GetSegmentTOCFilePath(0)).To(Equal("/data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_shard2_toc.yaml"))
+			Expect(shardFPInfo.GetSegmentPipeFilePath(0)).To(Equal("/data/gpseg0/gpbackup_0_20170101010101_pipe_1234_shard2"))
+			Expect(shardFPInfo.GetSegmentHelperFilePath(0, "oid")).To(Equal("/data/gpseg0/gpbackup_0_20170101010101_oid_1234_shard2"))
+			Expect(fpInfo.Shard).To(Equal(0))
+		})
+	})
+	Describe("GetSourceContentIDs", func() {
+		BeforeEach(func() {
+			c.Segments[0] = cluster.SegConfig{DataDir: segDirOne}
+			c.Segments[1] = cluster.SegConfig{DataDir: segDirTwo}
+		})
+		It("reads the files of the same segment if the backup was taken on a cluster of the same size", func() {
+			fpInfo := backup_filepath.NewFilePathInfo(c, "/backup_dir", "20170101010101", "gpseg")
+			fpInfo.SourceSegmentCount = 2
+			Expect(fpInfo.HasDifferentSegmentCount()).To(BeFalse())
+			Expect(fpInfo.GetSourceContentIDs(1)).To(Equal([]int{1}))
+		})
+		It("reads the files of several backup segments if the backup was taken on a larger cluster", func() {
+			fpInfo := backup_filepath.NewFilePathInfo(c, "/backup_dir", "20170101010101", "gpseg")
+			fpInfo.SourceSegmentCount = 5
+			Expect(fpInfo.HasDifferentSegmentCount()).To(BeTrue())
+			Expect(fpInfo.GetSourceContentIDs(0)).To(Equal([]int{0, 2, 4}))
+			Expect(fpInfo.GetSourceContentIDs(1)).To(Equal([]int{1, 3}))
+			Expect(fpInfo.GetSourceContentIDs(-1)).To(Equal([]int{-1}))
+		})
+		It("reads no files on some segments if the backup was taken on a smaller cluster", func() {
+			fpInfo := backup_filepath.NewFilePathInfo(c, "/backup_dir", "20170101010101", "gpseg")
+			fpInfo.SourceSegmentCount = 1
+			Expect(fpInfo.GetSourceContentIDs(0)).To(Equal([]int{0}))
+			Expect(fpInfo.GetSourceContentIDs(1)).To(Equal([]int{}))
+		})
+	})
+	Describe("DeleteBackupDirectoriesOnAllHosts", func() {
+		var testCluster *cluster.Cluster
+		var testExecutor *testhelper.TestExecutor
+		var fpInfo backup_filepath.FilePathInfo
+		BeforeEach(func() {
+			testExecutor = &testhelper.TestExecutor{ClusterOutput: &cluster.RemoteOutput{}}
+			testCluster = cluster.NewCluster([]cluster.SegConfig{
+				{ContentID: -1, Hostname: "localhost", DataDir: masterDir},
+				{ContentID: 0, Hostname: "localhost", DataDir: segDirOne},
+				{ContentID: 1, Hostname: "localhost", DataDir: segDirTwo},
+			})
+			testCluster.Executor = testExecutor
+			fpInfo = backup_filepath.NewFilePathInfo(testCluster, "", "20170101010101", "gpseg")
+		})
+		It("removes the backup directory on the master and every segment", func() {
+			deleted := backup_filepath.DeleteBackupDirectoriesOnAllHosts(testCluster, fpInfo, false)
+
+			Expect(deleted).To(BeTrue())
+			Expect(testExecutor.NumExecutions).To(Equal(1))
+			cc := testExecutor.ClusterCommands[0]
+			Expect(cc).To(HaveLen(3))
+			Expect(cc[-1]).To(ContainElement("rm -rf /data/gpseg-1/backups/20170101/20170101010101 && (rmdir /data/gpseg-1/backups/20170101 2>/dev/null || true)"))
+			Expect(cc[0]).To(ContainElement("rm -rf /data/gpseg0/backups/20170101/20170101010101 && (rmdir /data/gpseg0/backups/20170101 2>/dev/null || true)"))
+			Expect(cc[1]).To(ContainElement("rm -rf /data/gpseg1/backups/20170101/20170101010101 && (rmdir /data/gpseg1/backups/20170101 2>/dev/null || true)"))
+		})
+		It("panics if the directories cannot be removed", func() {
+			testExecutor.ClusterOutput = &cluster.RemoteOutput{NumErrors: 1, Scope: cluster.ON_SEGMENTS_AND_MASTER}
+			defer testhelper.ShouldPanicWithMessage("Unable to delete backup directories")
+			backup_filepath.DeleteBackupDirectoriesOnAllHosts(testCluster, fpInfo, false)
+		})
+		It("returns false without panicking if noFatal is set and the directories cannot be removed", func() {
+			testExecutor.ClusterOutput = &cluster.RemoteOutput{NumErrors: 1, Scope: cluster.ON_SEGMENTS_AND_MASTER}
+			deleted := backup_filepath.DeleteBackupDirectoriesOnAllHosts(testCluster, fpInfo, true)
+			Expect(deleted).To(BeFalse())
+		})
+	})
 	Describe("ParseSegPrefix", func() {
 		AfterEach(func() {
 			operating.System.Glob = filepath.Glob
@@ -10,6 +10,7 @@ import (
 	"github.com/greenplum-db/gp-common-go-libs/iohelper"
 	"github.com/greenplum-db/gp-common-go-libs/operating"
 	"github.com/nightlyone/lockfile"
+	"github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
 )
 
@@ -18,32 +19,58 @@ type RestorePlanEntry struct {
 	TableFQNs []string
 }
 
+/*
+ * The patterns given to the pattern filter flags are recorded along with the
+ * lists of names that they resolved to, which are recorded in the same fields
+ * as names given individually.
+ */
 type BackupConfig struct {
-	BackupDir             string
-	BackupVersion         string
-	Compressed            bool
-	DatabaseName          string
-	DatabaseVersion       string
-	DataOnly              bool
-	Deleted               bool
-	ExcludeRelations      []string
-	ExcludeSchemaFiltered bool
-	ExcludeSchemas        []string
-	ExcludeTableFiltered  bool
-	IncludeRelations      []string
-	IncludeSchemaFiltered bool
-	IncludeSchemas        []string
-	IncludeTableFiltered  bool
-	Incremental           bool
-	LeafPartitionData     bool
-	MetadataOnly          bool
-	Plugin                string
-	PluginVersion         string
-	RestorePlan           []RestorePlanEntry
-	SingleDataFile        bool
-	Timestamp             string
-	EndTime               string
-	WithStatistics        bool
+	BackupDir               string
+	BackupVersion           string
+	Checksummed             bool
+	Compressed              bool
+	CompressionType         string
+	DatabaseName            string
+	DatabaseVersion         string
+	DataOnly                bool
+	Deleted                 bool
+	Differential            bool
+	Encrypted               bool
+	ExcludeRelations        []string
+	ExcludeRelationPatterns []string `yaml:",omitempty"`
+	ExcludeSchemaFiltered   bool
+	ExcludeSchemas          []string
+	ExcludeSchemaPatterns   []string `yaml:",omitempty"`
+	ExcludeTableFiltered    bool
+	IncludeRelations        []string
+	IncludeRelationPatterns []string `yaml:",omitempty"`
+	IncludeSchemaFiltered   bool
+	IncludeSchemas          []string
+	IncludeSchemaPatterns   []string `yaml:",omitempty"`
+	IncludeTableFiltered    bool
+	Incremental             bool
+	LeafPartitionData       bool
+	MetadataOnly            bool
+	PatternType             string `yaml:",omitempty"`
+	Plugin                  string
+	PluginVersion           string
+	RestorePlan             []RestorePlanEntry
+	SegmentCount            int
+	SingleDataFile          bool
+	Timestamp               string
+	EndTime                 string
+	WithStatistics          bool
+}
+
+/*
+ * Backups taken before the compression type was recorded were always
+ * compressed with gzip.
+ */
+func (backupConfig *BackupConfig) GetCompressionType() string {
+	if backupConfig.Compressed && backupConfig.CompressionType == "" {
+		return "gzip"
+	}
+	return backupConfig.CompressionType
 }
 
 func ReadConfigFile(filename string) *BackupConfig {
@@ -120,6 +147,29 @@ func WriteBackupHistory(historyFilePath string, currentBackupConfig *BackupConfi
 	return history.WriteToFileAndMakeReadOnly(historyFilePath)
 }
 
+/*
+ * Flag the backup with the given timestamp as deleted.  The entry is kept in
+ * the history file so that the backup can still be listed and so that later
+ * incremental backups do not treat it as a valid base.
+ */
+func MarkBackupDeleted(historyFilePath string, timestamp string) error {
+	lock := lockHistoryFile()
+	defer func() {
+		_ = lock.Unlock()
+	}()
+
+	history, err := NewHistory(historyFilePath)
+	if err != nil {
+		return err
+	}
+	backupConfig := history.FindBackupConfig(timestamp)
+	if backupConfig == nil {
+		return errors.Errorf("Backup with timestamp %s not found in history file %s", timestamp, historyFilePath)
+	}
+	backupConfig.Deleted = true
+	return history.WriteToFileAndMakeReadOnly(historyFilePath)
+}
+
 func (history *History) RewriteHistoryFile(historyFilePath string) error {
 	lock := lockHistoryFile()
 	defer func() {
@@ -175,9 +225,9 @@ func (history *History) WriteToFileAndMakeReadOnly(filename string) error {
 }
 
 func (history *History) FindBackupConfig(timestamp string) *BackupConfig {
-	for _, backupConfig := range history.BackupConfigs {
-		if backupConfig.Timestamp == timestamp {
-			return &backupConfig
+	for i := range history.BackupConfigs {
+		if history.BackupConfigs[i].Timestamp == timestamp {
+			return &history.BackupConfigs[i]
 		}
 	}
 	return nil
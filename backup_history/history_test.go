@@ -65,6 +65,20 @@ var _ = Describe("backup/history tests", func() {
 			Expect(actual).To(Equal(expected))
 		})
 	})
+	Describe("GetCompressionType", func() {
+		It("returns the recorded compression type", func() {
+			config := backup_history.BackupConfig{Compressed: true, CompressionType: "zstd"}
+			Expect(config.GetCompressionType()).To(Equal("zstd"))
+		})
+		It("returns gzip for compressed backups that did not record a compression type", func() {
+			config := backup_history.BackupConfig{Compressed: true}
+			Expect(config.GetCompressionType()).To(Equal("gzip"))
+		})
+		It("returns an empty string for uncompressed backups", func() {
+			config := backup_history.BackupConfig{Compressed: false}
+			Expect(config.GetCompressionType()).To(Equal(""))
+		})
+	})
 	Describe("WriteToFileAndMakeReadOnly", func() {
 		var fileInfo os.FileInfo
 		var historyWithEntries backup_history.History
@@ -241,5 +255,37 @@ var _ = Describe("backup/history tests", func() {
 			foundConfig := resultHistory.FindBackupConfig("foo")
 			Expect(foundConfig).To(BeNil())
 		})
+		It("returns a config that can be modified in place", func() {
+			foundConfig := resultHistory.FindBackupConfig("timestamp2")
+			foundConfig.Deleted = true
+			Expect(resultHistory.BackupConfigs[0].Deleted).To(BeTrue())
+		})
+	})
+	Describe("MarkBackupDeleted", func() {
+		BeforeEach(func() {
+			err := backup_history.WriteBackupHistory(historyFilePath, &testConfig1)
+			Expect(err).ToNot(HaveOccurred())
+			err = backup_history.WriteBackupHistory(historyFilePath, &testConfig2)
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("marks only the backup with the given timestamp as deleted", func() {
+			err := backup_history.MarkBackupDeleted(historyFilePath, "timestamp1")
+			Expect(err).ToNot(HaveOccurred())
+
+			resultHistory, err := backup_history.NewHistory(historyFilePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resultHistory.FindBackupConfig("timestamp1").Deleted).To(BeTrue())
+			Expect(resultHistory.FindBackupConfig("timestamp2").Deleted).To(BeFalse())
+		})
+		It("returns an error when the timestamp is not in the history file", func() {
+			err := backup_history.MarkBackupDeleted(historyFilePath, "foo")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Backup with timestamp foo not found in history file"))
+		})
+		It("returns an error when the history file does not exist", func() {
+			_ = os.Remove(historyFilePath)
+			err := backup_history.MarkBackupDeleted(historyFilePath, "timestamp1")
+			Expect(err).To(HaveOccurred())
+		})
 	})
 })
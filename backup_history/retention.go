@@ -0,0 +1,79 @@
+package backup_history
+
+import (
+	"time"
+)
+
+/*
+ * A backup is retained if it satisfies any part of the policy.  A zero value
+ * for a field means that part of the policy is not in effect.
+ */
+type RetentionPolicy struct {
+	FullBackups int
+	Days        int
+}
+
+func (policy RetentionPolicy) IsSet() bool {
+	return policy.FullBackups > 0 || policy.Days > 0
+}
+
+/*
+ * Returns the backups in the history that fall outside the retention policy,
+ * newest first.  Only backups of the same database to the same destination
+ * (backup directory and plugin) as the current backup are considered, and the
+ * current backup is always retained.
+ *
+ * Incremental backups based on a retained full backup are retained, and every
+ * backup in the restore plan of a retained backup is retained, so that pruning
+ * never breaks an incremental chain that is still in use.
+ */
+func (history *History) GetBackupsToPrune(currentConfig *BackupConfig, policy RetentionPolicy, now time.Time) []BackupConfig {
+	candidates := make([]BackupConfig, 0)
+	for _, backupConfig := range history.BackupConfigs {
+		if !backupConfig.Deleted &&
+			backupConfig.DatabaseName == currentConfig.DatabaseName &&
+			backupConfig.BackupDir == currentConfig.BackupDir &&
+			backupConfig.Plugin == currentConfig.Plugin {
+			candidates = append(candidates, backupConfig)
+		}
+	}
+
+	retained := map[string]bool{currentConfig.Timestamp: true}
+	cutoffTimestamp := ""
+	if policy.Days > 0 {
+		cutoffTimestamp = now.AddDate(0, 0, -policy.Days).Format("20060102150405")
+	}
+	numFullBackups := 0
+	for _, backupConfig := range candidates {
+		if !backupConfig.Incremental {
+			numFullBackups++
+			if numFullBackups <= policy.FullBackups {
+				retained[backupConfig.Timestamp] = true
+			}
+		}
+		if cutoffTimestamp != "" && backupConfig.Timestamp >= cutoffTimestamp {
+			retained[backupConfig.Timestamp] = true
+		}
+	}
+
+	for _, backupConfig := range candidates {
+		if backupConfig.Incremental && len(backupConfig.RestorePlan) > 0 && retained[backupConfig.RestorePlan[0].Timestamp] {
+			retained[backupConfig.Timestamp] = true
+		}
+	}
+	for _, backupConfig := range candidates {
+		if retained[backupConfig.Timestamp] {
+			for _, restorePlanEntry := range backupConfig.RestorePlan {
+				retained[restorePlanEntry.Timestamp] = true
+			}
+		}
+	}
+
+	backupsToPrune := make([]BackupConfig, 0)
+	for _, backupConfig := range candidates {
+		if !retained[backupConfig.Timestamp] {
+			backupsToPrune = append(backupsToPrune, backupConfig)
+		}
+	}
+	return backupsToPrune
+}
@@ -0,0 +1,97 @@
+package backup_history_test
+
+import (
+	"time"
+
+	"github.com/greenplum-db/gpbackup/backup_history"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("backup_history/retention tests", func() {
+	var history *backup_history.History
+	now := time.Date(2017, time.January, 10, 1, 1, 1, 0, time.Local)
+
+	fullBackup := func(timestamp string) backup_history.BackupConfig {
+		return backup_history.BackupConfig{DatabaseName: "testdb", Timestamp: timestamp,
+			RestorePlan: []backup_history.RestorePlanEntry{{Timestamp: timestamp}}}
+	}
+	incrementalBackup := func(timestamp string, chain ...string) backup_history.BackupConfig {
+		restorePlan := make([]backup_history.RestorePlanEntry, 0)
+		for _, chainTimestamp := range append(chain, timestamp) {
+			restorePlan = append(restorePlan, backup_history.RestorePlanEntry{Timestamp: chainTimestamp})
+		}
+		return backup_history.BackupConfig{DatabaseName: "testdb", Timestamp: timestamp, Incremental: true, RestorePlan: restorePlan}
+	}
+	getTimestamps := func(configs []backup_history.BackupConfig) []string {
+		timestamps := make([]string, 0)
+		for _, config := range configs {
+			timestamps = append(timestamps, config.Timestamp)
+		}
+		return timestamps
+	}
+
+	BeforeEach(func() {
+		history = &backup_history.History{BackupConfigs: []backup_history.BackupConfig{
+			fullBackup("20170110010101"),
+			incrementalBackup("20170108010101", "20170105010101", "20170106010101"),
+			incrementalBackup("20170106010101", "20170105010101"),
+			fullBackup("20170105010101"),
+			incrementalBackup("20170103010101", "20170101010101"),
+			fullBackup("20170101010101"),
+		}}
+	})
+	Describe("IsSet", func() {
+		It("is not set when no policy is specified", func() {
+			Expect(backup_history.RetentionPolicy{}.IsSet()).To(BeFalse())
+		})
+		It("is set when either part of the policy is specified", func() {
+			Expect(backup_history.RetentionPolicy{FullBackups: 1}.IsSet()).To(BeTrue())
+			Expect(backup_history.RetentionPolicy{Days: 1}.IsSet()).To(BeTrue())
+		})
+	})
+	Describe("GetBackupsToPrune", func() {
+		It("keeps the latest full backups along with their incremental chains", func() {
+			policy := backup_history.RetentionPolicy{FullBackups: 2}
+			toPrune := history.GetBackupsToPrune(&history.BackupConfigs[0], policy, now)
+			Expect(getTimestamps(toPrune)).To(Equal([]string{"20170103010101", "20170101010101"}))
+		})
+		It("keeps only the current backup when keeping a single full backup", func() {
+			policy := backup_history.RetentionPolicy{FullBackups: 1}
+			toPrune := history.GetBackupsToPrune(&history.BackupConfigs[0], policy, now)
+			Expect(getTimestamps(toPrune)).To(Equal([]string{"20170108010101", "20170106010101", "20170105010101", "20170103010101", "20170101010101"}))
+		})
+		It("keeps backups newer than the cutoff and every backup they depend on", func() {
+			policy := backup_history.RetentionPolicy{Days: 3}
+			toPrune := history.GetBackupsToPrune(&history.BackupConfigs[0], policy, now)
+			Expect(getTimestamps(toPrune)).To(Equal([]string{"20170103010101", "20170101010101"}))
+		})
+		It("keeps a backup if it satisfies either part of the policy", func() {
+			policy := backup_history.RetentionPolicy{FullBackups: 1, Days: 6}
+			toPrune := history.GetBackupsToPrune(&history.BackupConfigs[0], policy, now)
+			Expect(getTimestamps(toPrune)).To(Equal([]string{"20170103010101", "20170101010101"}))
+		})
+		It("never prunes the full backup of a retained incremental backup", func() {
+			history.BackupConfigs = append([]backup_history.BackupConfig{incrementalBackup("20170111010101", "20170101010101", "20170103010101")}, history.BackupConfigs...)
+			policy := backup_history.RetentionPolicy{FullBackups: 1}
+			toPrune := history.GetBackupsToPrune(&history.BackupConfigs[0], policy, now)
+			Expect(getTimestamps(toPrune)).To(Equal([]string{"20170108010101", "20170106010101", "20170105010101"}))
+		})
+		It("only considers backups of the same database to the same destination that have not been deleted", func() {
+			otherDatabase := fullBackup("20170104010101")
+			otherDatabase.DatabaseName = "otherdb"
+			otherDir := fullBackup("20170104020202")
+			otherDir.BackupDir = "/tmp/backups"
+			otherPlugin := fullBackup("20170104030303")
+			otherPlugin.Plugin = "/tmp/plugin"
+			deleted := fullBackup("20170102010101")
+			deleted.Deleted = true
+			history.BackupConfigs = []backup_history.BackupConfig{fullBackup("20170110010101"), otherPlugin, otherDir, otherDatabase, deleted, fullBackup("20170101010101")}
+
+			policy := backup_history.RetentionPolicy{FullBackups: 1}
+			toPrune := history.GetBackupsToPrune(&history.BackupConfigs[0], policy, now)
+			Expect(getTimestamps(toPrune)).To(Equal([]string{"20170101010101"}))
+		})
+	})
+})
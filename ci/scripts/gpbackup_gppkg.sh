@@ -38,15 +38,16 @@ if [ ${GPDB_MAJOR_VERSION} == '6' ] && [ ${OS} == "rhel6" ]; then
     rm -f ${GPBIN}/gpbackup
     rm -f ${GPBIN}/gprestore
     rm -f ${GPBIN}/gpbackup_helper
+    rm -f ${GPBIN}/gpbackup_manager
 
     gppkg -i ${GPBACKUP_GPPKG}
-    if [ ! -f ${GPBIN}/gpbackup ] || [ ! -f ${GPBIN}/gprestore ] || [ ! -f ${GPBIN}/gpbackup_helper ]; then
+    if [ ! -f ${GPBIN}/gpbackup ] || [ ! -f ${GPBIN}/gprestore ] || [ ! -f ${GPBIN}/gpbackup_helper ] || [ ! -f ${GPBIN}/gpbackup_manager ]; then
         echo "Failed to install gpbackup using gppkg!"
         exit 1
     fi
 
     gppkg -r gpbackup
-    if [ -f ${GPBIN}/gpbackup ] || [ -f ${GPBIN}/gprestore ] || [ -f ${GPBIN}/gpbackup_helper ]; then
+    if [ -f ${GPBIN}/gpbackup ] || [ -f ${GPBIN}/gprestore ] || [ -f ${GPBIN}/gpbackup_helper ] || [ -f ${GPBIN}/gpbackup_manager ]; then
         echo "Failed to remove gpbackup using gppkg!"
         exit 1
     fi
@@ -372,6 +372,45 @@ var _ = Describe("backup end to end integration tests", func() {
 				assertRelationsCreated(restoreConn, 16)
 				assertDataRestored(restoreConn, map[string]int{"public.sales": 13, "public.foo": 40000})
 			})
+			It("runs gpbackup and gprestore with include-table and redirect-schema restore flags", func() {
+				if useOldBackupVersion {
+					Skip("This test is not needed for old backup versions")
+				}
+				timestamp := gpbackup(gpbackupPath, backupHelperPath)
+				gprestore(gprestorePath, restoreHelperPath, timestamp, "--redirect-db", "restoredb", "--include-table", "public.foo", "--redirect-schema", "redirected")
+
+				assertRelationsCreated(restoreConn, 0)
+				assertDataRestored(restoreConn, map[string]int{"redirected.foo": 40000})
+			})
+			It("runs gpbackup and gprestore with list and use-list restore flags", func() {
+				if useOldBackupVersion {
+					Skip("This test is not needed for old backup versions")
+				}
+				timestamp := gpbackup(gpbackupPath, backupHelperPath)
+				gprestore(gprestorePath, restoreHelperPath, timestamp, "--list", "/tmp/restore-list.txt")
+				gprestore(gprestorePath, restoreHelperPath, timestamp, "--redirect-db", "restoredb", "--use-list", "/tmp/restore-list.txt")
+
+				assertRelationsCreated(restoreConn, TOTAL_RELATIONS)
+				assertDataRestored(restoreConn, publicSchemaTupleCounts)
+				assertDataRestored(restoreConn, schema2TupleCounts)
+
+				_ = os.Remove("/tmp/restore-list.txt")
+			})
+			It("runs gpbackup and gprestore with to-sql restore flag", func() {
+				if useOldBackupVersion {
+					Skip("This test is not needed for old backup versions")
+				}
+				timestamp := gpbackup(gpbackupPath, backupHelperPath)
+				gprestore(gprestorePath, restoreHelperPath, timestamp, "--redirect-db", "restoredb", "--include-table", "public.foo", "--to-sql", "/tmp/restore.sql")
+
+				assertRelationsCreated(restoreConn, 0)
+				contents, err := ioutil.ReadFile("/tmp/restore.sql")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(contents)).To(ContainSubstring("CREATE TABLE public.foo ("))
+				Expect(string(contents)).ToNot(ContainSubstring("CREATE TABLE public.sales ("))
+
+				_ = os.Remove("/tmp/restore.sql")
+			})
 			It("runs gpbackup and gprestore with include-table-file restore flag", func() {
 				includeFile := iohelper.MustOpenFileForWriting("/tmp/include-tables.txt")
 				utils.MustPrintln(includeFile, "public.sales\npublic.foo\npublic.myseq1\npublic.myview1")
@@ -957,6 +996,20 @@ var _ = Describe("backup end to end integration tests", func() {
 
 			os.RemoveAll(backupdir)
 		})
+		It("runs gpbackup and gprestore with jobs flag and single-data-file flag", func() {
+			if useOldBackupVersion {
+				Skip("This test is not needed for old backup versions")
+			}
+			backupdir := filepath.Join(customBackupDir, "parallel_sdf") // Must be unique
+			timestamp := gpbackup(gpbackupPath, backupHelperPath, "--backup-dir", backupdir, "--jobs", "4", "--single-data-file")
+			gprestore(gprestorePath, restoreHelperPath, timestamp, "--redirect-db", "restoredb", "--backup-dir", backupdir, "--jobs", "2")
+
+			assertRelationsCreated(restoreConn, TOTAL_RELATIONS)
+			assertDataRestored(restoreConn, schema2TupleCounts)
+			assertDataRestored(restoreConn, publicSchemaTupleCounts)
+
+			os.RemoveAll(backupdir)
+		})
 		It("runs gpbackup and sends a SIGINT to ensure cleanup functions successfully", func() {
 			if useOldBackupVersion {
 				Skip("This test is not needed for old backup versions")
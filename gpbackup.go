@@ -19,6 +19,10 @@ func main() {
 		Run: func(cmd *cobra.Command, args []string) {
 			defer DoTeardown()
 			DoFlagValidation(cmd)
+			if MustGetFlagString(utils.VERIFY) != "" {
+				DoVerify()
+				return
+			}
 			DoSetup()
 			DoBackup()
 		}}
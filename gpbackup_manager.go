@@ -0,0 +1,25 @@
+// +build gpbackup_manager
+
+package main
+
+import (
+	"os"
+
+	. "github.com/greenplum-db/gpbackup/manager"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/spf13/cobra"
+)
+
+func main() {
+	var rootCmd = &cobra.Command{
+		Use:     "gpbackup_manager",
+		Short:   "gpbackup_manager lists, inspects, deletes, synthesizes and compares backups taken by gpbackup",
+		Args:    cobra.NoArgs,
+		Version: GetVersion(),
+	}
+	rootCmd.SetArgs(utils.HandleSingleDashes(os.Args[1:]))
+	DoInit(rootCmd)
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(2)
+	}
+}
@@ -3,6 +3,8 @@ package helper
 import (
 	"bufio"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
@@ -21,13 +23,14 @@ func doBackupAgent() error {
 	var lastRead uint64
 	var (
 		finalWriter io.Writer
-		gzipWriter  *gzip.Writer
+		closers     []io.Closer
 		bufIoWriter *bufio.Writer
 		writeHandle io.WriteCloser
 		writeCmd    *exec.Cmd
 	)
 	toc := &utils.SegmentTOC{}
 	toc.DataEntries = make(map[uint]utils.SegmentDataEntry, 0)
+	fileHasher := sha256.New()
 
 	oidList, err := getOidListFromFile()
 	if err != nil {
@@ -59,21 +62,22 @@ func doBackupAgent() error {
 			return err
 		}
 		if i == 0 {
-			finalWriter, gzipWriter, bufIoWriter, writeHandle, writeCmd, err = getBackupPipeWriter(*compressionLevel)
+			finalWriter, closers, bufIoWriter, writeHandle, writeCmd, err = getBackupPipeWriter(*compressionType, *compressionLevel, fileHasher)
 			if err != nil {
 				return err
 			}
 		}
 
 		log(fmt.Sprintf("Backing up table with oid %d\n", oid))
-		numBytes, err := io.Copy(finalWriter, reader)
+		tableHasher := sha256.New()
+		numBytes, err := io.Copy(io.MultiWriter(finalWriter, tableHasher), reader)
 		if err != nil {
 			return errors.Wrap(err, strings.Trim(errBuf.String(), "\x00"))
 		}
 		log(fmt.Sprintf("Read %d bytes\n", numBytes))
 
 		lastProcessed := lastRead + uint64(numBytes)
-		toc.AddSegmentDataEntry(uint(oid), lastRead, lastProcessed)
+		toc.AddSegmentDataEntry(uint(oid), lastRead, lastProcessed, hex.EncodeToString(tableHasher.Sum(nil)))
 		lastRead = lastProcessed
 
 		lastPipe = currentPipe
@@ -89,8 +93,11 @@ func doBackupAgent() error {
 	 * The order for flushing and closing the writers below is very specific
 	 * to ensure all data is written to the file and file handles are not leaked.
 	 */
-	if gzipWriter != nil {
-		_ = gzipWriter.Close()
+	for _, closer := range closers {
+		err = closer.Close()
+		if err != nil {
+			return err
+		}
 	}
 	_ = bufIoWriter.Flush()
 	_ = writeHandle.Close()
@@ -108,6 +115,7 @@ func doBackupAgent() error {
 			return errors.Wrap(err, strings.Trim(errBuf.String(), "\x00"))
 		}
 	}
+	toc.DataFileChecksum = hex.EncodeToString(fileHasher.Sum(nil))
 	err = toc.WriteToFileAndMakeReadOnly(*tocFile)
 	if err != nil {
 		return err
@@ -128,7 +136,13 @@ func getBackupPipeReader(currentPipe string) (io.Reader, io.ReadCloser, error) {
 	return reader, readHandle, nil
 }
 
-func getBackupPipeWriter(compressLevel int) (io.Writer, *gzip.Writer, *bufio.Writer, io.WriteCloser, *exec.Cmd, error) {
+/*
+ * Everything written to the data file is also written to fileHasher, so that
+ * the checksum covers the file exactly as it is stored.  Data is compressed
+ * before it is encrypted, and the returned closers must be closed in order
+ * before bufIoWriter is flushed.
+ */
+func getBackupPipeWriter(compressType string, compressLevel int, fileHasher io.Writer) (io.Writer, []io.Closer, *bufio.Writer, io.WriteCloser, *exec.Cmd, error) {
 	var writeHandle io.WriteCloser
 	var err error
 	var writeCmd *exec.Cmd
@@ -141,18 +155,39 @@ func getBackupPipeWriter(compressLevel int) (io.Writer, *gzip.Writer, *bufio.Wri
 		return nil, nil, nil, nil, nil, err
 	}
 
-	var finalWriter io.Writer
-	var gzipWriter *gzip.Writer
-	bufIoWriter := bufio.NewWriter(writeHandle)
+	var finalWriter io.Writer = writeHandle
+	if *maxBandwidth > 0 {
+		finalWriter = utils.NewThrottle(*maxBandwidth).Writer(writeHandle)
+	}
+	closers := make([]io.Closer, 0)
+	bufIoWriter := bufio.NewWriter(io.MultiWriter(finalWriter, fileHasher))
 	finalWriter = bufIoWriter
+	if *encryptionKey != "" {
+		key, err := utils.ReadEncryptionKey(*encryptionKey, "")
+		if err != nil {
+			return nil, nil, nil, nil, nil, err
+		}
+		encWriter, err := utils.NewEncryptionWriter(finalWriter, key)
+		if err != nil {
+			return nil, nil, nil, nil, nil, err
+		}
+		closers = append([]io.Closer{encWriter}, closers...)
+		finalWriter = encWriter
+	}
 	if compressLevel > 0 {
-		gzipWriter, err = gzip.NewWriterLevel(bufIoWriter, compressLevel)
+		var compressWriter io.WriteCloser
+		if compressType == "gzip" {
+			compressWriter, err = gzip.NewWriterLevel(finalWriter, compressLevel)
+		} else {
+			compressWriter, err = startCompressionCommand(compressType, compressLevel, finalWriter)
+		}
 		if err != nil {
 			return nil, nil, nil, nil, nil, err
 		}
-		finalWriter = gzipWriter
+		closers = append([]io.Closer{compressWriter}, closers...)
+		finalWriter = compressWriter
 	}
-	return finalWriter, gzipWriter, bufIoWriter, writeHandle, writeCmd, nil
+	return finalWriter, closers, bufIoWriter, writeHandle, writeCmd, nil
 }
 
 func startBackupPluginCommand() (*exec.Cmd, io.WriteCloser, error) {
@@ -0,0 +1,105 @@
+package helper
+
+import (
+	"bytes"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+/*
+ * gzip is handled in-process, but other compression types are handled by
+ * piping data through the same program used for multiple data file backups,
+ * so that both kinds of backup can be read by the same tools.
+ */
+
+type compressionCommandWriter struct {
+	io.WriteCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+}
+
+// Close must be called to ensure all compressed data has been written to the output
+func (writer *compressionCommandWriter) Close() error {
+	err := writer.WriteCloser.Close()
+	if err != nil {
+		return err
+	}
+	err = writer.cmd.Wait()
+	if err != nil {
+		return errors.Wrap(err, strings.TrimSpace(writer.stderr.String()))
+	}
+	return nil
+}
+
+type decompressionCommandReader struct {
+	io.Reader
+	cmd     *exec.Cmd
+	stderr  *bytes.Buffer
+	waitErr error
+	done    bool
+}
+
+/*
+ * A decompression program that fails partway through a file closes its output
+ * early, so on EOF we check its exit status to avoid silently truncating data.
+ */
+func (reader *decompressionCommandReader) Read(p []byte) (int, error) {
+	n, err := reader.Reader.Read(p)
+	if err == io.EOF {
+		if !reader.done {
+			reader.done = true
+			reader.waitErr = reader.cmd.Wait()
+			if reader.waitErr != nil {
+				reader.waitErr = errors.Wrap(reader.waitErr, strings.TrimSpace(reader.stderr.String()))
+			}
+		}
+		if reader.waitErr != nil {
+			return n, reader.waitErr
+		}
+	}
+	return n, err
+}
+
+func startCompressionCommand(compressionType string, compressLevel int, output io.Writer) (io.WriteCloser, error) {
+	program, err := utils.NewCompressionProgram(compressionType, compressLevel)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("bash", "-c", program.OutputCommand)
+	stderr := &bytes.Buffer{}
+	cmd.Stdout = output
+	cmd.Stderr = stderr
+	writeHandle, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	return &compressionCommandWriter{WriteCloser: writeHandle, cmd: cmd, stderr: stderr}, nil
+}
+
+func startDecompressionCommand(compressionType string, input io.Reader) (io.Reader, error) {
+	program, err := utils.NewCompressionProgram(compressionType, 0)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("bash", "-c", program.InputCommand)
+	stderr := &bytes.Buffer{}
+	cmd.Stdin = input
+	cmd.Stderr = stderr
+	readHandle, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	return &decompressionCommandReader{Reader: readHandle, cmd: cmd, stderr: stderr}, nil
+}
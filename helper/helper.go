@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"runtime/debug"
 	"sort"
@@ -17,6 +18,7 @@ import (
 	"github.com/greenplum-db/gp-common-go-libs/iohelper"
 	"github.com/greenplum-db/gp-common-go-libs/operating"
 	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
 )
 
 /*
@@ -40,15 +42,23 @@ var (
  */
 var (
 	backupAgent      *bool
+	checksumFile     *string
 	compressionLevel *int
+	compressionType  *string
 	content          *int
 	dataFile         *string
+	decryptData      *bool
+	encryptData      *bool
+	encryptionKey    *string
+	maxBandwidth     *int64
 	oidFile          *string
 	pipeFile         *string
 	pluginConfigFile *string
 	printVersion     *bool
 	restoreAgent     *bool
+	throttle         *bool
 	tocFile          *string
+	verifyData       *bool
 )
 
 func DoHelper() {
@@ -68,11 +78,21 @@ func DoHelper() {
 		err = doBackupAgent()
 	} else if *restoreAgent {
 		err = doRestoreAgent()
+	} else if *encryptData || *decryptData {
+		err = doEncryptionFilter()
+	} else if *throttle {
+		err = doThrottleFilter()
+	} else if *verifyData {
+		err = doChecksumReader()
 	}
 	if err != nil {
 		gplog.Error(fmt.Sprintf("%v: %s", err, debug.Stack()))
-		handle, _ := iohelper.OpenFileForWriting(fmt.Sprintf("%s_error", *pipeFile))
-		_ = handle.Close()
+		if *backupAgent || *restoreAgent {
+			// The error is written to the error file so that gpbackup and gprestore can report it
+			handle, _ := iohelper.OpenFileForWriting(fmt.Sprintf("%s_error", *pipeFile))
+			_, _ = handle.Write([]byte(err.Error()))
+			_ = handle.Close()
+		}
 	}
 }
 
@@ -82,15 +102,23 @@ func InitializeGlobals() {
 	gplog.InitializeLogging("gpbackup_helper", "")
 
 	backupAgent = flag.Bool("backup-agent", false, "Use gpbackup_helper as an agent for backup")
+	checksumFile = flag.String("checksum-file", "", "Absolute path to the file containing the checksum of the data file to verify")
 	content = flag.Int("content", -2, "Content ID of the corresponding segment")
-	compressionLevel = flag.Int("compression-level", 0, "The level of compression to use with gzip. O indicates no compression.")
-	dataFile = flag.String("data-file", "", "Absolute path to the data file")
+	compressionLevel = flag.Int("compression-level", 0, "The level of compression to use. O indicates no compression.")
+	compressionType = flag.String("compression-type", "", "The type of compression to use or, when restoring, that was used.  Valid values are gzip, zstd, and lz4.")
+	dataFile = flag.String("data-file", "", "Absolute path to the data file, or a comma-separated list of data files to restore from")
+	decryptData = flag.Bool("decrypt", false, "Decrypt data from stdin to stdout")
+	encryptData = flag.Bool("encrypt", false, "Encrypt data from stdin to stdout")
+	encryptionKey = flag.String("encryption-key-file", "", "Absolute path to the file containing the encryption key")
+	maxBandwidth = flag.Int64("max-bandwidth", 0, "The maximum number of bytes per second to read from or write to the data file, or to copy when throttling")
 	oidFile = flag.String("oid-file", "", "Absolute path to the file containing a list of oids to restore")
 	pipeFile = flag.String("pipe-file", "", "Absolute path to the pipe file")
 	pluginConfigFile = flag.String("plugin-config", "", "The configuration file to use for a plugin")
 	printVersion = flag.Bool("version", false, "Print version number and exit")
 	restoreAgent = flag.Bool("restore-agent", false, "Use gpbackup_helper as an agent for restore")
-	tocFile = flag.String("toc-file", "", "Absolute path to the table of contents file")
+	throttle = flag.Bool("throttle", false, "Copy stdin to stdout at no more than --max-bandwidth bytes per second")
+	tocFile = flag.String("toc-file", "", "Absolute path to the table of contents file, or a comma-separated list matching the data files to restore from")
+	verifyData = flag.Bool("verify-checksum", false, "Write the data file to stdout, and fail if it does not match its checksum")
 
 	flag.Parse()
 	if *printVersion {
@@ -124,6 +152,59 @@ func getOidListFromFile() ([]int, error) {
 	return oidList, nil
 }
 
+/*
+ * When encrypting or decrypting, the helper is used as a filter in the COPY
+ * commands for multiple data file backups.  It reads from stdin and writes to
+ * stdout, so gplog output must only go to the log file and stderr.
+ */
+func doEncryptionFilter() error {
+	key, err := utils.ReadEncryptionKey(*encryptionKey, "")
+	if err != nil {
+		return err
+	}
+	stdout := bufio.NewWriter(os.Stdout)
+	if *encryptData {
+		encWriter, err := utils.NewEncryptionWriter(stdout, key)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(encWriter, bufio.NewReader(os.Stdin))
+		if err != nil {
+			return err
+		}
+		err = encWriter.Close()
+		if err != nil {
+			return err
+		}
+	} else {
+		decReader, err := utils.NewDecryptionReader(bufio.NewReader(os.Stdin), key)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(stdout, decReader)
+		if err != nil {
+			return err
+		}
+	}
+	return stdout.Flush()
+}
+
+/*
+ * When throttling, the helper is used as a filter in the COPY commands for
+ * multiple data file backups and restores, in the same way as for encryption.
+ */
+func doThrottleFilter() error {
+	if *maxBandwidth <= 0 {
+		return errors.New("--max-bandwidth must be specified with --throttle")
+	}
+	stdout := bufio.NewWriter(os.Stdout)
+	_, err := io.Copy(utils.NewThrottle(*maxBandwidth).Writer(stdout), bufio.NewReader(os.Stdin))
+	if err != nil {
+		return err
+	}
+	return stdout.Flush()
+}
+
 func flushAndCloseRestoreWriter() error {
 	if writer != nil {
 		err := writer.Flush()
@@ -195,3 +276,18 @@ func log(s string, v ...interface{}) {
 	s = fmt.Sprintf("Segment %d: %s", *content, s)
 	gplog.Verbose(s, v...)
 }
+
+/*
+ * When verifying checksums, the helper reads the data file in the COPY
+ * commands for multiple data file restores in place of cat.  The checksum can
+ * only be compared once the whole file has been written to stdout, so the COPY
+ * command must fail if the helper does.
+ */
+func doChecksumReader() error {
+	stdout := bufio.NewWriter(os.Stdout)
+	err := utils.CopyAndVerifyChecksum(*checksumFile, *dataFile, stdout)
+	if err != nil {
+		return err
+	}
+	return stdout.Flush()
+}
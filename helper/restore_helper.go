@@ -3,8 +3,12 @@ package helper
 import (
 	"bufio"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"strings"
@@ -17,9 +21,28 @@ import (
  * Restore specific functions
  */
 
+/*
+ * When restoring onto a cluster with a different number of segments, the
+ * helper may be given the data files and segment TOCs of several backup
+ * segments, in which case the data for each table is read from each data file
+ * in turn and written to the same pipe.
+ */
+type restoreSource struct {
+	dataFile   string
+	segmentTOC *utils.SegmentTOC
+	reader     *bufio.Reader
+	fileHasher hash.Hash
+	lastByte   uint64
+}
+
+func splitFileList(fileList string) []string {
+	if fileList == "" {
+		return []string{}
+	}
+	return strings.Split(fileList, ",")
+}
+
 func doRestoreAgent() error {
-	tocEntries := utils.NewSegmentTOC(*tocFile).DataEntries
-	var lastByte uint64
 	oidList, err := getOidListFromFile()
 	if err != nil {
 		return err
@@ -28,17 +51,31 @@ func doRestoreAgent() error {
 	currentPipe = fmt.Sprintf("%s_%d", *pipeFile, oidList[0])
 	log(fmt.Sprintf("Opening pipe for oid %d", oidList[0]))
 	/*
-	 * It is important that we create the writer before creating the reader
+	 * It is important that we create the writer before creating the readers
 	 * so that we establish a connection to the first pipe (created by gprestore)
-	 * and properly clean it up if an error occurs while creating the reader.
+	 * and properly clean it up if an error occurs while creating a reader.
 	 */
 	writer, writeHandle, err = getRestorePipeWriter(currentPipe)
 	if err != nil {
 		return err
 	}
-	reader, err := getRestorePipeReader()
-	if err != nil {
-		return err
+	tocFiles := splitFileList(*tocFile)
+	dataFiles := splitFileList(*dataFile)
+	if len(tocFiles) != len(dataFiles) {
+		return errors.Errorf("Found %d segment TOC files but %d data files", len(tocFiles), len(dataFiles))
+	}
+	var throttle *utils.Throttle
+	if *maxBandwidth > 0 {
+		throttle = utils.NewThrottle(*maxBandwidth)
+	}
+	sources := make([]*restoreSource, len(dataFiles))
+	for i, sourceDataFile := range dataFiles {
+		source := &restoreSource{dataFile: sourceDataFile, segmentTOC: utils.NewSegmentTOC(tocFiles[i]), fileHasher: sha256.New()}
+		source.reader, err = getRestorePipeReader(sourceDataFile, source.fileHasher, throttle)
+		if err != nil {
+			return err
+		}
+		sources[i] = source
 	}
 
 	for i, oid := range oidList {
@@ -55,25 +92,17 @@ func doRestoreAgent() error {
 		} else {
 			nextPipe = ""
 		}
-		start := tocEntries[uint(oid)].StartByte
-		end := tocEntries[uint(oid)].EndByte
-		log(fmt.Sprintf("Start Byte: %d; End Byte: %d; Last Byte: %d", start, end, lastByte))
-		_, err := reader.Discard(int(start - lastByte))
-		if err != nil {
-			return err
-		}
-		log(fmt.Sprintf("Discarded %d bytes", start-lastByte))
-		bytesRead, err := io.CopyN(writer, reader, int64(end-start))
-		log(fmt.Sprintf("Read %d bytes", bytesRead))
-		if err != nil {
-			return errors.Wrap(err, strings.Trim(errBuf.String(), "\x00"))
+		for _, source := range sources {
+			err = restoreTableFromSource(source, oid)
+			if err != nil {
+				return err
+			}
 		}
 		log(fmt.Sprintf("Closing pipe for oid %d", oid))
 		err = flushAndCloseRestoreWriter()
 		if err != nil {
 			return err
 		}
-		lastByte = end
 
 		lastPipe = currentPipe
 		currentPipe = nextPipe
@@ -89,30 +118,112 @@ func doRestoreAgent() error {
 			}
 		}
 	}
+
+	/*
+	 * The checksum of the whole file can only be checked if every table in it
+	 * was restored, as otherwise we would have to read data we don't need.
+	 */
+	for _, source := range sources {
+		if source.segmentTOC.DataFileChecksum != "" && len(oidList) == len(source.segmentTOC.DataEntries) {
+			_, err = io.Copy(ioutil.Discard, source.reader)
+			if err != nil {
+				return err
+			}
+			err = verifyChecksum(source.segmentTOC.DataFileChecksum, source.fileHasher, fmt.Sprintf("data file %s", source.dataFile))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func restoreTableFromSource(source *restoreSource, oid int) error {
+	tocEntry := source.segmentTOC.DataEntries[uint(oid)]
+	start := tocEntry.StartByte
+	end := tocEntry.EndByte
+	log(fmt.Sprintf("Start Byte: %d; End Byte: %d; Last Byte: %d", start, end, source.lastByte))
+	_, err := source.reader.Discard(int(start - source.lastByte))
+	if err != nil {
+		return err
+	}
+	log(fmt.Sprintf("Discarded %d bytes", start-source.lastByte))
+	tableHasher := sha256.New()
+	bytesRead, err := io.CopyN(io.MultiWriter(writer, tableHasher), source.reader, int64(end-start))
+	log(fmt.Sprintf("Read %d bytes", bytesRead))
+	if err != nil {
+		return errors.Wrap(err, strings.Trim(errBuf.String(), "\x00"))
+	}
+	err = verifyChecksum(tocEntry.Checksum, tableHasher, fmt.Sprintf("data for table with oid %d in data file %s", oid, source.dataFile))
+	if err != nil {
+		return err
+	}
+	source.lastByte = end
+	return nil
+}
+
+/*
+ * Backups taken before checksums were recorded have no checksum in their
+ * segment TOC, so there is nothing to verify.
+ */
+func verifyChecksum(expectedChecksum string, hasher hash.Hash, description string) error {
+	if expectedChecksum == "" {
+		return nil
+	}
+	actualChecksum := hex.EncodeToString(hasher.Sum(nil))
+	if actualChecksum != expectedChecksum {
+		return errors.Errorf("Checksum verification failed for %s: expected %s, found %s.  The file may be corrupt.", description, expectedChecksum, actualChecksum)
+	}
 	return nil
 }
 
-func getRestorePipeReader() (*bufio.Reader, error) {
+/*
+ * Everything read from the data file is also written to fileHasher.  The data
+ * files share one throttle, if any, so that together they are read at no more
+ * than the maximum bandwidth.
+ */
+func getRestorePipeReader(sourceDataFile string, fileHasher io.Writer, throttle *utils.Throttle) (*bufio.Reader, error) {
 	var readHandle io.Reader
 	var err error
 	if *pluginConfigFile != "" {
-		readHandle, err = startRestorePluginCommand()
+		readHandle, err = startRestorePluginCommand(sourceDataFile)
 	} else {
-		readHandle, err = os.Open(*dataFile)
+		readHandle, err = os.Open(sourceDataFile)
 	}
 	if err != nil {
 		return nil, err
 	}
+	if throttle != nil {
+		readHandle = throttle.Reader(readHandle)
+	}
+	readHandle = io.TeeReader(readHandle, fileHasher)
+	if *encryptionKey != "" {
+		key, err := utils.ReadEncryptionKey(*encryptionKey, "")
+		if err != nil {
+			return nil, err
+		}
+		readHandle, err = utils.NewDecryptionReader(readHandle, key)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	var bufIoReader *bufio.Reader
-	if strings.HasSuffix(*dataFile, ".gz") {
+	switch *compressionType {
+	case "":
+		bufIoReader = bufio.NewReader(readHandle)
+	case "gzip":
 		gzipReader, err := gzip.NewReader(readHandle)
 		if err != nil {
 			return nil, err
 		}
 		bufIoReader = bufio.NewReader(gzipReader)
-	} else {
-		bufIoReader = bufio.NewReader(readHandle)
+	default:
+		decompressReader, err := startDecompressionCommand(*compressionType, readHandle)
+		if err != nil {
+			return nil, err
+		}
+		bufIoReader = bufio.NewReader(decompressReader)
 	}
 	// Check that no error has occurred in plugin command
 	errString := strings.Trim(errBuf.String(), "\x00")
@@ -131,12 +242,12 @@ func getRestorePipeWriter(currentPipe string) (*bufio.Writer, *os.File, error) {
 	return pipeWriter, fileHandle, nil
 }
 
-func startRestorePluginCommand() (io.Reader, error) {
+func startRestorePluginCommand(sourceDataFile string) (io.Reader, error) {
 	pluginConfig, err := utils.ReadPluginConfig(*pluginConfigFile)
 	if err != nil {
 		return nil, err
 	}
-	cmdStr := fmt.Sprintf("%s restore_data %s %s", pluginConfig.ExecutablePath, pluginConfig.ConfigPath, *dataFile)
+	cmdStr := fmt.Sprintf("%s restore_data %s %s", pluginConfig.ExecutablePath, pluginConfig.ConfigPath, sourceDataFile)
 	cmd := exec.Command("bash", "-c", cmdStr)
 
 	readHandle, err := cmd.StdoutPipe()
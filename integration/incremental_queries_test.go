@@ -5,6 +5,7 @@ import (
 
 	"github.com/greenplum-db/gp-common-go-libs/testhelper"
 	"github.com/greenplum-db/gpbackup/backup"
+	"github.com/greenplum-db/gpbackup/testutils"
 	"github.com/greenplum-db/gpbackup/utils"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -261,4 +262,54 @@ var _ = Describe("backup integration tests", func() {
 			})
 		})
 	})
+	Describe("GetHeapIncrementalMetadata", func() {
+		var heapTableFQN = "public.heap_foo"
+		var heapPartParentTableFQN = "public.heap_part"
+		var heapPartChildTableFQN = "public.heap_part_1_prt_child"
+		BeforeEach(func() {
+			testutils.SkipIfBefore6(connectionPool)
+			testhelper.AssertQueryRuns(connectionPool, fmt.Sprintf("CREATE TABLE %s (i int) DISTRIBUTED BY (i)", heapTableFQN))
+			testhelper.AssertQueryRuns(connectionPool, fmt.Sprintf(`CREATE TABLE %s (i int)
+	DISTRIBUTED BY (i)
+	PARTITION BY LIST (i)
+	(
+		PARTITION child VALUES (10)
+	);`, heapPartParentTableFQN))
+		})
+		AfterEach(func() {
+			testhelper.AssertQueryRuns(connectionPool, fmt.Sprintf(dropTableSQL, heapTableFQN))
+			testhelper.AssertQueryRuns(connectionPool, fmt.Sprintf(dropTableSQL, heapPartParentTableFQN))
+		})
+		It("has entries for the segments of heap tables without children", func() {
+			heapIncrementalMetadata := backup.GetHeapIncrementalMetadata(connectionPool)
+
+			Expect(heapIncrementalMetadata).To(HaveKey(heapTableFQN))
+			Expect(heapIncrementalMetadata).To(HaveKey(heapPartChildTableFQN))
+			Expect(heapIncrementalMetadata).To(Not(HaveKey(heapPartParentTableFQN)))
+			Expect(heapIncrementalMetadata).To(Not(HaveKey(aoTableFQN)))
+			Expect(heapIncrementalMetadata[heapTableFQN].Segments).To(Not(BeEmpty()))
+			Expect(heapIncrementalMetadata[heapTableFQN].LastDDLTimestamp).To(Not(BeEmpty()))
+		})
+		It("has entries for heap tables outside of the included tables when reading all tables", func() {
+			backupCmdFlags.Set(utils.INCLUDE_RELATION, heapPartChildTableFQN)
+
+			Expect(backup.GetHeapIncrementalMetadata(connectionPool)).To(Not(HaveKey(heapTableFQN)))
+			Expect(backup.GetHeapIncrementalMetadataForAllTables(connectionPool)).To(HaveKey(heapTableFQN))
+		})
+		It("detects a change to a heap table after data is inserted", func() {
+			initialHeapIncrementalMetadata := backup.GetHeapIncrementalMetadata(connectionPool)
+			testhelper.AssertQueryRuns(connectionPool, fmt.Sprintf(insertSQL, heapTableFQN))
+			heapIncrementalMetadata := backup.GetHeapIncrementalMetadata(connectionPool)
+
+			Expect(backup.HeapTableChanged(initialHeapIncrementalMetadata[heapTableFQN], heapIncrementalMetadata[heapTableFQN])).To(BeTrue())
+		})
+		It("detects a change to a heap table after it is truncated", func() {
+			testhelper.AssertQueryRuns(connectionPool, fmt.Sprintf(insertSQL, heapTableFQN))
+			initialHeapIncrementalMetadata := backup.GetHeapIncrementalMetadata(connectionPool)
+			testhelper.AssertQueryRuns(connectionPool, fmt.Sprintf("TRUNCATE %s", heapTableFQN))
+			heapIncrementalMetadata := backup.GetHeapIncrementalMetadata(connectionPool)
+
+			Expect(backup.HeapTableChanged(initialHeapIncrementalMetadata[heapTableFQN], heapIncrementalMetadata[heapTableFQN])).To(BeTrue())
+		})
+	})
 })
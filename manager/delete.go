@@ -0,0 +1,80 @@
+package manager
+
+import (
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gpbackup/backup_filepath"
+	"github.com/greenplum-db/gpbackup/backup_history"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+/*
+ * Returns the timestamps of all backups that have not been deleted and whose
+ * restore plan includes the given backup, i.e. the incremental backups that
+ * could no longer be restored if the given backup were deleted.
+ */
+func GetDependentBackups(history *backup_history.History, timestamp string) []string {
+	dependentBackups := make([]string, 0)
+	for _, backupConfig := range history.BackupConfigs {
+		if backupConfig.Deleted || backupConfig.Timestamp == timestamp {
+			continue
+		}
+		for _, restorePlanEntry := range backupConfig.RestorePlan {
+			if restorePlanEntry.Timestamp == timestamp {
+				dependentBackups = append(dependentBackups, backupConfig.Timestamp)
+				break
+			}
+		}
+	}
+	return dependentBackups
+}
+
+func DoDelete(timestamp string) {
+	history := ReadHistory()
+	backupConfig := MustFindBackupConfig(history, timestamp)
+	if backupConfig.Deleted {
+		gplog.Fatal(errors.Errorf("Backup %s has already been deleted", timestamp), "")
+	}
+	dependentBackups := GetDependentBackups(history, timestamp)
+	if len(dependentBackups) > 0 {
+		dependentBackupsStr := strings.Join(dependentBackups, ", ")
+		if !MustGetFlagBool(utils.FORCE) {
+			gplog.Fatal(errors.Errorf("Backup %s cannot be deleted because the following backups depend on it: %s.  Use --%s to delete it anyway.",
+				timestamp, dependentBackupsStr, utils.FORCE), "")
+		}
+		gplog.Warn("Deleting backup %s; the following backups depend on it and will no longer be restorable: %s", timestamp, dependentBackupsStr)
+	}
+
+	fpInfo := GetBackupFPInfo(backupConfig)
+	if backupConfig.Plugin != "" {
+		DeleteBackupUsingPlugin(backupConfig)
+	}
+	backup_filepath.DeleteBackupDirectoriesOnAllHosts(globalCluster, fpInfo, false)
+
+	err := backup_history.MarkBackupDeleted(historyFilePath, timestamp)
+	gplog.FatalOnError(err)
+	gplog.Info("Backup %s has been deleted", timestamp)
+}
+
+func DeleteBackupUsingPlugin(backupConfig *backup_history.BackupConfig) {
+	pluginConfigFile := MustGetFlagString(utils.PLUGIN_CONFIG)
+	if pluginConfigFile == "" {
+		gplog.Fatal(errors.Errorf("Backup %s was taken using plugin %s.  The --%s flag is required to delete it.",
+			backupConfig.Timestamp, backupConfig.Plugin, utils.PLUGIN_CONFIG), "")
+	}
+	var err error
+	pluginConfig, err = utils.ReadPluginConfig(pluginConfigFile)
+	gplog.FatalOnError(err)
+	if pluginConfig.ExecutablePath != backupConfig.Plugin {
+		gplog.Warn("Backup %s was taken using plugin %s, but plugin config specifies %s",
+			backupConfig.Timestamp, backupConfig.Plugin, pluginConfig.ExecutablePath)
+	}
+	pluginConfig.SetBackupPluginVersion(backupConfig.Timestamp, backupConfig.PluginVersion)
+	pluginConfig.CopyPluginConfigToAllHosts(globalCluster)
+
+	gplog.Info("Deleting backup %s from plugin destination", backupConfig.Timestamp)
+	err = pluginConfig.DeleteBackup(backupConfig.Timestamp)
+	gplog.FatalOnError(err)
+}
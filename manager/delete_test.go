@@ -0,0 +1,103 @@
+package manager_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/backup_history"
+	"github.com/greenplum-db/gpbackup/manager"
+	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/spf13/pflag"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("manager/delete tests", func() {
+	var (
+		history         *backup_history.History
+		historyFilePath string
+		tempDir         string
+		testCluster     *cluster.Cluster
+		testExecutor    *testhelper.TestExecutor
+	)
+
+	BeforeEach(func() {
+		cmdFlags = pflag.NewFlagSet("gpbackup_manager", pflag.ExitOnError)
+		manager.SetDeleteFlagDefaults(cmdFlags)
+		manager.SetCmdFlags(cmdFlags)
+
+		testExecutor = &testhelper.TestExecutor{ClusterOutput: &cluster.RemoteOutput{}}
+		testCluster = testutils.SetDefaultSegmentConfiguration()
+		testCluster.Executor = testExecutor
+		manager.SetCluster(testCluster)
+
+		history = &backup_history.History{BackupConfigs: []backup_history.BackupConfig{
+			{Timestamp: "20170104010101", DatabaseName: "testdb", Incremental: true,
+				RestorePlan: []backup_history.RestorePlanEntry{{Timestamp: "20170102010101"}, {Timestamp: "20170104010101"}}},
+			{Timestamp: "20170103010101", DatabaseName: "testdb", Incremental: true, Deleted: true,
+				RestorePlan: []backup_history.RestorePlanEntry{{Timestamp: "20170102010101"}, {Timestamp: "20170103010101"}}},
+			{Timestamp: "20170102010101", DatabaseName: "testdb",
+				RestorePlan: []backup_history.RestorePlanEntry{{Timestamp: "20170102010101"}}},
+			{Timestamp: "20170101010101", DatabaseName: "testdb", Plugin: "/tmp/my_plugin",
+				RestorePlan: []backup_history.RestorePlanEntry{{Timestamp: "20170101010101"}}},
+		}}
+		tempDir, _ = ioutil.TempDir("", "manager")
+		historyFilePath = filepath.Join(tempDir, "gpbackup_history.yaml")
+		err := history.WriteToFileAndMakeReadOnly(historyFilePath)
+		Expect(err).ToNot(HaveOccurred())
+		manager.SetHistoryFilePath(historyFilePath)
+	})
+	AfterEach(func() {
+		_ = os.RemoveAll(tempDir)
+	})
+	Describe("GetDependentBackups", func() {
+		It("returns backups that have not been deleted whose restore plan includes the timestamp", func() {
+			Expect(manager.GetDependentBackups(history, "20170102010101")).To(Equal([]string{"20170104010101"}))
+		})
+		It("returns an empty list for a backup with no dependent backups", func() {
+			Expect(manager.GetDependentBackups(history, "20170104010101")).To(BeEmpty())
+		})
+	})
+	Describe("DoDelete", func() {
+		It("deletes a backup with no dependent backups and marks it as deleted in the history file", func() {
+			manager.DoDelete("20170104010101")
+
+			Expect(testExecutor.NumExecutions).To(Equal(1))
+			resultHistory, err := backup_history.NewHistory(historyFilePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resultHistory.FindBackupConfig("20170104010101").Deleted).To(BeTrue())
+			Expect(resultHistory.FindBackupConfig("20170102010101").Deleted).To(BeFalse())
+		})
+		It("panics if other backups depend on the backup", func() {
+			defer testhelper.ShouldPanicWithMessage("Backup 20170102010101 cannot be deleted because the following backups depend on it: 20170104010101.  Use --force to delete it anyway.")
+			manager.DoDelete("20170102010101")
+		})
+		It("deletes a backup that other backups depend on when --force is specified", func() {
+			_ = cmdFlags.Set(utils.FORCE, "true")
+			manager.DoDelete("20170102010101")
+
+			Expect(testExecutor.NumExecutions).To(Equal(1))
+			resultHistory, err := backup_history.NewHistory(historyFilePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resultHistory.FindBackupConfig("20170102010101").Deleted).To(BeTrue())
+			testhelper.ExpectRegexp(logfile, "the following backups depend on it and will no longer be restorable: 20170104010101")
+		})
+		It("panics if the backup has already been deleted", func() {
+			defer testhelper.ShouldPanicWithMessage("Backup 20170103010101 has already been deleted")
+			manager.DoDelete("20170103010101")
+		})
+		It("panics if the backup is not in the history file", func() {
+			defer testhelper.ShouldPanicWithMessage("Backup with timestamp 20170105010101 not found in history file")
+			manager.DoDelete("20170105010101")
+		})
+		It("panics if the backup was taken with a plugin and no plugin config is specified", func() {
+			defer testhelper.ShouldPanicWithMessage("Backup 20170101010101 was taken using plugin /tmp/my_plugin.  The --plugin-config flag is required to delete it.")
+			manager.DoDelete("20170101010101")
+		})
+	})
+})
@@ -0,0 +1,292 @@
+package manager
+
+/*
+ * This file contains structs and functions for comparing the metadata of a
+ * backup against the metadata of another backup or of a live database.
+ */
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/iohelper"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/backup"
+	"github.com/greenplum-db/gpbackup/backup_history"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+/*
+ * Objects are identified by their section, object type, schema, name, and
+ * reference object.  When several TOC entries identify the same object, their
+ * statements are compared as a whole.
+ */
+type MetadataObject struct {
+	Section         string
+	ObjectType      string
+	Schema          string
+	Name            string
+	ReferenceObject string
+	Statement       string
+}
+
+func (object MetadataObject) key() string {
+	return fmt.Sprintf("%s\x00%s\x00%s\x00%s\x00%s", object.Section, object.ObjectType, object.Schema, object.Name, object.ReferenceObject)
+}
+
+func (object MetadataObject) DisplayName() string {
+	name := object.Name
+	if object.Schema != "" && object.ObjectType != "SCHEMA" {
+		name = fmt.Sprintf("%s.%s", object.Schema, object.Name)
+	}
+	if object.ReferenceObject != "" {
+		name = fmt.Sprintf("%s ON %s", name, object.ReferenceObject)
+	}
+	return name
+}
+
+const (
+	DIFF_ADDED   = "Added"
+	DIFF_DROPPED = "Dropped"
+	DIFF_CHANGED = "Changed"
+)
+
+/*
+ * Source is the object in the first set of metadata and Target the object in
+ * the second; only one of them is set for an added or dropped object.
+ */
+type ObjectDiff struct {
+	Status string
+	Source MetadataObject
+	Target MetadataObject
+}
+
+func (diff ObjectDiff) Object() MetadataObject {
+	if diff.Status == DIFF_DROPPED {
+		return diff.Source
+	}
+	return diff.Target
+}
+
+// Session GUCs are settings for restoring the metadata rather than objects, so they are not compared
+func GetMetadataObjects(toc *utils.TOC, metadataFile io.ReaderAt) []MetadataObject {
+	objects := make([]MetadataObject, 0)
+	objectIndexes := make(map[string]int, 0)
+	for _, section := range []string{"global", "predata", "postdata"} {
+		statements := toc.GetSQLStatementForObjectTypes(section, metadataFile, []string{}, []string{"SESSION GUCS"}, []string{}, []string{}, []string{}, []string{})
+		for _, statement := range statements {
+			object := MetadataObject{Section: section, ObjectType: statement.ObjectType, Schema: statement.Schema,
+				Name: statement.Name, ReferenceObject: statement.ReferenceObject, Statement: statement.Statement}
+			if index, ok := objectIndexes[object.key()]; ok {
+				objects[index].Statement += object.Statement
+			} else {
+				objectIndexes[object.key()] = len(objects)
+				objects = append(objects, object)
+			}
+		}
+	}
+	return objects
+}
+
+/*
+ * Dropped objects are returned in the order of the source metadata, followed
+ * by added and changed objects in the order of the target metadata.
+ */
+func DiffMetadataObjects(sourceObjects []MetadataObject, targetObjects []MetadataObject) []ObjectDiff {
+	sourceMap := make(map[string]MetadataObject, len(sourceObjects))
+	for _, object := range sourceObjects {
+		sourceMap[object.key()] = object
+	}
+	targetMap := make(map[string]MetadataObject, len(targetObjects))
+	for _, object := range targetObjects {
+		targetMap[object.key()] = object
+	}
+
+	diffs := make([]ObjectDiff, 0)
+	for _, object := range sourceObjects {
+		if _, ok := targetMap[object.key()]; !ok {
+			diffs = append(diffs, ObjectDiff{Status: DIFF_DROPPED, Source: object})
+		}
+	}
+	for _, object := range targetObjects {
+		sourceObject, ok := sourceMap[object.key()]
+		if !ok {
+			diffs = append(diffs, ObjectDiff{Status: DIFF_ADDED, Target: object})
+		} else if sourceObject.Statement != object.Statement {
+			diffs = append(diffs, ObjectDiff{Status: DIFF_CHANGED, Source: sourceObject, Target: object})
+		}
+	}
+	return diffs
+}
+
+func PrintMetadataDiff(output io.Writer, diffs []ObjectDiff) {
+	writer := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	utils.MustPrintf(writer, "STATUS\tSECTION\tOBJECT TYPE\tNAME\n")
+	for _, diff := range diffs {
+		object := diff.Object()
+		utils.MustPrintf(writer, "%s\t%s\t%s\t%s\n", diff.Status, object.Section, object.ObjectType, object.DisplayName())
+	}
+	_ = writer.Flush()
+}
+
+// Changed objects of these types can be dropped and created again without losing data
+var recreatableObjectTypes = map[string]bool{
+	"AGGREGATE":  true,
+	"CONSTRAINT": true,
+	"FUNCTION":   true,
+	"INDEX":      true,
+	"RULE":       true,
+	"TRIGGER":    true,
+	"VIEW":       true,
+}
+
+// The keyword for each object type that can be dropped with DROP <keyword> <name>
+var dropKeywords = map[string]string{
+	"AGGREGATE":                 "AGGREGATE",
+	"COLLATION":                 "COLLATION",
+	"CONVERSION":                "CONVERSION",
+	"DOMAIN":                    "DOMAIN",
+	"EXTENSION":                 "EXTENSION",
+	"FOREIGN SERVER":            "SERVER",
+	"FUNCTION":                  "FUNCTION",
+	"INDEX":                     "INDEX",
+	"LANGUAGE":                  "LANGUAGE",
+	"PROTOCOL":                  "PROTOCOL",
+	"RESOURCE GROUP":            "RESOURCE GROUP",
+	"RESOURCE QUEUE":            "RESOURCE QUEUE",
+	"ROLE":                      "ROLE",
+	"SCHEMA":                    "SCHEMA",
+	"SEQUENCE":                  "SEQUENCE",
+	"TABLE":                     "TABLE",
+	"TABLESPACE":                "TABLESPACE",
+	"TEXT SEARCH CONFIGURATION": "TEXT SEARCH CONFIGURATION",
+	"TEXT SEARCH DICTIONARY":    "TEXT SEARCH DICTIONARY",
+	"TEXT SEARCH PARSER":        "TEXT SEARCH PARSER",
+	"TEXT SEARCH TEMPLATE":      "TEXT SEARCH TEMPLATE",
+	"TYPE":                      "TYPE",
+	"VIEW":                      "VIEW",
+}
+
+// Returns an empty string if no DROP statement can be generated for the object
+func GetDropStatement(object MetadataObject) string {
+	switch object.ObjectType {
+	case "CONSTRAINT":
+		return fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s;", object.ReferenceObject, object.Name)
+	case "RULE", "TRIGGER":
+		return fmt.Sprintf("DROP %s %s ON %s;", object.ObjectType, object.Name, object.ReferenceObject)
+	}
+	keyword, ok := dropKeywords[object.ObjectType]
+	if !ok {
+		return ""
+	}
+	name := object.Name
+	if object.Schema != "" && object.ObjectType != "SCHEMA" {
+		name = fmt.Sprintf("%s.%s", object.Schema, object.Name)
+	}
+	return fmt.Sprintf("DROP %s %s;", keyword, name)
+}
+
+/*
+ * Dropped objects, and changed objects that can be recreated, are dropped in
+ * the reverse of the order in which they were listed so that dependent objects
+ * are dropped first.  Added and recreated objects are then created in the
+ * order of the target metadata.  Other changes must be made by hand, so they
+ * are only noted in comments.
+ */
+func WriteMigrationSQL(output io.Writer, diffs []ObjectDiff) {
+	utils.MustPrintf(output, "--\n-- Greenplum Database migration script generated by gpbackup_manager diff\n--\n")
+	for i := len(diffs) - 1; i >= 0; i-- {
+		diff := diffs[i]
+		if diff.Status == DIFF_ADDED || (diff.Status == DIFF_CHANGED && !recreatableObjectTypes[diff.Source.ObjectType]) {
+			continue
+		}
+		if dropStatement := GetDropStatement(diff.Source); dropStatement != "" {
+			utils.MustPrintf(output, "\n\n%s\n", dropStatement)
+		} else {
+			utils.MustPrintf(output, "\n\n-- %s %s was dropped and must be dropped by hand\n", diff.Source.ObjectType, diff.Source.DisplayName())
+		}
+	}
+	for _, diff := range diffs {
+		switch {
+		case diff.Status == DIFF_ADDED, diff.Status == DIFF_CHANGED && recreatableObjectTypes[diff.Target.ObjectType]:
+			utils.MustPrintf(output, "%s", diff.Target.Statement)
+		case diff.Status == DIFF_CHANGED:
+			utils.MustPrintf(output, "\n\n-- %s %s was changed and must be altered by hand\n", diff.Target.ObjectType, diff.Target.DisplayName())
+		}
+	}
+}
+
+func readBackupMetadataObjects(history *backup_history.History, timestamp string) []MetadataObject {
+	backupConfig := MustFindBackupConfig(history, timestamp)
+	if backupConfig.Plugin != "" {
+		gplog.Fatal(errors.Errorf("Backup %s was taken using plugin %s.  Backups taken using a plugin cannot be compared, as their metadata files are not stored on the cluster.", timestamp, backupConfig.Plugin), "")
+	}
+	if backupConfig.DataOnly {
+		gplog.Fatal(errors.Errorf("Backup %s is a data-only backup and has no metadata to compare", timestamp), "")
+	}
+	if backupConfig.Encrypted && utils.GetEncryptionKey() == nil {
+		gplog.Fatal(errors.Errorf("Backup %s is encrypted.  Use --%s or --%s to provide the encryption key.",
+			timestamp, utils.ENCRYPTION_KEY_FILE, utils.ENCRYPTION_KEY_CMD), "")
+	}
+	if !backupConfig.Encrypted && utils.GetEncryptionKey() != nil {
+		// The key is given for the other backup being compared, and does not apply to this one
+		key := utils.GetEncryptionKey()
+		utils.SetEncryptionKey(nil)
+		defer utils.SetEncryptionKey(key)
+	}
+	fpInfo := GetBackupFPInfo(backupConfig)
+	toc := mustReadTOC(fpInfo, backupConfig)
+	toc.InitializeMetadataEntryMap()
+	metadataFilename := fpInfo.GetMetadataFilePath()
+	if backupConfig.Checksummed {
+		err := utils.VerifyChecksums(fpInfo.GetChecksumFilePath(), []string{metadataFilename})
+		gplog.FatalOnError(err)
+	}
+	return GetMetadataObjects(toc, utils.MustOpenMetadataFileForReading(metadataFilename))
+}
+
+func readDatabaseMetadataObjects(dbName string) []MetadataObject {
+	tempDir, err := ioutil.TempDir("", "gpbackup_manager_diff")
+	gplog.FatalOnError(err)
+	defer os.RemoveAll(tempDir)
+	metadataFilename := filepath.Join(tempDir, "metadata.sql")
+	toc := backup.WriteDatabaseMetadata(dbName, metadataFilename)
+	return GetMetadataObjects(toc, utils.MustOpenMetadataFileForReading(metadataFilename))
+}
+
+/*
+ * The backup with the given timestamp is compared against the backup with the
+ * target timestamp if one is given, or else against the live database dbName.
+ */
+func DoDiff(timestamp string, targetTimestamp string, dbName string) {
+	history := ReadHistory()
+	sourceObjects := readBackupMetadataObjects(history, timestamp)
+	var targetObjects []MetadataObject
+	if targetTimestamp != "" {
+		gplog.Info("Comparing the metadata of backup %s to backup %s", timestamp, targetTimestamp)
+		targetObjects = readBackupMetadataObjects(history, targetTimestamp)
+	} else {
+		gplog.Info("Comparing the metadata of backup %s to database %s", timestamp, dbName)
+		targetObjects = readDatabaseMetadataObjects(dbName)
+	}
+
+	diffs := DiffMetadataObjects(sourceObjects, targetObjects)
+	if len(diffs) == 0 {
+		gplog.Info("No differences found")
+	} else {
+		PrintMetadataDiff(operating.System.Stdout, diffs)
+	}
+	if migrationFilename := MustGetFlagString(utils.MIGRATION_SQL); migrationFilename != "" {
+		migrationFile := iohelper.MustOpenFileForWriting(migrationFilename)
+		WriteMigrationSQL(migrationFile, diffs)
+		err := migrationFile.Close()
+		gplog.FatalOnError(err)
+		gplog.Info("Migration SQL written to %s", migrationFilename)
+	}
+}
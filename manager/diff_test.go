@@ -0,0 +1,132 @@
+package manager_test
+
+import (
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/manager"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/spf13/pflag"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("manager/diff tests", func() {
+	var (
+		schema   = manager.MetadataObject{Section: "predata", ObjectType: "SCHEMA", Schema: "foo", Name: "foo", Statement: "\n\nCREATE SCHEMA foo;\n"}
+		table    = manager.MetadataObject{Section: "predata", ObjectType: "TABLE", Schema: "foo", Name: "bar", Statement: "\n\nCREATE TABLE foo.bar (i int);\n"}
+		newTable = manager.MetadataObject{Section: "predata", ObjectType: "TABLE", Schema: "foo", Name: "bar", Statement: "\n\nCREATE TABLE foo.bar (i int, j int);\n"}
+		view     = manager.MetadataObject{Section: "predata", ObjectType: "VIEW", Schema: "foo", Name: "v", Statement: "\n\nCREATE VIEW foo.v AS SELECT 1;\n"}
+		newView  = manager.MetadataObject{Section: "predata", ObjectType: "VIEW", Schema: "foo", Name: "v", Statement: "\n\nCREATE VIEW foo.v AS SELECT 2;\n"}
+		index    = manager.MetadataObject{Section: "postdata", ObjectType: "INDEX", Schema: "foo", Name: "bar_idx", ReferenceObject: "foo.bar", Statement: "\n\nCREATE INDEX bar_idx ON foo.bar USING btree (i);\n"}
+		trigger  = manager.MetadataObject{Section: "postdata", ObjectType: "TRIGGER", Schema: "foo", Name: "bar_trigger", ReferenceObject: "foo.bar", Statement: "\n\nCREATE TRIGGER bar_trigger AFTER INSERT ON foo.bar FOR EACH ROW EXECUTE PROCEDURE foo.f();\n"}
+	)
+	BeforeEach(func() {
+		cmdFlags = pflag.NewFlagSet("gpbackup_manager", pflag.ExitOnError)
+		manager.SetDiffFlagDefaults(cmdFlags)
+		manager.SetCmdFlags(cmdFlags)
+	})
+	Describe("ValidateDiffArgs", func() {
+		It("returns the second timestamp", func() {
+			Expect(manager.ValidateDiffArgs([]string{"20170101010101", "20170102010101"})).To(Equal("20170102010101"))
+		})
+		It("returns no timestamp when comparing against a database", func() {
+			_ = cmdFlags.Set(utils.DBNAME, "testdb")
+			Expect(manager.ValidateDiffArgs([]string{"20170101010101"})).To(Equal(""))
+		})
+		It("panics if there is nothing to compare against", func() {
+			defer testhelper.ShouldPanicWithMessage("Either a second timestamp or --dbname must be given to compare the backup against")
+			manager.ValidateDiffArgs([]string{"20170101010101"})
+		})
+		It("panics if both a second timestamp and a database are given", func() {
+			_ = cmdFlags.Set(utils.DBNAME, "testdb")
+			defer testhelper.ShouldPanicWithMessage("Cannot compare a backup to both another backup and a database")
+			manager.ValidateDiffArgs([]string{"20170101010101", "20170102010101"})
+		})
+	})
+	Describe("GetMetadataObjects", func() {
+		It("combines the statements of entries for the same object and skips session GUCs", func() {
+			toc := &utils.TOC{}
+			toc.InitializeMetadataEntryMap()
+			metadata := ""
+			addStatement := func(section string, entry utils.MetadataEntry, statement string) {
+				toc.AddMetadataEntry(section, entry, uint64(len(metadata)), uint64(len(metadata)+len(statement)))
+				metadata += statement
+			}
+			addStatement("global", utils.MetadataEntry{ObjectType: "SESSION GUCS"}, "SET client_encoding = 'UTF8';")
+			addStatement("predata", utils.MetadataEntry{Schema: "foo", Name: "bar", ObjectType: "TABLE"}, "CREATE TABLE foo.bar (i int);")
+			addStatement("predata", utils.MetadataEntry{Schema: "foo", Name: "bar", ObjectType: "TABLE"}, "ALTER TABLE foo.bar OWNER TO testrole;")
+			addStatement("postdata", utils.MetadataEntry{Schema: "foo", Name: "bar_idx", ObjectType: "INDEX", ReferenceObject: "foo.bar"}, "CREATE INDEX bar_idx ON foo.bar USING btree (i);")
+
+			objects := manager.GetMetadataObjects(toc, strings.NewReader(metadata))
+			Expect(objects).To(Equal([]manager.MetadataObject{
+				{Section: "predata", ObjectType: "TABLE", Schema: "foo", Name: "bar", Statement: "CREATE TABLE foo.bar (i int);ALTER TABLE foo.bar OWNER TO testrole;"},
+				{Section: "postdata", ObjectType: "INDEX", Schema: "foo", Name: "bar_idx", ReferenceObject: "foo.bar", Statement: "CREATE INDEX bar_idx ON foo.bar USING btree (i);"},
+			}))
+		})
+	})
+	Describe("DiffMetadataObjects", func() {
+		It("returns dropped objects followed by added and changed objects", func() {
+			diffs := manager.DiffMetadataObjects([]manager.MetadataObject{schema, table, index}, []manager.MetadataObject{schema, newTable, view})
+			Expect(diffs).To(Equal([]manager.ObjectDiff{
+				{Status: manager.DIFF_DROPPED, Source: index},
+				{Status: manager.DIFF_CHANGED, Source: table, Target: newTable},
+				{Status: manager.DIFF_ADDED, Target: view},
+			}))
+		})
+		It("returns no differences for identical metadata", func() {
+			Expect(manager.DiffMetadataObjects([]manager.MetadataObject{schema, table}, []manager.MetadataObject{schema, table})).To(BeEmpty())
+		})
+	})
+	Describe("PrintMetadataDiff", func() {
+		It("prints one line per difference", func() {
+			manager.PrintMetadataDiff(buffer, manager.DiffMetadataObjects([]manager.MetadataObject{schema, table, index}, []manager.MetadataObject{schema, newTable, view}))
+			Expect(string(buffer.Contents())).To(Equal(`STATUS   SECTION   OBJECT TYPE  NAME
+Dropped  postdata  INDEX        foo.bar_idx ON foo.bar
+Changed  predata   TABLE        foo.bar
+Added    predata   VIEW         foo.v
+`))
+		})
+	})
+	Describe("GetDropStatement", func() {
+		It("drops a schema by name", func() {
+			Expect(manager.GetDropStatement(schema)).To(Equal("DROP SCHEMA foo;"))
+		})
+		It("drops a schema-qualified object", func() {
+			Expect(manager.GetDropStatement(index)).To(Equal("DROP INDEX foo.bar_idx;"))
+		})
+		It("drops a trigger from its table", func() {
+			Expect(manager.GetDropStatement(trigger)).To(Equal("DROP TRIGGER bar_trigger ON foo.bar;"))
+		})
+		It("drops a constraint from its table", func() {
+			constraint := manager.MetadataObject{ObjectType: "CONSTRAINT", Schema: "foo", Name: "bar_pkey", ReferenceObject: "foo.bar"}
+			Expect(manager.GetDropStatement(constraint)).To(Equal("ALTER TABLE foo.bar DROP CONSTRAINT bar_pkey;"))
+		})
+		It("returns an empty string for an object type that cannot be dropped by name", func() {
+			Expect(manager.GetDropStatement(manager.MetadataObject{ObjectType: "DATABASE GUC", Name: "testdb"})).To(Equal(""))
+		})
+	})
+	Describe("WriteMigrationSQL", func() {
+		It("drops, creates, and recreates objects, and notes changes that must be made by hand", func() {
+			diffs := manager.DiffMetadataObjects([]manager.MetadataObject{schema, table, view, index, trigger}, []manager.MetadataObject{schema, newTable, newView, index})
+			manager.WriteMigrationSQL(buffer, diffs)
+			Expect(string(buffer.Contents())).To(Equal(`--
+-- Greenplum Database migration script generated by gpbackup_manager diff
+--
+
+
+DROP VIEW foo.v;
+
+
+DROP TRIGGER bar_trigger ON foo.bar;
+
+
+-- TABLE foo.bar was changed and must be altered by hand
+
+
+CREATE VIEW foo.v AS SELECT 2;
+`))
+		})
+	})
+})
@@ -0,0 +1,72 @@
+package manager
+
+import (
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/spf13/pflag"
+)
+
+/*
+ * This file contains global variables and setter functions for those variables
+ * used in testing.
+ */
+
+/*
+ * Non-flag variables
+ */
+
+var (
+	connectionPool  *dbconn.DBConn
+	globalCluster   *cluster.Cluster
+	historyFilePath string
+	pluginConfig    *utils.PluginConfig
+	version         string
+)
+
+/*
+ * Command-line flags
+ */
+var cmdFlags *pflag.FlagSet
+
+/*
+ * Setter functions
+ */
+
+func SetCmdFlags(flagSet *pflag.FlagSet) {
+	cmdFlags = flagSet
+}
+
+func SetConnection(conn *dbconn.DBConn) {
+	connectionPool = conn
+}
+
+func SetCluster(cluster *cluster.Cluster) {
+	globalCluster = cluster
+}
+
+func SetHistoryFilePath(filePath string) {
+	historyFilePath = filePath
+}
+
+func SetPluginConfig(config *utils.PluginConfig) {
+	pluginConfig = config
+}
+
+// Util functions to enable ease of access to global flag values
+
+func MustGetFlagString(flagName string) string {
+	return utils.MustGetFlagString(cmdFlags, flagName)
+}
+
+func MustGetFlagBool(flagName string) bool {
+	return utils.MustGetFlagBool(cmdFlags, flagName)
+}
+
+func GetVersion() string {
+	return version
+}
+
+func SetVersion(v string) {
+	version = v
+}
@@ -0,0 +1,166 @@
+package manager
+
+import (
+	"io"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/iohelper"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/backup_filepath"
+	"github.com/greenplum-db/gpbackup/backup_history"
+	"github.com/greenplum-db/gpbackup/utils"
+	"gopkg.in/yaml.v2"
+)
+
+/*
+ * Timestamps are compared as strings, which is safe because they are all
+ * zero-padded and in YYYYMMDDHHMMSS format.
+ */
+type BackupFilter struct {
+	AfterTimestamp  string
+	BeforeTimestamp string
+	DatabaseName    string
+	FullOnly        bool
+	IncludeDeleted  bool
+	IncrementalOnly bool
+	Plugin          string
+}
+
+func NewBackupFilter() BackupFilter {
+	return BackupFilter{
+		AfterTimestamp:  MustGetFlagString(utils.AFTER_TIMESTAMP),
+		BeforeTimestamp: MustGetFlagString(utils.BEFORE_TIMESTAMP),
+		DatabaseName:    MustGetFlagString(utils.DBNAME),
+		FullOnly:        MustGetFlagBool(utils.FULL),
+		IncludeDeleted:  MustGetFlagBool(utils.INCLUDE_DELETED),
+		IncrementalOnly: MustGetFlagBool(utils.INCREMENTAL),
+		Plugin:          MustGetFlagString(utils.PLUGIN),
+	}
+}
+
+func (filter BackupFilter) Matches(backupConfig *backup_history.BackupConfig) bool {
+	if backupConfig.Deleted && !filter.IncludeDeleted {
+		return false
+	}
+	if filter.AfterTimestamp != "" && backupConfig.Timestamp <= filter.AfterTimestamp {
+		return false
+	}
+	if filter.BeforeTimestamp != "" && backupConfig.Timestamp >= filter.BeforeTimestamp {
+		return false
+	}
+	// The database name is stored quoted, so allow users to pass it either way
+	if filter.DatabaseName != "" && filter.DatabaseName != backupConfig.DatabaseName &&
+		filter.DatabaseName != utils.UnquoteIdent(backupConfig.DatabaseName) {
+		return false
+	}
+	if filter.FullOnly && backupConfig.Incremental {
+		return false
+	}
+	if filter.IncrementalOnly && !backupConfig.Incremental {
+		return false
+	}
+	if filter.Plugin != "" && filter.Plugin != backupConfig.Plugin &&
+		filter.Plugin != filepath.Base(backupConfig.Plugin) {
+		return false
+	}
+	return true
+}
+
+func FilterBackupConfigs(backupConfigs []backup_history.BackupConfig, filter BackupFilter) []backup_history.BackupConfig {
+	filteredConfigs := make([]backup_history.BackupConfig, 0)
+	for _, backupConfig := range backupConfigs {
+		if filter.Matches(&backupConfig) {
+			filteredConfigs = append(filteredConfigs, backupConfig)
+		}
+	}
+	return filteredConfigs
+}
+
+func PrintBackupList(output io.Writer, backupConfigs []backup_history.BackupConfig) {
+	writer := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	utils.MustPrintf(writer, "TIMESTAMP\tDATABASE\tTYPE\tSECTIONS\tPLUGIN\tSTATUS\n")
+	for _, backupConfig := range backupConfigs {
+		utils.MustPrintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n", backupConfig.Timestamp, backupConfig.DatabaseName,
+			getBackupTypeString(&backupConfig), getSectionString(&backupConfig),
+			getPluginString(&backupConfig), getStatusString(&backupConfig))
+	}
+	_ = writer.Flush()
+}
+
+func getBackupTypeString(backupConfig *backup_history.BackupConfig) string {
+	if backupConfig.Differential {
+		return "Differential"
+	} else if backupConfig.Incremental {
+		return "Incremental"
+	}
+	return "Full"
+}
+
+func getSectionString(backupConfig *backup_history.BackupConfig) string {
+	if backupConfig.DataOnly {
+		return "Data Only"
+	} else if backupConfig.MetadataOnly {
+		return "Metadata Only"
+	}
+	return "All Sections"
+}
+
+func getPluginString(backupConfig *backup_history.BackupConfig) string {
+	if backupConfig.Plugin == "" {
+		return "None"
+	}
+	return filepath.Base(backupConfig.Plugin)
+}
+
+func getStatusString(backupConfig *backup_history.BackupConfig) string {
+	if backupConfig.Deleted {
+		return "Deleted"
+	}
+	return "Available"
+}
+
+func DoList() {
+	history := ReadHistory()
+	backupConfigs := FilterBackupConfigs(history.BackupConfigs, NewBackupFilter())
+	if len(backupConfigs) == 0 {
+		gplog.Info("No backups found matching the specified criteria")
+		return
+	}
+	PrintBackupList(operating.System.Stdout, backupConfigs)
+}
+
+/*
+ * The history file only records the user-specified backup directory, not the
+ * segment prefix, so we use the prefix of the current cluster as gpbackup does.
+ */
+func GetBackupFPInfo(backupConfig *backup_history.BackupConfig) backup_filepath.FilePathInfo {
+	segPrefix := ""
+	if backupConfig.BackupDir != "" {
+		segPrefix = backup_filepath.GetSegPrefix(connectionPool)
+	}
+	return backup_filepath.NewFilePathInfo(globalCluster, backupConfig.BackupDir, backupConfig.Timestamp, segPrefix)
+}
+
+func DoShow(timestamp string) {
+	history := ReadHistory()
+	backupConfig := MustFindBackupConfig(history, timestamp)
+	PrintBackupConfig(operating.System.Stdout, backupConfig)
+
+	fpInfo := GetBackupFPInfo(backupConfig)
+	reportFilename := fpInfo.GetBackupReportFilePath()
+	if !iohelper.FileExistsAndIsReadable(reportFilename) {
+		gplog.Warn("Unable to read report file %s for backup %s", reportFilename, timestamp)
+		return
+	}
+	reportContents, err := operating.System.ReadFile(reportFilename)
+	gplog.FatalOnError(err)
+	utils.MustPrintf(operating.System.Stdout, "\n%s\n", reportContents)
+}
+
+func PrintBackupConfig(output io.Writer, backupConfig *backup_history.BackupConfig) {
+	configContents, err := yaml.Marshal(backupConfig)
+	gplog.FatalOnError(err)
+	utils.MustPrintf(output, "%s", configContents)
+}
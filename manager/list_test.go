@@ -0,0 +1,125 @@
+package manager_test
+
+import (
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/backup_history"
+	"github.com/greenplum-db/gpbackup/manager"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/spf13/pflag"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("manager/list tests", func() {
+	var backupConfigs []backup_history.BackupConfig
+
+	BeforeEach(func() {
+		cmdFlags = pflag.NewFlagSet("gpbackup_manager", pflag.ExitOnError)
+		manager.SetListFlagDefaults(cmdFlags)
+		manager.SetCmdFlags(cmdFlags)
+		backupConfigs = []backup_history.BackupConfig{
+			{Timestamp: "20170104010101", DatabaseName: "testdb", Incremental: true, Plugin: "/usr/local/bin/my_plugin",
+				RestorePlan: []backup_history.RestorePlanEntry{{Timestamp: "20170102010101"}, {Timestamp: "20170104010101"}}},
+			{Timestamp: "20170103010101", DatabaseName: `"TestDB"`, MetadataOnly: true},
+			{Timestamp: "20170102010101", DatabaseName: "testdb", Plugin: "/usr/local/bin/my_plugin"},
+			{Timestamp: "20170101010101", DatabaseName: "testdb", DataOnly: true, Deleted: true},
+		}
+	})
+	Describe("ValidateListFlags", func() {
+		It("panics if both --full and --incremental are specified", func() {
+			_ = cmdFlags.Set(utils.FULL, "true")
+			_ = cmdFlags.Set(utils.INCREMENTAL, "true")
+			defer testhelper.ShouldPanicWithMessage("The following flags may not be specified together: full, incremental")
+			manager.ValidateListFlags()
+		})
+		It("panics if a timestamp filter is invalid", func() {
+			_ = cmdFlags.Set(utils.BEFORE_TIMESTAMP, "2017")
+			defer testhelper.ShouldPanicWithMessage("Timestamp 2017 is invalid.  Timestamps must be in the format YYYYMMDDHHMMSS.")
+			manager.ValidateListFlags()
+		})
+		It("does not panic with valid timestamp filters", func() {
+			_ = cmdFlags.Set(utils.AFTER_TIMESTAMP, "20170101010101")
+			_ = cmdFlags.Set(utils.BEFORE_TIMESTAMP, "20170104010101")
+			manager.ValidateListFlags()
+		})
+	})
+	Describe("FilterBackupConfigs", func() {
+		getTimestamps := func(configs []backup_history.BackupConfig) []string {
+			timestamps := make([]string, 0)
+			for _, config := range configs {
+				timestamps = append(timestamps, config.Timestamp)
+			}
+			return timestamps
+		}
+		It("excludes deleted backups by default", func() {
+			filtered := manager.FilterBackupConfigs(backupConfigs, manager.NewBackupFilter())
+			Expect(getTimestamps(filtered)).To(Equal([]string{"20170104010101", "20170103010101", "20170102010101"}))
+		})
+		It("includes deleted backups when --include-deleted is specified", func() {
+			_ = cmdFlags.Set(utils.INCLUDE_DELETED, "true")
+			filtered := manager.FilterBackupConfigs(backupConfigs, manager.NewBackupFilter())
+			Expect(getTimestamps(filtered)).To(Equal([]string{"20170104010101", "20170103010101", "20170102010101", "20170101010101"}))
+		})
+		It("filters by date range, excluding the boundary timestamps", func() {
+			_ = cmdFlags.Set(utils.AFTER_TIMESTAMP, "20170102010101")
+			_ = cmdFlags.Set(utils.BEFORE_TIMESTAMP, "20170104010101")
+			filtered := manager.FilterBackupConfigs(backupConfigs, manager.NewBackupFilter())
+			Expect(getTimestamps(filtered)).To(Equal([]string{"20170103010101"}))
+		})
+		It("filters by database name, quoted or unquoted", func() {
+			_ = cmdFlags.Set(utils.DBNAME, "TestDB")
+			filtered := manager.FilterBackupConfigs(backupConfigs, manager.NewBackupFilter())
+			Expect(getTimestamps(filtered)).To(Equal([]string{"20170103010101"}))
+
+			_ = cmdFlags.Set(utils.DBNAME, `"TestDB"`)
+			filtered = manager.FilterBackupConfigs(backupConfigs, manager.NewBackupFilter())
+			Expect(getTimestamps(filtered)).To(Equal([]string{"20170103010101"}))
+		})
+		It("filters full backups", func() {
+			_ = cmdFlags.Set(utils.FULL, "true")
+			filtered := manager.FilterBackupConfigs(backupConfigs, manager.NewBackupFilter())
+			Expect(getTimestamps(filtered)).To(Equal([]string{"20170103010101", "20170102010101"}))
+		})
+		It("filters incremental backups", func() {
+			_ = cmdFlags.Set(utils.INCREMENTAL, "true")
+			filtered := manager.FilterBackupConfigs(backupConfigs, manager.NewBackupFilter())
+			Expect(getTimestamps(filtered)).To(Equal([]string{"20170104010101"}))
+		})
+		It("filters by plugin path or plugin executable name", func() {
+			_ = cmdFlags.Set(utils.PLUGIN, "my_plugin")
+			filtered := manager.FilterBackupConfigs(backupConfigs, manager.NewBackupFilter())
+			Expect(getTimestamps(filtered)).To(Equal([]string{"20170104010101", "20170102010101"}))
+
+			_ = cmdFlags.Set(utils.PLUGIN, "/usr/local/bin/my_plugin")
+			filtered = manager.FilterBackupConfigs(backupConfigs, manager.NewBackupFilter())
+			Expect(getTimestamps(filtered)).To(Equal([]string{"20170104010101", "20170102010101"}))
+		})
+	})
+	Describe("PrintBackupList", func() {
+		It("prints one aligned row per backup", func() {
+			manager.PrintBackupList(buffer, backupConfigs)
+			Expect(string(buffer.Contents())).To(Equal(`TIMESTAMP       DATABASE  TYPE         SECTIONS       PLUGIN     STATUS
+20170104010101  testdb    Incremental  All Sections   my_plugin  Available
+20170103010101  "TestDB"  Full         Metadata Only  None       Available
+20170102010101  testdb    Full         All Sections   my_plugin  Available
+20170101010101  testdb    Full         Data Only      None       Deleted
+`))
+		})
+		It("prints the type of a differential backup", func() {
+			differentialConfigs := []backup_history.BackupConfig{
+				{Timestamp: "20170105010101", DatabaseName: "testdb", Incremental: true, Differential: true},
+			}
+			manager.PrintBackupList(buffer, differentialConfigs)
+			Expect(string(buffer.Contents())).To(ContainSubstring("20170105010101  testdb    Differential  All Sections  None    Available"))
+		})
+	})
+	Describe("PrintBackupConfig", func() {
+		It("prints the backup config as yaml", func() {
+			manager.PrintBackupConfig(buffer, &backupConfigs[2])
+			testhelper.ExpectRegexp(buffer, "databasename: testdb\n")
+			testhelper.ExpectRegexp(buffer, "plugin: /usr/local/bin/my_plugin\n")
+			testhelper.ExpectRegexp(buffer, "timestamp: \"20170102010101\"\n")
+		})
+	})
+})
@@ -0,0 +1,244 @@
+package manager
+
+/*
+ * gpbackup_manager operates on backups that have already been taken, using
+ * gpbackup_history.yaml in the master data directory as the list of backups
+ * that exist on the cluster.
+ */
+
+import (
+	"fmt"
+	"os"
+	"runtime/debug"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/iohelper"
+	"github.com/greenplum-db/gpbackup/backup_filepath"
+	"github.com/greenplum-db/gpbackup/backup_history"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+func SetCommonFlagDefaults(flagSet *pflag.FlagSet) {
+	flagSet.Bool(utils.DEBUG, false, "Print verbose and debug log messages")
+	flagSet.Bool(utils.QUIET, false, "Suppress non-warning, non-error log messages")
+	flagSet.Bool(utils.VERBOSE, false, "Print verbose log messages")
+}
+
+func SetListFlagDefaults(flagSet *pflag.FlagSet) {
+	SetCommonFlagDefaults(flagSet)
+	flagSet.String(utils.AFTER_TIMESTAMP, "", "Only list backups taken after the specified timestamp, in the format YYYYMMDDHHMMSS")
+	flagSet.String(utils.BEFORE_TIMESTAMP, "", "Only list backups taken before the specified timestamp, in the format YYYYMMDDHHMMSS")
+	flagSet.String(utils.DBNAME, "", "Only list backups of the specified database")
+	flagSet.Bool(utils.FULL, false, "Only list full backups")
+	flagSet.Bool(utils.INCLUDE_DELETED, false, "Also list backups that have been deleted")
+	flagSet.Bool(utils.INCREMENTAL, false, "Only list incremental backups")
+	flagSet.String(utils.PLUGIN, "", "Only list backups taken with the specified plugin executable")
+}
+
+func SetShowFlagDefaults(flagSet *pflag.FlagSet) {
+	SetCommonFlagDefaults(flagSet)
+}
+
+func SetDeleteFlagDefaults(flagSet *pflag.FlagSet) {
+	SetCommonFlagDefaults(flagSet)
+	flagSet.Bool(utils.FORCE, false, "Delete the backup even if other backups in the history file depend on it")
+	flagSet.String(utils.PLUGIN_CONFIG, "", "The configuration file to use for a plugin; required to delete a backup taken with a plugin")
+}
+
+func SetSynthesizeFlagDefaults(flagSet *pflag.FlagSet) {
+	SetCommonFlagDefaults(flagSet)
+	flagSet.String(utils.ENCRYPTION_KEY_CMD, "", "A command that prints the key with which the backups were encrypted, as 64 hexadecimal characters")
+	flagSet.String(utils.ENCRYPTION_KEY_FILE, "", "A file containing the key with which the backups were encrypted, as 64 hexadecimal characters")
+}
+
+func SetDiffFlagDefaults(flagSet *pflag.FlagSet) {
+	SetCommonFlagDefaults(flagSet)
+	flagSet.String(utils.DBNAME, "", "Compare the backup to the live metadata of the specified database instead of to another backup")
+	flagSet.String(utils.ENCRYPTION_KEY_CMD, "", "A command that prints the key with which the backups were encrypted, as 64 hexadecimal characters")
+	flagSet.String(utils.ENCRYPTION_KEY_FILE, "", "A file containing the key with which the backups were encrypted, as 64 hexadecimal characters")
+	flagSet.String(utils.MIGRATION_SQL, "", "Write SQL statements that make the first set of metadata match the second to the specified file")
+}
+
+// This function handles setup that can be done before parsing flags.
+func DoInit(cmd *cobra.Command) {
+	gplog.InitializeLogging("gpbackup_manager", "")
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the backups recorded in the backup history file",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			defer DoTeardown()
+			SetCmdFlags(cmd.Flags())
+			ValidateListFlags()
+			DoSetup()
+			DoList()
+		}}
+	SetListFlagDefaults(listCmd.Flags())
+
+	showCmd := &cobra.Command{
+		Use:   "show <timestamp>",
+		Short: "Show the configuration and report of a backup",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			defer DoTeardown()
+			SetCmdFlags(cmd.Flags())
+			ValidateTimestamp(args[0])
+			DoSetup()
+			DoShow(args[0])
+		}}
+	SetShowFlagDefaults(showCmd.Flags())
+
+	deleteCmd := &cobra.Command{
+		Use:   "delete <timestamp>",
+		Short: "Delete a backup from all hosts or from the plugin destination",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			defer DoTeardown()
+			SetCmdFlags(cmd.Flags())
+			ValidateTimestamp(args[0])
+			err := utils.ValidateFullPath(MustGetFlagString(utils.PLUGIN_CONFIG))
+			gplog.FatalOnError(err)
+			DoSetup()
+			DoDelete(args[0])
+		}}
+	SetDeleteFlagDefaults(deleteCmd.Flags())
+
+	synthesizeCmd := &cobra.Command{
+		Use:   "synthesize <timestamp>",
+		Short: "Combine an incremental backup and the backups it depends on into a new full backup",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			defer DoTeardown()
+			SetCmdFlags(cmd.Flags())
+			ValidateTimestamp(args[0])
+			utils.CheckExclusiveFlags(cmdFlags, utils.ENCRYPTION_KEY_FILE, utils.ENCRYPTION_KEY_CMD)
+			DoSetup()
+			utils.InitializeEncryptionKey(MustGetFlagString(utils.ENCRYPTION_KEY_FILE), MustGetFlagString(utils.ENCRYPTION_KEY_CMD))
+			DoSynthesize(args[0])
+		}}
+	SetSynthesizeFlagDefaults(synthesizeCmd.Flags())
+
+	diffCmd := &cobra.Command{
+		Use:   "diff <timestamp> [<timestamp>]",
+		Short: "Compare the metadata of a backup to that of another backup or of a live database",
+		Args:  cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			defer DoTeardown()
+			SetCmdFlags(cmd.Flags())
+			targetTimestamp := ValidateDiffArgs(args)
+			DoSetup()
+			utils.InitializeEncryptionKey(MustGetFlagString(utils.ENCRYPTION_KEY_FILE), MustGetFlagString(utils.ENCRYPTION_KEY_CMD))
+			DoDiff(args[0], targetTimestamp, MustGetFlagString(utils.DBNAME))
+		}}
+	SetDiffFlagDefaults(diffCmd.Flags())
+
+	cmd.AddCommand(listCmd, showCmd, deleteCmd, synthesizeCmd, diffCmd)
+}
+
+func ValidateTimestamp(timestamp string) {
+	if !backup_filepath.IsValidTimestamp(timestamp) {
+		gplog.Fatal(errors.Errorf("Timestamp %s is invalid.  Timestamps must be in the format YYYYMMDDHHMMSS.", timestamp), "")
+	}
+}
+
+func ValidateListFlags() {
+	utils.CheckExclusiveFlags(cmdFlags, utils.FULL, utils.INCREMENTAL)
+	for _, flagName := range []string{utils.AFTER_TIMESTAMP, utils.BEFORE_TIMESTAMP} {
+		if timestamp := MustGetFlagString(flagName); timestamp != "" {
+			ValidateTimestamp(timestamp)
+		}
+	}
+}
+
+// Returns the timestamp of the backup to compare against, if one was given
+func ValidateDiffArgs(args []string) string {
+	utils.CheckExclusiveFlags(cmdFlags, utils.ENCRYPTION_KEY_FILE, utils.ENCRYPTION_KEY_CMD)
+	ValidateTimestamp(args[0])
+	if len(args) == 2 {
+		if MustGetFlagString(utils.DBNAME) != "" {
+			gplog.Fatal(errors.Errorf("Cannot compare a backup to both another backup and a database"), "")
+		}
+		ValidateTimestamp(args[1])
+		return args[1]
+	}
+	if MustGetFlagString(utils.DBNAME) == "" {
+		gplog.Fatal(errors.Errorf("Either a second timestamp or --%s must be given to compare the backup against", utils.DBNAME), "")
+	}
+	return ""
+}
+
+// This function handles setup that must be done after parsing flags.
+func DoSetup() {
+	SetLoggerVerbosity()
+	InitializeConnectionPool()
+	segConfig := cluster.MustGetSegmentConfiguration(connectionPool)
+	globalCluster = cluster.NewCluster(segConfig)
+	fpInfo := backup_filepath.NewFilePathInfo(globalCluster, "", "", "")
+	historyFilePath = fpInfo.GetBackupHistoryFilePath()
+}
+
+func SetLoggerVerbosity() {
+	if MustGetFlagBool(utils.QUIET) {
+		gplog.SetVerbosity(gplog.LOGERROR)
+	} else if MustGetFlagBool(utils.DEBUG) {
+		gplog.SetVerbosity(gplog.LOGDEBUG)
+	} else if MustGetFlagBool(utils.VERBOSE) {
+		gplog.SetVerbosity(gplog.LOGVERBOSE)
+	}
+}
+
+func InitializeConnectionPool() {
+	connectionPool = dbconn.NewDBConnFromEnvironment("postgres")
+	connectionPool.MustConnect(1)
+	utils.ValidateGPDBVersionCompatibility(connectionPool)
+}
+
+/*
+ * A missing history file just means no backups have been taken yet, so we
+ * return an empty history rather than failing.
+ */
+func ReadHistory() *backup_history.History {
+	if !iohelper.FileExistsAndIsReadable(historyFilePath) {
+		gplog.Verbose("No backup history file found at %s", historyFilePath)
+		return &backup_history.History{BackupConfigs: make([]backup_history.BackupConfig, 0)}
+	}
+	history, err := backup_history.NewHistory(historyFilePath)
+	gplog.FatalOnError(err)
+	return history
+}
+
+func MustFindBackupConfig(history *backup_history.History, timestamp string) *backup_history.BackupConfig {
+	backupConfig := history.FindBackupConfig(timestamp)
+	if backupConfig == nil {
+		gplog.Fatal(errors.Errorf("Backup with timestamp %s not found in history file %s", timestamp, historyFilePath), "")
+	}
+	return backupConfig
+}
+
+func DoTeardown() {
+	defer func() {
+		if pluginConfig != nil {
+			pluginConfig.DeletePluginConfigWhenEncrypting(globalCluster)
+		}
+		if connectionPool != nil {
+			connectionPool.Close()
+		}
+		os.Exit(gplog.GetErrorCode())
+	}()
+
+	if err := recover(); err != nil {
+		// Check if gplog.Fatal did not cause the panic
+		if gplog.GetErrorCode() != 2 {
+			gplog.Error(fmt.Sprintf("%v: %s", err, debug.Stack()))
+			gplog.SetErrorCode(2)
+		} else {
+			fmt.Println(err)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package manager_test
+
+import (
+	"testing"
+
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
+	"github.com/greenplum-db/gpbackup/manager"
+	"github.com/greenplum-db/gpbackup/testutils"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/spf13/pflag"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var (
+	connectionPool *dbconn.DBConn
+	mock           sqlmock.Sqlmock
+	stdout         *gbytes.Buffer
+	stderr         *gbytes.Buffer
+	logfile        *gbytes.Buffer
+	buffer         *gbytes.Buffer
+)
+
+func TestManager(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "manager tests")
+}
+
+var cmdFlags *pflag.FlagSet
+
+var _ = BeforeEach(func() {
+	connectionPool, mock, stdout, stderr, logfile = testutils.SetupTestEnvironment()
+	manager.SetConnection(connectionPool)
+	buffer = gbytes.NewBuffer()
+})
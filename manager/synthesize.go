@@ -0,0 +1,363 @@
+package manager
+
+/*
+ * This file contains functions for consolidating an incremental backup and the
+ * backups in its restore plan into a new full backup.  Only backup files are
+ * read and written; the database being backed up is never queried.
+ */
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/iohelper"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/backup_filepath"
+	"github.com/greenplum-db/gpbackup/backup_history"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+/*
+ * Timestamp is the timestamp of the backup in the restore plan whose data file
+ * holds the data for the table.
+ */
+type SyntheticDataEntry struct {
+	Timestamp string
+	utils.MasterDataEntry
+}
+
+func ValidateSynthesizeSource(history *backup_history.History, backupConfig *backup_history.BackupConfig) error {
+	timestamp := backupConfig.Timestamp
+	if backupConfig.Deleted {
+		return errors.Errorf("Backup %s has been deleted", timestamp)
+	}
+	if !backupConfig.Incremental {
+		return errors.Errorf("Backup %s is already a full backup", timestamp)
+	}
+	if backupConfig.Plugin != "" {
+		return errors.Errorf("Backup %s was taken using plugin %s.  Backups taken using a plugin cannot be synthesized, as their data files are not stored on the cluster.", timestamp, backupConfig.Plugin)
+	}
+	for _, restorePlanEntry := range backupConfig.RestorePlan {
+		planConfig := history.FindBackupConfig(restorePlanEntry.Timestamp)
+		if planConfig == nil {
+			return errors.Errorf("Backup %s in the restore plan of backup %s was not found in the history file", restorePlanEntry.Timestamp, timestamp)
+		}
+		if planConfig.Deleted {
+			return errors.Errorf("Backup %s in the restore plan of backup %s has been deleted", restorePlanEntry.Timestamp, timestamp)
+		}
+	}
+	return nil
+}
+
+/*
+ * Returns a data entry for each table in the restore plan, taken from the TOC
+ * of the backup that holds its data, in restore plan order.  Tables without a
+ * data entry, such as external tables, have no data to copy and are skipped.
+ * As data files are named by oid, two tables with the same oid cannot be
+ * combined into one backup.
+ */
+func GetSyntheticDataEntries(restorePlan []backup_history.RestorePlanEntry, tocs map[string]*utils.TOC) ([]SyntheticDataEntry, error) {
+	dataEntries := make([]SyntheticDataEntry, 0)
+	oidTimestamps := make(map[uint32]string, 0)
+	for _, restorePlanEntry := range restorePlan {
+		if len(restorePlanEntry.TableFQNs) == 0 {
+			continue
+		}
+		toc := tocs[restorePlanEntry.Timestamp]
+		for _, entry := range toc.GetDataEntriesMatching([]string{}, []string{}, []string{}, []string{}, restorePlanEntry.TableFQNs) {
+			if otherTimestamp, ok := oidTimestamps[entry.Oid]; ok {
+				return nil, errors.Errorf("Table %s in backup %s has the same oid as a table in backup %s", utils.MakeFQN(entry.Schema, entry.Name), restorePlanEntry.Timestamp, otherTimestamp)
+			}
+			oidTimestamps[entry.Oid] = restorePlanEntry.Timestamp
+			dataEntries = append(dataEntries, SyntheticDataEntry{Timestamp: restorePlanEntry.Timestamp, MasterDataEntry: entry})
+		}
+	}
+	return dataEntries, nil
+}
+
+func NewSyntheticBackupConfig(sourceConfig *backup_history.BackupConfig, timestamp string) *backup_history.BackupConfig {
+	newConfig := *sourceConfig
+	newConfig.Timestamp = timestamp
+	newConfig.Incremental = false
+	newConfig.Differential = false
+	newConfig.Checksummed = true
+	newConfig.EndTime = ""
+	tableFQNs := make([]string, 0)
+	for _, restorePlanEntry := range sourceConfig.RestorePlan {
+		tableFQNs = append(tableFQNs, restorePlanEntry.TableFQNs...)
+	}
+	newConfig.RestorePlan = []backup_history.RestorePlanEntry{{Timestamp: timestamp, TableFQNs: tableFQNs}}
+	return &newConfig
+}
+
+type SyntheticShard struct {
+	Timestamp string
+	Shard     int
+}
+
+/*
+ * A table's data cannot be moved within a single data file without reading
+ * the whole file, and the helper must read the tables in a data file in the
+ * order they were written, so each data file that holds data for at least
+ * one table becomes a shard of the new backup unchanged.  Sets the shard of
+ * each data entry to its shard in the new backup, and returns the data file
+ * that each new shard is taken from, indexed by new shard.
+ */
+func AssignSyntheticShards(dataEntries []SyntheticDataEntry) []SyntheticShard {
+	sourceShards := make([]SyntheticShard, 0)
+	newShards := make(map[SyntheticShard]int, 0)
+	for i, entry := range dataEntries {
+		sourceShard := SyntheticShard{Timestamp: entry.Timestamp, Shard: entry.Shard}
+		newShard, ok := newShards[sourceShard]
+		if !ok {
+			newShard = len(sourceShards)
+			newShards[sourceShard] = newShard
+			sourceShards = append(sourceShards, sourceShard)
+		}
+		dataEntries[i].Shard = newShard
+	}
+	return sourceShards
+}
+
+func linkOrCopyCommand(sourceFile string, destFile string) string {
+	return fmt.Sprintf("(ln %s %s 2>/dev/null || cp %s %s)", sourceFile, destFile, sourceFile, destFile)
+}
+
+/*
+ * Each data file is hard-linked into the new backup directory where possible,
+ * so that no data is copied when the backups share a filesystem.  The checksum
+ * recorded for each file when it was backed up is carried over, so that the
+ * new backup does not vouch for a file that has since been corrupted.  As when
+ * backing up, files are listed relative to the backup directory.
+ */
+func GetSyntheticDataFilesScript(contentID int, newFPInfo backup_filepath.FilePathInfo, ownerFPInfos map[string]backup_filepath.FilePathInfo,
+	ownerConfigs map[string]*backup_history.BackupConfig, dataEntries []SyntheticDataEntry, extension string) string {
+	newChecksumFile := newFPInfo.GetSegmentChecksumFilePath(contentID)
+	commands := []string{fmt.Sprintf("rm -f %s || exit 1", newChecksumFile)}
+	for _, entry := range dataEntries {
+		ownerFPInfo := ownerFPInfos[entry.Timestamp]
+		sourceFile := ownerFPInfo.GetTableBackupFilePath(contentID, entry.Oid, extension, false)
+		destFile := newFPInfo.GetTableBackupFilePath(contentID, entry.Oid, extension, false)
+		commands = append(commands, fmt.Sprintf("%s || exit 1", linkOrCopyCommand(sourceFile, destFile)))
+		if ownerConfigs[entry.Timestamp].Checksummed {
+			commands = append(commands, fmt.Sprintf(`checksum=$(awk '$2 == "%s" {print $1}' %s) && test -n "$checksum" && echo "$checksum  %s" >> %s || exit 1`,
+				path.Base(sourceFile), ownerFPInfo.GetSegmentChecksumFilePath(contentID), path.Base(destFile), newChecksumFile))
+		} else {
+			commands = append(commands, fmt.Sprintf("(cd %s && sha256sum %s) >> %s || exit 1", path.Dir(destFile), path.Base(destFile), newChecksumFile))
+		}
+	}
+	return strings.Join(commands, "\n") + "\n"
+}
+
+/*
+ * The segment TOC of each data file is linked along with it, so it still
+ * lists any tables superseded by a later backup.  These are never read, as
+ * the new TOC has no entry for them.
+ */
+func GetSyntheticSingleDataFilesScript(contentID int, newFPInfo backup_filepath.FilePathInfo, ownerFPInfos map[string]backup_filepath.FilePathInfo,
+	sourceShards []SyntheticShard, extension string) string {
+	commands := make([]string, 0)
+	for shard, sourceShard := range sourceShards {
+		ownerFPInfo := ownerFPInfos[sourceShard.Timestamp]
+		sourceFPInfo := ownerFPInfo.ForShard(sourceShard.Shard)
+		destFPInfo := newFPInfo.ForShard(shard)
+		commands = append(commands, fmt.Sprintf("%s || exit 1", linkOrCopyCommand(sourceFPInfo.GetTableBackupFilePath(contentID, 0, extension, true), destFPInfo.GetTableBackupFilePath(contentID, 0, extension, true))))
+		commands = append(commands, fmt.Sprintf("%s || exit 1", linkOrCopyCommand(sourceFPInfo.GetSegmentTOCFilePath(contentID), destFPInfo.GetSegmentTOCFilePath(contentID))))
+	}
+	return strings.Join(commands, "\n") + "\n"
+}
+
+/*
+ * The scripts can be too long to pass over ssh, so each one is copied to its
+ * segment and run from there.
+ */
+func runScriptsOnSegments(description string, fpInfo backup_filepath.FilePathInfo, scripts map[int]string) {
+	localFiles := make(map[int]string, 0)
+	defer func() {
+		for _, localFile := range localFiles {
+			_ = operating.System.Remove(localFile)
+		}
+	}()
+	for contentID, script := range scripts {
+		localFile, err := operating.System.TempFile("", "gpbackup-synthesize")
+		gplog.FatalOnError(err, "Cannot open temporary file to write script")
+		localFiles[contentID] = localFile.Name()
+		_, err = localFile.Write([]byte(script))
+		gplog.FatalOnError(err, localFile.Name())
+		err = localFile.Close()
+		gplog.FatalOnError(err, localFile.Name())
+	}
+	remoteOutput := globalCluster.GenerateAndExecuteCommand(fmt.Sprintf("Copying scripts for %s to segments", strings.ToLower(description)), func(contentID int) string {
+		return fmt.Sprintf("scp %s %s:%s", localFiles[contentID], globalCluster.GetHostForContent(contentID), fpInfo.GetSegmentHelperFilePath(contentID, "synthesize"))
+	}, cluster.ON_MASTER_TO_SEGMENTS)
+	globalCluster.CheckClusterError(remoteOutput, "Unable to copy scripts to segments", func(contentID int) string {
+		return fmt.Sprintf("Unable to copy script to segment %d", contentID)
+	})
+	remoteOutput = globalCluster.GenerateAndExecuteCommand(description, func(contentID int) string {
+		scriptFile := fpInfo.GetSegmentHelperFilePath(contentID, "synthesize")
+		return fmt.Sprintf("bash %s; status=$?; rm -f %s; exit $status", scriptFile, scriptFile)
+	}, cluster.ON_SEGMENTS)
+	globalCluster.CheckClusterError(remoteOutput, fmt.Sprintf("Unable to complete: %s", strings.ToLower(description)), func(contentID int) string {
+		return fmt.Sprintf("Unable to complete: %s", strings.ToLower(description))
+	})
+}
+
+func synthesizeSingleDataFiles(newFPInfo backup_filepath.FilePathInfo, ownerFPInfos map[string]backup_filepath.FilePathInfo,
+	dataEntries []SyntheticDataEntry, extension string) {
+	sourceShards := AssignSyntheticShards(dataEntries)
+	if len(sourceShards) == 0 {
+		return
+	}
+	scripts := make(map[int]string, 0)
+	for _, contentID := range globalCluster.ContentIDs {
+		if contentID == -1 {
+			continue
+		}
+		scripts[contentID] = GetSyntheticSingleDataFilesScript(contentID, newFPInfo, ownerFPInfos, sourceShards, extension)
+	}
+	runScriptsOnSegments("Linking data files on segments", newFPInfo, scripts)
+}
+
+func synthesizeDataFiles(newFPInfo backup_filepath.FilePathInfo, ownerFPInfos map[string]backup_filepath.FilePathInfo,
+	ownerConfigs map[string]*backup_history.BackupConfig, dataEntries []SyntheticDataEntry, extension string) {
+	if len(dataEntries) == 0 {
+		return
+	}
+	scripts := make(map[int]string, 0)
+	for _, contentID := range globalCluster.ContentIDs {
+		if contentID == -1 {
+			continue
+		}
+		scripts[contentID] = GetSyntheticDataFilesScript(contentID, newFPInfo, ownerFPInfos, ownerConfigs, dataEntries, extension)
+	}
+	runScriptsOnSegments("Linking data files on segments", newFPInfo, scripts)
+}
+
+func linkOrCopyFile(sourceFile string, destFile string) error {
+	if err := os.Link(sourceFile, destFile); err == nil {
+		return nil
+	}
+	sourceHandle, err := os.Open(sourceFile)
+	if err != nil {
+		return err
+	}
+	defer sourceHandle.Close()
+	destHandle, err := operating.System.OpenFileWrite(destFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(destHandle, sourceHandle)
+	if err != nil {
+		_ = destHandle.Close()
+		return err
+	}
+	return destHandle.Close()
+}
+
+func mustReadTOC(fpInfo backup_filepath.FilePathInfo, backupConfig *backup_history.BackupConfig) *utils.TOC {
+	tocFilename := fpInfo.GetTOCFilePath()
+	if backupConfig.Checksummed {
+		err := utils.VerifyChecksums(fpInfo.GetChecksumFilePath(), []string{tocFilename})
+		gplog.FatalOnError(err)
+	}
+	return utils.NewTOC(tocFilename)
+}
+
+func DoSynthesize(timestamp string) {
+	history := ReadHistory()
+	sourceConfig := MustFindBackupConfig(history, timestamp)
+	err := ValidateSynthesizeSource(history, sourceConfig)
+	gplog.FatalOnError(err)
+	if sourceConfig.Encrypted && utils.GetEncryptionKey() == nil {
+		gplog.Fatal(errors.Errorf("Backup %s is encrypted.  Use --%s or --%s to provide the encryption key.",
+			timestamp, utils.ENCRYPTION_KEY_FILE, utils.ENCRYPTION_KEY_CMD), "")
+	}
+	newTimestamp := backup_history.CurrentTimestamp()
+	if history.FindBackupConfig(newTimestamp) != nil {
+		gplog.Fatal(errors.Errorf("A backup with timestamp %s already exists.  Wait 1 second and try again.", newTimestamp), "")
+	}
+
+	gplog.Info("Reading table of contents files for the backups in the restore plan of backup %s", timestamp)
+	sourceFPInfo := GetBackupFPInfo(sourceConfig)
+	sourceTOC := mustReadTOC(sourceFPInfo, sourceConfig)
+	ownerFPInfos := make(map[string]backup_filepath.FilePathInfo, 0)
+	ownerConfigs := make(map[string]*backup_history.BackupConfig, 0)
+	ownerTOCs := make(map[string]*utils.TOC, 0)
+	for _, restorePlanEntry := range sourceConfig.RestorePlan {
+		ownerConfig := history.FindBackupConfig(restorePlanEntry.Timestamp)
+		ownerConfigs[restorePlanEntry.Timestamp] = ownerConfig
+		ownerFPInfos[restorePlanEntry.Timestamp] = GetBackupFPInfo(ownerConfig)
+		if len(restorePlanEntry.TableFQNs) > 0 {
+			ownerTOCs[restorePlanEntry.Timestamp] = mustReadTOC(ownerFPInfos[restorePlanEntry.Timestamp], ownerConfig)
+		}
+	}
+	dataEntries, err := GetSyntheticDataEntries(sourceConfig.RestorePlan, ownerTOCs)
+	gplog.FatalOnError(err)
+
+	metadataFiles := []string{sourceFPInfo.GetMetadataFilePath(), sourceFPInfo.GetStatisticsFilePath()}
+	if sourceConfig.Checksummed {
+		err = utils.VerifyChecksums(sourceFPInfo.GetChecksumFilePath(), metadataFiles)
+		gplog.FatalOnError(err)
+	}
+
+	newConfig := NewSyntheticBackupConfig(sourceConfig, newTimestamp)
+	newFPInfo := GetBackupFPInfo(newConfig)
+	gplog.Info("Synthesizing full backup %s from backup %s", newTimestamp, timestamp)
+	defer func() {
+		if err := recover(); err != nil {
+			gplog.Warn("Removing incomplete backup %s", newTimestamp)
+			backup_filepath.DeleteBackupDirectoriesOnAllHosts(globalCluster, newFPInfo, true)
+			panic(err)
+		}
+	}()
+	remoteOutput := globalCluster.GenerateAndExecuteCommand("Creating backup directories", func(contentID int) string {
+		return fmt.Sprintf("mkdir -p %s", newFPInfo.GetDirForContent(contentID))
+	}, cluster.ON_SEGMENTS_AND_MASTER)
+	globalCluster.CheckClusterError(remoteOutput, "Unable to create backup directories", func(contentID int) string {
+		return fmt.Sprintf("Unable to create backup directory %s", newFPInfo.GetDirForContent(contentID))
+	})
+
+	extension := ""
+	if sourceConfig.Compressed {
+		compressionProgram, err := utils.NewCompressionProgram(sourceConfig.GetCompressionType(), 1)
+		gplog.FatalOnError(err)
+		extension = compressionProgram.Extension
+	}
+	if sourceConfig.SingleDataFile {
+		synthesizeSingleDataFiles(newFPInfo, ownerFPInfos, dataEntries, extension)
+	} else {
+		synthesizeDataFiles(newFPInfo, ownerFPInfos, ownerConfigs, dataEntries, extension)
+	}
+
+	newMetadataFiles := []string{newFPInfo.GetMetadataFilePath(), newFPInfo.GetStatisticsFilePath()}
+	for i, sourceFile := range metadataFiles {
+		if !iohelper.FileExistsAndIsReadable(sourceFile) {
+			continue
+		}
+		err = linkOrCopyFile(sourceFile, newMetadataFiles[i])
+		gplog.FatalOnError(err, newMetadataFiles[i])
+	}
+	newTOC := *sourceTOC
+	newTOC.DataEntries = make([]utils.MasterDataEntry, 0)
+	for _, entry := range dataEntries {
+		newTOC.DataEntries = append(newTOC.DataEntries, entry.MasterDataEntry)
+	}
+	newTOC.WriteToFileAndMakeReadOnly(newFPInfo.GetTOCFilePath())
+	configFilename := newFPInfo.GetConfigFilePath()
+	backup_history.WriteConfigFile(newConfig, configFilename)
+	err = utils.WriteChecksumFile(newFPInfo.GetChecksumFilePath(), append([]string{configFilename, newFPInfo.GetTOCFilePath()}, newMetadataFiles...))
+	gplog.FatalOnError(err)
+	report := &utils.Report{BackupConfig: *newConfig}
+	report.ConstructBackupParamsString()
+	report.WriteBackupReportFile(newFPInfo.GetBackupReportFilePath(), newTimestamp, map[string]int{}, newTOC.DataEntries, "")
+
+	err = backup_history.WriteBackupHistory(historyFilePath, newConfig)
+	gplog.FatalOnError(err)
+	gplog.Info("Full backup %s has been synthesized from backup %s", newTimestamp, timestamp)
+}
@@ -0,0 +1,172 @@
+package manager_test
+
+import (
+	"github.com/greenplum-db/gpbackup/backup_filepath"
+	"github.com/greenplum-db/gpbackup/backup_history"
+	"github.com/greenplum-db/gpbackup/manager"
+	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("manager/synthesize tests", func() {
+	var (
+		history     *backup_history.History
+		restorePlan []backup_history.RestorePlanEntry
+		tocs        map[string]*utils.TOC
+	)
+
+	BeforeEach(func() {
+		restorePlan = []backup_history.RestorePlanEntry{
+			{Timestamp: "20170101010101", TableFQNs: []string{"public.foo", "public.ext"}},
+			{Timestamp: "20170102010101", TableFQNs: []string{}},
+			{Timestamp: "20170103010101", TableFQNs: []string{"public.bar"}},
+		}
+		history = &backup_history.History{BackupConfigs: []backup_history.BackupConfig{
+			{Timestamp: "20170103010101", DatabaseName: "testdb", Incremental: true, RestorePlan: restorePlan},
+			{Timestamp: "20170102010101", DatabaseName: "testdb", Incremental: true, RestorePlan: restorePlan[:2]},
+			{Timestamp: "20170101010101", DatabaseName: "testdb", RestorePlan: restorePlan[:1]},
+		}}
+		tocs = map[string]*utils.TOC{
+			"20170101010101": {DataEntries: []utils.MasterDataEntry{
+				{Schema: "public", Name: "foo", Oid: 1},
+				{Schema: "public", Name: "bar", Oid: 2},
+			}},
+			"20170103010101": {DataEntries: []utils.MasterDataEntry{
+				{Schema: "public", Name: "bar", Oid: 2},
+			}},
+		}
+	})
+	Describe("ValidateSynthesizeSource", func() {
+		It("accepts an incremental backup whose restore plan is available", func() {
+			Expect(manager.ValidateSynthesizeSource(history, &history.BackupConfigs[0])).To(Succeed())
+		})
+		It("rejects a full backup", func() {
+			err := manager.ValidateSynthesizeSource(history, &history.BackupConfigs[2])
+			Expect(err).To(MatchError("Backup 20170101010101 is already a full backup"))
+		})
+		It("rejects a backup taken with a plugin", func() {
+			history.BackupConfigs[0].Plugin = "/tmp/my_plugin"
+			err := manager.ValidateSynthesizeSource(history, &history.BackupConfigs[0])
+			Expect(err.Error()).To(HavePrefix("Backup 20170103010101 was taken using plugin /tmp/my_plugin."))
+		})
+		It("rejects a backup whose restore plan includes a deleted backup", func() {
+			history.BackupConfigs[1].Deleted = true
+			err := manager.ValidateSynthesizeSource(history, &history.BackupConfigs[0])
+			Expect(err).To(MatchError("Backup 20170102010101 in the restore plan of backup 20170103010101 has been deleted"))
+		})
+		It("rejects a backup whose restore plan includes a backup missing from the history file", func() {
+			history.BackupConfigs = history.BackupConfigs[:2]
+			err := manager.ValidateSynthesizeSource(history, &history.BackupConfigs[0])
+			Expect(err).To(MatchError("Backup 20170101010101 in the restore plan of backup 20170103010101 was not found in the history file"))
+		})
+	})
+	Describe("GetSyntheticDataEntries", func() {
+		It("takes each table from the backup in the restore plan that holds its data", func() {
+			dataEntries, err := manager.GetSyntheticDataEntries(restorePlan, tocs)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dataEntries).To(Equal([]manager.SyntheticDataEntry{
+				{Timestamp: "20170101010101", MasterDataEntry: utils.MasterDataEntry{Schema: "public", Name: "foo", Oid: 1}},
+				{Timestamp: "20170103010101", MasterDataEntry: utils.MasterDataEntry{Schema: "public", Name: "bar", Oid: 2}},
+			}))
+		})
+		It("returns an error if two tables have the same oid", func() {
+			tocs["20170103010101"].DataEntries[0].Oid = 1
+
+			_, err := manager.GetSyntheticDataEntries(restorePlan, tocs)
+
+			Expect(err).To(MatchError("Table public.bar in backup 20170103010101 has the same oid as a table in backup 20170101010101"))
+		})
+	})
+	Describe("NewSyntheticBackupConfig", func() {
+		It("creates a checksummed full backup whose restore plan contains only itself", func() {
+			sourceConfig := history.BackupConfigs[0]
+			sourceConfig.Differential = true
+			sourceConfig.EndTime = "20170103010202"
+
+			newConfig := manager.NewSyntheticBackupConfig(&sourceConfig, "20170104010101")
+
+			Expect(newConfig.Timestamp).To(Equal("20170104010101"))
+			Expect(newConfig.DatabaseName).To(Equal("testdb"))
+			Expect(newConfig.Incremental).To(BeFalse())
+			Expect(newConfig.Differential).To(BeFalse())
+			Expect(newConfig.Checksummed).To(BeTrue())
+			Expect(newConfig.EndTime).To(Equal(""))
+			Expect(newConfig.RestorePlan).To(Equal([]backup_history.RestorePlanEntry{
+				{Timestamp: "20170104010101", TableFQNs: []string{"public.foo", "public.ext", "public.bar"}},
+			}))
+			Expect(sourceConfig.Incremental).To(BeTrue())
+		})
+	})
+	Describe("AssignSyntheticShards", func() {
+		It("makes each data file that holds table data a shard of the new backup", func() {
+			dataEntries := []manager.SyntheticDataEntry{
+				{Timestamp: "20170101010101", MasterDataEntry: utils.MasterDataEntry{Schema: "public", Name: "foo", Oid: 1, Shard: 1}},
+				{Timestamp: "20170101010101", MasterDataEntry: utils.MasterDataEntry{Schema: "public", Name: "baz", Oid: 3, Shard: 0}},
+				{Timestamp: "20170103010101", MasterDataEntry: utils.MasterDataEntry{Schema: "public", Name: "bar", Oid: 2, Shard: 0}},
+				{Timestamp: "20170101010101", MasterDataEntry: utils.MasterDataEntry{Schema: "public", Name: "qux", Oid: 4, Shard: 1}},
+			}
+
+			sourceShards := manager.AssignSyntheticShards(dataEntries)
+
+			Expect(sourceShards).To(Equal([]manager.SyntheticShard{
+				{Timestamp: "20170101010101", Shard: 1},
+				{Timestamp: "20170101010101", Shard: 0},
+				{Timestamp: "20170103010101", Shard: 0},
+			}))
+			Expect(dataEntries[0].Shard).To(Equal(0))
+			Expect(dataEntries[1].Shard).To(Equal(1))
+			Expect(dataEntries[2].Shard).To(Equal(2))
+			Expect(dataEntries[3].Shard).To(Equal(0))
+		})
+	})
+	Describe("GetSyntheticDataFilesScript", func() {
+		It("links each data file and carries over its recorded checksum", func() {
+			testCluster := testutils.SetDefaultSegmentConfiguration()
+			newFPInfo := backup_filepath.NewFilePathInfo(testCluster, "", "20170104010101", "")
+			ownerFPInfos := map[string]backup_filepath.FilePathInfo{
+				"20170101010101": backup_filepath.NewFilePathInfo(testCluster, "", "20170101010101", ""),
+				"20170103010101": backup_filepath.NewFilePathInfo(testCluster, "", "20170103010101", ""),
+			}
+			ownerConfigs := map[string]*backup_history.BackupConfig{
+				"20170101010101": {Checksummed: false},
+				"20170103010101": {Checksummed: true},
+			}
+			dataEntries := []manager.SyntheticDataEntry{
+				{Timestamp: "20170101010101", MasterDataEntry: utils.MasterDataEntry{Schema: "public", Name: "foo", Oid: 1}},
+				{Timestamp: "20170103010101", MasterDataEntry: utils.MasterDataEntry{Schema: "public", Name: "bar", Oid: 2}},
+			}
+
+			script := manager.GetSyntheticDataFilesScript(0, newFPInfo, ownerFPInfos, ownerConfigs, dataEntries, ".gz")
+
+			Expect(script).To(Equal(`rm -f gpseg0/backups/20170104/20170104010101/gpbackup_0_20170104010101_checksums || exit 1
+(ln gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_1.gz gpseg0/backups/20170104/20170104010101/gpbackup_0_20170104010101_1.gz 2>/dev/null || cp gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_1.gz gpseg0/backups/20170104/20170104010101/gpbackup_0_20170104010101_1.gz) || exit 1
+(cd gpseg0/backups/20170104/20170104010101 && sha256sum gpbackup_0_20170104010101_1.gz) >> gpseg0/backups/20170104/20170104010101/gpbackup_0_20170104010101_checksums || exit 1
+(ln gpseg0/backups/20170103/20170103010101/gpbackup_0_20170103010101_2.gz gpseg0/backups/20170104/20170104010101/gpbackup_0_20170104010101_2.gz 2>/dev/null || cp gpseg0/backups/20170103/20170103010101/gpbackup_0_20170103010101_2.gz gpseg0/backups/20170104/20170104010101/gpbackup_0_20170104010101_2.gz) || exit 1
+checksum=$(awk '$2 == "gpbackup_0_20170103010101_2.gz" {print $1}' gpseg0/backups/20170103/20170103010101/gpbackup_0_20170103010101_checksums) && test -n "$checksum" && echo "$checksum  gpbackup_0_20170104010101_2.gz" >> gpseg0/backups/20170104/20170104010101/gpbackup_0_20170104010101_checksums || exit 1
+`))
+		})
+	})
+	Describe("GetSyntheticSingleDataFilesScript", func() {
+		It("links each source data file and its segment TOC as a shard of the new backup", func() {
+			testCluster := testutils.SetDefaultSegmentConfiguration()
+			newFPInfo := backup_filepath.NewFilePathInfo(testCluster, "", "20170104010101", "")
+			ownerFPInfos := map[string]backup_filepath.FilePathInfo{
+				"20170101010101": backup_filepath.NewFilePathInfo(testCluster, "", "20170101010101", ""),
+				"20170103010101": backup_filepath.NewFilePathInfo(testCluster, "", "20170103010101", ""),
+			}
+			sourceShards := []manager.SyntheticShard{{Timestamp: "20170101010101", Shard: 1}, {Timestamp: "20170103010101", Shard: 0}}
+
+			script := manager.GetSyntheticSingleDataFilesScript(1, newFPInfo, ownerFPInfos, sourceShards, "")
+
+			Expect(script).To(Equal(`(ln gpseg1/backups/20170101/20170101010101/gpbackup_1_20170101010101_shard1 gpseg1/backups/20170104/20170104010101/gpbackup_1_20170104010101 2>/dev/null || cp gpseg1/backups/20170101/20170101010101/gpbackup_1_20170101010101_shard1 gpseg1/backups/20170104/20170104010101/gpbackup_1_20170104010101) || exit 1
+(ln gpseg1/backups/20170101/20170101010101/gpbackup_1_20170101010101_shard1_toc.yaml gpseg1/backups/20170104/20170104010101/gpbackup_1_20170104010101_toc.yaml 2>/dev/null || cp gpseg1/backups/20170101/20170101010101/gpbackup_1_20170101010101_shard1_toc.yaml gpseg1/backups/20170104/20170104010101/gpbackup_1_20170104010101_toc.yaml) || exit 1
+(ln gpseg1/backups/20170103/20170103010101/gpbackup_1_20170103010101 gpseg1/backups/20170104/20170104010101/gpbackup_1_20170104010101_shard1 2>/dev/null || cp gpseg1/backups/20170103/20170103010101/gpbackup_1_20170103010101 gpseg1/backups/20170104/20170104010101/gpbackup_1_20170104010101_shard1) || exit 1
+(ln gpseg1/backups/20170103/20170103010101/gpbackup_1_20170103010101_toc.yaml gpseg1/backups/20170104/20170104010101/gpbackup_1_20170104010101_shard1_toc.yaml 2>/dev/null || cp gpseg1/backups/20170103/20170103010101/gpbackup_1_20170103010101_toc.yaml gpseg1/backups/20170104/20170104010101/gpbackup_1_20170104010101_shard1_toc.yaml) || exit 1
+`))
+		})
+	})
+})
@@ -6,6 +6,7 @@ package restore
 
 import (
 	"fmt"
+	"strings"
 	"sync"
 	"sync/atomic"
 
@@ -21,20 +22,52 @@ var (
 	tableDelim = ","
 )
 
-func CopyTableIn(connectionPool *dbconn.DBConn, tableName string, tableAttributes string, destinationToRead string, singleDataFile bool, whichConn int) (int64, error) {
+func CopyTableIn(connectionPool *dbconn.DBConn, tableName string, tableAttributes string, destinationToRead string, checksumFileToRead string, singleDataFile bool, readsBackupSegments bool, whichConn int) (int64, error) {
 	whichConn = connectionPool.ValidateConnNum(whichConn)
 	copyCommand := ""
 	readFromDestinationCommand := "cat"
 	customPipeThroughCommand := utils.GetPipeThroughProgram().InputCommand
 
 	if singleDataFile {
-		//helper.go handles compression, so we don't want to set it here
+		//helper.go handles compression and encryption, so we don't want to set it here
 		customPipeThroughCommand = "cat -"
-	} else if MustGetFlagString(utils.PLUGIN_CONFIG) != "" {
-		readFromDestinationCommand = fmt.Sprintf("%s restore_data %s", pluginConfig.ExecutablePath, pluginConfig.ConfigPath)
+	} else {
+		if utils.GetEncryptionKey() != nil {
+			customPipeThroughCommand = fmt.Sprintf("%s | %s", utils.GetEncryptionFilterCommand(true, globalFPInfo.GetSegmentEncryptionKeyFilePathForCopyCommand()), customPipeThroughCommand)
+		}
+		if utils.GetMaxBandwidth() > 0 {
+			customPipeThroughCommand = fmt.Sprintf("%s | %s", utils.GetThrottleFilterCommand(connectionPool.NumConns), customPipeThroughCommand)
+		}
+		if MustGetFlagString(utils.PLUGIN_CONFIG) != "" {
+			readFromDestinationCommand = fmt.Sprintf("%s restore_data %s", pluginConfig.ExecutablePath, pluginConfig.ConfigPath)
+		}
 	}
 
-	copyCommand = fmt.Sprintf("PROGRAM '%s %s | %s'", readFromDestinationCommand, destinationToRead, customPipeThroughCommand)
+	/*
+	 * When restoring onto a cluster with a different number of segments, each
+	 * segment reads the data file of each backup segment mapped to it in turn,
+	 * so the backup segment takes the place of the segment in the file paths.
+	 */
+	if readsBackupSegments {
+		destinationToRead = strings.Replace(destinationToRead, "<SEGID>", "${segid}", -1)
+		checksumFileToRead = strings.Replace(checksumFileToRead, "<SEGID>", "${segid}", -1)
+	}
+
+	readCommand := fmt.Sprintf("%s %s | %s", readFromDestinationCommand, destinationToRead, customPipeThroughCommand)
+	if checksumFileToRead != "" {
+		/*
+		 * The helper checks the data file against its checksum as it reads it,
+		 * and only fails once the whole file has been read.  The shell reports
+		 * the exit status of only the last command in a pipeline, so the exit
+		 * status of the helper is passed out on another file descriptor.
+		 */
+		readCommand = fmt.Sprintf(`{ status=$( { { %s; echo $? >&3; } | %s >&4 || echo 1 >&3; } 3>&1 ); } 4>&1; test "$status" = 0`,
+			utils.GetChecksumReadCommand(checksumFileToRead, destinationToRead), customPipeThroughCommand)
+	}
+	if readsBackupSegments {
+		readCommand = fmt.Sprintf("for segid in $(seq <SEGID> %d %d); do %s || exit 1; done", len(globalFPInfo.SegDirMap)-1, globalFPInfo.SourceSegmentCount-1, readCommand)
+	}
+	copyCommand = fmt.Sprintf("PROGRAM '%s'", readCommand)
 
 	query := fmt.Sprintf("COPY %s%s FROM %s WITH CSV DELIMITER '%s' ON SEGMENT;", tableName, tableAttributes, copyCommand, tableDelim)
 	result, err := connectionPool.Exec(query, whichConn)
@@ -45,8 +78,16 @@ func CopyTableIn(connectionPool *dbconn.DBConn, tableName string, tableAttribute
 	return numRows, err
 }
 
+// Returns the name of the table that the data entry is restored into
+func getRestoreTableFQN(entry utils.MasterDataEntry) string {
+	if redirectSchema != "" {
+		return utils.MakeFQN(redirectSchema, entry.Name)
+	}
+	return utils.MakeFQN(entry.Schema, entry.Name)
+}
+
 func restoreSingleTableData(fpInfo *backup_filepath.FilePathInfo, entry utils.MasterDataEntry, tableNum uint32, totalTables int, whichConn int) error {
-	name := utils.MakeFQN(entry.Schema, entry.Name)
+	name := getRestoreTableFQN(entry)
 	if gplog.GetVerbosity() > gplog.LOGINFO {
 		// No progress bar at this log level, so we note table count here
 		gplog.Verbose("Reading data for table %s from file (table %d of %d)", name, tableNum, totalTables)
@@ -54,12 +95,24 @@ func restoreSingleTableData(fpInfo *backup_filepath.FilePathInfo, entry utils.Ma
 		gplog.Verbose("Reading data for table %s from file", name)
 	}
 	destinationToRead := ""
+	checksumFileToRead := ""
 	if backupConfig.SingleDataFile {
-		destinationToRead = fmt.Sprintf("%s_%d", fpInfo.GetSegmentPipePathForCopyCommand(), entry.Oid)
+		shardFPInfo := fpInfo.ForShard(entry.Shard)
+		destinationToRead = fmt.Sprintf("%s_%d", shardFPInfo.GetSegmentPipePathForCopyCommand(), entry.Oid)
 	} else {
 		destinationToRead = fpInfo.GetTableBackupFilePathForCopyCommand(entry.Oid, utils.GetPipeThroughProgram().Extension, backupConfig.SingleDataFile)
+		if backupConfig.Checksummed && MustGetFlagString(utils.PLUGIN_CONFIG) == "" {
+			checksumFileToRead = fpInfo.GetSegmentChecksumFilePathForCopyCommand()
+		}
 	}
-	numRowsRestored, err := CopyTableIn(connectionPool, name, entry.AttributeString, destinationToRead, backupConfig.SingleDataFile, whichConn)
+	/*
+	 * A replicated table has a full copy of its rows in the data file of every
+	 * backup segment, so each segment reads only the copy of the backup segment
+	 * with the same content ID and the table needs no redistribution.
+	 */
+	redistributes := fpInfo.HasDifferentSegmentCount() && !replicatedTables[name]
+	readsBackupSegments := redistributes && !backupConfig.SingleDataFile
+	numRowsRestored, err := CopyTableIn(connectionPool, name, entry.AttributeString, destinationToRead, checksumFileToRead, backupConfig.SingleDataFile, readsBackupSegments, whichConn)
 	if err != nil {
 		return err
 	}
@@ -68,9 +121,60 @@ func restoreSingleTableData(fpInfo *backup_filepath.FilePathInfo, entry utils.Ma
 	if err != nil {
 		return err
 	}
+	if redistributes {
+		err = RedistributeTableData(connectionPool, name, whichConn)
+		if err != nil {
+			return err
+		}
+	}
+	err = restoreState.MarkTableComplete(fpInfo.Timestamp, entry.Oid)
+	if err != nil {
+		return err
+	}
+	restoredTables.Add(utils.TableReport{Schema: entry.Schema, Name: entry.Name, Oid: entry.Oid, RowsCopied: numRowsRestored})
+	return nil
+}
+
+/*
+ * Each segment loads the rows of the backup segments mapped to it regardless
+ * of the distribution policy of the table, so the table is rewritten to move
+ * its rows to the segments they belong on in this cluster.
+ */
+func RedistributeTableData(connectionPool *dbconn.DBConn, tableName string, whichConn int) error {
+	gplog.Verbose("Redistributing data for table %s", tableName)
+	_, err := connectionPool.Exec(fmt.Sprintf("ALTER TABLE %s SET WITH (REORGANIZE=true);", tableName), whichConn)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("Error redistributing data for table %s", tableName))
+	}
 	return nil
 }
 
+func GetReplicatedTables(connectionPool *dbconn.DBConn) map[string]bool {
+	replicatedTables := make(map[string]bool)
+	if connectionPool.Version.Before("6") {
+		return replicatedTables
+	}
+	query := `
+	SELECT
+		quote_ident(n.nspname) || '.' || quote_ident(c.relname)
+	FROM
+		gp_distribution_policy p
+	JOIN
+		pg_class c
+	ON
+		p.localoid = c.oid
+	JOIN
+		pg_namespace n
+	ON
+		c.relnamespace = n.oid
+	WHERE
+		p.policytype = 'r'`
+	for _, tableFQN := range dbconn.MustSelectStringSlice(connectionPool, query) {
+		replicatedTables[tableFQN] = true
+	}
+	return replicatedTables
+}
+
 func CheckRowsRestored(rowsRestored int64, rowsBackedUp int64, tableName string) error {
 	if rowsRestored != rowsBackedUp {
 		rowsErrMsg := fmt.Sprintf("Expected to restore %d rows to table %s, but restored %d instead", rowsBackedUp, tableName, rowsRestored)
@@ -79,6 +183,27 @@ func CheckRowsRestored(rowsRestored int64, rowsBackedUp int64, tableName string)
 	return nil
 }
 
+/*
+ * Each helper in a single data file restore reads the tables of its shard in
+ * order, so all of the tables in a shard are restored in order on a single
+ * connection.  Otherwise, each table can be restored on any connection.
+ */
+func GetDataEntryBatches(dataEntries []utils.MasterDataEntry, singleDataFile bool) [][]utils.MasterDataEntry {
+	batches := make([][]utils.MasterDataEntry, 0)
+	if singleDataFile {
+		for _, shardEntries := range utils.GetDataEntriesByShard(dataEntries) {
+			if len(shardEntries) > 0 {
+				batches = append(batches, shardEntries)
+			}
+		}
+		return batches
+	}
+	for _, entry := range dataEntries {
+		batches = append(batches, []utils.MasterDataEntry{entry})
+	}
+	return batches
+}
+
 func restoreDataFromTimestamp(fpInfo backup_filepath.FilePathInfo, dataEntries []utils.MasterDataEntry,
 	gucStatements []utils.StatementWithType, dataProgressBar utils.ProgressBar) {
 	if len(dataEntries) == 0 {
@@ -89,17 +214,34 @@ func restoreDataFromTimestamp(fpInfo backup_filepath.FilePathInfo, dataEntries [
 	if backupConfig.SingleDataFile {
 		gplog.Verbose("Initializing pipes and gpbackup_helper on segments for single data file restore")
 		utils.VerifyHelperVersionOnSegments(version, globalCluster)
-		filteredOids := make([]string, len(dataEntries))
-		for i, entry := range dataEntries {
-			filteredOids[i] = fmt.Sprintf("%d", entry.Oid)
+		compressStr := ""
+		if backupConfig.Compressed {
+			compressStr = fmt.Sprintf(" --compression-type %s", backupConfig.GetCompressionType())
+		}
+		entriesByShard := utils.GetDataEntriesByShard(dataEntries)
+		numAgents := 0
+		for _, shardEntries := range entriesByShard {
+			if len(shardEntries) > 0 {
+				numAgents++
+			}
 		}
-		utils.WriteOidListToSegments(filteredOids, globalCluster, fpInfo)
-		firstOid := fmt.Sprintf("%d", dataEntries[0].Oid)
-		utils.CreateFirstSegmentPipeOnAllHosts(firstOid, globalCluster, fpInfo)
-		if wasTerminated {
-			return
+		for shard, shardEntries := range entriesByShard {
+			if len(shardEntries) == 0 {
+				continue
+			}
+			shardFPInfo := fpInfo.ForShard(shard)
+			filteredOids := make([]string, len(shardEntries))
+			for i, entry := range shardEntries {
+				filteredOids[i] = fmt.Sprintf("%d", entry.Oid)
+			}
+			utils.WriteOidListToSegments(filteredOids, globalCluster, shardFPInfo)
+			firstOid := fmt.Sprintf("%d", shardEntries[0].Oid)
+			utils.CreateFirstSegmentPipeOnAllHosts(firstOid, globalCluster, shardFPInfo)
+			if wasTerminated {
+				return
+			}
+			utils.StartAgent(globalCluster, shardFPInfo, "--restore-agent", MustGetFlagString(utils.PLUGIN_CONFIG), compressStr, numAgents)
 		}
-		utils.StartAgent(globalCluster, fpInfo, "--restore-agent", MustGetFlagString(utils.PLUGIN_CONFIG), "")
 	}
 	/*
 	 * We break when an interrupt is received and rely on
@@ -107,7 +249,8 @@ func restoreDataFromTimestamp(fpInfo backup_filepath.FilePathInfo, dataEntries [
 	 * statements in progress if they don't finish on their own.
 	 */
 	var tableNum uint32 = 1
-	tasks := make(chan utils.MasterDataEntry, len(dataEntries))
+	batches := GetDataEntryBatches(dataEntries, backupConfig.SingleDataFile)
+	tasks := make(chan []utils.MasterDataEntry, len(batches))
 	var workerPool sync.WaitGroup
 	var fatalErr error
 	var numErrors int32
@@ -116,34 +259,36 @@ func restoreDataFromTimestamp(fpInfo backup_filepath.FilePathInfo, dataEntries [
 		go func(whichConn int) {
 			defer workerPool.Done()
 			setGUCsForConnection(gucStatements, whichConn)
-			for entry := range tasks {
-				if wasTerminated || fatalErr != nil {
-					dataProgressBar.(*pb.ProgressBar).NotPrint = true
-					return
-				}
-				err := restoreSingleTableData(&fpInfo, entry, tableNum, len(dataEntries), whichConn)
-				if err != nil {
-					if MustGetFlagBool(utils.ON_ERROR_CONTINUE) {
-						gplog.Verbose(err.Error())
-						atomic.AddInt32(&numErrors, 1)
-					} else {
-						fatalErr = err
+			for batch := range tasks {
+				for _, entry := range batch {
+					if wasTerminated || fatalErr != nil {
+						dataProgressBar.(*pb.ProgressBar).NotPrint = true
+						return
+					}
+					err := restoreSingleTableData(&fpInfo, entry, tableNum, len(dataEntries), whichConn)
+					if err != nil {
+						if MustGetFlagBool(utils.ON_ERROR_CONTINUE) {
+							gplog.Verbose(err.Error())
+							atomic.AddInt32(&numErrors, 1)
+						} else {
+							fatalErr = err
+						}
 					}
+					atomic.AddUint32(&tableNum, 1)
+					dataProgressBar.Increment()
 				}
-				atomic.AddUint32(&tableNum, 1)
-				dataProgressBar.Increment()
 			}
 		}(i)
 	}
-	for _, entry := range dataEntries {
-		tasks <- entry
+	for _, batch := range batches {
+		tasks <- batch
 	}
 	close(tasks)
 	workerPool.Wait()
 
 	var agentErr error
 	if backupConfig.SingleDataFile {
-		agentErr = utils.CheckAgentErrorsOnSegments(globalCluster, globalFPInfo)
+		agentErr = utils.CheckAgentErrorsOnSegments(globalCluster, fpInfo)
 		if agentErr != nil {
 			/*
 			 * if fatalErr is present, we only want to use gplog.Error here
@@ -161,5 +306,6 @@ func restoreDataFromTimestamp(fpInfo backup_filepath.FilePathInfo, dataEntries [
 		gplog.Fatal(fatalErr, "")
 	} else if numErrors > 0 {
 		gplog.Error("Encountered %d errors during table data restore; see log file %s for a list of table errors.", numErrors, gplog.GetLogFilePath())
+		utils.AddNonFatalErrors(numErrors)
 	}
 }
@@ -3,8 +3,12 @@ package restore_test
 import (
 	"regexp"
 
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
 	"github.com/greenplum-db/gpbackup/backup"
+	"github.com/greenplum-db/gpbackup/backup_filepath"
 	"github.com/greenplum-db/gpbackup/restore"
+	"github.com/greenplum-db/gpbackup/testutils"
 	"github.com/greenplum-db/gpbackup/utils"
 
 	. "github.com/onsi/ginkgo"
@@ -24,7 +28,7 @@ var _ = Describe("restore/data tests", func() {
 			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM 'cat <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz | gzip -d -c' WITH CSV DELIMITER ',' ON SEGMENT;")
 			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
 			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz"
-			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, false, 0)
+			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, "", false, false, 0)
 
 			Expect(err).ShouldNot(HaveOccurred())
 		})
@@ -32,7 +36,7 @@ var _ = Describe("restore/data tests", func() {
 			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM 'cat <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456 | cat -' WITH CSV DELIMITER ',' ON SEGMENT;")
 			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
 			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
-			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, false, 0)
+			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, "", false, false, 0)
 
 			Expect(err).ShouldNot(HaveOccurred())
 		})
@@ -40,7 +44,51 @@ var _ = Describe("restore/data tests", func() {
 			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM 'cat <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_pipe_3456 | cat -' WITH CSV DELIMITER ',' ON SEGMENT;")
 			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
 			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_pipe_3456"
-			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, true, 0)
+			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, "", true, false, 0)
+
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("will verify the checksum of a table's data file while restoring it", func() {
+			operating.System.Getenv = func(key string) string {
+				return "/usr/local/gpdb"
+			}
+			defer func() { operating.System = operating.InitializeSystemFunctions() }()
+			execStr := regexp.QuoteMeta(`COPY public.foo(i,j) FROM PROGRAM '{ status=$( { { /usr/local/gpdb/bin/gpbackup_helper --verify-checksum --checksum-file <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_checksums --data-file <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456; echo $? >&3; } | cat - >&4 || echo 1 >&3; } 3>&1 ); } 4>&1; test "$status" = 0' WITH CSV DELIMITER ',' ON SEGMENT;`)
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
+			checksumFilename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_checksums"
+			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, checksumFilename, false, false, 0)
+
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("will restore a table from its own encrypted file", func() {
+			operating.System.Getenv = func(key string) string {
+				return "/usr/local/gpdb"
+			}
+			defer func() { operating.System = operating.InitializeSystemFunctions() }()
+			utils.SetEncryptionKey(make([]byte, 32))
+			defer utils.SetEncryptionKey(nil)
+			restore.SetFPInfo(backup_filepath.FilePathInfo{PID: 5678})
+			utils.SetPipeThroughProgram(utils.PipeThroughProgram{Name: "gzip", OutputCommand: "gzip -c -1", InputCommand: "gzip -d -c", Extension: ".gz"})
+			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM 'cat <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz | /usr/local/gpdb/bin/gpbackup_helper --decrypt --encryption-key-file <SEG_DATA_DIR>/gpbackup_<SEGID>_encryption_key_5678 | gzip -d -c' WITH CSV DELIMITER ',' ON SEGMENT;")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz"
+			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, "", false, false, 0)
+
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("will restore a table from its own file at no more than the maximum bandwidth", func() {
+			operating.System.Getenv = func(key string) string {
+				return "/usr/local/gpdb"
+			}
+			defer func() { operating.System = operating.InitializeSystemFunctions() }()
+			utils.InitializeMaxBandwidth(1048576)
+			defer utils.InitializeMaxBandwidth(0)
+			utils.SetPipeThroughProgram(utils.PipeThroughProgram{Name: "gzip", OutputCommand: "gzip -c -1", InputCommand: "gzip -d -c", Extension: ".gz"})
+			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM 'cat <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz | /usr/local/gpdb/bin/gpbackup_helper --throttle --max-bandwidth 1048576 | gzip -d -c' WITH CSV DELIMITER ',' ON SEGMENT;")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz"
+			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, "", false, false, 0)
 
 			Expect(err).ShouldNot(HaveOccurred())
 		})
@@ -53,7 +101,7 @@ var _ = Describe("restore/data tests", func() {
 			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
 
 			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_pipe_3456.gz"
-			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, false, 0)
+			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, "", false, false, 0)
 
 			Expect(err).ShouldNot(HaveOccurred())
 		})
@@ -65,7 +113,55 @@ var _ = Describe("restore/data tests", func() {
 			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
 
 			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_pipe_3456.gz"
-			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, false, 0)
+			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, "", false, false, 0)
+
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("will restore a table from the files of each backup segment mapped to a segment when the backup was taken on a different number of segments", func() {
+			fpInfo := backup_filepath.NewFilePathInfo(testutils.SetDefaultSegmentConfiguration(), "/backup_dir", "20170101010101", "gpseg")
+			fpInfo.SourceSegmentCount = 5
+			restore.SetFPInfo(fpInfo)
+			defer restore.SetFPInfo(backup_filepath.FilePathInfo{})
+			operating.System.Getenv = func(key string) string {
+				return "/usr/local/gpdb"
+			}
+			defer func() { operating.System = operating.InitializeSystemFunctions() }()
+			execStr := regexp.QuoteMeta(`COPY public.foo(i,j) FROM PROGRAM 'for segid in $(seq <SEGID> 2 4); do { status=$( { { /usr/local/gpdb/bin/gpbackup_helper --verify-checksum --checksum-file /backup_dir/gpseg${segid}/backups/20170101/20170101010101/gpbackup_${segid}_20170101010101_checksums --data-file /backup_dir/gpseg${segid}/backups/20170101/20170101010101/gpbackup_${segid}_20170101010101_3456; echo $? >&3; } | cat - >&4 || echo 1 >&3; } 3>&1 ); } 4>&1; test "$status" = 0 || exit 1; done' WITH CSV DELIMITER ',' ON SEGMENT;`)
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "/backup_dir/gpseg<SEGID>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
+			checksumFilename := "/backup_dir/gpseg<SEGID>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_checksums"
+			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, checksumFilename, false, true, 0)
+
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("will restore a replicated table from only the backup segment with the same content ID when the backup was taken on more segments", func() {
+			fpInfo := backup_filepath.NewFilePathInfo(testutils.SetDefaultSegmentConfiguration(), "/backup_dir", "20170101010101", "gpseg")
+			fpInfo.SourceSegmentCount = 5
+			restore.SetFPInfo(fpInfo)
+			defer restore.SetFPInfo(backup_filepath.FilePathInfo{})
+			execStr := regexp.QuoteMeta(`COPY public.foo(i,j) FROM PROGRAM 'cat /backup_dir/gpseg<SEGID>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456 | cat -' WITH CSV DELIMITER ',' ON SEGMENT;`)
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "/backup_dir/gpseg<SEGID>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
+			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, "", false, false, 0)
+
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+	})
+	Describe("GetReplicatedTables", func() {
+		It("returns the replicated tables in the restore database", func() {
+			testhelper.SetDBVersion(connectionPool, "6.0.0")
+			mock.ExpectQuery("SELECT (.*) WHERE p.policytype = 'r'").WillReturnRows(sqlmock.NewRows([]string{"string"}).AddRow("public.foo"))
+			Expect(restore.GetReplicatedTables(connectionPool)).To(Equal(map[string]bool{"public.foo": true}))
+		})
+		It("returns no tables before GPDB 6", func() {
+			testhelper.SetDBVersion(connectionPool, "5.1.0")
+			Expect(restore.GetReplicatedTables(connectionPool)).To(BeEmpty())
+		})
+	})
+	Describe("RedistributeTableData", func() {
+		It("rewrites the table to move its rows to the segments they belong on", func() {
+			mock.ExpectExec(regexp.QuoteMeta("ALTER TABLE public.foo SET WITH (REORGANIZE=true);")).WillReturnResult(sqlmock.NewResult(0, 0))
+			err := restore.RedistributeTableData(connectionPool, "public.foo", 0)
 
 			Expect(err).ShouldNot(HaveOccurred())
 		})
@@ -84,4 +180,23 @@ var _ = Describe("restore/data tests", func() {
 			Expect(err.Error()).To(Equal("Expected to restore 10 rows to table public.foo, but restored 5 instead"))
 		})
 	})
+	Describe("GetDataEntryBatches", func() {
+		dataEntries := []utils.MasterDataEntry{
+			{Schema: "public", Name: "foo", Oid: 1, Shard: 0},
+			{Schema: "public", Name: "bar", Oid: 2, Shard: 1},
+			{Schema: "public", Name: "baz", Oid: 3, Shard: 0},
+		}
+		It("puts each table in its own batch for a backup with a data file per table", func() {
+			batches := restore.GetDataEntryBatches(dataEntries, false)
+			Expect(batches).To(Equal([][]utils.MasterDataEntry{{dataEntries[0]}, {dataEntries[1]}, {dataEntries[2]}}))
+		})
+		It("puts the tables in each shard in one batch, in order, for a single data file backup", func() {
+			batches := restore.GetDataEntryBatches(dataEntries, true)
+			Expect(batches).To(Equal([][]utils.MasterDataEntry{{dataEntries[0], dataEntries[2]}, {dataEntries[1]}}))
+		})
+		It("skips shards with no tables to restore", func() {
+			batches := restore.GetDataEntryBatches(dataEntries[1:2], true)
+			Expect(batches).To(Equal([][]utils.MasterDataEntry{{dataEntries[1]}}))
+		})
+	})
 })
@@ -26,8 +26,17 @@ var (
 	globalCluster    *cluster.Cluster
 	globalFPInfo     backup_filepath.FilePathInfo
 	globalTOC        *utils.TOC
+	hookConfig       utils.HookConfig
+	metricsWritten   bool
 	pluginConfig     *utils.PluginConfig
+	preHookRan       bool
+	redirectSchema   string
+	replicatedTables map[string]bool
+	restoreList      map[string]bool
 	restoreStartTime string
+	restoreState     *RestoreState
+	restoredTables   utils.TableReportList
+	sectionTimer     utils.SectionTimer
 	version          string
 	wasTerminated    bool
 
@@ -72,10 +81,34 @@ func SetPluginConfig(config *utils.PluginConfig) {
 	pluginConfig = config
 }
 
+func SetHookConfig(config utils.HookConfig) {
+	hookConfig = config
+}
+
+func SetPreHookRan(ran bool) {
+	preHookRan = ran
+}
+
 func SetTOC(toc *utils.TOC) {
 	globalTOC = toc
 }
 
+func SetRedirectSchema(schema string) {
+	redirectSchema = schema
+}
+
+func SetReplicatedTables(tables map[string]bool) {
+	replicatedTables = tables
+}
+
+func SetRestoreList(listedTables map[string]bool) {
+	restoreList = listedTables
+}
+
+func SetRestoreState(state *RestoreState) {
+	restoreState = state
+}
+
 // Util functions to enable ease of access to global flag values
 
 func MustGetFlagString(flagName string) string {
@@ -94,6 +127,10 @@ func MustGetFlagStringSlice(flagName string) []string {
 	return utils.MustGetFlagStringSlice(cmdFlags, flagName)
 }
 
+func MustGetFlagStringArray(flagName string) []string {
+	return utils.MustGetFlagStringArray(cmdFlags, flagName)
+}
+
 func GetVersion() string {
 	return version
 }
@@ -101,3 +138,7 @@ func GetVersion() string {
 func SetVersion(v string) {
 	version = v
 }
+
+func SetWasTerminated(terminated bool) {
+	wasTerminated = terminated
+}
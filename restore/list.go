@@ -0,0 +1,181 @@
+package restore
+
+/*
+ * This file contains structs and functions for listing the entries of a
+ * backup's table of contents with --list, and for restoring only the entries
+ * in an edited copy of that list with --use-list.
+ *
+ * Each line of the list describes one entry and begins with an ID, which is
+ * the only field read back by --use-list; the other fields are informational.
+ * Lines beginning with a semicolon are comments.
+ */
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/iohelper"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+/*
+ * Entries are numbered in the order global, predata, data, postdata, and
+ * statistics, so the ID of an entry is the same every time the same backup is
+ * listed.  Data entries use the Data field and all others use the Metadata field.
+ */
+type RestoreListEntry struct {
+	ID       int
+	Section  string
+	Metadata utils.MetadataEntry
+	Data     utils.MasterDataEntry
+}
+
+func (entry RestoreListEntry) String() string {
+	if entry.Section == "data" {
+		return fmt.Sprintf("%d; data; TABLE DATA; %s; %s; ", entry.ID, entry.Data.Schema, entry.Data.Name)
+	}
+	return fmt.Sprintf("%d; %s; %s; %s; %s; %s", entry.ID, entry.Section, entry.Metadata.ObjectType, entry.Metadata.Schema, entry.Metadata.Name, entry.Metadata.ReferenceObject)
+}
+
+func GetRestoreListEntries(toc *utils.TOC, dataEntries []utils.MasterDataEntry) []RestoreListEntry {
+	listEntries := make([]RestoreListEntry, 0)
+	addMetadataEntries := func(section string, entries []utils.MetadataEntry) {
+		for _, entry := range entries {
+			listEntries = append(listEntries, RestoreListEntry{ID: len(listEntries) + 1, Section: section, Metadata: entry})
+		}
+	}
+	addMetadataEntries("global", toc.GlobalEntries)
+	addMetadataEntries("predata", toc.PredataEntries)
+	for _, entry := range dataEntries {
+		listEntries = append(listEntries, RestoreListEntry{ID: len(listEntries) + 1, Section: "data", Data: entry})
+	}
+	addMetadataEntries("postdata", toc.PostdataEntries)
+	addMetadataEntries("statistics", toc.StatisticsEntries)
+	return listEntries
+}
+
+/*
+ * The data entries of every backup in the restore plan are listed, since the
+ * table of contents of an incremental backup only has entries for the tables
+ * whose data is in that backup.
+ */
+func GetRestorePlanDataEntries() []utils.MasterDataEntry {
+	dataEntries := make([]utils.MasterDataEntry, 0)
+	if backupConfig.MetadataOnly {
+		return dataEntries
+	}
+	for i, fpInfo := range GetBackupFPInfoListFromRestorePlan() {
+		toc := utils.NewTOC(fpInfo.GetTOCFilePath())
+		dataEntries = append(dataEntries, toc.GetDataEntriesMatching([]string{}, []string{}, []string{}, []string{}, backupConfig.RestorePlan[i].TableFQNs)...)
+	}
+	return dataEntries
+}
+
+func WriteRestoreListFile(filename string, listEntries []RestoreListEntry) {
+	listFile := iohelper.MustOpenFileForWriting(filename)
+	WriteRestoreList(listFile, globalFPInfo.Timestamp, backupConfig.DatabaseName, listEntries)
+	err := listFile.Close()
+	gplog.FatalOnError(err)
+	gplog.Info("Restore list written to %s", filename)
+}
+
+func WriteRestoreList(writer io.Writer, timestamp string, database string, listEntries []RestoreListEntry) {
+	header := fmt.Sprintf(`;
+; Table of contents of backup %s of database %s
+;
+; Format: ID; section; object type; schema; name; reference object
+;
+; To restore only some of these entries, comment out the lines of the others
+; with a semicolon or delete them, and pass the edited file to --use-list.
+; The metadata entries of each section are restored in the order in which they
+; are listed; table data is always restored in the order in which it was backed up.
+;
+`, timestamp, database)
+	_, err := io.WriteString(writer, header)
+	gplog.FatalOnError(err)
+	for _, entry := range listEntries {
+		_, err = fmt.Fprintln(writer, entry.String())
+		gplog.FatalOnError(err)
+	}
+}
+
+// Each ID is returned once, in the order in which it is first listed
+func ParseRestoreList(lines []string) ([]int, error) {
+	ids := make([]int, 0)
+	seenIDs := make(map[int]bool, 0)
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimSpace(strings.SplitN(line, ";", 2)[0]))
+		if err != nil {
+			return nil, errors.Errorf("Invalid restore list entry: %s", line)
+		}
+		if !seenIDs[id] {
+			seenIDs[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func ReadRestoreList(filename string) []int {
+	lines, err := iohelper.ReadLinesFromFile(filename)
+	gplog.FatalOnError(err)
+	ids, err := ParseRestoreList(lines)
+	if err != nil {
+		gplog.Fatal(errors.Wrapf(err, "Unable to read restore list file %s", filename), "")
+	}
+	return ids
+}
+
+/*
+ * Replaces the metadata entries of each section of the table of contents with
+ * the listed entries of that section, in the order in which they are listed,
+ * so that statements for unlisted entries are never read from the metadata file.
+ * The returned set holds each listed table whose data is to be restored.
+ */
+func ApplyRestoreList(toc *utils.TOC, dataEntries []utils.MasterDataEntry, ids []int) (map[string]bool, error) {
+	listEntries := GetRestoreListEntries(toc, dataEntries)
+	metadataEntries := map[string][]utils.MetadataEntry{"global": {}, "predata": {}, "postdata": {}, "statistics": {}}
+	listedTables := make(map[string]bool, 0)
+	for _, id := range ids {
+		if id < 1 || id > len(listEntries) {
+			return nil, errors.Errorf("Restore list entry %d does not exist in the backup", id)
+		}
+		entry := listEntries[id-1]
+		if entry.Section == "data" {
+			listedTables[utils.MakeFQN(entry.Data.Schema, entry.Data.Name)] = true
+		} else {
+			metadataEntries[entry.Section] = append(metadataEntries[entry.Section], entry.Metadata)
+		}
+	}
+	toc.GlobalEntries = metadataEntries["global"]
+	toc.PredataEntries = metadataEntries["predata"]
+	toc.PostdataEntries = metadataEntries["postdata"]
+	toc.StatisticsEntries = metadataEntries["statistics"]
+	return listedTables, nil
+}
+
+/*
+ * A nil set of listed tables means that no restore list is in use.  The data
+ * entries keep their backup order whatever the order of the list, as the data
+ * of a single data file backup can only be read forward from the start of the file.
+ */
+func FilterDataEntriesByRestoreList(dataEntries []utils.MasterDataEntry, listedTables map[string]bool) []utils.MasterDataEntry {
+	if listedTables == nil {
+		return dataEntries
+	}
+	filteredEntries := make([]utils.MasterDataEntry, 0)
+	for _, entry := range dataEntries {
+		if listedTables[utils.MakeFQN(entry.Schema, entry.Name)] {
+			filteredEntries = append(filteredEntries, entry)
+		}
+	}
+	return filteredEntries
+}
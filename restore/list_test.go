@@ -0,0 +1,106 @@
+package restore_test
+
+import (
+	"github.com/greenplum-db/gpbackup/restore"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/onsi/gomega/gbytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("restore/list tests", func() {
+	var (
+		toc         *utils.TOC
+		dataEntries []utils.MasterDataEntry
+		database    = utils.MetadataEntry{Name: "testdb", ObjectType: "DATABASE"}
+		schema      = utils.MetadataEntry{Name: "foo", ObjectType: "SCHEMA"}
+		table       = utils.MetadataEntry{Schema: "foo", Name: "bar", ObjectType: "TABLE"}
+		function    = utils.MetadataEntry{Schema: "foo", Name: "baz()", ObjectType: "FUNCTION"}
+		index       = utils.MetadataEntry{Schema: "foo", Name: "bar_idx", ObjectType: "INDEX", ReferenceObject: "foo.bar"}
+		statistics  = utils.MetadataEntry{Schema: "foo", Name: "bar", ObjectType: "STATISTICS"}
+		barData     = utils.MasterDataEntry{Schema: "foo", Name: "bar", Oid: 1}
+		quxData     = utils.MasterDataEntry{Schema: "foo", Name: "qux", Oid: 2}
+	)
+	BeforeEach(func() {
+		toc = &utils.TOC{
+			GlobalEntries:     []utils.MetadataEntry{database},
+			PredataEntries:    []utils.MetadataEntry{schema, table, function},
+			PostdataEntries:   []utils.MetadataEntry{index},
+			StatisticsEntries: []utils.MetadataEntry{statistics},
+		}
+		toc.InitializeMetadataEntryMap()
+		dataEntries = []utils.MasterDataEntry{barData, quxData}
+	})
+	Describe("GetRestoreListEntries", func() {
+		It("numbers the entries of every section in restore order", func() {
+			listEntries := restore.GetRestoreListEntries(toc, dataEntries)
+			Expect(listEntries).To(Equal([]restore.RestoreListEntry{
+				{ID: 1, Section: "global", Metadata: database},
+				{ID: 2, Section: "predata", Metadata: schema},
+				{ID: 3, Section: "predata", Metadata: table},
+				{ID: 4, Section: "predata", Metadata: function},
+				{ID: 5, Section: "data", Data: barData},
+				{ID: 6, Section: "data", Data: quxData},
+				{ID: 7, Section: "postdata", Metadata: index},
+				{ID: 8, Section: "statistics", Metadata: statistics},
+			}))
+		})
+	})
+	Describe("WriteRestoreList", func() {
+		It("writes a header and one line per entry", func() {
+			buffer := gbytes.NewBuffer()
+			restore.WriteRestoreList(buffer, "20170101010101", "testdb", restore.GetRestoreListEntries(toc, dataEntries))
+			Expect(buffer).To(gbytes.Say("; Table of contents of backup 20170101010101 of database testdb"))
+			Expect(buffer).To(gbytes.Say("\n1; global; DATABASE; ; testdb; \n"))
+			Expect(buffer).To(gbytes.Say("4; predata; FUNCTION; foo; baz\\(\\); \n"))
+			Expect(buffer).To(gbytes.Say("5; data; TABLE DATA; foo; bar; \n"))
+			Expect(buffer).To(gbytes.Say("7; postdata; INDEX; foo; bar_idx; foo.bar\n"))
+		})
+	})
+	Describe("ParseRestoreList", func() {
+		It("returns the IDs of uncommented entries in the order listed", func() {
+			ids, err := restore.ParseRestoreList([]string{"; comment", "", "3; predata; TABLE; foo; bar; ", ";4; predata; FUNCTION; foo; baz(); ", "2; predata; SCHEMA; ; foo; ", "  6;data", "3; predata; TABLE; foo; bar; "})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ids).To(Equal([]int{3, 2, 6}))
+		})
+		It("returns an error for a line that does not begin with an ID", func() {
+			_, err := restore.ParseRestoreList([]string{"predata; TABLE; foo; bar; "})
+			Expect(err).To(MatchError("Invalid restore list entry: predata; TABLE; foo; bar;"))
+		})
+	})
+	Describe("ApplyRestoreList", func() {
+		It("keeps only the listed metadata entries of each section, in the order listed", func() {
+			listedTables, err := restore.ApplyRestoreList(toc, dataEntries, []int{7, 3, 6, 2})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(toc.GlobalEntries).To(BeEmpty())
+			Expect(toc.PredataEntries).To(Equal([]utils.MetadataEntry{table, schema}))
+			Expect(toc.PostdataEntries).To(Equal([]utils.MetadataEntry{index}))
+			Expect(toc.StatisticsEntries).To(BeEmpty())
+			Expect(listedTables).To(Equal(map[string]bool{"foo.qux": true}))
+		})
+		It("returns an error for an ID that is not in the backup", func() {
+			_, err := restore.ApplyRestoreList(toc, dataEntries, []int{2, 9})
+			Expect(err).To(MatchError("Restore list entry 9 does not exist in the backup"))
+		})
+	})
+	Describe("FilterDataEntriesByRestoreList", func() {
+		It("keeps only the listed tables, in backup order", func() {
+			bazData := utils.MasterDataEntry{Schema: "foo", Name: "baz", Oid: 3}
+			filteredEntries := restore.FilterDataEntriesByRestoreList([]utils.MasterDataEntry{barData, quxData, bazData}, map[string]bool{"foo.baz": true, "foo.bar": true})
+			Expect(filteredEntries).To(Equal([]utils.MasterDataEntry{barData, bazData}))
+		})
+		It("keeps the data entries in backup order when the list gives the tables in a different order", func() {
+			bazData := utils.MasterDataEntry{Schema: "foo", Name: "baz", Oid: 3}
+			backupDataEntries := []utils.MasterDataEntry{barData, quxData, bazData}
+			listTOC := &utils.TOC{}
+			listedTables, err := restore.ApplyRestoreList(listTOC, backupDataEntries, []int{3, 1})
+			Expect(err).ToNot(HaveOccurred())
+			filteredEntries := restore.FilterDataEntriesByRestoreList(backupDataEntries, listedTables)
+			Expect(filteredEntries).To(Equal([]utils.MasterDataEntry{barData, bazData}))
+		})
+		It("keeps all tables when no restore list is in use", func() {
+			Expect(restore.FilterDataEntriesByRestoreList(dataEntries, nil)).To(Equal(dataEntries))
+		})
+	})
+})
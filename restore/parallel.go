@@ -27,6 +27,8 @@ func executeStatementsForConn(statements chan utils.StatementWithType, fatalErr
 			} else {
 				*fatalErr = err
 			}
+		} else if err = restoreState.MarkStatementExecuted(statement); err != nil {
+			*fatalErr = err
 		}
 		progressBar.Increment()
 	}
@@ -64,6 +66,7 @@ func ExecuteStatements(statements []utils.StatementWithType, progressBar utils.P
 		gplog.Fatal(fatalErr, "")
 	} else if numErrors > 0 {
 		gplog.Error("Encountered %d errors during metadata restore; see log file %s for a list of failed statements.", numErrors, gplog.GetLogFilePath())
+		utils.AddNonFatalErrors(numErrors)
 	}
 }
 
@@ -21,17 +21,38 @@ func VerifyBackupDirectoriesExistOnAllHosts() {
 	gplog.FatalOnError(err, "Backup directory %s missing or inaccessible", globalFPInfo.GetDirForContent(-1))
 	if MustGetFlagString(utils.PLUGIN_CONFIG) == "" || backupConfig.SingleDataFile {
 		remoteOutput := globalCluster.GenerateAndExecuteCommand("Verifying backup directories exist", func(contentID int) string {
-			return fmt.Sprintf("test -d %s", globalFPInfo.GetDirForContent(contentID))
+			backupDirs := getSourceDirsForContent(contentID)
+			if len(backupDirs) == 0 {
+				return "true"
+			}
+			return fmt.Sprintf("test -d %s", strings.Join(backupDirs, " && test -d "))
 		}, cluster.ON_SEGMENTS)
 		globalCluster.CheckClusterError(remoteOutput, "Backup directories missing or inaccessible", func(contentID int) string {
-			return fmt.Sprintf("Backup directory %s missing or inaccessible", globalFPInfo.GetDirForContent(contentID))
+			return fmt.Sprintf("Backup directory %s missing or inaccessible", strings.Join(getSourceDirsForContent(contentID), " or "))
 		})
 	}
 }
 
+/*
+ * When restoring onto a cluster with a different number of segments, each
+ * segment reads the backup directories of the backup segments mapped to it.
+ */
+func getSourceDirsForContent(contentID int) []string {
+	backupDirs := make([]string, 0)
+	for _, sourceContentID := range globalFPInfo.GetSourceContentIDs(contentID) {
+		backupDirs = append(backupDirs, globalFPInfo.GetDirForContent(sourceContentID))
+	}
+	return backupDirs
+}
+
 func VerifyBackupFileCountOnSegments(fileCount int) {
 	remoteOutput := globalCluster.GenerateAndExecuteCommand("Verifying backup file count", func(contentID int) string {
-		return fmt.Sprintf("find %s -type f | wc -l", globalFPInfo.GetDirForContent(contentID))
+		backupDirs := getSourceDirsForContent(contentID)
+		if len(backupDirs) == 0 {
+			return "echo 0"
+		}
+		// The data file checksum file is not counted, as backups taken before checksums were recorded do not have one
+		return fmt.Sprintf("find %s -type f ! -name \"*_checksums\" | wc -l", strings.Join(backupDirs, " "))
 	}, cluster.ON_SEGMENTS)
 	globalCluster.CheckClusterError(remoteOutput, "Could not verify backup file count", func(contentID int) string {
 		return "Could not verify backup file count"
@@ -40,8 +61,9 @@ func VerifyBackupFileCountOnSegments(fileCount int) {
 	numIncorrect := 0
 	for contentID := range remoteOutput.Stdouts {
 		numFound, _ := strconv.Atoi(strings.TrimSpace(remoteOutput.Stdouts[contentID]))
-		if numFound != fileCount {
-			gplog.Verbose("Expected to find %d file(s) on segment %d on host %s, but found %d instead.", fileCount, contentID, globalCluster.GetHostForContent(contentID), numFound)
+		numExpected := fileCount * len(globalFPInfo.GetSourceContentIDs(contentID))
+		if numFound != numExpected {
+			gplog.Verbose("Expected to find %d file(s) on segment %d on host %s, but found %d instead.", numExpected, contentID, globalCluster.GetHostForContent(contentID), numFound)
 			numIncorrect++
 		}
 	}
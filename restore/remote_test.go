@@ -43,6 +43,31 @@ var _ = Describe("restore/remote tests", func() {
 			restore.VerifyBackupFileCountOnSegments(2)
 			Expect((*testExecutor).NumExecutions).To(Equal(1))
 		})
+		It("does not count data file checksum files", func() {
+			testExecutor.ClusterOutput = &cluster.RemoteOutput{
+				NumErrors: 0,
+			}
+			testCluster.Executor = testExecutor
+			restore.SetCluster(testCluster)
+			restore.VerifyBackupFileCountOnSegments(2)
+			Expect(testExecutor.ClusterCommands[0][0]).To(ContainElement(`find /data/gpseg0/backups/20170101/20170101010101 -type f ! -name "*_checksums" | wc -l`))
+		})
+		It("counts the files of each backup segment read by a segment when the backup was taken on a different number of segments", func() {
+			testFPInfo = backup_filepath.NewFilePathInfo(testCluster, "/backup_dir", "20170101010101", "gpseg")
+			testFPInfo.SourceSegmentCount = 3
+			restore.SetFPInfo(testFPInfo)
+			testExecutor.ClusterOutput = &cluster.RemoteOutput{
+				Stdouts: map[int]string{
+					0: "4",
+					1: "2",
+				},
+			}
+			testCluster.Executor = testExecutor
+			restore.SetCluster(testCluster)
+			restore.VerifyBackupFileCountOnSegments(2)
+			Expect(testExecutor.ClusterCommands[0][0]).To(ContainElement(`find /backup_dir/gpseg0/backups/20170101/20170101010101 /backup_dir/gpseg2/backups/20170101/20170101010101 -type f ! -name "*_checksums" | wc -l`))
+			Expect(testExecutor.ClusterCommands[0][1]).To(ContainElement(`find /backup_dir/gpseg1/backups/20170101/20170101010101 -type f ! -name "*_checksums" | wc -l`))
+		})
 		It("panics if backup file counts do not match on all segments", func() {
 			testExecutor.ClusterOutput = &cluster.RemoteOutput{
 				Stdouts: map[int]string{
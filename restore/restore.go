@@ -33,22 +33,40 @@ func SetFlagDefaults(flagSet *pflag.FlagSet) {
 	flagSet.Bool(utils.CREATE_DB, false, "Create the database before metadata restore")
 	flagSet.Bool(utils.DATA_ONLY, false, "Only restore data, do not restore metadata")
 	flagSet.Bool(utils.DEBUG, false, "Print verbose and debug log messages")
+	flagSet.String(utils.ENCRYPTION_KEY_CMD, "", "A command that prints the key with which the backup was encrypted")
+	flagSet.String(utils.ENCRYPTION_KEY_FILE, "", "A file containing the key with which the backup was encrypted")
 	flagSet.StringSlice(utils.EXCLUDE_SCHEMA, []string{}, "Restore all metadata except objects in the specified schema(s). --exclude-schema can be specified multiple times.")
 	flagSet.StringSlice(utils.EXCLUDE_RELATION, []string{}, "Restore all metadata except the specified relation(s). --exclude-table can be specified multiple times.")
 	flagSet.String(utils.EXCLUDE_RELATION_FILE, "", "A file containing a list of fully-qualified relation(s) that will not be restored")
+	flagSet.StringArray(utils.EXCLUDE_RELATION_PATTERN, []string{}, "Restore all metadata except the relations whose fully-qualified names match the specified pattern. --exclude-table-pattern can be specified multiple times.")
+	flagSet.StringArray(utils.EXCLUDE_SCHEMA_PATTERN, []string{}, "Restore all metadata except objects in the schemas whose names match the specified pattern. --exclude-schema-pattern can be specified multiple times.")
+	flagSet.StringSlice(utils.EXCLUDE_OBJECT_TYPE, []string{}, "Restore all metadata except objects of the specified type(s), such as TRIGGER. --exclude-object-type can be specified multiple times.")
 	flagSet.Bool("help", false, "Help for gprestore")
+	flagSet.String(utils.HOOK_CONFIG, "", "A configuration file listing executables to run before and after the restore, and on failure")
 	flagSet.StringSlice(utils.INCLUDE_SCHEMA, []string{}, "Restore only the specified schema(s). --include-schema can be specified multiple times.")
 	flagSet.StringSlice(utils.INCLUDE_RELATION, []string{}, "Restore only the specified relation(s). --include-table can be specified multiple times.")
 	flagSet.String(utils.INCLUDE_RELATION_FILE, "", "A file containing a list of fully-qualified relation(s) that will be restored")
+	flagSet.StringArray(utils.INCLUDE_RELATION_PATTERN, []string{}, "Restore only the relations whose fully-qualified names match the specified pattern. --include-table-pattern can be specified multiple times.")
+	flagSet.StringArray(utils.INCLUDE_SCHEMA_PATTERN, []string{}, "Restore only the schemas whose names match the specified pattern. --include-schema-pattern can be specified multiple times.")
+	flagSet.StringSlice(utils.INCLUDE_OBJECT_TYPE, []string{}, "Restore only objects of the specified type(s), such as FUNCTION. --include-object-type can be specified multiple times.")
+	flagSet.String(utils.LIST, "", "Write the entries of the backup's table of contents to the specified file, one per line, and exit without restoring")
+	flagSet.Int(utils.MAX_BANDWIDTH, 0, "The maximum number of bytes per second to read from the backup files on each segment")
 	flagSet.Bool(utils.METADATA_ONLY, false, "Only restore metadata, do not restore data")
+	flagSet.String(utils.METRICS_FILE, "", "Write metrics for the restore in the Prometheus text format to the specified file, for the node exporter's textfile collector")
+	flagSet.String(utils.METRICS_URL, "", "Push metrics for the restore to the Pushgateway at the specified URL")
 	flagSet.Int(utils.JOBS, 1, "Number of parallel connections to use when restoring table data and post-data")
 	flagSet.Bool(utils.ON_ERROR_CONTINUE, false, "Log errors and continue restore, instead of exiting on first error")
+	flagSet.String(utils.PATTERN_TYPE, utils.GLOB_PATTERN, "The type of the patterns given to the pattern filter flags. Valid values are glob and regex.")
 	flagSet.String(utils.PLUGIN_CONFIG, "", "The configuration file to use for a plugin")
 	flagSet.Bool("version", false, "Print version number and exit")
 	flagSet.Bool(utils.QUIET, false, "Suppress non-warning, non-error log messages")
 	flagSet.String(utils.REDIRECT_DB, "", "Restore to the specified database instead of the database that was backed up")
+	flagSet.String(utils.REDIRECT_SCHEMA, "", "Restore the objects and data of a filtered restore to the specified schema instead of the schema that was backed up")
+	flagSet.Bool(utils.RESUME, false, "Resume a failed restore of the same timestamp to the same database, skipping objects and tables that were already restored")
 	flagSet.Bool(utils.WITH_GLOBALS, false, "Restore global metadata")
 	flagSet.String(utils.TIMESTAMP, "", "The timestamp to be restored, in the format YYYYMMDDHHMMSS")
+	flagSet.String(utils.TO_SQL, "", "Write the SQL statements for the metadata that would be restored to the specified file, instead of restoring to a database")
+	flagSet.String(utils.USE_LIST, "", "A file containing an edited copy of the list written by --list. Only the entries that are not commented out are restored, in the order listed")
 	flagSet.Bool(utils.VERBOSE, false, "Print verbose log messages")
 	flagSet.Bool(utils.WITH_STATS, false, "Restore query plan statistics")
 }
@@ -72,6 +90,14 @@ func DoValidation(cmd *cobra.Command) {
 	gplog.FatalOnError(err)
 	err = utils.ValidateFullPath(MustGetFlagString(utils.PLUGIN_CONFIG))
 	gplog.FatalOnError(err)
+	err = utils.ValidateFullPath(MustGetFlagString(utils.HOOK_CONFIG))
+	gplog.FatalOnError(err)
+	err = utils.ValidatePatternFlags(cmd.Flags())
+	gplog.FatalOnError(err)
+	// The flag defaults to 0 to mean that no limit is set, so 0 may not be given explicitly
+	if MustGetFlagInt(utils.MAX_BANDWIDTH) < 0 || (cmd.Flags().Changed(utils.MAX_BANDWIDTH) && MustGetFlagInt(utils.MAX_BANDWIDTH) == 0) {
+		gplog.Fatal(errors.Errorf("--%s must be a positive number", utils.MAX_BANDWIDTH), "")
+	}
 	if !backup_filepath.IsValidTimestamp(MustGetFlagString(utils.TIMESTAMP)) {
 		gplog.Fatal(errors.Errorf("Timestamp %s is invalid.  Timestamps must be in the format YYYYMMDDHHMMSS.", MustGetFlagString(utils.TIMESTAMP)), "")
 	}
@@ -80,13 +106,25 @@ func DoValidation(cmd *cobra.Command) {
 // This function handles setup that must be done after parsing flags.
 func DoSetup() {
 	SetLoggerVerbosity()
-	utils.CheckGpexpandRunning(utils.RestorePreventedByGpexpandMessage)
+	writesSQL := MustGetFlagString(utils.TO_SQL) != ""
+	if !writesSQL {
+		utils.CheckGpexpandRunning(utils.RestorePreventedByGpexpandMessage)
+	}
 	restoreStartTime = backup_history.CurrentTimestamp()
 	gplog.Info("Restore Key = %s", MustGetFlagString(utils.TIMESTAMP))
+	if hookConfigFlag := MustGetFlagString(utils.HOOK_CONFIG); hookConfigFlag != "" {
+		var err error
+		hookConfig, err = utils.ReadHookConfig(hookConfigFlag)
+		gplog.FatalOnError(err)
+	}
 
-	InitializeConnectionPool("postgres")
-	segConfig := cluster.MustGetSegmentConfiguration(connectionPool)
-	globalCluster = cluster.NewCluster(segConfig)
+	if writesSQL {
+		globalCluster = GetMasterOnlyCluster()
+	} else {
+		InitializeConnectionPool("postgres")
+		segConfig := cluster.MustGetSegmentConfiguration(connectionPool)
+		globalCluster = cluster.NewCluster(segConfig)
+	}
 	segPrefix := backup_filepath.ParseSegPrefix(MustGetFlagString(utils.BACKUP_DIR), MustGetFlagString(utils.TIMESTAMP))
 	globalFPInfo = backup_filepath.NewFilePathInfo(globalCluster, MustGetFlagString(utils.BACKUP_DIR), MustGetFlagString(utils.TIMESTAMP), segPrefix)
 
@@ -96,106 +134,249 @@ func DoSetup() {
 	} else {
 		InitializeBackupConfig()
 	}
+	globalFPInfo.SourceSegmentCount = backupConfig.SegmentCount
 
+	ValidateBackupEncryptionFlags()
+	utils.InitializeEncryptionKey(MustGetFlagString(utils.ENCRYPTION_KEY_FILE), MustGetFlagString(utils.ENCRYPTION_KEY_CMD))
+	utils.InitializeMaxBandwidth(int64(MustGetFlagInt(utils.MAX_BANDWIDTH)))
 	BackupConfigurationValidation()
+	if backupConfig.Checksummed {
+		VerifyMetadataFileChecksums()
+	}
+	if MustGetFlagString(utils.LIST) != "" {
+		WriteRestoreListFile(MustGetFlagString(utils.LIST), GetRestoreListEntries(globalTOC, GetRestorePlanDataEntries()))
+		return
+	}
+	if MustGetFlagString(utils.USE_LIST) != "" {
+		var err error
+		restoreList, err = ApplyRestoreList(globalTOC, GetRestorePlanDataEntries(), ReadRestoreList(MustGetFlagString(utils.USE_LIST)))
+		gplog.FatalOnError(err)
+	}
+	if writesSQL {
+		if MustGetFlagString(utils.REDIRECT_SCHEMA) != "" {
+			redirectSchema = quoteRestoreIdent(MustGetFlagString(utils.REDIRECT_SCHEMA))
+		}
+		WriteRestoreSQL(MustGetFlagString(utils.TO_SQL))
+		return
+	}
 	metadataFilename := globalFPInfo.GetMetadataFilePath()
 	if !backupConfig.DataOnly {
 		gplog.Verbose("Metadata will be restored from %s", metadataFilename)
 	}
-	unquotedRestoreDatabase := utils.UnquoteIdent(backupConfig.DatabaseName)
-	if MustGetFlagString(utils.REDIRECT_DB) != "" {
-		unquotedRestoreDatabase = MustGetFlagString(utils.REDIRECT_DB)
-	}
-	ValidateDatabaseExistence(unquotedRestoreDatabase, MustGetFlagBool(utils.CREATE_DB), backupConfig.IncludeTableFiltered || backupConfig.DataOnly)
-	if MustGetFlagBool(utils.WITH_GLOBALS) {
+	unquotedRestoreDatabase := getUnquotedRestoreDatabase()
+	// The post-restore or on-failure hook runs on exit once the pre-restore hook has been started
+	preHookRan = true
+	hookConfig.RunHook(globalCluster, utils.PRE_RESTORE_HOOK, globalFPInfo, unquotedRestoreDatabase, "")
+	InitializeRestoreState(unquotedRestoreDatabase)
+	createDB := MustGetFlagBool(utils.CREATE_DB) && !restoreState.IsSectionComplete("createdb")
+	ValidateDatabaseExistence(unquotedRestoreDatabase, createDB, backupConfig.IncludeTableFiltered || backupConfig.DataOnly)
+	if MustGetFlagBool(utils.WITH_GLOBALS) && !restoreState.IsSectionComplete("global") {
+		sectionTimer.StartSection("global")
 		restoreGlobal(metadataFilename)
-	} else if MustGetFlagBool(utils.CREATE_DB) {
+		sectionTimer.FinishSection()
+	} else if createDB {
+		sectionTimer.StartSection("createdb")
 		createDatabase(metadataFilename)
+		sectionTimer.FinishSection()
 	}
 	if connectionPool != nil {
 		connectionPool.Close()
 	}
 	InitializeConnectionPool(unquotedRestoreDatabase)
+	if MustGetFlagString(utils.REDIRECT_SCHEMA) != "" {
+		redirectSchema = utils.QuoteIdent(connectionPool, MustGetFlagString(utils.REDIRECT_SCHEMA))
+	}
 
 	/*
 	 * We don't need to validate anything if we're creating the database; we
 	 * should not error out for validation reasons once the restore database exists.
 	 * For on-error-continue, we will see the same errors later when we try to run SQL,
 	 * but since they will not stop the restore, it is not necessary to log them twice.
+	 * A resumed restore expects some of the relations to exist already, and
+	 * a restore that excludes tables by object type does not create them.
 	 */
-	if !MustGetFlagBool(utils.CREATE_DB) && !MustGetFlagBool(utils.ON_ERROR_CONTINUE) {
+	if !MustGetFlagBool(utils.CREATE_DB) && !MustGetFlagBool(utils.ON_ERROR_CONTINUE) && !MustGetFlagBool(utils.RESUME) && objectTypeIsRestored("TABLE") {
 		relationsToRestore := GenerateRestoreRelationList()
+		if redirectSchema != "" {
+			relationsToRestore = RedirectRelationList(relationsToRestore, redirectSchema)
+		}
 		ValidateRelationsInRestoreDatabase(connectionPool, relationsToRestore)
 	}
 }
 
 func DoRestore() {
+	if !restoresToDatabase() {
+		return
+	}
 	gucStatements := setGUCsForConnection(nil, 0)
 	metadataFilename := globalFPInfo.GetMetadataFilePath()
 	isDataOnly := backupConfig.DataOnly || MustGetFlagBool(utils.DATA_ONLY)
 	isMetadataOnly := backupConfig.MetadataOnly || MustGetFlagBool(utils.METADATA_ONLY)
 	if !isDataOnly {
+		sectionTimer.StartSection("predata")
 		restorePredata(metadataFilename)
+		sectionTimer.FinishSection()
 	}
 
-	if !isMetadataOnly {
+	// Table data is only restored along with the tables themselves
+	if !isMetadataOnly && objectTypeIsRestored("TABLE") {
 		if MustGetFlagString(utils.PLUGIN_CONFIG) == "" {
-			backupFileCount := 2 // 1 for the actual data file, 1 for the segment TOC file
+			// 1 for each data file, 1 for each segment TOC file
+			backupFileCount := 2 * utils.GetNumDataFileShards(globalTOC.DataEntries)
 			if !backupConfig.SingleDataFile {
 				backupFileCount = len(globalTOC.DataEntries)
 			}
 			VerifyBackupFileCountOnSegments(backupFileCount)
 		}
+		if utils.GetEncryptionKey() != nil {
+			if !backupConfig.SingleDataFile {
+				// The helper is used to decrypt data in the COPY commands
+				utils.VerifyHelperVersionOnSegments(version, globalCluster)
+			}
+			utils.CopyEncryptionKeyToSegments(globalCluster, globalFPInfo)
+		}
+		sectionTimer.StartSection("data")
 		restoreData(GetBackupFPInfoListFromRestorePlan(), gucStatements)
+		sectionTimer.FinishSection()
 	}
 
 	if !isDataOnly {
+		sectionTimer.StartSection("postdata")
 		restorePostdata(metadataFilename)
+		sectionTimer.FinishSection()
 	}
 
 	if MustGetFlagBool(utils.WITH_STATS) && backupConfig.WithStatistics {
+		sectionTimer.StartSection("statistics")
 		restoreStatistics()
+		sectionTimer.FinishSection()
+	}
+
+	// The state of a failed restore is kept so that it can be resumed
+	if !wasTerminated && gplog.GetErrorCode() == 0 {
+		RemoveRestoreState()
 	}
 }
 
-func createDatabase(metadataFilename string) {
+// --list and --to-sql read the backup without restoring anything to a database
+func restoresToDatabase() bool {
+	return MustGetFlagString(utils.LIST) == "" && MustGetFlagString(utils.TO_SQL) == ""
+}
+
+// Identifiers written to a SQL script are quoted without querying the database
+func quoteRestoreIdent(ident string) string {
+	if !restoresToDatabase() {
+		return utils.DoubleQuoteIdent(ident)
+	}
+	return utils.QuoteIdent(connectionPool, ident)
+}
+
+func getUnquotedRestoreDatabase() string {
+	if MustGetFlagString(utils.REDIRECT_DB) != "" {
+		return MustGetFlagString(utils.REDIRECT_DB)
+	}
+	if backupConfig == nil {
+		return ""
+	}
+	return utils.UnquoteIdent(backupConfig.DatabaseName)
+}
+
+// Returns the statements along with the quoted name of the database they create
+func getCreateDatabaseStatements(metadataFilename string) ([]utils.StatementWithType, string) {
 	objectTypes := []string{"SESSION GUCS", "DATABASE GUC", "DATABASE", "DATABASE METADATA"}
 	dbName := backupConfig.DatabaseName
-	gplog.Info("Creating database")
 	statements := GetRestoreMetadataStatements("global", metadataFilename, objectTypes, []string{}, false, false)
 	if MustGetFlagString(utils.REDIRECT_DB) != "" {
-		quotedDBName := utils.QuoteIdent(connectionPool, MustGetFlagString(utils.REDIRECT_DB))
+		quotedDBName := quoteRestoreIdent(MustGetFlagString(utils.REDIRECT_DB))
 		dbName = quotedDBName
 		statements = utils.SubstituteRedirectDatabaseInStatements(statements, backupConfig.DatabaseName, quotedDBName)
 	}
+	return statements, dbName
+}
+
+func createDatabase(metadataFilename string) {
+	gplog.Info("Creating database")
+	statements, dbName := getCreateDatabaseStatements(metadataFilename)
+	statements = restoreState.FilterExecutedStatements(statements)
 	ExecuteRestoreMetadataStatements(statements, "", nil, utils.PB_NONE, false)
+	markSectionCompleteIfAllExecuted("createdb", statements)
 	gplog.Info("Database creation complete for: %s", dbName)
 }
 
-func restoreGlobal(metadataFilename string) {
+func getGlobalStatements(metadataFilename string) []utils.StatementWithType {
 	objectTypes := []string{"SESSION GUCS", "DATABASE GUC", "DATABASE METADATA", "RESOURCE QUEUE", "RESOURCE GROUP", "ROLE", "ROLE GUCS", "ROLE GRANT", "TABLESPACE"}
 	if MustGetFlagBool(utils.CREATE_DB) {
 		objectTypes = append(objectTypes, "DATABASE")
 	}
-	gplog.Info("Restoring global metadata")
 	statements := GetRestoreMetadataStatements("global", metadataFilename, objectTypes, []string{}, false, false)
 	if MustGetFlagString(utils.REDIRECT_DB) != "" {
-		quotedDBName := utils.QuoteIdent(connectionPool, MustGetFlagString(utils.REDIRECT_DB))
+		quotedDBName := quoteRestoreIdent(MustGetFlagString(utils.REDIRECT_DB))
 		statements = utils.SubstituteRedirectDatabaseInStatements(statements, backupConfig.DatabaseName, quotedDBName)
 	}
-	statements = utils.RemoveActiveRole(connectionPool.User, statements)
+	// The role running a SQL script is not known when the script is written
+	if !restoresToDatabase() {
+		return statements
+	}
+	return utils.RemoveActiveRole(connectionPool.User, statements)
+}
+
+func restoreGlobal(metadataFilename string) {
+	gplog.Info("Restoring global metadata")
+	statements := getGlobalStatements(metadataFilename)
+	statements = restoreState.FilterExecutedStatements(statements)
 	ExecuteRestoreMetadataStatements(statements, "Global objects", nil, utils.PB_VERBOSE, false)
+	markSectionCompleteIfAllExecuted("global", statements)
+	if MustGetFlagBool(utils.CREATE_DB) {
+		markSectionCompleteIfAllExecuted("createdb", statements)
+	}
 	gplog.Info("Global database metadata restore complete")
 }
 
+// Schemas are returned separately, as they must be created before any other objects
+func getPredataStatements(metadataFilename string) ([]utils.StatementWithType, []utils.StatementWithType) {
+	includeObjectTypes, excludeObjectTypes := getObjectTypeFilters()
+	schemaStatements := GetRestoreMetadataStatements("predata", metadataFilename, []string{"SCHEMA"}, []string{}, true, false)
+	statements := GetRestoreMetadataStatements("predata", metadataFilename, includeObjectTypes, excludeObjectTypes, true, true)
+	statements = removeStatementsOfType(statements, "SCHEMA")
+	if !objectTypeIsRestored("SCHEMA") {
+		// The restored objects cannot be created without their schemas, so those are restored whatever the type filters say
+		schemaStatements = getSchemaStatementsForObjects(schemaStatements, statements)
+	}
+	if redirectSchema != "" {
+		// The backed-up schemas are left alone, and every restored object is created in the redirect schema instead
+		schemaStatements = []utils.StatementWithType{{Name: redirectSchema, ObjectType: "SCHEMA", Statement: fmt.Sprintf("\n\nCREATE SCHEMA %s;\n", redirectSchema)}}
+		statements = utils.SubstituteRedirectSchemaInStatements(statements, redirectSchema)
+	}
+	return schemaStatements, statements
+}
+
+func getSchemaStatementsForObjects(schemaStatements []utils.StatementWithType, statements []utils.StatementWithType) []utils.StatementWithType {
+	neededSchemas := make(map[string]bool)
+	for _, statement := range statements {
+		neededSchemas[statement.Schema] = true
+	}
+	filteredStatements := make([]utils.StatementWithType, 0)
+	for _, schema := range schemaStatements {
+		if neededSchemas[schema.Name] {
+			filteredStatements = append(filteredStatements, schema)
+		}
+	}
+	return filteredStatements
+}
+
 func restorePredata(metadataFilename string) {
 	if wasTerminated {
 		return
 	}
+	if restoreState.IsSectionComplete("predata") {
+		gplog.Info("Pre-data metadata was restored before the previous restore failed; skipping")
+		return
+	}
 	gplog.Info("Restoring pre-data metadata")
 
-	schemaStatements := GetRestoreMetadataStatements("predata", metadataFilename, []string{"SCHEMA"}, []string{}, true, false)
-	statements := GetRestoreMetadataStatements("predata", metadataFilename, []string{}, []string{"SCHEMA"}, true, true)
+	schemaStatements, statements := getPredataStatements(metadataFilename)
+	schemaStatements = restoreState.FilterExecutedStatements(schemaStatements)
+	statements = restoreState.FilterExecutedStatements(statements)
 
 	progressBar := utils.NewProgressBar(len(schemaStatements)+len(statements), "Pre-data objects restored: ", utils.PB_VERBOSE)
 	progressBar.Start()
@@ -204,6 +385,7 @@ func restorePredata(metadataFilename string) {
 	ExecuteRestoreMetadataStatements(statements, "Pre-data objects", progressBar, utils.PB_VERBOSE, false)
 
 	progressBar.Finish()
+	markSectionCompleteIfAllExecuted("predata", append(schemaStatements, statements...))
 	if wasTerminated {
 		gplog.Info("Pre-data metadata restore incomplete")
 	} else {
@@ -226,10 +408,16 @@ func restoreData(fpInfoList []backup_filepath.FilePathInfo, gucStatements []util
 		filteredDataEntriesForTimestamp := toc.GetDataEntriesMatching(MustGetFlagStringSlice(utils.INCLUDE_SCHEMA),
 			MustGetFlagStringSlice(utils.EXCLUDE_SCHEMA), MustGetFlagStringSlice(utils.INCLUDE_RELATION),
 			MustGetFlagStringSlice(utils.EXCLUDE_RELATION), restorePlanTableFQNs)
+		filteredDataEntriesForTimestamp = FilterDataEntriesByRestoreList(filteredDataEntriesForTimestamp, restoreList)
+		filteredDataEntriesForTimestamp = restoreState.FilterCompletedDataEntries(fpInfo.Timestamp, filteredDataEntriesForTimestamp)
 		filteredDataEntries = append(filteredDataEntries, filteredDataEntriesForTimestamp)
 
 		totalTables += len(filteredDataEntriesForTimestamp)
 	}
+	if globalFPInfo.HasDifferentSegmentCount() {
+		replicatedTables = GetReplicatedTables(connectionPool)
+		ValidateReplicatedTablesForSegmentCount(filteredDataEntries)
+	}
 	dataProgressBar := utils.NewProgressBar(totalTables, "Tables restored: ", utils.PB_INFO)
 	dataProgressBar.Start()
 
@@ -246,18 +434,33 @@ func restoreData(fpInfoList []backup_filepath.FilePathInfo, gucStatements []util
 	}
 }
 
+func getPostdataStatements(metadataFilename string) []utils.StatementWithType {
+	includeObjectTypes, excludeObjectTypes := getObjectTypeFilters()
+	statements := GetRestoreMetadataStatements("postdata", metadataFilename, includeObjectTypes, excludeObjectTypes, true, true)
+	if redirectSchema != "" {
+		statements = utils.SubstituteRedirectSchemaInStatements(statements, redirectSchema)
+	}
+	return statements
+}
+
 func restorePostdata(metadataFilename string) {
 	if wasTerminated {
 		return
 	}
+	if restoreState.IsSectionComplete("postdata") {
+		gplog.Info("Post-data metadata was restored before the previous restore failed; skipping")
+		return
+	}
 	gplog.Info("Restoring post-data metadata")
-	statements := GetRestoreMetadataStatements("postdata", metadataFilename, []string{}, []string{}, true, true)
+	statements := getPostdataStatements(metadataFilename)
+	statements = restoreState.FilterExecutedStatements(statements)
 	firstBatch, secondBatch := BatchPostdataStatements(statements)
 	progressBar := utils.NewProgressBar(len(statements), "Post-data objects restored: ", utils.PB_VERBOSE)
 	progressBar.Start()
 	ExecuteRestoreMetadataStatements(firstBatch, "", progressBar, utils.PB_VERBOSE, connectionPool.NumConns > 1)
 	ExecuteRestoreMetadataStatements(secondBatch, "", progressBar, utils.PB_VERBOSE, connectionPool.NumConns > 1)
 	progressBar.Finish()
+	markSectionCompleteIfAllExecuted("postdata", statements)
 	if wasTerminated {
 		gplog.Info("Post-data metadata restore incomplete")
 	} else {
@@ -269,10 +472,16 @@ func restoreStatistics() {
 	if wasTerminated {
 		return
 	}
+	if restoreState.IsSectionComplete("statistics") {
+		gplog.Info("Query planner statistics were restored before the previous restore failed; skipping")
+		return
+	}
 	statisticsFilename := globalFPInfo.GetStatisticsFilePath()
 	gplog.Info("Restoring query planner statistics from %s", statisticsFilename)
 	statements := GetRestoreMetadataStatements("statistics", statisticsFilename, []string{}, []string{}, true, false)
+	statements = restoreState.FilterExecutedStatements(statements)
 	ExecuteRestoreMetadataStatements(statements, "Table statistics", nil, utils.PB_VERBOSE, false)
+	markSectionCompleteIfAllExecuted("statistics", statements)
 	gplog.Info("Query planner statistics restore complete")
 }
 
@@ -313,7 +522,16 @@ func DoTeardown() {
 	}
 	errMsg := utils.ParseErrorMessage(errStr)
 
-	if globalFPInfo.Timestamp != "" {
+	// The copies of the plugin config on each host may hold secrets, so they are removed whatever was run
+	if pluginConfig != nil {
+		defer func() {
+			pluginConfig.CleanupPluginForRestore(globalCluster, globalFPInfo)
+			pluginConfig.DeletePluginConfigWhenEncrypting(globalCluster)
+		}()
+	}
+
+	// Nothing is restored when listing the backup or writing SQL, so there is nothing to report
+	if globalFPInfo.Timestamp != "" && restoresToDatabase() {
 		_, statErr := os.Stat(globalFPInfo.GetDirForContent(-1))
 		if statErr != nil { // Even if this isn't os.IsNotExist, don't try to write a report file in case of further errors
 			return
@@ -321,11 +539,14 @@ func DoTeardown() {
 
 		reportFilename := globalFPInfo.GetRestoreReportFilePath(restoreStartTime)
 		utils.WriteRestoreReportFile(reportFilename, globalFPInfo.Timestamp, restoreStartTime, connectionPool, version, errMsg)
-		utils.EmailReport(globalCluster, globalFPInfo.Timestamp, reportFilename, "gprestore")
-		if pluginConfig != nil {
-			pluginConfig.CleanupPluginForRestore(globalCluster, globalFPInfo)
-			pluginConfig.DeletePluginConfigWhenEncrypting(globalCluster)
-		}
+		jsonReport := utils.GetRestoreJSONReport(globalFPInfo.Timestamp, restoreStartTime, connectionPool, version,
+			backupConfig, &sectionTimer, restoredTables.Tables(), errMsg)
+		jsonReportFilename := globalFPInfo.GetRestoreJSONReportFilePath(restoreStartTime)
+		utils.WriteJSONReportFile(jsonReportFilename, jsonReport)
+		utils.WriteMetrics(jsonReport, nil, MustGetFlagString(utils.METRICS_FILE), MustGetFlagString(utils.METRICS_URL))
+		metricsWritten = true
+		utils.SendNotifications(globalCluster, utils.Notification{Utility: "gprestore", Timestamp: globalFPInfo.Timestamp,
+			ReportFilePath: reportFilename, JSONReportFilePath: jsonReportFilename, JSONReport: jsonReport})
 	}
 }
 
@@ -334,6 +555,8 @@ func DoCleanup() {
 		if err := recover(); err != nil {
 			gplog.Warn("Encountered error during cleanup: %v", err)
 		}
+		writeStatusMetrics()
+		RunPostHook()
 		gplog.Verbose("Cleanup complete")
 		CleanupGroup.Done()
 	}()
@@ -344,11 +567,18 @@ func DoCleanup() {
 		for _, fpInfo := range fpInfoList {
 			utils.CleanUpSegmentHelperProcesses(globalCluster, fpInfo, "restore")
 			utils.CleanUpHelperFilesOnAllHosts(globalCluster, fpInfo)
-			if wasTerminated { // These should all end on their own in a successful restore
+			if wasTerminated && connectionPool != nil { // These should all end on their own in a successful restore
 				utils.TerminateHangingCopySessions(connectionPool, fpInfo, "gprestore")
 			}
 		}
 	}
+	if utils.GetEncryptionKey() != nil && globalCluster != nil {
+		utils.RemoveEncryptionKeyFromSegments(globalCluster, globalFPInfo)
+	}
+
+	if restoreState != nil {
+		_ = restoreState.Close()
+	}
 
 	if connectionPool != nil {
 		connectionPool.Close()
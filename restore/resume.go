@@ -0,0 +1,247 @@
+package restore
+
+/*
+ * This file contains structs and functions for recording the progress of a
+ * restore so that it can be resumed with --resume after a failure.
+ *
+ * The state file begins with the name of the database being restored to, and
+ * a line is appended to it for each metadata statement executed, each table
+ * whose data is loaded, and each metadata section that is fully restored.
+ */
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/iohelper"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+/*
+ * A nil *RestoreState records nothing and treats nothing as complete, so
+ * callers do not need to check whether progress is being recorded.
+ */
+type RestoreState struct {
+	Database           string
+	completedSections  map[string]bool
+	executedStatements map[string]bool
+	completedTables    map[string]bool
+	file               io.WriteCloser
+	mutex              sync.Mutex
+}
+
+func newRestoreState(database string) *RestoreState {
+	return &RestoreState{
+		Database:           database,
+		completedSections:  make(map[string]bool, 0),
+		executedStatements: make(map[string]bool, 0),
+		completedTables:    make(map[string]bool, 0),
+	}
+}
+
+func CreateRestoreState(filename string, database string) (*RestoreState, error) {
+	file, err := operating.System.OpenFileWrite(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to create restore state file %s", filename)
+	}
+	state := newRestoreState(database)
+	state.file = file
+	_, err = fmt.Fprintf(file, "database %s\n", database)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to write restore state file %s", filename)
+	}
+	return state, nil
+}
+
+/*
+ * Lines that cannot be parsed, such as a partial line written as the restore
+ * failed, are skipped.
+ */
+func ReadRestoreState(filename string) (*RestoreState, error) {
+	lines, err := iohelper.ReadLinesFromFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to read restore state file %s", filename)
+	}
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "database ") {
+		return nil, errors.Errorf("Restore state file %s is invalid", filename)
+	}
+	state := newRestoreState(strings.TrimPrefix(lines[0], "database "))
+	for _, line := range lines[1:] {
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 || fields[1] == "" {
+			continue
+		}
+		switch fields[0] {
+		case "section":
+			state.completedSections[fields[1]] = true
+		case "statement":
+			state.executedStatements[fields[1]] = true
+		case "table":
+			state.completedTables[fields[1]] = true
+		}
+	}
+	file, err := operating.System.OpenFileWrite(filename, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to open restore state file %s", filename)
+	}
+	state.file = file
+	return state, nil
+}
+
+func getStatementKey(statement utils.StatementWithType) string {
+	checksum := sha256.Sum256([]byte(statement.Statement))
+	return hex.EncodeToString(checksum[:])
+}
+
+func getTableKey(timestamp string, oid uint32) string {
+	return fmt.Sprintf("%s %d", timestamp, oid)
+}
+
+// Session GUCs are set on every connection, so they are never treated as already executed
+func isTrackedStatement(statement utils.StatementWithType) bool {
+	return statement.ObjectType != "SESSION GUCS"
+}
+
+// Each entry is written as a single line, so that an entry is either recorded in full or not at all
+func (state *RestoreState) record(entries map[string]bool, entryType string, key string) error {
+	if state == nil {
+		return nil
+	}
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if entries[key] {
+		return nil
+	}
+	_, err := fmt.Fprintf(state.file, "%s %s\n", entryType, key)
+	if err != nil {
+		return errors.Wrap(err, "Unable to write to restore state file")
+	}
+	entries[key] = true
+	return nil
+}
+
+func (state *RestoreState) isRecorded(entries map[string]bool, key string) bool {
+	if state == nil {
+		return false
+	}
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	return entries[key]
+}
+
+func (state *RestoreState) MarkSectionComplete(section string) error {
+	if state == nil {
+		return nil
+	}
+	return state.record(state.completedSections, "section", section)
+}
+
+func (state *RestoreState) IsSectionComplete(section string) bool {
+	return state != nil && state.isRecorded(state.completedSections, section)
+}
+
+func (state *RestoreState) MarkStatementExecuted(statement utils.StatementWithType) error {
+	if state == nil || !isTrackedStatement(statement) {
+		return nil
+	}
+	return state.record(state.executedStatements, "statement", getStatementKey(statement))
+}
+
+func (state *RestoreState) MarkTableComplete(timestamp string, oid uint32) error {
+	if state == nil {
+		return nil
+	}
+	return state.record(state.completedTables, "table", getTableKey(timestamp, oid))
+}
+
+func (state *RestoreState) FilterExecutedStatements(statements []utils.StatementWithType) []utils.StatementWithType {
+	if state == nil {
+		return statements
+	}
+	remainingStatements := make([]utils.StatementWithType, 0)
+	for _, statement := range statements {
+		if !isTrackedStatement(statement) || !state.isRecorded(state.executedStatements, getStatementKey(statement)) {
+			remainingStatements = append(remainingStatements, statement)
+		}
+	}
+	return remainingStatements
+}
+
+func (state *RestoreState) FilterCompletedDataEntries(timestamp string, dataEntries []utils.MasterDataEntry) []utils.MasterDataEntry {
+	if state == nil {
+		return dataEntries
+	}
+	remainingEntries := make([]utils.MasterDataEntry, 0)
+	for _, entry := range dataEntries {
+		if !state.isRecorded(state.completedTables, getTableKey(timestamp, entry.Oid)) {
+			remainingEntries = append(remainingEntries, entry)
+		}
+	}
+	return remainingEntries
+}
+
+func (state *RestoreState) Close() error {
+	if state == nil {
+		return nil
+	}
+	return state.file.Close()
+}
+
+/*
+ * A new restore replaces any state left by a previous restore of the same
+ * backup, while a resumed restore must be to the same database as the restore
+ * that failed.
+ */
+func InitializeRestoreState(unquotedRestoreDatabase string) {
+	var err error
+	stateFilename := globalFPInfo.GetRestoreStateFilePath()
+	if !MustGetFlagBool(utils.RESUME) {
+		restoreState, err = CreateRestoreState(stateFilename, unquotedRestoreDatabase)
+		gplog.FatalOnError(err)
+		return
+	}
+	if !iohelper.FileExistsAndIsReadable(stateFilename) {
+		gplog.Fatal(errors.Errorf("Restore of backup %s cannot be resumed, as state file %s was not found.", globalFPInfo.Timestamp, stateFilename), "")
+	}
+	restoreState, err = ReadRestoreState(stateFilename)
+	gplog.FatalOnError(err)
+	if restoreState.Database != unquotedRestoreDatabase {
+		gplog.Fatal(errors.Errorf(`The failed restore of backup %s was to database "%s".  It cannot be resumed to database "%s".`,
+			globalFPInfo.Timestamp, restoreState.Database, unquotedRestoreDatabase), "")
+	}
+	gplog.Info("Resuming restore of backup %s to database %s", globalFPInfo.Timestamp, unquotedRestoreDatabase)
+}
+
+func markSectionCompleteIfAllExecuted(section string, statements []utils.StatementWithType) {
+	if wasTerminated {
+		return
+	}
+	for _, statement := range restoreState.FilterExecutedStatements(statements) {
+		if isTrackedStatement(statement) {
+			return
+		}
+	}
+	err := restoreState.MarkSectionComplete(section)
+	gplog.FatalOnError(err)
+}
+
+func RemoveRestoreState() {
+	err := restoreState.Close()
+	if err != nil {
+		gplog.Warn("Unable to close restore state file: %v", err)
+	}
+	restoreState = nil
+	stateFilename := globalFPInfo.GetRestoreStateFilePath()
+	err = operating.System.Remove(stateFilename)
+	if err != nil && !os.IsNotExist(err) {
+		gplog.Warn("Unable to remove restore state file %s: %v", stateFilename, err)
+	}
+}
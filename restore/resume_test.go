@@ -0,0 +1,89 @@
+package restore_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/restore"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("restore/resume tests", func() {
+	var (
+		tempDir    string
+		filename   string
+		schema     = utils.StatementWithType{Schema: "foo", Name: "foo", ObjectType: "SCHEMA", Statement: "CREATE SCHEMA foo;"}
+		table      = utils.StatementWithType{Schema: "foo", Name: "bar", ObjectType: "TABLE", Statement: "CREATE TABLE foo.bar (i int);"}
+		sessionGUC = utils.StatementWithType{ObjectType: "SESSION GUCS", Statement: "SET client_encoding = 'UTF8';"}
+		entries    = []utils.MasterDataEntry{{Schema: "foo", Name: "bar", Oid: 1}, {Schema: "foo", Name: "baz", Oid: 2}}
+	)
+	BeforeEach(func() {
+		operating.System = operating.InitializeSystemFunctions()
+		tempDir, _ = ioutil.TempDir("", "resume")
+		filename = filepath.Join(tempDir, "gprestore_20170101010101_state")
+	})
+	AfterEach(func() {
+		_ = os.RemoveAll(tempDir)
+	})
+	Describe("CreateRestoreState and ReadRestoreState", func() {
+		It("reads the progress recorded by a previous restore", func() {
+			state, err := restore.CreateRestoreState(filename, "testdb")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(state.MarkStatementExecuted(schema)).To(Succeed())
+			Expect(state.MarkStatementExecuted(sessionGUC)).To(Succeed())
+			Expect(state.MarkSectionComplete("global")).To(Succeed())
+			Expect(state.MarkTableComplete("20170101010101", 1)).To(Succeed())
+			Expect(state.Close()).To(Succeed())
+
+			resumeState, err := restore.ReadRestoreState(filename)
+			Expect(err).ToNot(HaveOccurred())
+			defer resumeState.Close()
+			Expect(resumeState.Database).To(Equal("testdb"))
+			Expect(resumeState.IsSectionComplete("global")).To(BeTrue())
+			Expect(resumeState.IsSectionComplete("predata")).To(BeFalse())
+			Expect(resumeState.FilterExecutedStatements([]utils.StatementWithType{sessionGUC, schema, table})).To(Equal([]utils.StatementWithType{sessionGUC, table}))
+			Expect(resumeState.FilterCompletedDataEntries("20170101010101", entries)).To(Equal(entries[1:]))
+			Expect(resumeState.FilterCompletedDataEntries("20170101020202", entries)).To(Equal(entries))
+		})
+		It("appends to the state file of a resumed restore", func() {
+			state, _ := restore.CreateRestoreState(filename, "testdb")
+			_ = state.MarkStatementExecuted(schema)
+			_ = state.Close()
+
+			resumeState, _ := restore.ReadRestoreState(filename)
+			Expect(resumeState.MarkStatementExecuted(table)).To(Succeed())
+			Expect(resumeState.Close()).To(Succeed())
+
+			finalState, _ := restore.ReadRestoreState(filename)
+			defer finalState.Close()
+			Expect(finalState.FilterExecutedStatements([]utils.StatementWithType{schema, table})).To(BeEmpty())
+		})
+		It("skips a partially written line", func() {
+			_ = ioutil.WriteFile(filename, []byte("database testdb\ntable 20170101010101 1\ntable"), 0644)
+			state, err := restore.ReadRestoreState(filename)
+			Expect(err).ToNot(HaveOccurred())
+			defer state.Close()
+			Expect(state.FilterCompletedDataEntries("20170101010101", entries)).To(Equal(entries[1:]))
+		})
+		It("returns an error if the state file has no database", func() {
+			_ = ioutil.WriteFile(filename, []byte("table 20170101010101 1\n"), 0644)
+			_, err := restore.ReadRestoreState(filename)
+			Expect(err).To(MatchError(ContainSubstring("is invalid")))
+		})
+	})
+	Describe("nil RestoreState", func() {
+		It("records nothing and filters nothing", func() {
+			var state *restore.RestoreState
+			Expect(state.MarkStatementExecuted(schema)).To(Succeed())
+			Expect(state.IsSectionComplete("predata")).To(BeFalse())
+			Expect(state.FilterExecutedStatements([]utils.StatementWithType{schema})).To(Equal([]utils.StatementWithType{schema}))
+			Expect(state.FilterCompletedDataEntries("20170101010101", entries)).To(Equal(entries))
+			Expect(state.Close()).To(Succeed())
+		})
+	})
+})
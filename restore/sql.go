@@ -0,0 +1,79 @@
+package restore
+
+/*
+ * This file contains functions for writing the statements that a restore
+ * would execute to a SQL script with --to-sql, instead of executing them.
+ */
+
+import (
+	"io"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/iohelper"
+	"github.com/greenplum-db/gpbackup/utils"
+)
+
+/*
+ * The script is built from the same statements, with the same filtering and
+ * substitutions, as a restore with the same flags.  Session GUCs are set at
+ * the beginning of the script and again after connecting to a database that
+ * the script creates, so the script can be run with psql as is.
+ */
+func WriteRestoreSQL(filename string) {
+	sqlFile := iohelper.MustOpenFileForWriting(filename)
+	WriteRestoreSQLStatements(sqlFile)
+	err := sqlFile.Close()
+	gplog.FatalOnError(err)
+	gplog.Info("Restore SQL written to %s", filename)
+}
+
+func WriteRestoreSQLStatements(sqlFile io.Writer) {
+	metadataFilename := globalFPInfo.GetMetadataFilePath()
+	utils.MustPrintf(sqlFile, "--\n-- Greenplum Database restore script for backup %s of database %s\n--\n", globalFPInfo.Timestamp, backupConfig.DatabaseName)
+
+	gucStatements := GetRestoreMetadataStatements("global", metadataFilename, []string{"SESSION GUCS"}, []string{}, false, false)
+	writeSQLStatements(sqlFile, gucStatements)
+	dbName := ""
+	if MustGetFlagBool(utils.WITH_GLOBALS) {
+		writeSQLStatements(sqlFile, removeStatementsOfType(getGlobalStatements(metadataFilename), "SESSION GUCS"))
+		if MustGetFlagBool(utils.CREATE_DB) {
+			_, dbName = getCreateDatabaseStatements(metadataFilename)
+		}
+	} else if MustGetFlagBool(utils.CREATE_DB) {
+		var statements []utils.StatementWithType
+		statements, dbName = getCreateDatabaseStatements(metadataFilename)
+		writeSQLStatements(sqlFile, removeStatementsOfType(statements, "SESSION GUCS"))
+	}
+	if dbName != "" {
+		utils.MustPrintf(sqlFile, "\n\n\\connect %s\n", dbName)
+		writeSQLStatements(sqlFile, gucStatements)
+	}
+
+	if !backupConfig.DataOnly {
+		schemaStatements, statements := getPredataStatements(metadataFilename)
+		writeSQLStatements(sqlFile, schemaStatements)
+		writeSQLStatements(sqlFile, statements)
+		writeSQLStatements(sqlFile, getPostdataStatements(metadataFilename))
+	}
+
+	if MustGetFlagBool(utils.WITH_STATS) && backupConfig.WithStatistics {
+		statisticsFilename := globalFPInfo.GetStatisticsFilePath()
+		writeSQLStatements(sqlFile, GetRestoreMetadataStatements("statistics", statisticsFilename, []string{}, []string{}, true, false))
+	}
+}
+
+func writeSQLStatements(sqlFile io.Writer, statements []utils.StatementWithType) {
+	for _, statement := range statements {
+		utils.MustPrintf(sqlFile, "%s", statement.Statement)
+	}
+}
+
+func removeStatementsOfType(statements []utils.StatementWithType, objectType string) []utils.StatementWithType {
+	filteredStatements := make([]utils.StatementWithType, 0)
+	for _, statement := range statements {
+		if statement.ObjectType != objectType {
+			filteredStatements = append(filteredStatements, statement)
+		}
+	}
+	return filteredStatements
+}
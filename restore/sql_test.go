@@ -0,0 +1,153 @@
+package restore_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/backup_filepath"
+	"github.com/greenplum-db/gpbackup/backup_history"
+	"github.com/greenplum-db/gpbackup/restore"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("restore/sql tests", func() {
+	var (
+		tempDir string
+		toc     *utils.TOC
+	)
+	BeforeEach(func() {
+		operating.System = operating.InitializeSystemFunctions()
+		tempDir, _ = ioutil.TempDir("", "sql")
+		testCluster := cluster.NewCluster([]cluster.SegConfig{{ContentID: -1, Hostname: "localhost", DataDir: "/data/gpseg-1"}})
+		fpInfo := backup_filepath.NewFilePathInfo(testCluster, tempDir, "20170101010101", "gpseg")
+		restore.SetFPInfo(fpInfo)
+		restore.SetBackupConfig(&backup_history.BackupConfig{DatabaseName: "testdb"})
+		restore.SetRedirectSchema("")
+
+		toc = &utils.TOC{}
+		toc.InitializeMetadataEntryMap()
+		metadata := ""
+		addStatement := func(section string, entry utils.MetadataEntry, statement string) {
+			toc.AddMetadataEntry(section, entry, uint64(len(metadata)), uint64(len(metadata)+len(statement)))
+			metadata += statement
+		}
+		addStatement("global", utils.MetadataEntry{ObjectType: "SESSION GUCS"}, "\n\nSET client_encoding = 'UTF8';\n")
+		addStatement("global", utils.MetadataEntry{Name: "testdb", ObjectType: "DATABASE"}, "\n\nCREATE DATABASE testdb;\n")
+		addStatement("global", utils.MetadataEntry{Name: "testrole", ObjectType: "ROLE"}, "\n\nCREATE ROLE testrole;\n")
+		addStatement("predata", utils.MetadataEntry{Name: "foo", ObjectType: "SCHEMA"}, "\n\nCREATE SCHEMA foo;\n")
+		addStatement("predata", utils.MetadataEntry{Schema: "foo", Name: "bar", ObjectType: "TABLE"}, "\n\nCREATE TABLE foo.bar (i int);\n")
+		addStatement("postdata", utils.MetadataEntry{Schema: "foo", Name: "bar_idx", ObjectType: "INDEX", ReferenceObject: "foo.bar"}, "\n\nCREATE INDEX bar_idx ON foo.bar USING btree (i);\n")
+		restore.SetTOC(toc)
+		cmdFlags.Set(utils.TO_SQL, filepath.Join(tempDir, "restore.sql"))
+
+		metadataFilename := fpInfo.GetMetadataFilePath()
+		_ = os.MkdirAll(filepath.Dir(metadataFilename), 0755)
+		_ = ioutil.WriteFile(metadataFilename, []byte(metadata), 0644)
+	})
+	AfterEach(func() {
+		_ = os.RemoveAll(tempDir)
+	})
+	Describe("WriteRestoreSQLStatements", func() {
+		It("writes the session GUCs followed by the pre-data and post-data statements", func() {
+			restore.WriteRestoreSQLStatements(buffer)
+			Expect(string(buffer.Contents())).To(Equal(`--
+-- Greenplum Database restore script for backup 20170101010101 of database testdb
+--
+
+
+SET client_encoding = 'UTF8';
+
+
+CREATE SCHEMA foo;
+
+
+CREATE TABLE foo.bar (i int);
+
+
+CREATE INDEX bar_idx ON foo.bar USING btree (i);
+`))
+		})
+		It("creates and connects to the database with --create-db", func() {
+			cmdFlags.Set(utils.CREATE_DB, "true")
+			restore.WriteRestoreSQLStatements(buffer)
+			Expect(string(buffer.Contents())).To(ContainSubstring(`
+
+SET client_encoding = 'UTF8';
+
+
+CREATE DATABASE testdb;
+
+
+\connect testdb
+
+
+SET client_encoding = 'UTF8';
+
+
+CREATE SCHEMA foo;
+`))
+		})
+		It("quotes the redirect database without querying the database", func() {
+			cmdFlags.Set(utils.CREATE_DB, "true")
+			cmdFlags.Set(utils.REDIRECT_DB, "newdb")
+			restore.WriteRestoreSQLStatements(buffer)
+			Expect(string(buffer.Contents())).To(ContainSubstring("\n\nCREATE DATABASE \"newdb\";\n\n\n\\connect \"newdb\"\n"))
+		})
+		It("does not remove the role of the current user from the global statements", func() {
+			connectionPool.User = "testrole"
+			cmdFlags.Set(utils.WITH_GLOBALS, "true")
+			restore.WriteRestoreSQLStatements(buffer)
+			Expect(string(buffer.Contents())).To(ContainSubstring("\n\nCREATE ROLE testrole;\n"))
+		})
+		It("writes only the listed statements when a restore list is applied", func() {
+			_, err := restore.ApplyRestoreList(toc, []utils.MasterDataEntry{}, []int{1, 5})
+			Expect(err).ToNot(HaveOccurred())
+			restore.WriteRestoreSQLStatements(buffer)
+			Expect(string(buffer.Contents())).To(ContainSubstring("SET client_encoding = 'UTF8';\n\n\nCREATE TABLE foo.bar (i int);\n"))
+			Expect(string(buffer.Contents())).ToNot(ContainSubstring("CREATE SCHEMA foo;"))
+			Expect(string(buffer.Contents())).ToNot(ContainSubstring("CREATE INDEX"))
+		})
+		It("writes only the statements for objects of the included types and their schemas", func() {
+			cmdFlags.Set(utils.INCLUDE_OBJECT_TYPE, "table,INDEX")
+			restore.WriteRestoreSQLStatements(buffer)
+			Expect(string(buffer.Contents())).To(HaveSuffix("SET client_encoding = 'UTF8';\n\n\nCREATE SCHEMA foo;\n\n\nCREATE TABLE foo.bar (i int);\n\n\nCREATE INDEX bar_idx ON foo.bar USING btree (i);\n"))
+		})
+		It("does not write the statements for objects of the excluded types", func() {
+			cmdFlags.Set(utils.EXCLUDE_OBJECT_TYPE, "INDEX")
+			restore.WriteRestoreSQLStatements(buffer)
+			Expect(string(buffer.Contents())).To(HaveSuffix("\n\nCREATE SCHEMA foo;\n\n\nCREATE TABLE foo.bar (i int);\n"))
+		})
+		It("writes the statements for objects of the included types that reference the included tables", func() {
+			cmdFlags.Set(utils.INCLUDE_OBJECT_TYPE, "TABLE,INDEX")
+			cmdFlags.Set(utils.INCLUDE_RELATION, "foo.bar")
+			restore.WriteRestoreSQLStatements(buffer)
+			Expect(string(buffer.Contents())).To(HaveSuffix("\n\nCREATE TABLE foo.bar (i int);\n\n\nCREATE INDEX bar_idx ON foo.bar USING btree (i);\n"))
+		})
+		It("does not write the statements for objects that reference tables that are not restored", func() {
+			cmdFlags.Set(utils.INCLUDE_OBJECT_TYPE, "INDEX")
+			restore.WriteRestoreSQLStatements(buffer)
+			Expect(string(buffer.Contents())).To(HaveSuffix("SET client_encoding = 'UTF8';\n"))
+		})
+		It("does not write the schema statements when no objects in the schemas are restored", func() {
+			cmdFlags.Set(utils.EXCLUDE_OBJECT_TYPE, "SCHEMA,TABLE")
+			restore.WriteRestoreSQLStatements(buffer)
+			Expect(string(buffer.Contents())).ToNot(ContainSubstring("CREATE"))
+		})
+		It("writes the schema statements for restored objects when schemas are excluded", func() {
+			cmdFlags.Set(utils.EXCLUDE_OBJECT_TYPE, "SCHEMA")
+			restore.WriteRestoreSQLStatements(buffer)
+			Expect(string(buffer.Contents())).To(HaveSuffix("SET client_encoding = 'UTF8';\n\n\nCREATE SCHEMA foo;\n\n\nCREATE TABLE foo.bar (i int);\n\n\nCREATE INDEX bar_idx ON foo.bar USING btree (i);\n"))
+		})
+		It("creates statements in the redirect schema", func() {
+			restore.SetRedirectSchema("baz")
+			restore.WriteRestoreSQLStatements(buffer)
+			Expect(string(buffer.Contents())).To(ContainSubstring("\n\nCREATE SCHEMA baz;\n\n\nCREATE TABLE baz.bar (i int);\n\n\nCREATE INDEX bar_idx ON baz.bar USING btree (i);\n"))
+		})
+	})
+})
@@ -2,11 +2,14 @@ package restore
 
 import (
 	"fmt"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/iohelper"
 	"github.com/greenplum-db/gpbackup/utils"
 	"github.com/pkg/errors"
 	"github.com/spf13/pflag"
@@ -21,6 +24,9 @@ func validateFilterListsInBackupSet() {
 	ValidateExcludeSchemasInBackupSet(MustGetFlagStringSlice(utils.EXCLUDE_SCHEMA))
 	ValidateIncludeRelationsInBackupSet(MustGetFlagStringSlice(utils.INCLUDE_RELATION))
 	ValidateExcludeRelationsInBackupSet(MustGetFlagStringSlice(utils.EXCLUDE_RELATION))
+	includeObjectTypes, excludeObjectTypes := getObjectTypeFilters()
+	ValidateIncludeObjectTypesInBackupSet(includeObjectTypes)
+	ValidateExcludeObjectTypesInBackupSet(excludeObjectTypes)
 }
 
 func ValidateIncludeSchemasInBackupSet(schemaList []string) {
@@ -35,6 +41,37 @@ func ValidateExcludeSchemasInBackupSet(schemaList []string) {
 	}
 }
 
+func ValidateIncludeObjectTypesInBackupSet(objectTypeList []string) {
+	if missingTypes, backupTypes := getFilterObjectTypesInBackupSet(objectTypeList); len(missingTypes) != 0 {
+		gplog.Fatal(errors.Errorf("Could not find the following object type(s) in the backup set: %s.  Object types in the backup set are: %s",
+			strings.Join(missingTypes, ", "), strings.Join(backupTypes, ", ")), "")
+	}
+}
+
+func ValidateExcludeObjectTypesInBackupSet(objectTypeList []string) {
+	if missingTypes, _ := getFilterObjectTypesInBackupSet(objectTypeList); len(missingTypes) != 0 {
+		gplog.Warn("Could not find the following excluded object type(s) in the backup set: %s", strings.Join(missingTypes, ", "))
+	}
+}
+
+/*
+ * Returns the object types in the list that do not appear in the backup, along
+ * with the sorted object types that do.  Only pre-data and post-data objects
+ * can be filtered by type, so types such as ROLE are never found.
+ */
+func getFilterObjectTypesInBackupSet(objectTypeList []string) ([]string, []string) {
+	backupTypes := globalTOC.GetObjectTypes("predata", "postdata")
+	backupTypeSet := utils.NewSet(backupTypes)
+	missingTypes := make([]string, 0)
+	for _, objectType := range objectTypeList {
+		if !backupTypeSet.MatchesFilter(objectType) {
+			missingTypes = append(missingTypes, objectType)
+		}
+	}
+	sort.Strings(backupTypes)
+	return missingTypes, backupTypes
+}
+
 /* This only checks the globalTOC, but will still succesfully validate tables
  * in incremental backups since incremental backups will always take backups of
  * the metadata (--incremental and --data-only backup flags are not compatible)
@@ -101,6 +138,25 @@ func GenerateRestoreRelationList() []string {
 	}
 	return relationList
 }
+
+/*
+ * Relation names in the list are fully qualified, and the schema may be quoted,
+ * so everything after the first period outside of quotes is the relation name.
+ */
+func RedirectRelationList(relationList []string, quotedSchema string) []string {
+	fqnPattern := regexp.MustCompile(`^(?:"(?:[^"]|"")*"|[^".]*)\.(.*)$`)
+	redirectedList := make([]string, 0, len(relationList))
+	for _, fqn := range relationList {
+		matches := fqnPattern.FindStringSubmatch(fqn)
+		if matches == nil {
+			redirectedList = append(redirectedList, fqn)
+			continue
+		}
+		redirectedList = append(redirectedList, utils.MakeFQN(quotedSchema, matches[1]))
+	}
+	return redirectedList
+}
+
 func ValidateRelationsInRestoreDatabase(connectionPool *dbconn.DBConn, relationList []string) {
 	if len(relationList) == 0 {
 		return
@@ -215,10 +271,22 @@ END AS string;`, utils.EscapeSingleQuotes(unquotedDBName))
 	}
 }
 
-func ValidateBackupFlagCombinations() {
-	if backupConfig.SingleDataFile && MustGetFlagInt(utils.JOBS) != 1 {
-		gplog.Fatal(errors.Errorf("Cannot use jobs flag when restoring backups with a single data file per segment."), "")
+func VerifyMetadataFileChecksums() {
+	checksumFilename := globalFPInfo.GetChecksumFilePath()
+	if !iohelper.FileExistsAndIsReadable(checksumFilename) {
+		gplog.Warn("Checksum file %s not found; metadata file checksums will not be verified", checksumFilename)
+		return
+	}
+	gplog.Verbose("Verifying checksums of metadata files")
+	metadataFiles := []string{globalFPInfo.GetConfigFilePath(), globalFPInfo.GetMetadataFilePath(), globalFPInfo.GetTOCFilePath()}
+	if MustGetFlagBool(utils.WITH_STATS) {
+		metadataFiles = append(metadataFiles, globalFPInfo.GetStatisticsFilePath())
 	}
+	err := utils.VerifyChecksums(checksumFilename, metadataFiles)
+	gplog.FatalOnError(err)
+}
+
+func ValidateBackupFlagCombinations() {
 	if (backupConfig.IncludeTableFiltered || backupConfig.DataOnly) && MustGetFlagBool(utils.WITH_GLOBALS) {
 		gplog.Fatal(errors.Errorf("Global metadata is not backed up in table-filtered or data-only backups."), "")
 	}
@@ -229,6 +297,65 @@ func ValidateBackupFlagCombinations() {
 		gplog.Fatal(errors.Errorf("Cannot use metadata-only flag when restoring data-only backup"), "")
 	}
 	validateBackupFlagPluginCombinations()
+	validateBackupSegmentCount()
+}
+
+func ValidateBackupEncryptionFlags() {
+	keyProvided := MustGetFlagString(utils.ENCRYPTION_KEY_FILE) != "" || MustGetFlagString(utils.ENCRYPTION_KEY_CMD) != ""
+	if backupConfig.Encrypted && !keyProvided {
+		gplog.Fatal(errors.Errorf("Backup %s is encrypted. The --%s or --%s flag must be used to restore.", backupConfig.Timestamp, utils.ENCRYPTION_KEY_FILE, utils.ENCRYPTION_KEY_CMD), "")
+	} else if !backupConfig.Encrypted && keyProvided {
+		gplog.Fatal(errors.Errorf("The --%s and --%s flags cannot be used to restore a backup taken without encryption.", utils.ENCRYPTION_KEY_FILE, utils.ENCRYPTION_KEY_CMD), "")
+	}
+}
+
+/*
+ * The backup files of backup segments that have no counterpart in this cluster
+ * can only be found if they are in a user-specified backup directory.
+ */
+func validateBackupSegmentCount() {
+	// Only the master is known when writing SQL, and no data is restored
+	if MustGetFlagString(utils.TO_SQL) != "" || !globalFPInfo.HasDifferentSegmentCount() {
+		return
+	}
+	if backupConfig.Plugin != "" {
+		gplog.Fatal(errors.Errorf("Backup was taken on a cluster with %d segments using plugin %s.  Backups taken using a plugin cannot be restored onto a cluster with a different number of segments.", backupConfig.SegmentCount, backupConfig.Plugin), "")
+	}
+	if MustGetFlagString(utils.BACKUP_DIR) == "" {
+		gplog.Fatal(errors.Errorf("Backup was taken on a cluster with %d segments.  The --backup-dir flag must be used to restore onto a cluster with a different number of segments.", backupConfig.SegmentCount), "")
+	}
+	gplog.Info("Backup was taken on a cluster with %d segments; data will be redistributed across %d segments", backupConfig.SegmentCount, len(globalCluster.ContentIDs)-1)
+}
+
+/*
+ * Each segment restores a replicated table from the data file of the backup
+ * segment with the same content ID, so every segment must have one, and the
+ * helper for a single data file backup reads every backup segment mapped to
+ * it, so only multiple data file backups onto fewer segments are supported.
+ */
+func ValidateReplicatedTablesForSegmentCount(dataEntries [][]utils.MasterDataEntry) {
+	if !globalFPInfo.HasDifferentSegmentCount() {
+		return
+	}
+	tableFQNs := make([]string, 0)
+	for _, entries := range dataEntries {
+		for _, entry := range entries {
+			if tableFQN := getRestoreTableFQN(entry); replicatedTables[tableFQN] {
+				tableFQNs = append(tableFQNs, tableFQN)
+			}
+		}
+	}
+	if len(tableFQNs) == 0 {
+		return
+	}
+	if backupConfig.SingleDataFile {
+		gplog.Fatal(errors.Errorf("Backup was taken on a cluster with %d segments using --single-data-file.  Data for the following replicated table(s) cannot be restored onto a cluster with a different number of segments: %s",
+			backupConfig.SegmentCount, strings.Join(tableFQNs, ", ")), "")
+	}
+	if len(globalFPInfo.SegDirMap)-1 > globalFPInfo.SourceSegmentCount {
+		gplog.Fatal(errors.Errorf("Backup was taken on a cluster with %d segments.  Data for the following replicated table(s) cannot be restored onto a cluster with more segments: %s",
+			backupConfig.SegmentCount, strings.Join(tableFQNs, ", ")), "")
+	}
 }
 
 func validateBackupFlagPluginCombinations() {
@@ -243,9 +370,20 @@ func ValidateFlagCombinations(flags *pflag.FlagSet) {
 	utils.CheckExclusiveFlags(flags, utils.DATA_ONLY, utils.WITH_GLOBALS)
 	utils.CheckExclusiveFlags(flags, utils.DATA_ONLY, utils.CREATE_DB)
 	utils.CheckExclusiveFlags(flags, utils.DEBUG, utils.QUIET, utils.VERBOSE)
-	utils.CheckExclusiveFlags(flags, utils.INCLUDE_SCHEMA, utils.INCLUDE_RELATION, utils.INCLUDE_RELATION_FILE)
-	utils.CheckExclusiveFlags(flags, utils.EXCLUDE_SCHEMA, utils.INCLUDE_SCHEMA)
-	utils.CheckExclusiveFlags(flags, utils.EXCLUDE_SCHEMA, utils.EXCLUDE_RELATION, utils.INCLUDE_RELATION, utils.EXCLUDE_RELATION_FILE, utils.INCLUDE_RELATION_FILE)
+	utils.CheckExclusiveFlags(flags, utils.ENCRYPTION_KEY_FILE, utils.ENCRYPTION_KEY_CMD)
+	utils.CheckExclusiveFilterFlags(flags)
+	utils.CheckExclusiveFlags(flags, utils.INCLUDE_OBJECT_TYPE, utils.EXCLUDE_OBJECT_TYPE)
+	utils.CheckExclusiveFlags(flags, utils.INCLUDE_OBJECT_TYPE, utils.DATA_ONLY)
+	utils.CheckExclusiveFlags(flags, utils.EXCLUDE_OBJECT_TYPE, utils.DATA_ONLY)
+	utils.CheckExclusiveFlags(flags, utils.LIST, utils.USE_LIST, utils.TO_SQL)
+	utils.CheckExclusiveFlags(flags, utils.TO_SQL, utils.DATA_ONLY)
+	utils.CheckExclusiveFlags(flags, utils.TO_SQL, utils.RESUME)
 	utils.CheckExclusiveFlags(flags, utils.METADATA_ONLY, utils.DATA_ONLY)
 	utils.CheckExclusiveFlags(flags, utils.PLUGIN_CONFIG, utils.BACKUP_DIR)
+	utils.CheckExclusiveFlags(flags, utils.REDIRECT_SCHEMA, utils.WITH_STATS)
+	if flags.Changed(utils.REDIRECT_SCHEMA) && !flags.Changed(utils.INCLUDE_SCHEMA) && !flags.Changed(utils.INCLUDE_SCHEMA_PATTERN) &&
+		!flags.Changed(utils.INCLUDE_RELATION) && !flags.Changed(utils.INCLUDE_RELATION_FILE) && !flags.Changed(utils.INCLUDE_RELATION_PATTERN) {
+		gplog.Fatal(errors.Errorf("Cannot use --%s without --%s, --%s, --%s, --%s or --%s", utils.REDIRECT_SCHEMA, utils.INCLUDE_SCHEMA, utils.INCLUDE_SCHEMA_PATTERN,
+			utils.INCLUDE_RELATION, utils.INCLUDE_RELATION_FILE, utils.INCLUDE_RELATION_PATTERN), "")
+	}
 }
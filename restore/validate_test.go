@@ -2,6 +2,7 @@ package restore_test
 
 import (
 	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/backup_filepath"
 	"github.com/greenplum-db/gpbackup/backup_history"
 	"github.com/greenplum-db/gpbackup/restore"
 	"github.com/greenplum-db/gpbackup/testutils"
@@ -30,10 +31,10 @@ var _ = Describe("restore/validate tests", func() {
 			toc, backupfile = testutils.InitializeTestTOC(buffer, "predata")
 			backupfile.ByteCount = table1Len
 			toc.AddMetadataEntry("predata", utils.MetadataEntry{Schema: "schema1", Name: "table1", ObjectType: "TABLE"}, 0, backupfile.ByteCount)
-			toc.AddMasterDataEntry("schema1", "table1", 1, "(i)", 0, "")
+			toc.AddMasterDataEntry("schema1", "table1", 1, "(i)", 0, "", 0)
 			backupfile.ByteCount += table2Len
 			toc.AddMetadataEntry("predata", utils.MetadataEntry{Schema: "schema2", Name: "table2", ObjectType: "TABLE"}, table1Len, backupfile.ByteCount)
-			toc.AddMasterDataEntry("schema2", "table2", 2, "(j)", 0, "")
+			toc.AddMasterDataEntry("schema2", "table2", 2, "(j)", 0, "", 0)
 			backupfile.ByteCount += sequenceLen
 			toc.AddMetadataEntry("predata", utils.MetadataEntry{Schema: "schema", Name: "somesequence", ObjectType: "SEQUENCE"}, table1Len+table2Len, backupfile.ByteCount)
 			restore.SetTOC(toc)
@@ -71,10 +72,10 @@ var _ = Describe("restore/validate tests", func() {
 	Describe("GenerateRestoreRelationList", func() {
 		BeforeEach(func() {
 			toc, _ = testutils.InitializeTestTOC(buffer, "metadata")
-			toc.AddMasterDataEntry("s1", "table1", 1, "(j)", 0, "")
-			toc.AddMasterDataEntry("s1", "table2", 2, "(j)", 0, "")
-			toc.AddMasterDataEntry("s2", "table1", 3, "(j)", 0, "")
-			toc.AddMasterDataEntry("s2", "table2", 4, "(j)", 0, "")
+			toc.AddMasterDataEntry("s1", "table1", 1, "(j)", 0, "", 0)
+			toc.AddMasterDataEntry("s1", "table2", 2, "(j)", 0, "", 0)
+			toc.AddMasterDataEntry("s2", "table1", 3, "(j)", 0, "", 0)
+			toc.AddMasterDataEntry("s2", "table2", 4, "(j)", 0, "", 0)
 			restore.SetTOC(toc)
 			cmdFlags.Set(utils.INCLUDE_RELATION, "")
 			cmdFlags.Set(utils.EXCLUDE_RELATION, "")
@@ -130,6 +131,33 @@ var _ = Describe("restore/validate tests", func() {
 			Expect(resultRelations).To(ConsistOf(expectedRelations))
 		})
 	})
+	Describe("RedirectRelationList", func() {
+		It("replaces the schema of each relation with the redirect schema", func() {
+			relationList := restore.RedirectRelationList([]string{"prod.orders", `"Prod.Schema"."Orders.Table"`, `"Prod""Schema".items`}, "restore_check")
+			Expect(relationList).To(Equal([]string{"restore_check.orders", `restore_check."Orders.Table"`, "restore_check.items"}))
+		})
+	})
+	Describe("ValidateObjectTypesInBackupSet", func() {
+		BeforeEach(func() {
+			toc, _ = testutils.InitializeTestTOC(buffer, "predata")
+			toc.AddMetadataEntry("predata", utils.MetadataEntry{Schema: "schema1", Name: "func1", ObjectType: "FUNCTION"}, 0, 0)
+			toc.AddMetadataEntry("predata", utils.MetadataEntry{Schema: "schema1", Name: "view1", ObjectType: "VIEW"}, 0, 0)
+			toc.AddMetadataEntry("postdata", utils.MetadataEntry{Schema: "schema1", Name: "trigger1", ObjectType: "TRIGGER", ReferenceObject: "schema1.table1"}, 0, 0)
+			toc.AddMetadataEntry("global", utils.MetadataEntry{Name: "role1", ObjectType: "ROLE"}, 0, 0)
+			restore.SetTOC(toc)
+		})
+		It("passes when pre-data and post-data object types exist in the backup", func() {
+			restore.ValidateIncludeObjectTypesInBackupSet([]string{"FUNCTION", "TRIGGER"})
+		})
+		It("panics when an included object type does not exist in the backup", func() {
+			defer testhelper.ShouldPanicWithMessage("Could not find the following object type(s) in the backup set: RULE, ROLE.  Object types in the backup set are: FUNCTION, TRIGGER, VIEW")
+			restore.ValidateIncludeObjectTypesInBackupSet([]string{"RULE", "VIEW", "ROLE"})
+		})
+		It("warns when an excluded object type does not exist in the backup", func() {
+			restore.ValidateExcludeObjectTypesInBackupSet([]string{"RULE", "TRIGGER"})
+			testhelper.ExpectRegexp(logfile, "Could not find the following excluded object type(s) in the backup set: RULE")
+		})
+	})
 	Describe("ValidateRelationsInRestoreDatabase", func() {
 		BeforeEach(func() {
 			restore.SetBackupConfig(&backup_history.BackupConfig{DataOnly: false})
@@ -193,10 +221,10 @@ var _ = Describe("restore/validate tests", func() {
 		BeforeEach(func() {
 			toc, backupfile = testutils.InitializeTestTOC(buffer, "predata")
 			toc.AddMetadataEntry("predata", utils.MetadataEntry{Schema: "schema1", Name: "table1", ObjectType: "TABLE"}, 0, backupfile.ByteCount)
-			toc.AddMasterDataEntry("schema1", "table1", 1, "(i)", 0, "")
+			toc.AddMasterDataEntry("schema1", "table1", 1, "(i)", 0, "", 0)
 
 			toc.AddMetadataEntry("predata", utils.MetadataEntry{Schema: "schema2", Name: "table2", ObjectType: "TABLE"}, 0, backupfile.ByteCount)
-			toc.AddMasterDataEntry("schema2", "table2", 2, "(j)", 0, "")
+			toc.AddMasterDataEntry("schema2", "table2", 2, "(j)", 0, "", 0)
 
 			toc.AddMetadataEntry("predata", utils.MetadataEntry{Schema: "schema1", Name: "somesequence", ObjectType: "SEQUENCE"}, 0, backupfile.ByteCount)
 			toc.AddMetadataEntry("predata", utils.MetadataEntry{Schema: "schema1", Name: "someview", ObjectType: "VIEW"}, 0, backupfile.ByteCount)
@@ -289,4 +317,41 @@ var _ = Describe("restore/validate tests", func() {
 			restore.ValidateDatabaseExistence("testdb", false, false)
 		})
 	})
+	Describe("ValidateReplicatedTablesForSegmentCount", func() {
+		dataEntries := [][]utils.MasterDataEntry{{{Schema: "public", Name: "foo"}, {Schema: "public", Name: "bar"}}}
+		setSourceSegmentCount := func(sourceSegmentCount int) {
+			fpInfo := backup_filepath.NewFilePathInfo(testutils.SetDefaultSegmentConfiguration(), "/backup_dir", "20170101010101", "gpseg")
+			fpInfo.SourceSegmentCount = sourceSegmentCount
+			restore.SetFPInfo(fpInfo)
+			restore.SetBackupConfig(&backup_history.BackupConfig{SegmentCount: sourceSegmentCount})
+		}
+		BeforeEach(func() {
+			restore.SetRedirectSchema("")
+			restore.SetReplicatedTables(map[string]bool{"public.bar": true})
+		})
+		AfterEach(func() {
+			restore.SetFPInfo(backup_filepath.FilePathInfo{})
+			restore.SetReplicatedTables(nil)
+		})
+		It("passes when restoring replicated tables onto fewer segments", func() {
+			setSourceSegmentCount(5)
+			restore.ValidateReplicatedTablesForSegmentCount(dataEntries)
+		})
+		It("passes when restoring no replicated tables onto more segments", func() {
+			setSourceSegmentCount(1)
+			restore.SetReplicatedTables(map[string]bool{})
+			restore.ValidateReplicatedTablesForSegmentCount(dataEntries)
+		})
+		It("panics when restoring replicated tables onto more segments", func() {
+			setSourceSegmentCount(1)
+			defer testhelper.ShouldPanicWithMessage("Backup was taken on a cluster with 1 segments.  Data for the following replicated table(s) cannot be restored onto a cluster with more segments: public.bar")
+			restore.ValidateReplicatedTablesForSegmentCount(dataEntries)
+		})
+		It("panics when restoring replicated tables from a single data file backup", func() {
+			setSourceSegmentCount(5)
+			restore.SetBackupConfig(&backup_history.BackupConfig{SegmentCount: 5, SingleDataFile: true})
+			defer testhelper.ShouldPanicWithMessage("Backup was taken on a cluster with 5 segments using --single-data-file.  Data for the following replicated table(s) cannot be restored onto a cluster with a different number of segments: public.bar")
+			restore.ValidateReplicatedTablesForSegmentCount(dataEntries)
+		})
+	})
 })
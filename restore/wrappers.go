@@ -4,12 +4,15 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
 	"github.com/greenplum-db/gp-common-go-libs/iohelper"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
 	"github.com/greenplum-db/gpbackup/backup_filepath"
 	"github.com/greenplum-db/gpbackup/backup_history"
 	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
 )
 
 /*
@@ -62,6 +65,10 @@ SET default_with_oids = off;
 		setupQuery += "SET default_transaction_read_only = off;\n"
 	}
 	setupQuery += SetMaxCsvLineLengthQuery(connectionPool)
+	// Rows loaded onto the wrong segment are redistributed once each table is loaded
+	if globalFPInfo.HasDifferentSegmentCount() && connectionPool.Version.AtLeast("5") {
+		setupQuery += "SET gp_enable_segment_copy_checking = off;\n"
+	}
 
 	for i := 0; i < connectionPool.NumConns; i++ {
 		connectionPool.MustExec(setupQuery, i)
@@ -87,9 +94,27 @@ func SetMaxCsvLineLengthQuery(connectionPool *dbconn.DBConn) string {
 
 func InitializeBackupConfig() {
 	backupConfig = backup_history.ReadConfigFile(globalFPInfo.GetConfigFilePath())
-	utils.InitializePipeThroughParameters(backupConfig.Compressed, 0)
+	utils.InitializePipeThroughParameters(backupConfig.Compressed, backupConfig.GetCompressionType(), 0)
 	utils.EnsureBackupVersionCompatibility(backupConfig.BackupVersion, version)
-	utils.EnsureDatabaseVersionCompatibility(backupConfig.DatabaseVersion, connectionPool.Version)
+	// There is no connection when writing SQL, so the database version is not known
+	if connectionPool != nil {
+		utils.EnsureDatabaseVersionCompatibility(backupConfig.DatabaseVersion, connectionPool.Version)
+	}
+}
+
+/*
+ * Writing SQL does not connect to a database, so the segment configuration
+ * cannot be queried.  Only the master's backup directory is read, and it is
+ * found from --backup-dir or else from the master data directory.
+ */
+func GetMasterOnlyCluster() *cluster.Cluster {
+	masterDataDir := operating.System.Getenv("MASTER_DATA_DIRECTORY")
+	if MustGetFlagString(utils.BACKUP_DIR) == "" && masterDataDir == "" {
+		gplog.Fatal(errors.Errorf("The backup directory cannot be found without connecting to the database.  The --%s flag or the MASTER_DATA_DIRECTORY environment variable must be set to use --%s.", utils.BACKUP_DIR, utils.TO_SQL), "")
+	}
+	hostname, err := operating.System.Hostname()
+	gplog.FatalOnError(err)
+	return cluster.NewCluster([]cluster.SegConfig{{DbID: 1, ContentID: -1, Hostname: hostname, DataDir: masterDataDir}})
 }
 
 func InitializeFilterLists() {
@@ -105,6 +130,75 @@ func InitializeFilterLists() {
 	}
 }
 
+/*
+ * The names that the schema and table pattern flags may match are those that
+ * the schema and table filter flags are validated against.
+ */
+func getSchemasInBackupSet() []string {
+	schemas := make([]string, 0)
+	seen := make(map[string]bool)
+	addSchema := func(schema string) {
+		if schema != "" && !seen[schema] {
+			seen[schema] = true
+			schemas = append(schemas, schema)
+		}
+	}
+	if !backupConfig.DataOnly {
+		for _, entry := range globalTOC.PredataEntries {
+			addSchema(entry.Schema)
+		}
+	} else {
+		for _, entry := range globalTOC.DataEntries {
+			addSchema(entry.Schema)
+		}
+	}
+	return schemas
+}
+
+func getRelationsInBackupSet() []string {
+	relations := make([]string, 0)
+	seen := make(map[string]bool)
+	addRelation := func(fqn string) {
+		if !seen[fqn] {
+			seen[fqn] = true
+			relations = append(relations, fqn)
+		}
+	}
+	for _, entry := range globalTOC.PredataEntries {
+		if entry.ObjectType == "TABLE" || entry.ObjectType == "SEQUENCE" || entry.ObjectType == "VIEW" {
+			addRelation(utils.MakeFQN(entry.Schema, entry.Name))
+		}
+	}
+	for _, restorePlanEntry := range backupConfig.RestorePlan {
+		for _, fqn := range restorePlanEntry.TableFQNs {
+			addRelation(fqn)
+		}
+	}
+	return relations
+}
+
+/*
+ * The patterns are resolved against the backup's table of contents into the
+ * lists of names used by the exact-name filter flags, which the rest of the
+ * restore then uses as if each name had been given individually.
+ */
+func ResolveFilterPatterns() {
+	if len(MustGetFlagStringArray(utils.INCLUDE_SCHEMA_PATTERN)) > 0 || len(MustGetFlagStringArray(utils.EXCLUDE_SCHEMA_PATTERN)) > 0 {
+		schemas := getSchemasInBackupSet()
+		includeSchemas := utils.ResolvePatternFlag(cmdFlags, utils.INCLUDE_SCHEMA_PATTERN, schemas, "schema", false)
+		gplog.FatalOnError(utils.AppendToFlag(cmdFlags, utils.INCLUDE_SCHEMA, includeSchemas))
+		excludeSchemas := utils.ResolvePatternFlag(cmdFlags, utils.EXCLUDE_SCHEMA_PATTERN, schemas, "schema", true)
+		gplog.FatalOnError(utils.AppendToFlag(cmdFlags, utils.EXCLUDE_SCHEMA, excludeSchemas))
+	}
+	if len(MustGetFlagStringArray(utils.INCLUDE_RELATION_PATTERN)) > 0 || len(MustGetFlagStringArray(utils.EXCLUDE_RELATION_PATTERN)) > 0 {
+		relations := getRelationsInBackupSet()
+		includeRelations := utils.ResolvePatternFlag(cmdFlags, utils.INCLUDE_RELATION_PATTERN, relations, "relation", false)
+		gplog.FatalOnError(utils.AppendToFlag(cmdFlags, utils.INCLUDE_RELATION, includeRelations))
+		excludeRelations := utils.ResolvePatternFlag(cmdFlags, utils.EXCLUDE_RELATION_PATTERN, relations, "relation", true)
+		gplog.FatalOnError(utils.AppendToFlag(cmdFlags, utils.EXCLUDE_RELATION, excludeRelations))
+	}
+}
+
 func BackupConfigurationValidation() {
 	InitializeFilterLists()
 
@@ -124,6 +218,7 @@ func BackupConfigurationValidation() {
 
 	ValidateBackupFlagCombinations()
 
+	ResolveFilterPatterns()
 	validateFilterListsInBackupSet()
 }
 
@@ -176,6 +271,9 @@ func RecoverMetadataFilesUsingPlugin() {
 			pluginConfig.RestoreSegmentTOCs(globalCluster, fpInfo)
 		}
 	}
+	if backupConfig.Checksummed {
+		pluginConfig.MustRestoreFile(globalFPInfo.GetChecksumFilePath())
+	}
 }
 
 func FindHistoricalPluginVersion(timestamp string) string {
@@ -200,8 +298,29 @@ func FindHistoricalPluginVersion(timestamp string) string {
  * Metadata and/or data restore wrapper functions
  */
 
+// Object types are upper-cased to match the types recorded in the TOC
+func getObjectTypeFilters() ([]string, []string) {
+	includeObjectTypes := make([]string, 0)
+	for _, objectType := range MustGetFlagStringSlice(utils.INCLUDE_OBJECT_TYPE) {
+		includeObjectTypes = append(includeObjectTypes, strings.ToUpper(objectType))
+	}
+	excludeObjectTypes := make([]string, 0)
+	for _, objectType := range MustGetFlagStringSlice(utils.EXCLUDE_OBJECT_TYPE) {
+		excludeObjectTypes = append(excludeObjectTypes, strings.ToUpper(objectType))
+	}
+	return includeObjectTypes, excludeObjectTypes
+}
+
+func objectTypeIsRestored(objectType string) bool {
+	includeObjectTypes, excludeObjectTypes := getObjectTypeFilters()
+	if len(includeObjectTypes) > 0 {
+		return utils.NewIncludeSet(includeObjectTypes).MatchesFilter(objectType)
+	}
+	return utils.NewExcludeSet(excludeObjectTypes).MatchesFilter(objectType)
+}
+
 func GetRestoreMetadataStatements(section string, filename string, includeObjectTypes []string, excludeObjectTypes []string, filterSchemas bool, filterRelations bool) []utils.StatementWithType {
-	metadataFile := iohelper.MustOpenFileForReading(filename)
+	metadataFile := utils.MustOpenMetadataFileForReading(filename)
 	var statements []utils.StatementWithType
 	var inSchemas, exSchemas, inRelations, exRelations []string
 	if len(includeObjectTypes) > 0 || len(excludeObjectTypes) > 0 || filterSchemas || filterRelations {
@@ -238,6 +357,7 @@ func GetBackupFPInfoListFromRestorePlan() []backup_filepath.FilePathInfo {
 		segPrefix := backup_filepath.ParseSegPrefix(MustGetFlagString(utils.BACKUP_DIR), entry.Timestamp)
 
 		fpInfo := backup_filepath.NewFilePathInfo(globalCluster, MustGetFlagString(utils.BACKUP_DIR), entry.Timestamp, segPrefix)
+		fpInfo.SourceSegmentCount = backupConfig.SegmentCount
 		fpInfoList = append(fpInfoList, fpInfo)
 	}
 
@@ -262,9 +382,14 @@ func RestoreSchemas(schemaStatements []utils.StatementWithType, progressBar util
 	numErrors := 0
 	for _, schema := range schemaStatements {
 		_, err := connectionPool.Exec(schema.Statement, 0)
-		if err != nil {
+		if err == nil {
+			err = restoreState.MarkStatementExecuted(schema)
+			gplog.FatalOnError(err)
+		} else {
 			if strings.Contains(err.Error(), "already exists") {
 				gplog.Warn("Schema %s already exists", schema.Name)
+				err = restoreState.MarkStatementExecuted(schema)
+				gplog.FatalOnError(err)
 			} else {
 				errMsg := fmt.Sprintf("Error encountered while creating schema %s", schema.Name)
 				if MustGetFlagBool(utils.ON_ERROR_CONTINUE) {
@@ -279,5 +404,36 @@ func RestoreSchemas(schemaStatements []utils.StatementWithType, progressBar util
 	}
 	if numErrors > 0 {
 		gplog.Error("Encountered %d errors during schema restore; see log file %s for a list of errors.", numErrors, gplog.GetLogFilePath())
+		utils.AddNonFatalErrors(int32(numErrors))
+	}
+}
+
+// Listing the backup or writing SQL restores nothing, so only a restore has a status to record
+func writeStatusMetrics() {
+	if metricsWritten || !restoresToDatabase() {
+		return
+	}
+	metricsWritten = true
+	exitCode := gplog.GetErrorCode()
+	if wasTerminated {
+		exitCode = 2
+	}
+	utils.WriteStatusMetrics("gprestore", getUnquotedRestoreDatabase(), exitCode, MustGetFlagString(utils.METRICS_FILE), MustGetFlagString(utils.METRICS_URL))
+}
+
+// Nothing is run unless the pre-restore hook was started
+func RunPostHook() {
+	if !preHookRan {
+		return
+	}
+	preHookRan = false
+	status := utils.GetExitStatus()
+	if wasTerminated {
+		status = "failure"
+	}
+	hookName := utils.POST_RESTORE_HOOK
+	if status == "failure" {
+		hookName = utils.ON_FAILURE_HOOK
 	}
+	hookConfig.RunHook(globalCluster, hookName, globalFPInfo, getUnquotedRestoreDatabase(), status)
 }
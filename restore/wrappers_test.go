@@ -8,7 +8,10 @@ import (
 	"github.com/greenplum-db/gpbackup/testutils"
 
 	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
 	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/backup_filepath"
 	"github.com/greenplum-db/gpbackup/backup_history"
 	"github.com/greenplum-db/gpbackup/restore"
 	"github.com/greenplum-db/gpbackup/utils"
@@ -19,6 +22,46 @@ import (
 )
 
 var _ = Describe("wrapper tests", func() {
+	Describe("RunPostHook", func() {
+		var testExecutor *testhelper.TestExecutor
+		BeforeEach(func() {
+			testCluster := testutils.SetDefaultSegmentConfiguration()
+			testExecutor = &testhelper.TestExecutor{ClusterOutput: &cluster.RemoteOutput{}}
+			testCluster.Executor = testExecutor
+			restore.SetCluster(testCluster)
+			restore.SetFPInfo(backup_filepath.NewFilePathInfo(testCluster, "", "20170101010101", "gpseg"))
+			restore.SetBackupConfig(&backup_history.BackupConfig{DatabaseName: "testdb"})
+			restore.SetHookConfig(utils.HookConfig{
+				utils.POST_RESTORE_HOOK: {ExecutablePath: "/home/gpadmin/analyze.sh"},
+				utils.ON_FAILURE_HOOK:   {ExecutablePath: "/home/gpadmin/alert.sh"},
+			})
+			restore.SetPreHookRan(true)
+			gplog.SetErrorCode(0)
+		})
+		AfterEach(func() {
+			restore.SetHookConfig(nil)
+			restore.SetPreHookRan(false)
+			restore.SetWasTerminated(false)
+		})
+		It("runs the post-restore hook with the status of the restore", func() {
+			restore.RunPostHook()
+			Expect(testExecutor.LocalCommands).To(HaveLen(1))
+			Expect(testExecutor.LocalCommands[0]).To(ContainSubstring("GPBACKUP_DBNAME='testdb' GPBACKUP_HOOK='post-restore' GPBACKUP_SCOPE='master' GPBACKUP_STATUS='success'"))
+		})
+		It("runs the on-failure hook once when the restore is canceled", func() {
+			restore.SetWasTerminated(true)
+			restore.RunPostHook()
+			restore.RunPostHook()
+			Expect(testExecutor.LocalCommands).To(HaveLen(1))
+			Expect(testExecutor.LocalCommands[0]).To(ContainSubstring("GPBACKUP_HOOK='on-failure' GPBACKUP_SCOPE='master' GPBACKUP_STATUS='failure'"))
+			Expect(testExecutor.LocalCommands[0]).To(HaveSuffix("/home/gpadmin/alert.sh"))
+		})
+		It("runs no hook if the pre-restore hook was not started", func() {
+			restore.SetPreHookRan(false)
+			restore.RunPostHook()
+			Expect(testExecutor.NumExecutions).To(Equal(0))
+		})
+	})
 	Describe("SetMaxCsvLineLengthQuery", func() {
 		It("returns nothing with a connection version of at least 6.0.0", func() {
 			testhelper.SetDBVersion(connectionPool, "6.0.0")
@@ -46,6 +89,38 @@ var _ = Describe("wrapper tests", func() {
 			Expect(result).To(Equal("SET gp_max_csv_line_length = 4194304;\n"))
 		})
 	})
+	Describe("GetMasterOnlyCluster", func() {
+		BeforeEach(func() {
+			operating.System.Hostname = func() (string, error) { return "mdw", nil }
+			operating.System.Getenv = func(key string) string {
+				if key == "MASTER_DATA_DIRECTORY" {
+					return "/data/gpseg-1"
+				}
+				return ""
+			}
+		})
+		AfterEach(func() {
+			operating.System = operating.InitializeSystemFunctions()
+			cmdFlags.Set(utils.BACKUP_DIR, "")
+		})
+		It("returns a cluster with only the master, using the master data directory", func() {
+			testCluster := restore.GetMasterOnlyCluster()
+			Expect(testCluster.ContentIDs).To(Equal([]int{-1}))
+			Expect(testCluster.GetHostForContent(-1)).To(Equal("mdw"))
+			Expect(testCluster.GetDirForContent(-1)).To(Equal("/data/gpseg-1"))
+		})
+		It("returns a cluster with only the master when a backup directory is given and the master data directory is not set", func() {
+			operating.System.Getenv = func(key string) string { return "" }
+			cmdFlags.Set(utils.BACKUP_DIR, "/backup_dir")
+			testCluster := restore.GetMasterOnlyCluster()
+			Expect(testCluster.ContentIDs).To(Equal([]int{-1}))
+		})
+		It("panics if neither a backup directory nor the master data directory is set", func() {
+			operating.System.Getenv = func(key string) string { return "" }
+			defer testhelper.ShouldPanicWithMessage("The backup directory cannot be found without connecting to the database.  The --backup-dir flag or the MASTER_DATA_DIRECTORY environment variable must be set to use --to-sql.")
+			restore.GetMasterOnlyCluster()
+		})
+	})
 	Describe("RestoreSchemas", func() {
 		var (
 			ignoredProgressBar utils.ProgressBar
@@ -124,6 +199,39 @@ var _ = Describe("wrapper tests", func() {
 		})
 
 	})
+	Describe("ResolveFilterPatterns", func() {
+		BeforeEach(func() {
+			toc, _ := testutils.InitializeTestTOC(buffer, "metadata")
+			toc.AddMetadataEntry("predata", utils.MetadataEntry{Schema: "public", Name: "public", ObjectType: "SCHEMA"}, 0, 0)
+			toc.AddMetadataEntry("predata", utils.MetadataEntry{Schema: "staging", Name: "staging", ObjectType: "SCHEMA"}, 0, 0)
+			toc.AddMetadataEntry("predata", utils.MetadataEntry{Schema: "public", Name: "sales", ObjectType: "TABLE"}, 0, 0)
+			toc.AddMetadataEntry("predata", utils.MetadataEntry{Schema: "public", Name: "sales_seq", ObjectType: "SEQUENCE"}, 0, 0)
+			toc.AddMetadataEntry("predata", utils.MetadataEntry{Schema: "staging", Name: "load_1", ObjectType: "TABLE"}, 0, 0)
+			restore.SetTOC(toc)
+			restore.SetBackupConfig(&backup_history.BackupConfig{
+				RestorePlan: []backup_history.RestorePlanEntry{{Timestamp: "ts0", TableFQNs: []string{"public.sales", "staging.load_1"}}},
+			})
+		})
+		It("adds the relations matching the include pattern to the included relations", func() {
+			_ = cmdFlags.Set(utils.INCLUDE_RELATION, "public.sales")
+			_ = cmdFlags.Set(utils.INCLUDE_RELATION_PATTERN, "*.sales*")
+			restore.ResolveFilterPatterns()
+			includeRelations, _ := cmdFlags.GetStringSlice(utils.INCLUDE_RELATION)
+			Expect(includeRelations).To(Equal([]string{"public.sales", "public.sales_seq"}))
+		})
+		It("adds the schemas matching the exclude regular expression to the excluded schemas", func() {
+			_ = cmdFlags.Set(utils.PATTERN_TYPE, utils.REGEX_PATTERN)
+			_ = cmdFlags.Set(utils.EXCLUDE_SCHEMA_PATTERN, "stag.*")
+			restore.ResolveFilterPatterns()
+			excludeSchemas, _ := cmdFlags.GetStringSlice(utils.EXCLUDE_SCHEMA)
+			Expect(excludeSchemas).To(Equal([]string{"staging"}))
+		})
+		It("panics if an include pattern matches no relation in the backup set", func() {
+			_ = cmdFlags.Set(utils.INCLUDE_RELATION_PATTERN, "public.tmp_*")
+			defer testhelper.ShouldPanicWithMessage("No relations match the following pattern(s): public.tmp_*")
+			restore.ResolveFilterPatterns()
+		})
+	})
 	Describe("restore history tests", func() {
 		sampleConfigContents := `
 executablepath: /bin/echo
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"encoding/hex"
 	"fmt"
 	"io"
 	"path/filepath"
@@ -58,6 +59,41 @@ func WriteOidListToSegments(oidList []string, c *cluster.Cluster, fpInfo backup_
 	c.CheckClusterError(remoteOutput, errMsg, errFunc, false)
 }
 
+/*
+ * Segments read the key from a file in the segment data directory, which is
+ * only readable by the current user and is removed during cleanup.
+ */
+func CopyEncryptionKeyToSegments(c *cluster.Cluster, fpInfo backup_filepath.FilePathInfo) {
+	localKeyFile, err := operating.System.TempFile("", "gpbackup-key")
+	gplog.FatalOnError(err, "Cannot open temporary file to write encryption key")
+	defer func() {
+		err = operating.System.Remove(localKeyFile.Name())
+		if err != nil {
+			gplog.Warn("Cannot remove temporary encryption key file: %s, Err: %s", localKeyFile.Name(), err.Error())
+		}
+	}()
+	_, err = localKeyFile.Write([]byte(hex.EncodeToString(GetEncryptionKey())))
+	gplog.FatalOnError(err, localKeyFile.Name())
+	err = localKeyFile.Close()
+	gplog.FatalOnError(err, localKeyFile.Name())
+
+	remoteOutput := c.GenerateAndExecuteCommand("Copying encryption key to segments", func(contentID int) string {
+		return fmt.Sprintf(`scp -p %s %s:%s`, localKeyFile.Name(), c.GetHostForContent(contentID), fpInfo.GetSegmentEncryptionKeyFilePath(contentID))
+	}, cluster.ON_MASTER_TO_SEGMENTS)
+	c.CheckClusterError(remoteOutput, "Unable to copy encryption key to segments", func(contentID int) string {
+		return fmt.Sprintf("Unable to copy encryption key to segment %d on host %s", contentID, c.GetHostForContent(contentID))
+	})
+}
+
+func RemoveEncryptionKeyFromSegments(c *cluster.Cluster, fpInfo backup_filepath.FilePathInfo) {
+	remoteOutput := c.GenerateAndExecuteCommand("Removing encryption key from segments", func(contentID int) string {
+		return fmt.Sprintf("rm -f %s", fpInfo.GetSegmentEncryptionKeyFilePath(contentID))
+	}, cluster.ON_SEGMENTS)
+	c.CheckClusterError(remoteOutput, "Unable to remove encryption key from segments", func(contentID int) string {
+		return fmt.Sprintf("Unable to remove encryption key file %s on segment %d on host %s; remove it manually", fpInfo.GetSegmentEncryptionKeyFilePath(contentID), contentID, c.GetHostForContent(contentID))
+	}, true)
+}
+
 func WriteOidsToFile(filename string, oidList []string) {
 	oidFp, err := iohelper.OpenFileForWriting(filename)
 	gplog.FatalOnError(err, filename)
@@ -105,20 +141,28 @@ func VerifyHelperVersionOnSegments(version string, c *cluster.Cluster) {
 	}
 }
 
-func StartAgent(c *cluster.Cluster, fpInfo backup_filepath.FilePathInfo, operation string, pluginConfigFile string, compressStr string) {
+// The numAgents agents started on each segment, one for each shard, share the maximum bandwidth
+func StartAgent(c *cluster.Cluster, fpInfo backup_filepath.FilePathInfo, operation string, pluginConfigFile string, compressStr string, numAgents int) {
 	remoteOutput := c.GenerateAndExecuteCommand("Starting gpbackup_helper agent", func(contentID int) string {
-		tocFile := fpInfo.GetSegmentTOCFilePath(contentID)
+		tocFile, backupFile := getAgentSourceFiles(fpInfo, contentID)
 		oidFile := fpInfo.GetSegmentHelperFilePath(contentID, "oid")
 		scriptFile := fpInfo.GetSegmentHelperFilePath(contentID, "script")
 		pipeFile := fpInfo.GetSegmentPipeFilePath(contentID)
-		backupFile := fpInfo.GetTableBackupFilePath(contentID, 0, GetPipeThroughProgram().Extension, true)
 		gphomePath := operating.System.Getenv("GPHOME")
 		pluginStr := ""
 		if pluginConfigFile != "" {
 			_, configFilename := filepath.Split(pluginConfigFile)
 			pluginStr = fmt.Sprintf(" --plugin-config /tmp/%s", configFilename)
 		}
-		helperCmdStr := fmt.Sprintf("gpbackup_helper %s --toc-file %s --oid-file %s --pipe-file %s --data-file %s --content %d%s%s", operation, tocFile, oidFile, pipeFile, backupFile, contentID, pluginStr, compressStr)
+		encryptionStr := ""
+		if GetEncryptionKey() != nil {
+			encryptionStr = fmt.Sprintf(" --encryption-key-file %s", fpInfo.GetSegmentEncryptionKeyFilePath(contentID))
+		}
+		bandwidthStr := ""
+		if GetMaxBandwidth() > 0 {
+			bandwidthStr = fmt.Sprintf(" --max-bandwidth %d", GetMaxBandwidthShare(numAgents))
+		}
+		helperCmdStr := fmt.Sprintf("gpbackup_helper %s --toc-file %s --oid-file %s --pipe-file %s --data-file %s --content %d%s%s%s%s", operation, tocFile, oidFile, pipeFile, backupFile, contentID, pluginStr, compressStr, encryptionStr, bandwidthStr)
 
 		return fmt.Sprintf(`cat << HEREDOC > %s
 #!/bin/bash
@@ -134,12 +178,45 @@ chmod +x %s; (nohup %s > /dev/null 2>&1 &) &`, scriptFile, gphomePath, gphomePat
 	})
 }
 
+/*
+ * A restore onto a cluster with a different number of segments may read the
+ * files of several backup segments on one segment, which are passed to the
+ * helper as comma-separated lists, or of none, which are passed as empty
+ * strings.
+ */
+func getAgentSourceFiles(fpInfo backup_filepath.FilePathInfo, contentID int) (string, string) {
+	tocFiles := make([]string, 0)
+	backupFiles := make([]string, 0)
+	for _, sourceContentID := range fpInfo.GetSourceContentIDs(contentID) {
+		tocFiles = append(tocFiles, fpInfo.GetSegmentTOCFilePath(sourceContentID))
+		backupFiles = append(backupFiles, fpInfo.GetTableBackupFilePath(sourceContentID, 0, GetPipeThroughProgram().Extension, true))
+	}
+	if len(tocFiles) == 0 {
+		return "''", "''"
+	}
+	return strings.Join(tocFiles, ","), strings.Join(backupFiles, ",")
+}
+
+/*
+ * The paths for shards other than shard 0 are the shard 0 paths with a shard
+ * suffix, so these globs match the files for every shard of a backup.
+ */
+func getAllShardsErrorFiles(fpInfo backup_filepath.FilePathInfo, contentID int) string {
+	pipeFile := fpInfo.GetSegmentPipeFilePath(contentID)
+	return fmt.Sprintf("%s_error %s_shard*_error", pipeFile, pipeFile)
+}
+
+func getAllShardsHelperFiles(fpInfo backup_filepath.FilePathInfo, contentID int, suffix string) string {
+	helperFile := fpInfo.GetSegmentHelperFilePath(contentID, suffix)
+	return fmt.Sprintf("%s %s_shard*", helperFile, helperFile)
+}
+
 func CleanUpHelperFilesOnAllHosts(c *cluster.Cluster, fpInfo backup_filepath.FilePathInfo) {
 	remoteOutput := c.GenerateAndExecuteCommand("Removing oid list and helper script files from segment data directories", func(contentID int) string {
-		errorFile := fmt.Sprintf("%s_error", fpInfo.GetSegmentPipeFilePath(contentID))
-		oidFile := fpInfo.GetSegmentHelperFilePath(contentID, "oid")
-		scriptFile := fpInfo.GetSegmentHelperFilePath(contentID, "script")
-		return fmt.Sprintf("rm -f %s && rm -f %s && rm -f %s", errorFile, oidFile, scriptFile)
+		errorFiles := getAllShardsErrorFiles(fpInfo, contentID)
+		oidFiles := getAllShardsHelperFiles(fpInfo, contentID, "oid")
+		scriptFiles := getAllShardsHelperFiles(fpInfo, contentID, "script")
+		return fmt.Sprintf("rm -f %s && rm -f %s && rm -f %s", errorFiles, oidFiles, scriptFiles)
 	}, cluster.ON_SEGMENTS)
 	errMsg := fmt.Sprintf("Unable to remove segment helper file(s). See %s for a complete list of segments with errors and remove manually.",
 		gplog.GetLogFilePath())
@@ -151,8 +228,9 @@ func CleanUpHelperFilesOnAllHosts(c *cluster.Cluster, fpInfo backup_filepath.Fil
 
 func CleanUpSegmentHelperProcesses(c *cluster.Cluster, fpInfo backup_filepath.FilePathInfo, operation string) {
 	remoteOutput := c.GenerateAndExecuteCommand("Cleaning up segment agent processes", func(contentID int) string {
-		tocFile := fpInfo.GetSegmentTOCFilePath(contentID)
-		procPattern := fmt.Sprintf("gpbackup_helper --%s-agent --toc-file %s", operation, tocFile)
+		// The segment TOC path without its suffix matches the agents for every shard
+		tocFilePrefix := strings.TrimSuffix(fpInfo.GetSegmentTOCFilePath(contentID), "_toc.yaml")
+		procPattern := fmt.Sprintf("gpbackup_helper --%s-agent --toc-file %s", operation, tocFilePrefix)
 		/*
 		 * We try to avoid erroring out if no gpbackup_helper processes are found,
 		 * as it's possible that all gpbackup_helper processes have finished by
@@ -165,20 +243,45 @@ func CleanUpSegmentHelperProcesses(c *cluster.Cluster, fpInfo backup_filepath.Fi
 	})
 }
 
+/*
+ * Each backup agent writes its segment TOC file once it has written all of
+ * its data, or an error file if it fails, so this returns once every agent
+ * of the shard has finished.  An agent that is killed writes neither file, so
+ * the wait also ends once no agent process for the shard is running and
+ * neither file was written before it exited.
+ */
+func WaitForSegmentTOCs(c *cluster.Cluster, fpInfo backup_filepath.FilePathInfo) {
+	remoteOutput := c.GenerateAndExecuteCommand("Waiting for segment agents to finish writing data", func(contentID int) string {
+		tocFile := fpInfo.GetSegmentTOCFilePath(contentID)
+		errorFile := fmt.Sprintf("%s_error", fpInfo.GetSegmentPipeFilePath(contentID))
+		procPattern := fmt.Sprintf("gpbackup_helper --backup-agent --toc-file %s ", tocFile)
+		return fmt.Sprintf(`while [[ ! -f "%[1]s" && ! -f "%[2]s" ]]; do if ! ps ux | grep "%[3]s" | grep -v grep > /dev/null && [[ ! -f "%[1]s" && ! -f "%[2]s" ]]; then echo "gpbackup_helper exited without writing %[1]s" >&2; exit 1; fi; sleep 1; done; ls "%[1]s"`, tocFile, errorFile, procPattern)
+	}, cluster.ON_SEGMENTS)
+	c.CheckClusterError(remoteOutput, "Error occurred in gpbackup_helper", func(contentID int) string {
+		return "See gpAdminLog for gpbackup_helper on segment host for details"
+	})
+}
+
 func CheckAgentErrorsOnSegments(c *cluster.Cluster, fpInfo backup_filepath.FilePathInfo) error {
 	remoteOutput := c.GenerateAndExecuteCommand("Checking whether segment agents had errors", func(contentID int) string {
-		errorFile := fmt.Sprintf("%s_error", fpInfo.GetSegmentPipeFilePath(contentID))
+		errorFiles := getAllShardsErrorFiles(fpInfo, contentID)
 		/*
-		 * If an error file exists we want to indicate an error, as that means
-		 * the agent errored out.  If no file exists, the agent was successful.
+		 * If an error file exists for any shard we want to indicate an error, as
+		 * that means an agent errored out.  If no file exists, the agents were
+		 * successful.
 		 */
-		return fmt.Sprintf("if [[ -f %s ]]; then echo 'error'; fi; rm -f %s", errorFile, errorFile)
+		return fmt.Sprintf("errorFiles=$(ls %s 2>/dev/null); if [[ -n \"$errorFiles\" ]]; then echo 'error'; cat $errorFiles; fi; rm -f %s", errorFiles, errorFiles)
 	}, cluster.ON_SEGMENTS)
 
 	numErrors := 0
 	for contentID := range remoteOutput.Stdouts {
-		if strings.TrimSpace(remoteOutput.Stdouts[contentID]) == "error" {
-			gplog.Verbose("Error occurred with helper agent on segment %d on host %s.", contentID, c.GetHostForContent(contentID))
+		output := strings.SplitN(strings.TrimSpace(remoteOutput.Stdouts[contentID]), "\n", 2)
+		if output[0] == "error" {
+			if len(output) == 2 {
+				gplog.Error("Error occurred with helper agent on segment %d on host %s: %s", contentID, c.GetHostForContent(contentID), output[1])
+			} else {
+				gplog.Verbose("Error occurred with helper agent on segment %d on host %s.", contentID, c.GetHostForContent(contentID))
+			}
 			numErrors++
 		}
 	}
@@ -1,9 +1,11 @@
 package utils_test
 
 import (
+	"fmt"
 	"io"
 	"os"
 
+	"github.com/greenplum-db/gpbackup/testutils"
 	"github.com/greenplum-db/gpbackup/utils"
 
 	"github.com/greenplum-db/gp-common-go-libs/cluster"
@@ -76,6 +78,45 @@ var _ = Describe("agent remote", func() {
 			Expect(string(logfile.Contents())).To(ContainSubstring(`[DEBUG]:-Command was: scp fake_master fake_host`))
 		})
 	})
+	Describe("StartAgent()", func() {
+		AfterEach(func() {
+			utils.InitializeMaxBandwidth(0)
+		})
+		It("gives each agent on a segment an equal share of the maximum bandwidth", func() {
+			utils.InitializeMaxBandwidth(1000000)
+			testExecutor := &testhelper.TestExecutor{ClusterOutput: &cluster.RemoteOutput{}}
+			testCluster := testutils.SetDefaultSegmentConfiguration()
+			testCluster.Executor = testExecutor
+			fpInfo := backup_filepath.NewFilePathInfo(testCluster, "", "11112233445566", "gpseg")
+
+			utils.StartAgent(testCluster, fpInfo, "--backup-agent", "", "", 4)
+
+			Expect(testExecutor.NumExecutions).To(Equal(1))
+			Expect(testExecutor.ClusterCommands[0]).To(HaveLen(2))
+			for contentID, command := range testExecutor.ClusterCommands[0] {
+				Expect(command[len(command)-1]).To(ContainSubstring(fmt.Sprintf("--content %d --max-bandwidth 250000\n", contentID)))
+			}
+		})
+	})
+	Describe("WaitForSegmentTOCs()", func() {
+		It("waits on each segment until the segment TOC file or an error file of the shard exists, or the agent of the shard has exited", func() {
+			testExecutor := &testhelper.TestExecutor{ClusterOutput: &cluster.RemoteOutput{}}
+			testCluster := testutils.SetDefaultSegmentConfiguration()
+			testCluster.Executor = testExecutor
+			baseFPInfo := backup_filepath.NewFilePathInfo(testCluster, "", "11112233445566", "gpseg")
+			fpInfo := baseFPInfo.ForShard(1)
+
+			utils.WaitForSegmentTOCs(testCluster, fpInfo)
+
+			Expect(testExecutor.NumExecutions).To(Equal(1))
+			command := testExecutor.ClusterCommands[0][0]
+			tocFile := fpInfo.GetSegmentTOCFilePath(0)
+			errorFile := fpInfo.GetSegmentPipeFilePath(0) + "_error"
+			Expect(tocFile).To(ContainSubstring("shard1"))
+			procPattern := fmt.Sprintf("gpbackup_helper --backup-agent --toc-file %s ", tocFile)
+			Expect(command[len(command)-1]).To(Equal(fmt.Sprintf(`while [[ ! -f "%[1]s" && ! -f "%[2]s" ]]; do if ! ps ux | grep "%[3]s" | grep -v grep > /dev/null && [[ ! -f "%[1]s" && ! -f "%[2]s" ]]; then echo "gpbackup_helper exited without writing %[1]s" >&2; exit 1; fi; sleep 1; done; ls "%[1]s"`, tocFile, errorFile, procPattern)))
+		})
+	})
 	Describe("WriteOidsToFile()", func() {
 		It("writes oid list, delimited by newline characters", func() {
 			utils.WriteOidsToFile("myFilename", oidList)
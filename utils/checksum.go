@@ -0,0 +1,140 @@
+package utils
+
+/*
+ * This file contains functions for recording and checking SHA-256 checksums of
+ * backup files.  Checksum files use the same format as sha256sum, so they can
+ * also be checked by hand with "sha256sum -c".
+ */
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/iohelper"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/pkg/errors"
+)
+
+func GetFileChecksum(filename string) (string, error) {
+	fileHandle, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer fileHandle.Close()
+	hasher := sha256.New()
+	_, err = io.Copy(hasher, fileHandle)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+/*
+ * Files that do not exist, such as the statistics file for a backup taken
+ * without --with-stats, are skipped.  Filenames are recorded relative to the
+ * directory containing the checksum file.
+ */
+func WriteChecksumFile(checksumFilename string, filenames []string) error {
+	checksumFile, err := iohelper.OpenFileForWriting(checksumFilename)
+	if err != nil {
+		return err
+	}
+	defer checksumFile.Close()
+	for _, filename := range filenames {
+		if !iohelper.FileExistsAndIsReadable(filename) {
+			continue
+		}
+		checksum, err := GetFileChecksum(filename)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(checksumFile, "%s  %s\n", checksum, path.Base(filename))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ReadChecksumFile(checksumFilename string) (map[string]string, error) {
+	lines, err := iohelper.ReadLinesFromFile(checksumFilename)
+	if err != nil {
+		return nil, err
+	}
+	checksums := make(map[string]string, 0)
+	for _, line := range lines {
+		fields := strings.SplitN(strings.TrimSpace(line), "  ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		checksums[fields[1]] = fields[0]
+	}
+	return checksums, nil
+}
+
+/*
+ * Files with no entry in the checksum file are skipped, so that optional files
+ * such as the statistics file can be passed unconditionally.
+ */
+func VerifyChecksums(checksumFilename string, filenames []string) error {
+	checksums, err := ReadChecksumFile(checksumFilename)
+	if err != nil {
+		return err
+	}
+	for _, filename := range filenames {
+		expectedChecksum, ok := checksums[path.Base(filename)]
+		if !ok {
+			gplog.Verbose("No checksum recorded for %s", filename)
+			continue
+		}
+		actualChecksum, err := GetFileChecksum(filename)
+		if err != nil {
+			return err
+		}
+		if actualChecksum != expectedChecksum {
+			return errors.Errorf("Checksum verification failed for file %s: expected %s, found %s.  The file may be corrupt.", filename, expectedChecksum, actualChecksum)
+		}
+	}
+	return nil
+}
+
+/*
+ * Copies a file to the writer while computing its checksum, so that the file
+ * is only read once.  The error is returned after the whole file is written,
+ * so the reader must not commit what it read until the copy has succeeded.
+ */
+func CopyAndVerifyChecksum(checksumFilename string, filename string, writer io.Writer) error {
+	checksums, err := ReadChecksumFile(checksumFilename)
+	if err != nil {
+		return err
+	}
+	expectedChecksum, ok := checksums[path.Base(filename)]
+	if !ok {
+		return errors.Errorf("No checksum recorded for file %s in %s", filename, checksumFilename)
+	}
+	fileHandle, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer fileHandle.Close()
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(writer, hasher), fileHandle)
+	if err != nil {
+		return err
+	}
+	actualChecksum := hex.EncodeToString(hasher.Sum(nil))
+	if actualChecksum != expectedChecksum {
+		return errors.Errorf("Checksum verification failed for file %s: expected %s, found %s.  The file may be corrupt.", filename, expectedChecksum, actualChecksum)
+	}
+	return nil
+}
+
+func GetChecksumReadCommand(checksumFilename string, filename string) string {
+	return fmt.Sprintf("%s/bin/gpbackup_helper --verify-checksum --checksum-file %s --data-file %s", operating.System.Getenv("GPHOME"), checksumFilename, filename)
+}
@@ -0,0 +1,121 @@
+package utils_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/checksum tests", func() {
+	var (
+		tempDir          string
+		checksumFilename string
+		configFilename   string
+		metadataFilename string
+	)
+	BeforeEach(func() {
+		operating.System = operating.InitializeSystemFunctions()
+		tempDir, _ = ioutil.TempDir("", "checksum")
+		checksumFilename = filepath.Join(tempDir, "gpbackup_20170101010101_checksums")
+		configFilename = filepath.Join(tempDir, "gpbackup_20170101010101_config.yaml")
+		metadataFilename = filepath.Join(tempDir, "gpbackup_20170101010101_metadata.sql")
+		_ = ioutil.WriteFile(configFilename, []byte("foo"), 0644)
+		_ = ioutil.WriteFile(metadataFilename, []byte("bar"), 0644)
+	})
+	AfterEach(func() {
+		_ = os.RemoveAll(tempDir)
+	})
+	Describe("GetFileChecksum", func() {
+		It("returns the SHA-256 checksum of a file", func() {
+			checksum, err := utils.GetFileChecksum(configFilename)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(checksum).To(Equal("2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"))
+		})
+		It("returns an error if the file does not exist", func() {
+			_, err := utils.GetFileChecksum(filepath.Join(tempDir, "nonexistent"))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Describe("WriteChecksumFile", func() {
+		It("writes a checksum for each file in sha256sum format, skipping files that do not exist", func() {
+			err := utils.WriteChecksumFile(checksumFilename, []string{configFilename, metadataFilename, filepath.Join(tempDir, "gpbackup_20170101010101_statistics.sql")})
+			Expect(err).ToNot(HaveOccurred())
+			contents, _ := ioutil.ReadFile(checksumFilename)
+			Expect(string(contents)).To(Equal(`2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae  gpbackup_20170101010101_config.yaml
+fcde2b2edba56bf408601fb721fe9b5c338d10ee429ea04fae5511b68fbf8fb9  gpbackup_20170101010101_metadata.sql
+`))
+		})
+	})
+	Describe("ReadChecksumFile", func() {
+		It("reads the checksum for each file", func() {
+			_ = ioutil.WriteFile(checksumFilename, []byte("abc123  gpbackup_20170101010101_config.yaml\nnot a checksum line\n"), 0644)
+			checksums, err := utils.ReadChecksumFile(checksumFilename)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(checksums).To(Equal(map[string]string{"gpbackup_20170101010101_config.yaml": "abc123"}))
+		})
+	})
+	Describe("VerifyChecksums", func() {
+		BeforeEach(func() {
+			_ = utils.WriteChecksumFile(checksumFilename, []string{configFilename, metadataFilename})
+		})
+		It("passes if every file matches its recorded checksum", func() {
+			err := utils.VerifyChecksums(checksumFilename, []string{configFilename, metadataFilename})
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("skips files that have no recorded checksum", func() {
+			statisticsFilename := filepath.Join(tempDir, "gpbackup_20170101010101_statistics.sql")
+			_ = ioutil.WriteFile(statisticsFilename, []byte("baz"), 0644)
+			err := utils.VerifyChecksums(checksumFilename, []string{configFilename, statisticsFilename})
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("returns an error if a file does not match its recorded checksum", func() {
+			_ = ioutil.WriteFile(metadataFilename, []byte("corrupt"), 0644)
+			err := utils.VerifyChecksums(checksumFilename, []string{configFilename, metadataFilename})
+			Expect(err).To(MatchError(ContainSubstring("Checksum verification failed for file " + metadataFilename)))
+		})
+	})
+	Describe("CopyAndVerifyChecksum", func() {
+		var buffer *bytes.Buffer
+		BeforeEach(func() {
+			buffer = &bytes.Buffer{}
+			_ = utils.WriteChecksumFile(checksumFilename, []string{configFilename})
+		})
+		It("copies a file that matches its recorded checksum", func() {
+			err := utils.CopyAndVerifyChecksum(checksumFilename, configFilename, buffer)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(buffer.String()).To(Equal("foo"))
+		})
+		It("returns an error after copying a file that does not match its recorded checksum", func() {
+			_ = ioutil.WriteFile(configFilename, []byte("corrupt"), 0644)
+			err := utils.CopyAndVerifyChecksum(checksumFilename, configFilename, buffer)
+			Expect(err).To(MatchError(ContainSubstring("Checksum verification failed for file " + configFilename)))
+			Expect(buffer.String()).To(Equal("corrupt"))
+		})
+		It("returns an error if the file has no recorded checksum", func() {
+			err := utils.CopyAndVerifyChecksum(checksumFilename, metadataFilename, buffer)
+			Expect(err).To(MatchError(ContainSubstring("No checksum recorded for file " + metadataFilename)))
+			Expect(buffer.String()).To(BeEmpty())
+		})
+		It("returns an error if the checksum file does not exist", func() {
+			_ = os.Remove(checksumFilename)
+			err := utils.CopyAndVerifyChecksum(checksumFilename, configFilename, buffer)
+			Expect(err).To(HaveOccurred())
+			Expect(buffer.String()).To(BeEmpty())
+		})
+	})
+	Describe("GetChecksumReadCommand", func() {
+		It("reads the data file with the helper", func() {
+			operating.System.Getenv = func(key string) string {
+				return "/usr/local/gpdb"
+			}
+			Expect(utils.GetChecksumReadCommand("/data/gpbackup_0_20170101010101_checksums", "/data/gpbackup_0_20170101010101_3456")).To(Equal("/usr/local/gpdb/bin/gpbackup_helper --verify-checksum --checksum-file /data/gpbackup_0_20170101010101_checksums --data-file /data/gpbackup_0_20170101010101_3456"))
+		})
+	})
+})
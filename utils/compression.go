@@ -1,6 +1,11 @@
 package utils
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/pkg/errors"
+)
 
 var (
 	pipeThroughProgram PipeThroughProgram
@@ -13,14 +18,28 @@ type PipeThroughProgram struct {
 	Extension     string
 }
 
-func InitializePipeThroughParameters(compress bool, compressionLevel int) {
+func InitializePipeThroughParameters(compress bool, compressionType string, compressionLevel int) {
 	if compress {
-		pipeThroughProgram = PipeThroughProgram{Name: "gzip", OutputCommand: fmt.Sprintf("gzip -c -%d", compressionLevel), InputCommand: "gzip -d -c", Extension: ".gz"}
+		program, err := NewCompressionProgram(compressionType, compressionLevel)
+		gplog.FatalOnError(err)
+		pipeThroughProgram = program
 	} else {
 		pipeThroughProgram = PipeThroughProgram{Name: "cat", OutputCommand: "cat -", InputCommand: "cat -", Extension: ""}
 	}
 }
 
+func NewCompressionProgram(compressionType string, compressionLevel int) (PipeThroughProgram, error) {
+	switch compressionType {
+	case "gzip":
+		return PipeThroughProgram{Name: "gzip", OutputCommand: fmt.Sprintf("gzip -c -%d", compressionLevel), InputCommand: "gzip -d -c", Extension: ".gz"}, nil
+	case "zstd":
+		return PipeThroughProgram{Name: "zstd", OutputCommand: fmt.Sprintf("zstd --compress -%d -c -q", compressionLevel), InputCommand: "zstd --decompress -c -q", Extension: ".zst"}, nil
+	case "lz4":
+		return PipeThroughProgram{Name: "lz4", OutputCommand: fmt.Sprintf("lz4 -c -%d -q", compressionLevel), InputCommand: "lz4 -d -c -q", Extension: ".lz4"}, nil
+	}
+	return PipeThroughProgram{}, errors.Errorf("Unknown compression type %s.  Valid compression types are gzip, zstd, and lz4.", compressionType)
+}
+
 func GetPipeThroughProgram() PipeThroughProgram {
 	return pipeThroughProgram
 }
@@ -10,6 +10,7 @@ import (
 	"github.com/greenplum-db/gpbackup/utils"
 
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
 )
 
 var _ = Describe("utils/compression tests", func() {
@@ -39,7 +40,7 @@ var _ = Describe("utils/compression tests", func() {
 				InputCommand:  "cat -",
 				Extension:     "",
 			}
-			utils.InitializePipeThroughParameters(false, 3)
+			utils.InitializePipeThroughParameters(false, "", 3)
 			resultProgram := utils.GetPipeThroughProgram()
 			structmatcher.ExpectStructsToMatch(&expectedProgram, &resultProgram)
 		})
@@ -52,9 +53,41 @@ var _ = Describe("utils/compression tests", func() {
 				InputCommand:  "gzip -d -c",
 				Extension:     ".gz",
 			}
-			utils.InitializePipeThroughParameters(true, 7)
+			utils.InitializePipeThroughParameters(true, "gzip", 7)
 			resultProgram := utils.GetPipeThroughProgram()
 			structmatcher.ExpectStructsToMatch(&expectedProgram, &resultProgram)
 		})
+		It("initializes to use zstd when passed compression, zstd, and a level", func() {
+			originalProgram := utils.GetPipeThroughProgram()
+			defer utils.SetPipeThroughProgram(originalProgram)
+			expectedProgram := utils.PipeThroughProgram{
+				Name:          "zstd",
+				OutputCommand: "zstd --compress -3 -c -q",
+				InputCommand:  "zstd --decompress -c -q",
+				Extension:     ".zst",
+			}
+			utils.InitializePipeThroughParameters(true, "zstd", 3)
+			resultProgram := utils.GetPipeThroughProgram()
+			structmatcher.ExpectStructsToMatch(&expectedProgram, &resultProgram)
+		})
+		It("initializes to use lz4 when passed compression, lz4, and a level", func() {
+			originalProgram := utils.GetPipeThroughProgram()
+			defer utils.SetPipeThroughProgram(originalProgram)
+			expectedProgram := utils.PipeThroughProgram{
+				Name:          "lz4",
+				OutputCommand: "lz4 -c -5 -q",
+				InputCommand:  "lz4 -d -c -q",
+				Extension:     ".lz4",
+			}
+			utils.InitializePipeThroughParameters(true, "lz4", 5)
+			resultProgram := utils.GetPipeThroughProgram()
+			structmatcher.ExpectStructsToMatch(&expectedProgram, &resultProgram)
+		})
+	})
+	Describe("NewCompressionProgram", func() {
+		It("returns an error when passed an unknown compression type", func() {
+			_, err := utils.NewCompressionProgram("bzip2", 1)
+			Expect(err).To(MatchError("Unknown compression type bzip2.  Valid compression types are gzip, zstd, and lz4."))
+		})
 	})
 })
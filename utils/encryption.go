@@ -0,0 +1,323 @@
+package utils
+
+/*
+ * This file contains structs and functions for encrypting backup files with
+ * AES-256-GCM.
+ *
+ * Encrypted files begin with a header containing a magic string and a random
+ * nonce prefix, followed by a series of chunks.  Each chunk is a 4-byte
+ * big-endian length, the high bit of which marks the final chunk, followed by
+ * up to encryptionChunkSize bytes of plaintext sealed with a nonce made up of
+ * the prefix and the chunk number.  The length is authenticated along with the
+ * chunk, so chunks cannot be reordered, dropped, or truncated without
+ * detection.
+ */
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/iohelper"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/pkg/errors"
+)
+
+const (
+	encryptionMagic       = "GPBKENC1"
+	encryptionKeySize     = 32
+	encryptionChunkSize   = 64 * 1024
+	encryptionPrefixSize  = 8
+	encryptionFinalChunk  = uint32(1 << 31)
+	encryptionLengthBytes = 4
+)
+
+var (
+	encryptionKey []byte
+)
+
+func GetEncryptionKey() []byte {
+	return encryptionKey
+}
+
+func SetEncryptionKey(key []byte) {
+	encryptionKey = key
+}
+
+/*
+ * The key is read from a file or from the output of a command, and must be 64
+ * hexadecimal characters.  Surrounding whitespace is ignored.
+ */
+func ReadEncryptionKey(keyFile string, keyCommand string) ([]byte, error) {
+	var contents []byte
+	var err error
+	if keyFile != "" {
+		contents, err = operating.System.ReadFile(keyFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Unable to read encryption key file %s", keyFile)
+		}
+	} else {
+		contents, err = exec.Command("bash", "-c", keyCommand).Output()
+		if err != nil {
+			return nil, errors.Wrapf(err, "Unable to get encryption key from command %s", keyCommand)
+		}
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(contents)))
+	if err != nil || len(key) != encryptionKeySize {
+		return nil, errors.Errorf("Encryption key must be %d hexadecimal characters", encryptionKeySize*2)
+	}
+	return key, nil
+}
+
+func InitializeEncryptionKey(keyFile string, keyCommand string) {
+	if keyFile == "" && keyCommand == "" {
+		return
+	}
+	key, err := ReadEncryptionKey(keyFile, keyCommand)
+	gplog.FatalOnError(err)
+	SetEncryptionKey(key)
+}
+
+func newEncryptionCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func getChunkNonce(prefix []byte, chunkNum uint32) []byte {
+	nonce := make([]byte, encryptionPrefixSize+4)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encryptionPrefixSize:], chunkNum)
+	return nonce
+}
+
+type EncryptionWriter struct {
+	writer   io.Writer
+	aead     cipher.AEAD
+	prefix   []byte
+	chunkNum uint32
+	buffer   []byte
+}
+
+func NewEncryptionWriter(writer io.Writer, key []byte) (*EncryptionWriter, error) {
+	aead, err := newEncryptionCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, encryptionPrefixSize)
+	_, err = rand.Read(prefix)
+	if err != nil {
+		return nil, err
+	}
+	_, err = writer.Write(append([]byte(encryptionMagic), prefix...))
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptionWriter{writer: writer, aead: aead, prefix: prefix, buffer: make([]byte, 0, encryptionChunkSize)}, nil
+}
+
+/*
+ * A full buffer is only written out once more data arrives, so that the last
+ * chunk can always be marked as final when the writer is closed.
+ */
+func (encWriter *EncryptionWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if len(encWriter.buffer) == encryptionChunkSize {
+			err := encWriter.writeChunk(false)
+			if err != nil {
+				return written, err
+			}
+		}
+		n := copy(encWriter.buffer[len(encWriter.buffer):encryptionChunkSize], p)
+		encWriter.buffer = encWriter.buffer[:len(encWriter.buffer)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close writes the final chunk; it does not close the underlying writer
+func (encWriter *EncryptionWriter) Close() error {
+	return encWriter.writeChunk(true)
+}
+
+func (encWriter *EncryptionWriter) writeChunk(final bool) error {
+	if encWriter.chunkNum == math.MaxUint32 {
+		return errors.New("Too much data to encrypt in a single file")
+	}
+	length := uint32(len(encWriter.buffer) + encWriter.aead.Overhead())
+	if final {
+		length |= encryptionFinalChunk
+	}
+	header := make([]byte, encryptionLengthBytes)
+	binary.BigEndian.PutUint32(header, length)
+	chunk := encWriter.aead.Seal(header, getChunkNonce(encWriter.prefix, encWriter.chunkNum), encWriter.buffer, header)
+	_, err := encWriter.writer.Write(chunk)
+	if err != nil {
+		return err
+	}
+	encWriter.chunkNum++
+	encWriter.buffer = encWriter.buffer[:0]
+	return nil
+}
+
+type DecryptionReader struct {
+	reader   io.Reader
+	aead     cipher.AEAD
+	prefix   []byte
+	chunkNum uint32
+	buffer   []byte
+	done     bool
+}
+
+func NewDecryptionReader(reader io.Reader, key []byte) (*DecryptionReader, error) {
+	aead, err := newEncryptionCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, len(encryptionMagic)+encryptionPrefixSize)
+	_, err = io.ReadFull(reader, header)
+	if err != nil || string(header[:len(encryptionMagic)]) != encryptionMagic {
+		return nil, errors.New("Data is not encrypted or has an invalid encryption header")
+	}
+	return &DecryptionReader{reader: reader, aead: aead, prefix: header[len(encryptionMagic):]}, nil
+}
+
+func (decReader *DecryptionReader) Read(p []byte) (int, error) {
+	for len(decReader.buffer) == 0 {
+		if decReader.done {
+			return 0, io.EOF
+		}
+		err := decReader.readChunk()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, decReader.buffer)
+	decReader.buffer = decReader.buffer[n:]
+	return n, nil
+}
+
+func (decReader *DecryptionReader) readChunk() error {
+	header := make([]byte, encryptionLengthBytes)
+	_, err := io.ReadFull(decReader.reader, header)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errors.New("Encrypted data is truncated")
+	} else if err != nil {
+		return err
+	}
+	length := binary.BigEndian.Uint32(header)
+	final := length&encryptionFinalChunk != 0
+	length &^= encryptionFinalChunk
+	if length > uint32(encryptionChunkSize+decReader.aead.Overhead()) {
+		return errors.New("Encrypted data is corrupt")
+	}
+	ciphertext := make([]byte, length)
+	_, err = io.ReadFull(decReader.reader, ciphertext)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errors.New("Encrypted data is truncated")
+	} else if err != nil {
+		return err
+	}
+	plaintext, err := decReader.aead.Open(nil, getChunkNonce(decReader.prefix, decReader.chunkNum), ciphertext, header)
+	if err != nil {
+		return errors.New("Unable to decrypt data; the encryption key may be incorrect or the data may be corrupt")
+	}
+	decReader.chunkNum++
+	decReader.buffer = plaintext
+	decReader.done = final
+	return nil
+}
+
+// Returns a command that encrypts or decrypts stdin to stdout, for use in COPY commands
+func GetEncryptionFilterCommand(decrypt bool, keyFile string) string {
+	operation := "encrypt"
+	if decrypt {
+		operation = "decrypt"
+	}
+	return fmt.Sprintf("%s/bin/gpbackup_helper --%s --encryption-key-file %s", operating.System.Getenv("GPHOME"), operation, keyFile)
+}
+
+/*
+ * Functions for reading and writing master metadata files, which are
+ * encrypted if an encryption key has been set
+ */
+
+type encryptedFile struct {
+	*EncryptionWriter
+	file io.WriteCloser
+}
+
+func (encFile *encryptedFile) Close() error {
+	err := encFile.EncryptionWriter.Close()
+	if err != nil {
+		return err
+	}
+	return encFile.file.Close()
+}
+
+func MustOpenMetadataFileForWriting(filename string) io.WriteCloser {
+	file := iohelper.MustOpenFileForWriting(filename)
+	if encryptionKey == nil {
+		return file
+	}
+	encWriter, err := NewEncryptionWriter(file, encryptionKey)
+	gplog.FatalOnError(err)
+	return &encryptedFile{EncryptionWriter: encWriter, file: file}
+}
+
+func IsEncrypted(contents []byte) bool {
+	return bytes.HasPrefix(contents, []byte(encryptionMagic))
+}
+
+func ReadMetadataFile(filename string) ([]byte, error) {
+	contents, err := operating.System.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !IsEncrypted(contents) {
+		// A plaintext file in an encrypted backup means that it was replaced or never encrypted
+		if encryptionKey != nil {
+			return nil, errors.Errorf("File %s is not encrypted, but an encryption key was provided", filename)
+		}
+		return contents, nil
+	}
+	if encryptionKey == nil {
+		return nil, errors.Errorf("File %s is encrypted, but no encryption key was provided", filename)
+	}
+	decReader, err := NewDecryptionReader(bytes.NewReader(contents), encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := &bytes.Buffer{}
+	_, err = io.Copy(plaintext, decReader)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to decrypt file %s", filename)
+	}
+	return plaintext.Bytes(), nil
+}
+
+/*
+ * Encrypted metadata files are read into memory in full, as statements are read
+ * using byte offsets into the plaintext.
+ */
+func MustOpenMetadataFileForReading(filename string) io.ReaderAt {
+	if encryptionKey == nil {
+		return iohelper.MustOpenFileForReading(filename)
+	}
+	contents, err := ReadMetadataFile(filename)
+	gplog.FatalOnError(err)
+	return bytes.NewReader(contents)
+}
@@ -0,0 +1,160 @@
+package utils_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/encryption tests", func() {
+	key := bytes.Repeat([]byte{0x01}, 32)
+	otherKey := bytes.Repeat([]byte{0x02}, 32)
+	encrypt := func(plaintext []byte) []byte {
+		ciphertext := &bytes.Buffer{}
+		encWriter, err := utils.NewEncryptionWriter(ciphertext, key)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = encWriter.Write(plaintext)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(encWriter.Close()).To(Succeed())
+		return ciphertext.Bytes()
+	}
+	decrypt := func(ciphertext []byte, decryptionKey []byte) ([]byte, error) {
+		decReader, err := utils.NewDecryptionReader(bytes.NewReader(ciphertext), decryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(decReader)
+	}
+	Describe("EncryptionWriter and DecryptionReader", func() {
+		It("round-trips empty data", func() {
+			plaintext, err := decrypt(encrypt([]byte{}), key)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(plaintext).To(BeEmpty())
+		})
+		It("round-trips data spanning several chunks", func() {
+			original := []byte(strings.Repeat("0123456789", 20000))
+			ciphertext := encrypt(original)
+			Expect(bytes.Contains(ciphertext, []byte("0123456789"))).To(BeFalse())
+			plaintext, err := decrypt(ciphertext, key)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(plaintext).To(Equal(original))
+		})
+		It("returns an error if the data is decrypted with the wrong key", func() {
+			_, err := decrypt(encrypt([]byte("data")), otherKey)
+			Expect(err).To(MatchError(ContainSubstring("Unable to decrypt data")))
+		})
+		It("returns an error if the data is truncated at a chunk boundary", func() {
+			ciphertext := encrypt([]byte(strings.Repeat("0123456789", 20000)))
+			// Drop the final chunk, which holds the remaining 3392 bytes plus a 4-byte length and a 16-byte tag
+			_, err := decrypt(ciphertext[:len(ciphertext)-3412], key)
+			Expect(err).To(MatchError("Encrypted data is truncated"))
+		})
+		It("returns an error if the data has been modified", func() {
+			ciphertext := encrypt([]byte("data"))
+			ciphertext[len(ciphertext)-1] ^= 0xff
+			_, err := decrypt(ciphertext, key)
+			Expect(err).To(MatchError(ContainSubstring("Unable to decrypt data")))
+		})
+		It("returns an error if the data is not encrypted", func() {
+			_, err := decrypt([]byte("plaintext that is not encrypted"), key)
+			Expect(err).To(MatchError("Data is not encrypted or has an invalid encryption header"))
+		})
+	})
+	Describe("ReadEncryptionKey", func() {
+		var tempDir string
+		BeforeEach(func() {
+			operating.System = operating.InitializeSystemFunctions()
+			tempDir, _ = ioutil.TempDir("", "encryption")
+		})
+		AfterEach(func() {
+			_ = os.RemoveAll(tempDir)
+		})
+		It("reads a key from a file", func() {
+			keyFile := filepath.Join(tempDir, "key")
+			_ = ioutil.WriteFile(keyFile, []byte(strings.Repeat("01", 32)+"\n"), 0600)
+			readKey, err := utils.ReadEncryptionKey(keyFile, "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(readKey).To(Equal(key))
+		})
+		It("reads a key from the output of a command", func() {
+			readKey, err := utils.ReadEncryptionKey("", "echo "+strings.Repeat("02", 32))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(readKey).To(Equal(otherKey))
+		})
+		It("returns an error if the key is not 64 hexadecimal characters", func() {
+			_, err := utils.ReadEncryptionKey("", "echo abcd")
+			Expect(err).To(MatchError("Encryption key must be 64 hexadecimal characters"))
+		})
+		It("returns an error if the command fails", func() {
+			_, err := utils.ReadEncryptionKey("", "exit 1")
+			Expect(err).To(MatchError(ContainSubstring("Unable to get encryption key from command exit 1")))
+		})
+	})
+	Describe("metadata files", func() {
+		var (
+			tempDir  string
+			filename string
+		)
+		BeforeEach(func() {
+			operating.System = operating.InitializeSystemFunctions()
+			tempDir, _ = ioutil.TempDir("", "encryption")
+			filename = filepath.Join(tempDir, "gpbackup_20170101010101_metadata.sql")
+		})
+		AfterEach(func() {
+			utils.SetEncryptionKey(nil)
+			_ = os.RemoveAll(tempDir)
+		})
+		It("writes and reads an encrypted file when a key is set", func() {
+			utils.SetEncryptionKey(key)
+			metadataFile := utils.MustOpenMetadataFileForWriting(filename)
+			_, _ = metadataFile.Write([]byte("CREATE SCHEMA foo;"))
+			Expect(metadataFile.Close()).To(Succeed())
+
+			contents, _ := ioutil.ReadFile(filename)
+			Expect(utils.IsEncrypted(contents)).To(BeTrue())
+			plaintext, err := utils.ReadMetadataFile(filename)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(plaintext)).To(Equal("CREATE SCHEMA foo;"))
+			statement := make([]byte, 3)
+			_, _ = utils.MustOpenMetadataFileForReading(filename).ReadAt(statement, 7)
+			Expect(string(statement)).To(Equal("SCH"))
+		})
+		It("writes and reads a plaintext file when no key is set", func() {
+			metadataFile := utils.MustOpenMetadataFileForWriting(filename)
+			_, _ = metadataFile.Write([]byte("CREATE SCHEMA foo;"))
+			Expect(metadataFile.Close()).To(Succeed())
+
+			contents, _ := ioutil.ReadFile(filename)
+			Expect(string(contents)).To(Equal("CREATE SCHEMA foo;"))
+			plaintext, err := utils.ReadMetadataFile(filename)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(plaintext)).To(Equal("CREATE SCHEMA foo;"))
+		})
+		It("returns an error reading an encrypted file when no key is set", func() {
+			utils.SetEncryptionKey(key)
+			metadataFile := utils.MustOpenMetadataFileForWriting(filename)
+			_ = metadataFile.Close()
+			utils.SetEncryptionKey(nil)
+
+			_, err := utils.ReadMetadataFile(filename)
+			Expect(err).To(MatchError(ContainSubstring("is encrypted, but no encryption key was provided")))
+		})
+		It("returns an error reading a plaintext file when a key is set", func() {
+			metadataFile := utils.MustOpenMetadataFileForWriting(filename)
+			_, _ = metadataFile.Write([]byte("CREATE SCHEMA foo;"))
+			_ = metadataFile.Close()
+			utils.SetEncryptionKey(key)
+
+			_, err := utils.ReadMetadataFile(filename)
+			Expect(err).To(MatchError(ContainSubstring("is not encrypted, but an encryption key was provided")))
+		})
+	})
+})
@@ -14,33 +14,63 @@ import (
 )
 
 const (
-	BACKUP_DIR            = "backup-dir"
-	COMPRESSION_LEVEL     = "compression-level"
-	DATA_ONLY             = "data-only"
-	DBNAME                = "dbname"
-	DEBUG                 = "debug"
-	EXCLUDE_RELATION      = "exclude-table"
-	EXCLUDE_RELATION_FILE = "exclude-table-file"
-	EXCLUDE_SCHEMA        = "exclude-schema"
-	FROM_TIMESTAMP        = "from-timestamp"
-	INCLUDE_RELATION      = "include-table"
-	INCLUDE_RELATION_FILE = "include-table-file"
-	INCLUDE_SCHEMA        = "include-schema"
-	INCREMENTAL           = "incremental"
-	JOBS                  = "jobs"
-	LEAF_PARTITION_DATA   = "leaf-partition-data"
-	METADATA_ONLY         = "metadata-only"
-	NO_COMPRESSION        = "no-compression"
-	PLUGIN_CONFIG         = "plugin-config"
-	QUIET                 = "quiet"
-	SINGLE_DATA_FILE      = "single-data-file"
-	VERBOSE               = "verbose"
-	WITH_STATS            = "with-stats"
-	CREATE_DB             = "create-db"
-	ON_ERROR_CONTINUE     = "on-error-continue"
-	REDIRECT_DB           = "redirect-db"
-	TIMESTAMP             = "timestamp"
-	WITH_GLOBALS          = "with-globals"
+	BACKUP_DIR               = "backup-dir"
+	COMPRESSION_LEVEL        = "compression-level"
+	COMPRESSION_TYPE         = "compression-type"
+	DATA_ONLY                = "data-only"
+	DBNAME                   = "dbname"
+	DEBUG                    = "debug"
+	DIFFERENTIAL             = "differential"
+	ENCRYPTION_KEY_CMD       = "encryption-key-command"
+	ENCRYPTION_KEY_FILE      = "encryption-key-file"
+	EXCLUDE_RELATION         = "exclude-table"
+	EXCLUDE_RELATION_FILE    = "exclude-table-file"
+	EXCLUDE_RELATION_PATTERN = "exclude-table-pattern"
+	EXCLUDE_SCHEMA           = "exclude-schema"
+	EXCLUDE_OBJECT_TYPE      = "exclude-object-type"
+	EXCLUDE_SCHEMA_PATTERN   = "exclude-schema-pattern"
+	FROM_TIMESTAMP           = "from-timestamp"
+	INCLUDE_RELATION         = "include-table"
+	INCLUDE_RELATION_FILE    = "include-table-file"
+	INCLUDE_RELATION_PATTERN = "include-table-pattern"
+	INCLUDE_SCHEMA           = "include-schema"
+	INCLUDE_OBJECT_TYPE      = "include-object-type"
+	INCLUDE_SCHEMA_PATTERN   = "include-schema-pattern"
+	INCREMENTAL              = "incremental"
+	JOBS                     = "jobs"
+	LEAF_PARTITION_DATA      = "leaf-partition-data"
+	METADATA_ONLY            = "metadata-only"
+	NO_COMPRESSION           = "no-compression"
+	PLUGIN_CONFIG            = "plugin-config"
+	QUIET                    = "quiet"
+	SINGLE_DATA_FILE         = "single-data-file"
+	VERBOSE                  = "verbose"
+	WITH_STATS               = "with-stats"
+	CREATE_DB                = "create-db"
+	ON_ERROR_CONTINUE        = "on-error-continue"
+	REDIRECT_DB              = "redirect-db"
+	REDIRECT_SCHEMA          = "redirect-schema"
+	TIMESTAMP                = "timestamp"
+	WITH_GLOBALS             = "with-globals"
+	AFTER_TIMESTAMP          = "after-timestamp"
+	BEFORE_TIMESTAMP         = "before-timestamp"
+	FORCE                    = "force"
+	FULL                     = "full"
+	INCLUDE_DELETED          = "include-deleted"
+	PLUGIN                   = "plugin"
+	RETAIN_DAYS              = "retain-days"
+	RETAIN_FULL_BACKUPS      = "retain-full-backups"
+	RESUME                   = "resume"
+	VERIFY                   = "verify"
+	LIST                     = "list"
+	USE_LIST                 = "use-list"
+	TO_SQL                   = "to-sql"
+	MIGRATION_SQL            = "migration-sql"
+	METRICS_FILE             = "metrics-file"
+	METRICS_URL              = "metrics-url"
+	HOOK_CONFIG              = "hook-config"
+	MAX_BANDWIDTH            = "max-bandwidth"
+	PATTERN_TYPE             = "pattern-type"
 )
 
 /*
@@ -60,6 +90,44 @@ func CheckExclusiveFlags(flags *pflag.FlagSet, flagNames ...string) {
 	}
 }
 
+/*
+ * The schema and table filter flags, grouped by the list that each resolves
+ * to.  The flags within a group may be combined, except for a list with the
+ * file from which it is read.
+ */
+var (
+	includeSchemaFlags   = []string{INCLUDE_SCHEMA, INCLUDE_SCHEMA_PATTERN}
+	excludeSchemaFlags   = []string{EXCLUDE_SCHEMA, EXCLUDE_SCHEMA_PATTERN}
+	includeRelationFlags = []string{INCLUDE_RELATION, INCLUDE_RELATION_FILE, INCLUDE_RELATION_PATTERN}
+	excludeRelationFlags = []string{EXCLUDE_RELATION, EXCLUDE_RELATION_FILE, EXCLUDE_RELATION_PATTERN}
+)
+
+// Flags from at most one of the groups passed to this function may be set
+func CheckExclusiveFlagGroups(flags *pflag.FlagSet, flagGroups ...[]string) {
+	numSet := 0
+	groupNames := make([]string, 0, len(flagGroups))
+	for _, group := range flagGroups {
+		for _, name := range group {
+			if flags.Changed(name) {
+				numSet++
+				break
+			}
+		}
+		groupNames = append(groupNames, strings.Join(group, "/"))
+	}
+	if numSet > 1 {
+		gplog.Fatal(errors.Errorf("The following flags may not be specified together: %s", strings.Join(groupNames, ", ")), "")
+	}
+}
+
+func CheckExclusiveFilterFlags(flags *pflag.FlagSet) {
+	CheckExclusiveFlags(flags, INCLUDE_RELATION, INCLUDE_RELATION_FILE)
+	CheckExclusiveFlags(flags, EXCLUDE_RELATION, EXCLUDE_RELATION_FILE)
+	CheckExclusiveFlagGroups(flags, includeSchemaFlags, includeRelationFlags)
+	CheckExclusiveFlagGroups(flags, excludeSchemaFlags, includeSchemaFlags)
+	CheckExclusiveFlagGroups(flags, excludeSchemaFlags, excludeRelationFlags, includeRelationFlags)
+}
+
 /*
  * Functions for validating flag values
  */
@@ -39,6 +39,17 @@ var _ = Describe("utils/flag tests", func() {
 				utils.CheckExclusiveFlags(flagSet, "stringFlag", "boolFlag")
 			})
 		})
+		Context("CheckExclusiveFlagGroups", func() {
+			It("does not panic if flags from only one group are set", func() {
+				Expect(flagSet.Parse([]string{"--stringFlag", "foo", "--boolFlag"})).To(Succeed())
+				utils.CheckExclusiveFlagGroups(flagSet, []string{"stringFlag", "boolFlag"}, []string{"intFlag"})
+			})
+			It("panics if flags from two or more groups are set", func() {
+				Expect(flagSet.Parse([]string{"--boolFlag", "--intFlag", "42"})).To(Succeed())
+				defer testhelper.ShouldPanicWithMessage("The following flags may not be specified together: stringFlag/boolFlag, intFlag")
+				utils.CheckExclusiveFlagGroups(flagSet, []string{"stringFlag", "boolFlag"}, []string{"intFlag"})
+			})
+		})
 		Context("HandleSingleDashes", func() {
 			It("replaces single dash at beginning of command", func() {
 				result := utils.HandleSingleDashes([]string{"-some_flag", "some_argument"})
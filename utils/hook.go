@@ -0,0 +1,139 @@
+package utils
+
+/*
+ * This file contains structs and functions for running user-supplied hook
+ * executables before and after a backup or restore.
+ */
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/backup_filepath"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	PRE_BACKUP_HOOK   = "pre-backup"
+	POST_BACKUP_HOOK  = "post-backup"
+	ON_FAILURE_HOOK   = "on-failure"
+	PRE_RESTORE_HOOK  = "pre-restore"
+	POST_RESTORE_HOOK = "post-restore"
+)
+
+var validHooks = map[string]bool{
+	PRE_BACKUP_HOOK:   true,
+	POST_BACKUP_HOOK:  true,
+	ON_FAILURE_HOOK:   true,
+	PRE_RESTORE_HOOK:  true,
+	POST_RESTORE_HOOK: true,
+}
+
+/*
+ * A hook always runs once on master.  Including segment_host or segment in
+ * Scopes also runs it once on each segment host or once for each segment.
+ */
+type Hook struct {
+	ExecutablePath string        `yaml:"executablepath"`
+	Scopes         []PluginScope `yaml:"scopes"`
+}
+
+// The hooks to run, keyed by the name of the hook
+type HookConfig map[string]Hook
+
+func ReadHookConfig(configFile string) (HookConfig, error) {
+	config := HookConfig{}
+	contents, err := operating.System.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+	err = yaml.UnmarshalStrict(contents, &config)
+	if err != nil {
+		return nil, errors.Errorf("Unable to parse hook config file %s: %v", configFile, err)
+	}
+	for name, hook := range config {
+		if !validHooks[name] {
+			return nil, errors.Errorf("Unrecognized hook %s in hook config file %s", name, configFile)
+		}
+		if hook.ExecutablePath == "" {
+			return nil, errors.Errorf("No executablepath given for hook %s in hook config file %s", name, configFile)
+		}
+		hook.ExecutablePath = os.ExpandEnv(hook.ExecutablePath)
+		err = ValidateFullPath(hook.ExecutablePath)
+		if err != nil {
+			return nil, err
+		}
+		for _, scope := range hook.Scopes {
+			if scope != MASTER && scope != SEGMENT_HOST && scope != SEGMENT {
+				return nil, errors.Errorf("Invalid scope %s for hook %s in hook config file %s", scope, name, configFile)
+			}
+		}
+		config[name] = hook
+	}
+	return config, nil
+}
+
+func shellQuote(str string) string {
+	return fmt.Sprintf("'%s'", strings.Replace(str, "'", `'\''`, -1))
+}
+
+/*
+ * Everything the hook needs to know is passed in environment variables, so
+ * that hooks may ignore whatever they do not use.  The content ID is not set
+ * when running once per segment host, and the status is only set for hooks
+ * run after the backup or restore.
+ */
+func buildHookCommand(hook Hook, name string, fpInfo backup_filepath.FilePathInfo, dbName string,
+	status string, scope PluginScope, contentID int) string {
+	env := map[string]string{
+		"GPBACKUP_HOOK":       name,
+		"GPBACKUP_TIMESTAMP":  fpInfo.Timestamp,
+		"GPBACKUP_BACKUP_DIR": fpInfo.GetDirForContent(contentID),
+		"GPBACKUP_DBNAME":     dbName,
+		"GPBACKUP_SCOPE":      string(scope),
+	}
+	if scope != SEGMENT_HOST {
+		env["GPBACKUP_CONTENT_ID"] = fmt.Sprintf("%d", contentID)
+	}
+	if status != "" {
+		env["GPBACKUP_STATUS"] = status
+	}
+	names := make([]string, 0, len(env))
+	for envName := range env {
+		names = append(names, envName)
+	}
+	sort.Strings(names)
+	assignments := make([]string, 0, len(names))
+	for _, envName := range names {
+		assignments = append(assignments, fmt.Sprintf("%s=%s", envName, shellQuote(env[envName])))
+	}
+	return fmt.Sprintf("source %s/greenplum_path.sh && %s %s",
+		operating.System.Getenv("GPHOME"), strings.Join(assignments, " "), hook.ExecutablePath)
+}
+
+/*
+ * Hooks run before the backup or restore stop it if they fail, so that it
+ * does not start when, for example, ETL could not be paused.  Failures of the
+ * other hooks are logged as errors.  Nothing is done if the hook is not
+ * configured.
+ */
+func (config HookConfig) RunHook(c *cluster.Cluster, name string, fpInfo backup_filepath.FilePathInfo, dbName string, status string) {
+	hook, ok := config[name]
+	if !ok {
+		return
+	}
+	noFatal := name != PRE_BACKUP_HOOK && name != PRE_RESTORE_HOOK
+	buildCommand := func(scope PluginScope, contentID int) string {
+		return buildHookCommand(hook, name, fpInfo, dbName, status, scope, contentID)
+	}
+	buildErrorMsg := func(scope PluginScope) string {
+		return fmt.Sprintf("Unable to execute %s hook: %s, on: %s", name, hook.ExecutablePath, scope)
+	}
+	verboseCommandMsg := fmt.Sprintf("Running %s hook on %%s", name)
+	executeOnScopes(c, verboseCommandMsg, hook.Scopes, buildCommand, buildErrorMsg, noFatal)
+}
@@ -0,0 +1,106 @@
+package utils_test
+
+import (
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/backup_filepath"
+	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/hook tests", func() {
+	var configContents string
+	BeforeEach(func() {
+		operating.System.ReadFile = func(filename string) ([]byte, error) { return []byte(configContents), nil }
+		operating.System.Getenv = func(key string) string { return "/usr/local/greenplum-db" }
+	})
+	Describe("ReadHookConfig", func() {
+		It("reads the executable and scopes of each hook", func() {
+			configContents = `pre-backup:
+  executablepath: /home/gpadmin/pause_etl.sh
+on-failure:
+  executablepath: /home/gpadmin/notify.sh
+  scopes: [segment_host, segment]
+`
+			config, err := utils.ReadHookConfig("/tmp/hooks.yaml")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config).To(Equal(utils.HookConfig{
+				utils.PRE_BACKUP_HOOK: {ExecutablePath: "/home/gpadmin/pause_etl.sh"},
+				utils.ON_FAILURE_HOOK: {ExecutablePath: "/home/gpadmin/notify.sh", Scopes: []utils.PluginScope{utils.SEGMENT_HOST, utils.SEGMENT}},
+			}))
+		})
+		It("returns an error for an unrecognized hook", func() {
+			configContents = "pre-vacuum:\n  executablepath: /home/gpadmin/pause_etl.sh\n"
+			_, err := utils.ReadHookConfig("/tmp/hooks.yaml")
+			Expect(err).To(MatchError("Unrecognized hook pre-vacuum in hook config file /tmp/hooks.yaml"))
+		})
+		It("returns an error for an invalid scope", func() {
+			configContents = "post-restore:\n  executablepath: /home/gpadmin/notify.sh\n  scopes: [host]\n"
+			_, err := utils.ReadHookConfig("/tmp/hooks.yaml")
+			Expect(err).To(MatchError("Invalid scope host for hook post-restore in hook config file /tmp/hooks.yaml"))
+		})
+		It("returns an error for a relative executable path", func() {
+			configContents = "post-backup:\n  executablepath: notify.sh\n"
+			_, err := utils.ReadHookConfig("/tmp/hooks.yaml")
+			Expect(err).To(MatchError("notify.sh is not an absolute path."))
+		})
+	})
+	Describe("RunHook", func() {
+		var (
+			testExecutor *testhelper.TestExecutor
+			testCluster  *cluster.Cluster
+			testFPInfo   backup_filepath.FilePathInfo
+			config       utils.HookConfig
+		)
+		BeforeEach(func() {
+			testCluster = testutils.SetDefaultSegmentConfiguration()
+			testExecutor = &testhelper.TestExecutor{ClusterOutput: &cluster.RemoteOutput{}}
+			testCluster.Executor = testExecutor
+			testFPInfo = backup_filepath.NewFilePathInfo(testCluster, "/backups", "20170101010101", "gpseg")
+			config = utils.HookConfig{
+				utils.PRE_BACKUP_HOOK:  {ExecutablePath: "/home/gpadmin/pause_etl.sh"},
+				utils.POST_BACKUP_HOOK: {ExecutablePath: "/home/gpadmin/notify.sh", Scopes: []utils.PluginScope{utils.SEGMENT}},
+			}
+			gplog.SetErrorCode(0)
+		})
+		It("runs the hook on master with its arguments in the environment", func() {
+			config.RunHook(testCluster, utils.PRE_BACKUP_HOOK, testFPInfo, "test'db", "")
+			Expect(testExecutor.LocalCommands).To(Equal([]string{"source /usr/local/greenplum-db/greenplum_path.sh && " +
+				`GPBACKUP_BACKUP_DIR='/backups/gpseg-1/backups/20170101/20170101010101' GPBACKUP_CONTENT_ID='-1' GPBACKUP_DBNAME='test'\''db' ` +
+				`GPBACKUP_HOOK='pre-backup' GPBACKUP_SCOPE='master' GPBACKUP_TIMESTAMP='20170101010101' /home/gpadmin/pause_etl.sh`}))
+			Expect(testExecutor.ClusterCommands).To(BeEmpty())
+		})
+		It("runs the hook for each segment with the status of the run", func() {
+			config.RunHook(testCluster, utils.POST_BACKUP_HOOK, testFPInfo, "testdb", "success")
+			Expect(testExecutor.LocalCommands).To(HaveLen(1))
+			Expect(testExecutor.ClusterCommands).To(HaveLen(1))
+			command := testExecutor.ClusterCommands[0][1]
+			Expect(command[len(command)-1]).To(Equal("source /usr/local/greenplum-db/greenplum_path.sh && " +
+				`GPBACKUP_BACKUP_DIR='/backups/gpseg1/backups/20170101/20170101010101' GPBACKUP_CONTENT_ID='1' GPBACKUP_DBNAME='testdb' ` +
+				`GPBACKUP_HOOK='post-backup' GPBACKUP_SCOPE='segment' GPBACKUP_STATUS='success' GPBACKUP_TIMESTAMP='20170101010101' /home/gpadmin/notify.sh`))
+		})
+		It("does nothing for a hook that is not configured", func() {
+			config.RunHook(testCluster, utils.ON_FAILURE_HOOK, testFPInfo, "testdb", "failure")
+			Expect(testExecutor.NumExecutions).To(Equal(0))
+			Expect(testExecutor.ClusterCommands).To(BeEmpty())
+		})
+		It("panics if a hook run before the backup fails", func() {
+			testExecutor.LocalError = errors.New("exit status 1")
+			testExecutor.LocalOutput = "Unable to pause ETL"
+			defer testhelper.ShouldPanicWithMessage("Unable to pause ETL")
+			config.RunHook(testCluster, utils.PRE_BACKUP_HOOK, testFPInfo, "testdb", "")
+		})
+		It("logs an error if a hook run after the backup fails", func() {
+			testExecutor.ClusterOutput = &cluster.RemoteOutput{NumErrors: 1, Errors: map[int]error{0: errors.New("exit status 1")}}
+			config.RunHook(testCluster, utils.POST_BACKUP_HOOK, testFPInfo, "testdb", "success")
+			Expect(gplog.GetErrorCode()).To(Equal(1))
+			Expect(string(logfile.Contents())).To(ContainSubstring("Unable to execute post-backup hook: /home/gpadmin/notify.sh, on: segment"))
+		})
+	})
+})
@@ -13,7 +13,6 @@ import (
 
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
-	"github.com/greenplum-db/gp-common-go-libs/iohelper"
 	"github.com/greenplum-db/gp-common-go-libs/operating"
 )
 
@@ -35,6 +34,16 @@ func QuoteIdent(connectionPool *dbconn.DBConn, ident string) string {
 	return dbconn.MustSelectString(connectionPool, fmt.Sprintf(`SELECT quote_ident('%s')`, EscapeSingleQuotes(ident)))
 }
 
+/*
+ * Quotes an identifier without a database connection, for output that is not
+ * executed by gpbackup or gprestore.  Unlike quote_ident, the identifier is
+ * always quoted, since the set of keywords that must be quoted depends on the
+ * server version.
+ */
+func DoubleQuoteIdent(ident string) string {
+	return fmt.Sprintf(`"%s"`, strings.Replace(ident, `"`, `""`, -1))
+}
+
 func SliceToQuotedString(slice []string) string {
 	quotedStrings := make([]string, len(slice))
 	for i, str := range slice {
@@ -98,14 +107,17 @@ func NewFileWithByteCount(writer io.Writer) *FileWithByteCount {
 	return &FileWithByteCount{"", writer, nil, 0}
 }
 
+// ByteCount is always the number of bytes written before any encryption
 func NewFileWithByteCountFromFile(filename string) *FileWithByteCount {
-	file := iohelper.MustOpenFileForWriting(filename)
+	file := MustOpenMetadataFileForWriting(filename)
 	return &FileWithByteCount{filename, file, file, 0}
 }
 
 func (file *FileWithByteCount) Close() {
 	if file.closer != nil {
-		_ = file.closer.Close()
+		// Closing an encrypted file writes its final chunk, so an error means the file is incomplete
+		err := file.closer.Close()
+		gplog.FatalOnError(err, "Unable to close file")
 		if file.Filename != "" {
 			err := operating.System.Chmod(file.Filename, 0444)
 			gplog.FatalOnError(err)
@@ -11,9 +11,18 @@ import (
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
 )
 
 var _ = Describe("utils/io tests", func() {
+	Describe("DoubleQuoteIdent", func() {
+		It("quotes an identifier", func() {
+			Expect(utils.DoubleQuoteIdent(`test`)).To(Equal(`"test"`))
+		})
+		It("escapes double quotes in an identifier", func() {
+			Expect(utils.DoubleQuoteIdent(`"Test`)).To(Equal(`"""Test"`))
+		})
+	})
 	Describe("UnquoteIdent", func() {
 		It("returns unchanged ident when passed a single char", func() {
 			dbname := `a`
@@ -89,7 +98,7 @@ var _ = Describe("utils/io tests", func() {
 				return nil
 			}
 			operating.System.OpenFileWrite = func(name string, flag int, perm os.FileMode) (io.WriteCloser, error) {
-				return &os.File{}, nil
+				return gbytes.NewBuffer(), nil
 			}
 		})
 		AfterEach(func() {
@@ -104,16 +113,24 @@ var _ = Describe("utils/io tests", func() {
 			file = utils.NewFileWithByteCountFromFile("")
 			file.Close()
 			Expect(wasCalled).To(BeFalse())
-			defer testhelper.ShouldPanicWithMessage("invalid memory address or nil pointer dereference")
+			defer testhelper.ShouldPanicWithMessage("attempt to write to closed buffer")
 			file.MustPrintf("message")
 		})
 		It("closes the FileWithByteCount and makes it read-only if it has a filename", func() {
 			file = utils.NewFileWithByteCountFromFile("testfile")
 			file.Close()
 			Expect(wasCalled).To(BeTrue())
-			defer testhelper.ShouldPanicWithMessage("invalid memory address or nil pointer dereference")
+			defer testhelper.ShouldPanicWithMessage("attempt to write to closed buffer")
 			file.MustPrintf("message")
 		})
+		It("panics if the file cannot be closed", func() {
+			operating.System.OpenFileWrite = func(name string, flag int, perm os.FileMode) (io.WriteCloser, error) {
+				return &os.File{}, nil
+			}
+			file = utils.NewFileWithByteCountFromFile("testfile")
+			defer testhelper.ShouldPanicWithMessage("Unable to close file")
+			file.Close()
+		})
 	})
 	Describe("CopyFile", func() {
 		var sourceFilePath = "/tmp/test_file.txt"
@@ -0,0 +1,256 @@
+package utils
+
+/*
+ * This file contains functions for writing metrics about a backup or restore
+ * in the Prometheus text format, either to a file read by the textfile
+ * collector of the node exporter or to a Pushgateway.
+ */
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/backup_filepath"
+	"github.com/pkg/errors"
+)
+
+/*
+ * The size of each segment's backup directory, which holds only the data files
+ * of the backup.  Sizes are not gathered when the data was sent to a plugin.
+ */
+func GetBackupSizeOnSegments(c *cluster.Cluster, fpInfo backup_filepath.FilePathInfo) map[int]int64 {
+	remoteOutput := c.GenerateAndExecuteCommand("Getting backup size on segments", func(contentID int) string {
+		return fmt.Sprintf("du -sb %s | cut -f1", fpInfo.GetDirForContent(contentID))
+	}, cluster.ON_SEGMENTS)
+	sizes := make(map[int]int64, 0)
+	for contentID, stdout := range remoteOutput.Stdouts {
+		if remoteOutput.Errors[contentID] != nil {
+			gplog.Verbose("Unable to get backup size on segment %d: %s", contentID, remoteOutput.Stderrs[contentID])
+			continue
+		}
+		size, err := strconv.ParseInt(strings.TrimSpace(stdout), 10, 64)
+		if err == nil {
+			sizes[contentID] = size
+		}
+	}
+	return sizes
+}
+
+type metric struct {
+	name   string
+	help   string
+	labels [][2]string
+	value  float64
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(value)
+}
+
+func (m metric) String() string {
+	labels := make([]string, 0, len(m.labels))
+	for _, label := range m.labels {
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, label[0], escapeLabelValue(label[1])))
+	}
+	return fmt.Sprintf("%s{%s} %s", m.name, strings.Join(labels, ","), strconv.FormatFloat(m.value, 'f', -1, 64))
+}
+
+func lastSuccessMetricName(utility string) string {
+	return fmt.Sprintf("%s_last_success_timestamp_seconds", utility)
+}
+
+/*
+ * Every metric is labeled with the unquoted name of the database.  The time of
+ * the last success is only included when the run did not fail, so that the
+ * time of an earlier success is kept.
+ */
+func GetMetrics(report *JSONReport, segmentBytes map[int]int64) string {
+	prefix := report.Utility
+	database := [2]string{"database", UnquoteIdent(report.DatabaseName)}
+	var rowsCopied int64
+	for _, table := range report.Tables {
+		rowsCopied += table.RowsCopied
+	}
+	endTime, _ := time.ParseInLocation("2006-01-02 15:04:05", report.EndTime, operating.System.Local)
+
+	metrics := []metric{
+		{prefix + "_duration_seconds", "Duration of the run", [][2]string{database}, report.DurationSeconds},
+	}
+	for _, section := range report.Sections {
+		metrics = append(metrics, metric{prefix + "_section_duration_seconds", "Duration of each section of the run",
+			[][2]string{database, {"section", section.Section}}, section.DurationSeconds})
+	}
+	contentIDs := make([]int, 0, len(segmentBytes))
+	for contentID := range segmentBytes {
+		contentIDs = append(contentIDs, contentID)
+	}
+	sort.Ints(contentIDs)
+	for _, contentID := range contentIDs {
+		metrics = append(metrics, metric{prefix + "_segment_bytes", "Bytes of data written on each segment",
+			[][2]string{database, {"segment", strconv.Itoa(contentID)}}, float64(segmentBytes[contentID])})
+	}
+	metrics = append(metrics,
+		metric{prefix + "_tables", "Number of tables whose data was copied", [][2]string{database}, float64(len(report.Tables))},
+		metric{prefix + "_rows", "Number of rows copied", [][2]string{database}, float64(rowsCopied)},
+		metric{prefix + "_errors", "Number of errors encountered", [][2]string{database}, float64(report.ErrorCount)},
+	)
+	metrics = append(metrics, getStatusMetrics(prefix, database, report.ExitCode, endTime)...)
+	return formatMetrics(metrics)
+}
+
+func getStatusMetrics(prefix string, database [2]string, exitCode int, endTime time.Time) []metric {
+	metrics := []metric{
+		{prefix + "_exit_code", "Exit code of the run", [][2]string{database}, float64(exitCode)},
+		{prefix + "_last_run_timestamp_seconds", "Time at which the last run ended", [][2]string{database}, float64(endTime.Unix())},
+	}
+	if exitCode != 2 {
+		metrics = append(metrics, metric{lastSuccessMetricName(prefix), "Time at which the last successful run ended", [][2]string{database}, float64(endTime.Unix())})
+	}
+	return metrics
+}
+
+/*
+ * Only the exit code and the times of the run are known when a run stops
+ * before its report is written, such as when it fails during setup or is
+ * canceled.
+ */
+func GetStatusMetrics(utility string, databaseName string, exitCode int, endTime time.Time) string {
+	return formatMetrics(getStatusMetrics(utility, [2]string{"database", UnquoteIdent(databaseName)}, exitCode, endTime))
+}
+
+func formatMetrics(metrics []metric) string {
+	var buffer bytes.Buffer
+	lastName := ""
+	for _, m := range metrics {
+		if m.name != lastName {
+			buffer.WriteString(fmt.Sprintf("# HELP %s %s\n# TYPE %s gauge\n", m.name, m.help, m.name))
+			lastName = m.name
+		}
+		buffer.WriteString(m.String() + "\n")
+	}
+	return buffer.String()
+}
+
+// Returns the name and labels of a sample line, or false for a line that has no value
+func getSampleSeries(line string) (string, bool) {
+	valueStart := strings.LastIndex(line, " ")
+	if valueStart == -1 {
+		return "", false
+	}
+	return line[:valueStart], true
+}
+
+/*
+ * The file only holds the metrics of the latest run, except that the time of
+ * the last success of every database is kept, so that one file can be shared
+ * by the runs for several databases.  The file is written under a temporary
+ * name and renamed, as the textfile collector may read it at any time.
+ */
+func WriteMetricsFile(filename string, utility string, metrics string) error {
+	lastSuccessName := lastSuccessMetricName(utility)
+	hasLastSuccess := make(map[string]bool, 0)
+	for _, line := range strings.Split(metrics, "\n") {
+		if series, ok := getSampleSeries(line); ok && strings.HasPrefix(line, lastSuccessName+"{") {
+			hasLastSuccess[series] = true
+		}
+	}
+	previousLines := make([]string, 0)
+	if previousFile, err := os.Open(filename); err == nil {
+		scanner := bufio.NewScanner(previousFile)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if series, ok := getSampleSeries(line); ok && strings.HasPrefix(line, lastSuccessName+"{") && !hasLastSuccess[series] {
+				previousLines = append(previousLines, line)
+			}
+		}
+		_ = previousFile.Close()
+	}
+	if len(previousLines) > 0 {
+		if len(hasLastSuccess) == 0 {
+			metrics += fmt.Sprintf("# HELP %s Time at which the last successful run ended\n# TYPE %s gauge\n", lastSuccessName, lastSuccessName)
+		}
+		metrics += strings.Join(previousLines, "\n") + "\n"
+	}
+
+	tempFile, err := os.Create(filepath.Join(filepath.Dir(filename), fmt.Sprintf(".%s.tmp", filepath.Base(filename))))
+	if err != nil {
+		return err
+	}
+	_, err = tempFile.WriteString(metrics)
+	if err != nil {
+		_ = tempFile.Close()
+		return err
+	}
+	err = tempFile.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tempFile.Name(), filename)
+}
+
+/*
+ * The metrics are grouped by utility and database.  They are sent with POST,
+ * which only replaces the metrics of the group that are sent, so the time of
+ * the last success is kept when a run fails.
+ */
+func PushMetrics(gatewayURL string, utility string, database string, metrics string) error {
+	database = UnquoteIdent(database)
+	databaseLabel := "database/" + url.PathEscape(database)
+	if strings.Contains(database, "/") || database == "" {
+		databaseLabel = "database@base64/" + base64.RawURLEncoding.EncodeToString([]byte(database))
+	}
+	pushURL := fmt.Sprintf("%s/metrics/job/%s/%s", strings.TrimRight(gatewayURL, "/"), utility, databaseLabel)
+	client := &http.Client{Timeout: 30 * time.Second}
+	response, err := client.Post(pushURL, "text/plain; version=0.0.4", strings.NewReader(metrics))
+	if err != nil {
+		return err
+	}
+	_ = response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return errors.Errorf("Pushgateway at %s returned status %s", pushURL, response.Status)
+	}
+	return nil
+}
+
+// Failures are only logged as warnings, as they do not affect the backup or restore itself
+func WriteMetrics(report *JSONReport, segmentBytes map[int]int64, metricsFile string, metricsURL string) {
+	if metricsFile == "" && metricsURL == "" {
+		return
+	}
+	writeMetrics(GetMetrics(report, segmentBytes), report.Utility, report.DatabaseName, metricsFile, metricsURL)
+}
+
+func WriteStatusMetrics(utility string, databaseName string, exitCode int, metricsFile string, metricsURL string) {
+	if metricsFile == "" && metricsURL == "" {
+		return
+	}
+	writeMetrics(GetStatusMetrics(utility, databaseName, exitCode, operating.System.Now()), utility, databaseName, metricsFile, metricsURL)
+}
+
+func writeMetrics(metrics string, utility string, databaseName string, metricsFile string, metricsURL string) {
+	if metricsFile != "" {
+		err := WriteMetricsFile(metricsFile, utility, metrics)
+		if err != nil {
+			gplog.Warn("Unable to write metrics file %s: %v", metricsFile, err)
+		}
+	}
+	if metricsURL != "" {
+		err := PushMetrics(metricsURL, utility, databaseName, metrics)
+		if err != nil {
+			gplog.Warn("Unable to push metrics to %s: %v", metricsURL, err)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package utils_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/backup_filepath"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/metrics tests", func() {
+	var report *utils.JSONReport
+	BeforeEach(func() {
+		report = &utils.JSONReport{
+			Utility:         "gpbackup",
+			DatabaseName:    `"test""db"`,
+			EndTime:         "2017-01-01 05:04:03",
+			DurationSeconds: 14582,
+			ExitCode:        0,
+			Sections:        []utils.SectionTiming{{Section: "predata", DurationSeconds: 1.5}, {Section: "data", DurationSeconds: 14580}},
+			Tables:          []utils.TableReport{{Schema: "public", Name: "foo", RowsCopied: 10}, {Schema: "public", Name: "bar", RowsCopied: 32}},
+		}
+	})
+	Describe("GetBackupSizeOnSegments", func() {
+		It("returns the size of the backup directory on each segment that reported one", func() {
+			testExecutor := &testhelper.TestExecutor{ClusterOutput: &cluster.RemoteOutput{
+				NumErrors: 1,
+				Stdouts:   map[int]string{0: "1024\n", 1: ""},
+				Stderrs:   map[int]string{1: "du: cannot access"},
+				Errors:    map[int]error{1: errors.New("exit status 1")},
+			}}
+			testCluster := cluster.NewCluster([]cluster.SegConfig{
+				{ContentID: -1, Hostname: "localhost", DataDir: "/data/gpseg-1"},
+				{ContentID: 0, Hostname: "localhost", DataDir: "/data/gpseg0"},
+				{ContentID: 1, Hostname: "remotehost1", DataDir: "/data/gpseg1"},
+			})
+			testCluster.Executor = testExecutor
+			fpInfo := backup_filepath.NewFilePathInfo(testCluster, "", "20170101010101", "gpseg")
+
+			Expect(utils.GetBackupSizeOnSegments(testCluster, fpInfo)).To(Equal(map[int]int64{0: 1024}))
+			command := testExecutor.ClusterCommands[0][0]
+			Expect(command[len(command)-1]).To(Equal("du -sb /data/gpseg0/backups/20170101/20170101010101 | cut -f1"))
+		})
+	})
+	Describe("GetMetrics", func() {
+		It("writes gauges for the run labeled with the unquoted database name", func() {
+			endTime := time.Date(2017, 1, 1, 5, 4, 3, 0, time.Local).Unix()
+			Expect(utils.GetMetrics(report, map[int]int64{1: 2048, 0: 1024})).To(Equal(fmt.Sprintf(`# HELP gpbackup_duration_seconds Duration of the run
+# TYPE gpbackup_duration_seconds gauge
+gpbackup_duration_seconds{database="test\"db"} 14582
+# HELP gpbackup_section_duration_seconds Duration of each section of the run
+# TYPE gpbackup_section_duration_seconds gauge
+gpbackup_section_duration_seconds{database="test\"db",section="predata"} 1.5
+gpbackup_section_duration_seconds{database="test\"db",section="data"} 14580
+# HELP gpbackup_segment_bytes Bytes of data written on each segment
+# TYPE gpbackup_segment_bytes gauge
+gpbackup_segment_bytes{database="test\"db",segment="0"} 1024
+gpbackup_segment_bytes{database="test\"db",segment="1"} 2048
+# HELP gpbackup_tables Number of tables whose data was copied
+# TYPE gpbackup_tables gauge
+gpbackup_tables{database="test\"db"} 2
+# HELP gpbackup_rows Number of rows copied
+# TYPE gpbackup_rows gauge
+gpbackup_rows{database="test\"db"} 42
+# HELP gpbackup_errors Number of errors encountered
+# TYPE gpbackup_errors gauge
+gpbackup_errors{database="test\"db"} 0
+# HELP gpbackup_exit_code Exit code of the run
+# TYPE gpbackup_exit_code gauge
+gpbackup_exit_code{database="test\"db"} 0
+# HELP gpbackup_last_run_timestamp_seconds Time at which the last run ended
+# TYPE gpbackup_last_run_timestamp_seconds gauge
+gpbackup_last_run_timestamp_seconds{database="test\"db"} %d
+# HELP gpbackup_last_success_timestamp_seconds Time at which the last successful run ended
+# TYPE gpbackup_last_success_timestamp_seconds gauge
+gpbackup_last_success_timestamp_seconds{database="test\"db"} %d
+`, endTime, endTime)))
+		})
+		It("does not include the time of the last success for a failed run", func() {
+			report.ExitCode = 2
+			report.ErrorCount = 1
+			metrics := utils.GetMetrics(report, nil)
+			Expect(metrics).To(ContainSubstring(`gpbackup_errors{database="test\"db"} 1`))
+			Expect(metrics).ToNot(ContainSubstring("gpbackup_last_success_timestamp_seconds"))
+			Expect(metrics).ToNot(ContainSubstring("gpbackup_segment_bytes"))
+		})
+	})
+	Describe("GetStatusMetrics", func() {
+		It("writes only the exit code and times of a failed run", func() {
+			endTime := time.Date(2017, 1, 1, 5, 4, 3, 0, time.Local)
+			Expect(utils.GetStatusMetrics("gprestore", "testdb", 2, endTime)).To(Equal(fmt.Sprintf(`# HELP gprestore_exit_code Exit code of the run
+# TYPE gprestore_exit_code gauge
+gprestore_exit_code{database="testdb"} 2
+# HELP gprestore_last_run_timestamp_seconds Time at which the last run ended
+# TYPE gprestore_last_run_timestamp_seconds gauge
+gprestore_last_run_timestamp_seconds{database="testdb"} %d
+`, endTime.Unix())))
+		})
+	})
+	Describe("WriteMetricsFile", func() {
+		var (
+			tempDir     string
+			metricsFile string
+		)
+		BeforeEach(func() {
+			tempDir, _ = ioutil.TempDir("", "metrics")
+			metricsFile = filepath.Join(tempDir, "gpbackup.prom")
+		})
+		AfterEach(func() {
+			_ = os.RemoveAll(tempDir)
+		})
+		It("writes the metrics to the file", func() {
+			metrics := utils.GetMetrics(report, nil)
+			Expect(utils.WriteMetricsFile(metricsFile, "gpbackup", metrics)).To(Succeed())
+			contents, _ := ioutil.ReadFile(metricsFile)
+			Expect(string(contents)).To(Equal(metrics))
+		})
+		It("keeps the time of the last success of each database that did not succeed in this run", func() {
+			_ = ioutil.WriteFile(metricsFile, []byte(`gpbackup_exit_code{database="otherdb"} 0
+gpbackup_last_success_timestamp_seconds{database="otherdb"} 1483000000
+gpbackup_last_success_timestamp_seconds{database="test\"db"} 1483000001
+`), 0644)
+			report.ExitCode = 2
+			metrics := utils.GetMetrics(report, nil)
+			Expect(utils.WriteMetricsFile(metricsFile, "gpbackup", metrics)).To(Succeed())
+			contents, _ := ioutil.ReadFile(metricsFile)
+			Expect(string(contents)).To(Equal(metrics + `# HELP gpbackup_last_success_timestamp_seconds Time at which the last successful run ended
+# TYPE gpbackup_last_success_timestamp_seconds gauge
+gpbackup_last_success_timestamp_seconds{database="otherdb"} 1483000000
+gpbackup_last_success_timestamp_seconds{database="test\"db"} 1483000001
+`))
+		})
+		It("replaces the time of the last success of the database for a successful run", func() {
+			_ = ioutil.WriteFile(metricsFile, []byte(`gpbackup_last_success_timestamp_seconds{database="otherdb"} 1483000000
+gpbackup_last_success_timestamp_seconds{database="test\"db"} 1483000001
+`), 0644)
+			metrics := utils.GetMetrics(report, nil)
+			Expect(utils.WriteMetricsFile(metricsFile, "gpbackup", metrics)).To(Succeed())
+			contents, _ := ioutil.ReadFile(metricsFile)
+			Expect(string(contents)).To(Equal(metrics + `gpbackup_last_success_timestamp_seconds{database="otherdb"} 1483000000
+`))
+		})
+		It("drops lines of the previous file that have no value", func() {
+			_ = ioutil.WriteFile(metricsFile, []byte(`gpbackup_last_success_timestamp_seconds{database="otherdb"}
+gpbackup_last_success_timestamp_seconds{database="thirddb"} 1483000000
+`), 0644)
+			metrics := utils.GetMetrics(report, nil)
+			Expect(utils.WriteMetricsFile(metricsFile, "gpbackup", metrics)).To(Succeed())
+			contents, _ := ioutil.ReadFile(metricsFile)
+			Expect(string(contents)).To(Equal(metrics + `gpbackup_last_success_timestamp_seconds{database="thirddb"} 1483000000
+`))
+		})
+	})
+	Describe("PushMetrics", func() {
+		var (
+			requestMethod string
+			requestPath   string
+			requestBody   string
+			status        int
+			server        *httptest.Server
+		)
+		BeforeEach(func() {
+			status = http.StatusOK
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				requestMethod, requestPath, requestBody = r.Method, r.URL.EscapedPath(), string(body)
+				w.WriteHeader(status)
+			}))
+		})
+		AfterEach(func() {
+			server.Close()
+		})
+		It("posts the metrics to the group for the utility and database", func() {
+			Expect(utils.PushMetrics(server.URL+"/", "gpbackup", `"test db"`, "gpbackup_exit_code 0\n")).To(Succeed())
+			Expect(requestMethod).To(Equal("POST"))
+			Expect(requestPath).To(Equal("/metrics/job/gpbackup/database/test%20db"))
+			Expect(requestBody).To(Equal("gpbackup_exit_code 0\n"))
+		})
+		It("encodes a database name containing a slash", func() {
+			Expect(utils.PushMetrics(server.URL, "gprestore", "test/db", "")).To(Succeed())
+			Expect(requestPath).To(Equal("/metrics/job/gprestore/database@base64/dGVzdC9kYg"))
+		})
+		It("returns an error if the Pushgateway rejects the metrics", func() {
+			status = http.StatusBadRequest
+			err := utils.PushMetrics(server.URL, "gpbackup", "testdb", "")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("returned status 400 Bad Request"))
+		})
+	})
+})
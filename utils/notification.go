@@ -0,0 +1,293 @@
+package utils
+
+/*
+ * This file contains structs and functions for sending notifications of the
+ * outcome of a backup or restore, as configured in gp_email_contacts.yaml.
+ */
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/iohelper"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+/*
+ * Contacts, webhooks, and commands are listed per utility, and each is only
+ * notified of the exit statuses set to true in its Status map.  Email is sent
+ * through the SMTP server if one is configured, or else through sendmail.
+ */
+type ContactFile struct {
+	Contacts map[string][]EmailContact
+	SMTP     *SMTPConfig                 `yaml:"smtp,omitempty"`
+	Webhooks map[string][]WebhookContact `yaml:"webhooks,omitempty"`
+	Commands map[string][]CommandContact `yaml:"commands,omitempty"`
+}
+
+type EmailContact struct {
+	Address string
+	Status  map[string]bool
+}
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	From     string
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+}
+
+type WebhookContact struct {
+	URL    string `yaml:"url"`
+	Status map[string]bool
+}
+
+type CommandContact struct {
+	Command string
+	Status  map[string]bool
+}
+
+/*
+ * The outcome of a backup or restore, which is passed to each notifier.
+ * Status is one of the statuses returned by GetExitStatus.
+ */
+type Notification struct {
+	Utility            string
+	Timestamp          string
+	Status             string
+	ReportFilePath     string
+	JSONReportFilePath string
+	JSONReport         *JSONReport
+}
+
+type Notifier interface {
+	Notify(notification Notification) error
+	String() string
+}
+
+func ReadContactFile(filename string) (*ContactFile, error) {
+	contactFile := &ContactFile{}
+	contents, err := operating.System.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	err = yaml.Unmarshal(contents, contactFile)
+	if err != nil {
+		return nil, err
+	}
+	return contactFile, nil
+}
+
+func (contactFile *ContactFile) getEmailAddresses(utility string, status string) []string {
+	addresses := make([]string, 0)
+	for _, contact := range contactFile.Contacts[utility] {
+		if contact.Status[status] {
+			addresses = append(addresses, contact.Address)
+		}
+	}
+	return addresses
+}
+
+// Returns the notifiers for the utility that should be notified of the given status
+func (contactFile *ContactFile) GetNotifiers(c *cluster.Cluster, utility string, status string) []Notifier {
+	notifiers := make([]Notifier, 0)
+	if addresses := contactFile.getEmailAddresses(utility, status); len(addresses) > 0 {
+		if contactFile.SMTP != nil {
+			notifiers = append(notifiers, SMTPNotifier{Config: *contactFile.SMTP, Addresses: addresses})
+		} else {
+			notifiers = append(notifiers, SendmailNotifier{Cluster: c, Addresses: addresses})
+		}
+	}
+	for _, webhook := range contactFile.Webhooks[utility] {
+		if webhook.Status[status] {
+			notifiers = append(notifiers, WebhookNotifier{URL: webhook.URL})
+		}
+	}
+	for _, command := range contactFile.Commands[utility] {
+		if command.Status[status] {
+			notifiers = append(notifiers, CommandNotifier{Cluster: c, Command: command.Command})
+		}
+	}
+	return notifiers
+}
+
+func GetContacts(filename string, utility string) string {
+	contactFile, err := ReadContactFile(filename)
+	if err != nil {
+		gplog.Warn("Unable to send email report: Error reading email contacts file.")
+		gplog.Warn("Please ensure that the email contacts file is in valid YAML format.")
+		return ""
+	}
+	return strings.Join(contactFile.getEmailAddresses(utility, GetExitStatus()), " ")
+}
+
+func getEmailSubject(utility string, timestamp string) string {
+	hostname, _ := operating.System.Hostname()
+	return fmt.Sprintf("%s %s on %s completed", utility, timestamp, hostname)
+}
+
+func ConstructEmailMessage(timestamp string, contactList string, reportFilePath string, utility string) string {
+	emailHeader := fmt.Sprintf(`To: %s
+Subject: %s
+Content-Type: text/html
+Content-Disposition: inline
+<html>
+<body>
+<pre style=\"font: monospace\">
+`, contactList, getEmailSubject(utility, timestamp))
+	emailFooter := `
+</pre>
+</body>
+</html>`
+	fileContents := strings.Join(iohelper.MustReadLinesFromFile(reportFilePath), "\n")
+	return emailHeader + fileContents + emailFooter
+}
+
+type SendmailNotifier struct {
+	Cluster   *cluster.Cluster
+	Addresses []string
+}
+
+func (notifier SendmailNotifier) String() string {
+	return "sendmail"
+}
+
+func (notifier SendmailNotifier) Notify(notification Notification) error {
+	contactList := strings.Join(notifier.Addresses, " ")
+	message := ConstructEmailMessage(notification.Timestamp, contactList, notification.ReportFilePath, notification.Utility)
+	gplog.Verbose("Sending email report to the following addresses: %s", contactList)
+	output, err := notifier.Cluster.ExecuteLocalCommand(fmt.Sprintf(`echo "%s" | sendmail -t`, message))
+	if err != nil {
+		return errors.New(output)
+	}
+	return nil
+}
+
+type SMTPNotifier struct {
+	Config    SMTPConfig
+	Addresses []string
+}
+
+func (notifier SMTPNotifier) String() string {
+	return fmt.Sprintf("SMTP server %s", notifier.Config.Host)
+}
+
+func ConstructSMTPMessage(from string, addresses []string, timestamp string, reportFilePath string, utility string) string {
+	header := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/html; charset=UTF-8\r\n\r\n",
+		from, strings.Join(addresses, ", "), getEmailSubject(utility, timestamp))
+	fileContents := strings.Join(iohelper.MustReadLinesFromFile(reportFilePath), "\r\n")
+	return header + "<html>\r\n<body>\r\n<pre style=\"font: monospace\">\r\n" + fileContents + "\r\n</pre>\r\n</body>\r\n</html>\r\n"
+}
+
+// The port defaults to 25, and the server is only authenticated to if a username is given
+func (notifier SMTPNotifier) Notify(notification Notification) error {
+	port := notifier.Config.Port
+	if port == 0 {
+		port = 25
+	}
+	var auth smtp.Auth
+	if notifier.Config.Username != "" {
+		auth = smtp.PlainAuth("", notifier.Config.Username, notifier.Config.Password, notifier.Config.Host)
+	}
+	message := ConstructSMTPMessage(notifier.Config.From, notifier.Addresses, notification.Timestamp, notification.ReportFilePath, notification.Utility)
+	gplog.Verbose("Sending email report through %s to the following addresses: %s", notifier.Config.Host, strings.Join(notifier.Addresses, " "))
+	return smtp.SendMail(fmt.Sprintf("%s:%d", notifier.Config.Host, port), auth, notifier.Config.From, notifier.Addresses, []byte(message))
+}
+
+type WebhookNotifier struct {
+	URL string
+}
+
+func (notifier WebhookNotifier) String() string {
+	return fmt.Sprintf("webhook %s", notifier.URL)
+}
+
+// The JSON report is sent as the body of the request
+func (notifier WebhookNotifier) Notify(notification Notification) error {
+	body, err := json.Marshal(notification.JSONReport)
+	if err != nil {
+		return err
+	}
+	gplog.Verbose("Posting %s report to %s", notification.Utility, notifier.URL)
+	client := &http.Client{Timeout: 30 * time.Second}
+	response, err := client.Post(notifier.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	_ = response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return errors.Errorf("Webhook at %s returned status %s", notifier.URL, response.Status)
+	}
+	return nil
+}
+
+type CommandNotifier struct {
+	Cluster *cluster.Cluster
+	Command string
+}
+
+func (notifier CommandNotifier) String() string {
+	return fmt.Sprintf("command %s", notifier.Command)
+}
+
+// The command is run on master with the outcome of the run in its environment
+func (notifier CommandNotifier) Notify(notification Notification) error {
+	command := fmt.Sprintf("export GPBACKUP_UTILITY=%s GPBACKUP_TIMESTAMP=%s GPBACKUP_STATUS=%s GPBACKUP_REPORT=%s GPBACKUP_JSON_REPORT=%s && %s",
+		shellQuote(notification.Utility), shellQuote(notification.Timestamp), shellQuote(notification.Status),
+		shellQuote(notification.ReportFilePath), shellQuote(notification.JSONReportFilePath), notifier.Command)
+	gplog.Verbose("Running notification command %s", notifier.Command)
+	output, err := notifier.Cluster.ExecuteLocalCommand(command)
+	if err != nil {
+		return errors.Errorf("%v: %s", err, strings.TrimSpace(output))
+	}
+	return nil
+}
+
+/*
+ * Notifications are sent according to $HOME/gp_email_contacts.yaml, or to
+ * $GPHOME/bin/gp_email_contacts.yaml if that does not exist.  Failures to send
+ * a notification are only logged as warnings, as they do not affect the
+ * backup or restore itself.
+ */
+func SendNotifications(c *cluster.Cluster, notification Notification) {
+	contactsFilename := "gp_email_contacts.yaml"
+	gphomeFile := fmt.Sprintf("%s/bin/%s", operating.System.Getenv("GPHOME"), contactsFilename)
+	homeFile := fmt.Sprintf("%s/%s", operating.System.Getenv("HOME"), contactsFilename)
+	_, homeErr := c.ExecuteLocalCommand(fmt.Sprintf("test -f %s", homeFile))
+	if homeErr != nil {
+		_, gphomeErr := c.ExecuteLocalCommand(fmt.Sprintf("test -f %s", gphomeFile))
+		if gphomeErr != nil {
+			gplog.Info("Found neither %s nor %s", gphomeFile, homeFile)
+			gplog.Info("No notifications of %s report %s will be sent", notification.Utility, notification.ReportFilePath)
+			return
+		}
+		contactsFilename = gphomeFile
+	} else {
+		contactsFilename = homeFile
+	}
+	gplog.Info("%s list found, %s will be sent", contactsFilename, notification.ReportFilePath)
+	contactFile, err := ReadContactFile(contactsFilename)
+	if err != nil {
+		gplog.Warn("Unable to send email report: Error reading email contacts file.")
+		gplog.Warn("Please ensure that the email contacts file is in valid YAML format.")
+		return
+	}
+
+	notification.Status = GetExitStatus()
+	for _, notifier := range contactFile.GetNotifiers(c, notification.Utility, notification.Status) {
+		err := notifier.Notify(notification)
+		if err != nil {
+			gplog.Warn("Unable to send %s report through %s: %v", notification.Utility, notifier, err)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package utils_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/backup_filepath"
+	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/notification tests", func() {
+	Describe("Notification functions", func() {
+		reportFileContents := []byte(`Greenplum Database Backup Report
+
+Timestamp Key: 20170101010101`)
+		contactsFileContents, _ := yaml.Marshal(utils.ContactFile{
+			Contacts: map[string][]utils.EmailContact{
+				"gpbackup": {
+					{Address: "contact1@example.com",
+						Status: map[string]bool{
+							"success":             true,
+							"success_with_errors": true,
+							"failure":             false,
+						}},
+					{Address: "contact2@example.org",
+						Status: map[string]bool{
+							"success":             false,
+							"success_with_errors": true,
+							"failure":             true,
+						}},
+				},
+				"gprestore": {
+					{Address: "contact3@example.com"},
+					{Address: "contact4@example.org",
+						Status: map[string]bool{
+							"success":             true,
+							"success_with_errors": true,
+							"failure":             true,
+						}},
+				},
+			}})
+		contactsList := "contact1@example.com contact2@example.org"
+
+		var (
+			testExecutor *testhelper.TestExecutor
+			testCluster  *cluster.Cluster
+			testFPInfo   backup_filepath.FilePathInfo
+			notification utils.Notification
+			w            *os.File
+			r            *os.File
+		)
+		BeforeEach(func() {
+			r, w, _ = os.Pipe()
+			testCluster = testutils.SetDefaultSegmentConfiguration()
+			testFPInfo = backup_filepath.NewFilePathInfo(testCluster, "", "20170101010101", "gpseg")
+			notification = utils.Notification{Utility: "gpbackup", Timestamp: testFPInfo.Timestamp, ReportFilePath: "report_file"}
+			operating.System.OpenFileRead = func(name string, flag int, perm os.FileMode) (operating.ReadCloserAt, error) { return r, nil }
+			operating.System.ReadFile = func(filename string) ([]byte, error) { return ioutil.ReadAll(r) }
+			operating.System.Hostname = func() (string, error) { return "localhost", nil }
+			operating.System.Getenv = func(key string) string {
+				if key == "HOME" {
+					return "home"
+				} else {
+					return "gphome"
+				}
+			}
+			testExecutor = &testhelper.TestExecutor{}
+			testCluster.Executor = testExecutor
+			gplog.SetErrorCode(0)
+		})
+		AfterEach(func() {
+			operating.InitializeSystemFunctions()
+			gplog.SetErrorCode(0)
+		})
+		Context("GetContacts", func() {
+			contactsFilename := fmt.Sprintf("%s/bin/gp_email_contacts.yaml", operating.System.Getenv("GPHOME"))
+			It("Gets a list of gpbackup contacts on success", func() {
+				gplog.SetErrorCode(0)
+				w.Write(contactsFileContents)
+				w.Close()
+
+				contacts := utils.GetContacts(contactsFilename, "gpbackup")
+				Expect(contacts).To(Equal("contact1@example.com"))
+			})
+			It("Gets a list of gpbackup contacts on success with errors", func() {
+				gplog.SetErrorCode(1)
+				w.Write(contactsFileContents)
+				w.Close()
+
+				contacts := utils.GetContacts(contactsFilename, "gpbackup")
+				Expect(contacts).To(Equal("contact1@example.com contact2@example.org"))
+			})
+			It("Gets a list of gpbackup contacts on failure", func() {
+				gplog.SetErrorCode(2)
+				w.Write(contactsFileContents)
+				w.Close()
+
+				contacts := utils.GetContacts(contactsFilename, "gpbackup")
+				Expect(contacts).To(Equal("contact2@example.org"))
+			})
+			It("Gets a list of gprestore contacts and doesn't fail when no status specified", func() {
+				gplog.SetErrorCode(0)
+				w.Write(contactsFileContents)
+				w.Close()
+
+				contacts := utils.GetContacts(contactsFilename, "gprestore")
+				Expect(contacts).To(Equal("contact4@example.org"))
+			})
+		})
+		Context("ConstructEmailMessage", func() {
+			It("adds HTML formatting to the contents of the report file", func() {
+				w.Write(reportFileContents)
+				w.Close()
+
+				message := utils.ConstructEmailMessage(testFPInfo.Timestamp, contactsList, "report_file", "gpbackup")
+				expectedMessage := `To: contact1@example.com contact2@example.org
+Subject: gpbackup 20170101010101 on localhost completed
+Content-Type: text/html
+Content-Disposition: inline
+<html>
+<body>
+<pre style=\"font: monospace\">
+Greenplum Database Backup Report
+
+Timestamp Key: 20170101010101
+</pre>
+</body>
+</html>`
+				Expect(message).To(Equal(expectedMessage))
+			})
+		})
+		Context("EmailReport", func() {
+			var (
+				expectedHomeCmd   = "test -f home/gp_email_contacts.yaml"
+				expectedGpHomeCmd = "test -f gphome/bin/gp_email_contacts.yaml"
+				expectedMessage   = `echo "To: contact1@example.com
+Subject: gpbackup 20170101010101 on localhost completed
+Content-Type: text/html
+Content-Disposition: inline
+<html>
+<body>
+<pre style=\"font: monospace\">
+
+</pre>
+</body>
+</html>" | sendmail -t`
+			)
+			It("sends no email and raises a warning if no gp_email_contacts.yaml file is found", func() {
+				w.Write(contactsFileContents)
+				w.Close()
+
+				testExecutor.LocalError = errors.Errorf("exit status 2")
+
+				utils.SendNotifications(testCluster, notification)
+				Expect(testExecutor.NumExecutions).To(Equal(2))
+				Expect(testExecutor.LocalCommands).To(Equal([]string{expectedHomeCmd, expectedGpHomeCmd}))
+				Expect(stdout).To(gbytes.Say("Found neither gphome/bin/gp_email_contacts.yaml nor home/gp_email_contacts.yaml"))
+			})
+			It("sends an email to contacts in $HOME/gp_email_contacts.yaml if only that file is found", func() {
+				w.Write(contactsFileContents)
+				w.Close()
+
+				testExecutor.ErrorOnExecNum = 2 // Shouldn't hit this case, as it shouldn't be executed a second time
+				testExecutor.LocalError = errors.Errorf("exit status 2")
+
+				utils.SendNotifications(testCluster, notification)
+				Expect(testExecutor.NumExecutions).To(Equal(2))
+				Expect(testExecutor.LocalCommands).To(Equal([]string{expectedHomeCmd, expectedMessage}))
+				Expect(logfile).To(gbytes.Say("Sending email report to the following addresses: contact1@example.com"))
+			})
+			It("sends an email to contacts in $GPHOME/bin/gp_email_contacts.yaml if only that file is found", func() {
+				w.Write(contactsFileContents)
+				w.Close()
+
+				testExecutor.ErrorOnExecNum = 1
+				testExecutor.LocalError = errors.Errorf("exit status 2")
+
+				utils.SendNotifications(testCluster, notification)
+				Expect(testExecutor.NumExecutions).To(Equal(3))
+				Expect(testExecutor.LocalCommands).To(Equal([]string{expectedHomeCmd, expectedGpHomeCmd, expectedMessage}))
+				Expect(logfile).To(gbytes.Say("Sending email report to the following addresses: contact1@example.com"))
+			})
+			It("sends an email to contacts in $HOME/gp_email_contacts.yaml if a file exists in both $HOME and $GPHOME/bin", func() {
+				w.Write(contactsFileContents)
+				w.Close()
+
+				utils.SendNotifications(testCluster, notification)
+				Expect(testExecutor.NumExecutions).To(Equal(2))
+				Expect(testExecutor.LocalCommands).To(Equal([]string{expectedHomeCmd, expectedMessage}))
+				Expect(logfile).To(gbytes.Say("Sending email report to the following addresses: contact1@example.com"))
+			})
+		})
+		Context("GetNotifiers", func() {
+			contactFile := utils.ContactFile{
+				Contacts: map[string][]utils.EmailContact{
+					"gpbackup": {{Address: "contact1@example.com", Status: map[string]bool{"success": true}}},
+				},
+				Webhooks: map[string][]utils.WebhookContact{
+					"gpbackup":  {{URL: "http://localhost/hook", Status: map[string]bool{"failure": true}}},
+					"gprestore": {{URL: "http://localhost/restore_hook", Status: map[string]bool{"success": true}}},
+				},
+				Commands: map[string][]utils.CommandContact{
+					"gpbackup": {{Command: "/home/gpadmin/notify.sh", Status: map[string]bool{"success": true, "failure": true}}},
+				},
+			}
+			It("returns sendmail and the commands to notify of a success", func() {
+				Expect(contactFile.GetNotifiers(testCluster, "gpbackup", "success")).To(Equal([]utils.Notifier{
+					utils.SendmailNotifier{Cluster: testCluster, Addresses: []string{"contact1@example.com"}},
+					utils.CommandNotifier{Cluster: testCluster, Command: "/home/gpadmin/notify.sh"},
+				}))
+			})
+			It("returns the webhooks and commands to notify of a failure", func() {
+				Expect(contactFile.GetNotifiers(testCluster, "gpbackup", "failure")).To(Equal([]utils.Notifier{
+					utils.WebhookNotifier{URL: "http://localhost/hook"},
+					utils.CommandNotifier{Cluster: testCluster, Command: "/home/gpadmin/notify.sh"},
+				}))
+			})
+			It("sends email through the SMTP server if one is configured", func() {
+				smtpContactFile := contactFile
+				smtpContactFile.SMTP = &utils.SMTPConfig{Host: "smtp.example.com", From: "gpadmin@example.com"}
+				Expect(smtpContactFile.GetNotifiers(testCluster, "gpbackup", "success")[0]).To(Equal(utils.SMTPNotifier{
+					Config: utils.SMTPConfig{Host: "smtp.example.com", From: "gpadmin@example.com"}, Addresses: []string{"contact1@example.com"},
+				}))
+			})
+		})
+		Context("ConstructSMTPMessage", func() {
+			It("adds headers and HTML formatting to the contents of the report file", func() {
+				w.Write(reportFileContents)
+				w.Close()
+
+				message := utils.ConstructSMTPMessage("gpadmin@example.com", []string{"contact1@example.com", "contact2@example.org"}, testFPInfo.Timestamp, "report_file", "gpbackup")
+				Expect(message).To(Equal("From: gpadmin@example.com\r\nTo: contact1@example.com, contact2@example.org\r\n" +
+					"Subject: gpbackup 20170101010101 on localhost completed\r\nMIME-Version: 1.0\r\nContent-Type: text/html; charset=UTF-8\r\n\r\n" +
+					"<html>\r\n<body>\r\n<pre style=\"font: monospace\">\r\nGreenplum Database Backup Report\r\n\r\nTimestamp Key: 20170101010101\r\n</pre>\r\n</body>\r\n</html>\r\n"))
+			})
+		})
+		Context("CommandNotifier", func() {
+			It("runs the command with the outcome of the run in its environment", func() {
+				notification.Status = "failure"
+				notification.JSONReportFilePath = "json_report_file"
+				err := utils.CommandNotifier{Cluster: testCluster, Command: "/home/gpadmin/notify.sh"}.Notify(notification)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(testExecutor.LocalCommands).To(Equal([]string{"export GPBACKUP_UTILITY='gpbackup' GPBACKUP_TIMESTAMP='20170101010101' " +
+					"GPBACKUP_STATUS='failure' GPBACKUP_REPORT='report_file' GPBACKUP_JSON_REPORT='json_report_file' && /home/gpadmin/notify.sh"}))
+			})
+			It("returns an error containing the output of a failed command", func() {
+				testExecutor.LocalError = errors.New("exit status 1")
+				testExecutor.LocalOutput = "notify.sh: not found\n"
+				err := utils.CommandNotifier{Cluster: testCluster, Command: "notify.sh"}.Notify(notification)
+				Expect(err).To(MatchError("exit status 1: notify.sh: not found"))
+			})
+		})
+		Context("WebhookNotifier", func() {
+			var (
+				requestBody        []byte
+				requestContentType string
+				status             int
+				server             *httptest.Server
+			)
+			BeforeEach(func() {
+				status = http.StatusOK
+				requestBody = nil
+				server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					requestBody, _ = ioutil.ReadAll(r.Body)
+					requestContentType = r.Header.Get("Content-Type")
+					w.WriteHeader(status)
+				}))
+				notification.JSONReport = &utils.JSONReport{Utility: "gpbackup", Timestamp: "20170101010101", Status: "failure", ExitCode: 2}
+			})
+			AfterEach(func() {
+				server.Close()
+			})
+			It("posts the JSON report", func() {
+				Expect(utils.WebhookNotifier{URL: server.URL}.Notify(notification)).To(Succeed())
+				Expect(requestContentType).To(Equal("application/json"))
+				report := utils.JSONReport{}
+				Expect(json.Unmarshal(requestBody, &report)).To(Succeed())
+				Expect(report).To(Equal(*notification.JSONReport))
+			})
+			It("returns an error if the webhook rejects the report", func() {
+				status = http.StatusInternalServerError
+				err := utils.WebhookNotifier{URL: server.URL}.Notify(notification)
+				Expect(err).To(MatchError(fmt.Sprintf("Webhook at %s returned status 500 Internal Server Error", server.URL)))
+			})
+			It("is notified of a failure configured in the contacts file", func() {
+				gplog.SetErrorCode(2)
+				w.Write([]byte(fmt.Sprintf("webhooks:\n  gpbackup:\n  - url: %s\n    status:\n      failure: true\n", server.URL)))
+				w.Close()
+
+				utils.SendNotifications(testCluster, notification)
+				Expect(testExecutor.LocalCommands).To(Equal([]string{"test -f home/gp_email_contacts.yaml"}))
+				Expect(string(requestBody)).To(ContainSubstring(`"ExitCode":2`))
+			})
+		})
+	})
+})
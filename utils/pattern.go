@@ -0,0 +1,198 @@
+package utils
+
+/*
+ * This file contains functions for resolving the glob or regular expression
+ * patterns given to the pattern filter flags into lists of names.
+ */
+
+import (
+	"bytes"
+	"encoding/csv"
+	"regexp"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+)
+
+const (
+	GLOB_PATTERN  = "glob"
+	REGEX_PATTERN = "regex"
+)
+
+/*
+ * Glob patterns support *, ? and bracketed character classes, negated with !
+ * or ^, and any other character may be escaped with a backslash.
+ */
+func globToRegex(glob string) string {
+	var regex strings.Builder
+	inClass := false
+	for i := 0; i < len(glob); i++ {
+		char := glob[i]
+		switch {
+		case inClass:
+			if char == ']' {
+				inClass = false
+			} else if char == '\\' {
+				regex.WriteByte('\\')
+			}
+			regex.WriteByte(char)
+		case char == '*':
+			regex.WriteString(".*")
+		case char == '?':
+			regex.WriteString(".")
+		case char == '[' && strings.Contains(glob[i+1:], "]"):
+			inClass = true
+			regex.WriteByte('[')
+			if i+1 < len(glob) && (glob[i+1] == '!' || glob[i+1] == '^') {
+				regex.WriteByte('^')
+				i++
+			}
+		case char == '\\' && i+1 < len(glob):
+			i++
+			regex.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			regex.WriteString(regexp.QuoteMeta(string(char)))
+		}
+	}
+	return regex.String()
+}
+
+// Patterns must match the whole name, whether they are globs or regular expressions
+func CompilePatterns(patterns []string, patternType string) ([]*regexp.Regexp, error) {
+	if patternType != GLOB_PATTERN && patternType != REGEX_PATTERN {
+		return nil, errors.Errorf("Invalid pattern type %s.  Valid values are %s and %s.", patternType, GLOB_PATTERN, REGEX_PATTERN)
+	}
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		expression := pattern
+		if patternType == GLOB_PATTERN {
+			expression = globToRegex(pattern)
+		}
+		regex, err := regexp.Compile("^(?:" + expression + ")$")
+		if err != nil {
+			return nil, errors.Errorf("Invalid %s pattern %s: %v", patternType, pattern, err)
+		}
+		compiled = append(compiled, regex)
+	}
+	return compiled, nil
+}
+
+/*
+ * Returns the names that match any of the patterns, in the order given, along
+ * with the patterns that match none of the names.
+ */
+func MatchPatterns(names []string, patterns []string, patternType string) ([]string, []string, error) {
+	compiled, err := CompilePatterns(patterns, patternType)
+	if err != nil {
+		return nil, nil, err
+	}
+	matchedNames := make([]string, 0)
+	patternMatched := make([]bool, len(patterns))
+	for _, name := range names {
+		nameMatched := false
+		for i, regex := range compiled {
+			if regex.MatchString(name) {
+				nameMatched = true
+				patternMatched[i] = true
+			}
+		}
+		if nameMatched {
+			matchedNames = append(matchedNames, name)
+		}
+	}
+	unmatchedPatterns := make([]string, 0)
+	for i, pattern := range patterns {
+		if !patternMatched[i] {
+			unmatchedPatterns = append(unmatchedPatterns, pattern)
+		}
+	}
+	return matchedNames, unmatchedPatterns, nil
+}
+
+var patternFlags = []string{INCLUDE_SCHEMA_PATTERN, EXCLUDE_SCHEMA_PATTERN, INCLUDE_RELATION_PATTERN, EXCLUDE_RELATION_PATTERN}
+
+// Checks the pattern type and the syntax of the patterns before anything is resolved
+func ValidatePatternFlags(flags *pflag.FlagSet) error {
+	patternType, err := flags.GetString(PATTERN_TYPE)
+	if err != nil {
+		return err
+	}
+	for _, flagName := range patternFlags {
+		patterns, err := flags.GetStringArray(flagName)
+		if err != nil {
+			return err
+		}
+		_, err = CompilePatterns(patterns, patternType)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+ * Returns the names that match the patterns given to the pattern flag.  As
+ * with a name given individually, a pattern that matches nothing is an error
+ * for an include flag but only a warning for an exclude flag.
+ */
+func ResolvePatternFlag(flags *pflag.FlagSet, patternFlag string, names []string, nameType string, isExclude bool) []string {
+	patterns, err := flags.GetStringArray(patternFlag)
+	gplog.FatalOnError(err)
+	if len(patterns) == 0 {
+		return []string{}
+	}
+	patternType, err := flags.GetString(PATTERN_TYPE)
+	gplog.FatalOnError(err)
+	matchedNames, unmatchedPatterns, err := MatchPatterns(names, patterns, patternType)
+	gplog.FatalOnError(err)
+	if len(unmatchedPatterns) > 0 {
+		if isExclude {
+			gplog.Warn("No %ss match the following excluded pattern(s): %s", nameType, strings.Join(unmatchedPatterns, ", "))
+		} else {
+			gplog.Fatal(errors.Errorf("No %ss match the following pattern(s): %s", nameType, strings.Join(unmatchedPatterns, ", ")), "")
+		}
+	}
+	gplog.Verbose("The pattern(s) given to --%s match %d %s(s)", patternFlag, len(matchedNames), nameType)
+	return matchedNames
+}
+
+/*
+ * Adds the values to a string slice or string array flag, after any values
+ * already given and skipping those already present.  A string slice flag
+ * parses each value set as a line of CSV, so the values are CSV-encoded to
+ * preserve any quotes or commas in quoted identifiers.
+ */
+func AppendToFlag(flags *pflag.FlagSet, flagName string, values []string) error {
+	flag := flags.Lookup(flagName)
+	var existingValues []string
+	var err error
+	if flag.Value.Type() == "stringArray" {
+		existingValues, err = flags.GetStringArray(flagName)
+	} else {
+		existingValues, err = flags.GetStringSlice(flagName)
+	}
+	if err != nil {
+		return err
+	}
+	existingSet := NewSet(existingValues)
+	for _, value := range values {
+		if existingSet.MatchesFilter(value) {
+			continue
+		}
+		existingSet.Set[value] = true
+		if flag.Value.Type() == "stringSlice" {
+			buffer := &bytes.Buffer{}
+			writer := csv.NewWriter(buffer)
+			_ = writer.Write([]string{value})
+			writer.Flush()
+			value = strings.TrimSuffix(buffer.String(), "\n")
+		}
+		err = flags.Set(flagName, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
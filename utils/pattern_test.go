@@ -0,0 +1,107 @@
+package utils_test
+
+import (
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/spf13/pflag"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/pattern tests", func() {
+	Describe("MatchPatterns", func() {
+		names := []string{"public.sales", "public.sales_2017", "public.staging_1", "sch1.tmp_a", "sch1.tmp_ab"}
+		It("matches glob patterns against whole names", func() {
+			matched, unmatched, err := utils.MatchPatterns(names, []string{"public.sales*", "sch1.tmp_?"}, utils.GLOB_PATTERN)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(matched).To(Equal([]string{"public.sales", "public.sales_2017", "sch1.tmp_a"}))
+			Expect(unmatched).To(BeEmpty())
+		})
+		It("matches glob character classes and negated character classes", func() {
+			matched, _, err := utils.MatchPatterns(names, []string{"public.staging_[0-9]", "sch1.tmp_[!a]*"}, utils.GLOB_PATTERN)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(matched).To(Equal([]string{"public.staging_1"}))
+		})
+		It("treats regular expression metacharacters in glob patterns literally", func() {
+			matched, unmatched, err := utils.MatchPatterns([]string{"public.sales", "publicXsales"}, []string{"public.sales", "public+"}, utils.GLOB_PATTERN)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(matched).To(Equal([]string{"public.sales"}))
+			Expect(unmatched).To(Equal([]string{"public+"}))
+		})
+		It("matches regular expressions against whole names", func() {
+			matched, unmatched, err := utils.MatchPatterns(names, []string{`public\.sales_\d{4}`, "sales", `sch1\.tmp_a|sch1\.tmp_ab`}, utils.REGEX_PATTERN)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(matched).To(Equal([]string{"public.sales_2017", "sch1.tmp_a", "sch1.tmp_ab"}))
+			Expect(unmatched).To(Equal([]string{"sales"}))
+		})
+		It("returns an error for an invalid pattern type", func() {
+			_, _, err := utils.MatchPatterns(names, []string{"public.*"}, "like")
+			Expect(err).To(MatchError("Invalid pattern type like.  Valid values are glob and regex."))
+		})
+		It("returns an error for an invalid regular expression", func() {
+			_, _, err := utils.MatchPatterns(names, []string{"public.(sales"}, utils.REGEX_PATTERN)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("Invalid regex pattern public.(sales:"))
+		})
+	})
+	Describe("pattern flags", func() {
+		var flagSet *pflag.FlagSet
+		BeforeEach(func() {
+			flagSet = pflag.NewFlagSet("testFlags", pflag.ContinueOnError)
+			flagSet.StringArray(utils.INCLUDE_RELATION, []string{}, "")
+			flagSet.StringSlice(utils.EXCLUDE_RELATION, []string{}, "")
+			flagSet.StringArray(utils.INCLUDE_RELATION_PATTERN, []string{}, "")
+			flagSet.StringArray(utils.EXCLUDE_RELATION_PATTERN, []string{}, "")
+			flagSet.StringArray(utils.INCLUDE_SCHEMA_PATTERN, []string{}, "")
+			flagSet.StringArray(utils.EXCLUDE_SCHEMA_PATTERN, []string{}, "")
+			flagSet.String(utils.PATTERN_TYPE, utils.GLOB_PATTERN, "")
+		})
+		Context("ValidatePatternFlags", func() {
+			It("returns an error for an invalid pattern given to any pattern flag", func() {
+				_ = flagSet.Set(utils.PATTERN_TYPE, utils.REGEX_PATTERN)
+				_ = flagSet.Set(utils.EXCLUDE_SCHEMA_PATTERN, "tmp_[")
+				err := utils.ValidatePatternFlags(flagSet)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(HavePrefix("Invalid regex pattern tmp_[:"))
+			})
+		})
+		Context("ResolvePatternFlag", func() {
+			names := []string{"public.foo", "public.bar", "sch1.foo"}
+			It("returns nothing if no patterns are given", func() {
+				Expect(utils.ResolvePatternFlag(flagSet, utils.INCLUDE_RELATION_PATTERN, names, "table", false)).To(BeEmpty())
+			})
+			It("returns the names matching the patterns", func() {
+				_ = flagSet.Set(utils.INCLUDE_RELATION_PATTERN, "*.foo")
+				Expect(utils.ResolvePatternFlag(flagSet, utils.INCLUDE_RELATION_PATTERN, names, "table", false)).To(Equal([]string{"public.foo", "sch1.foo"}))
+			})
+			It("panics if an included pattern matches nothing", func() {
+				_ = flagSet.Set(utils.INCLUDE_RELATION_PATTERN, "*.foo")
+				_ = flagSet.Set(utils.INCLUDE_RELATION_PATTERN, "*.baz")
+				defer testhelper.ShouldPanicWithMessage("No tables match the following pattern(s): *.baz")
+				utils.ResolvePatternFlag(flagSet, utils.INCLUDE_RELATION_PATTERN, names, "table", false)
+			})
+			It("warns if an excluded pattern matches nothing", func() {
+				_ = flagSet.Set(utils.EXCLUDE_RELATION_PATTERN, "*.baz")
+				Expect(utils.ResolvePatternFlag(flagSet, utils.EXCLUDE_RELATION_PATTERN, names, "table", true)).To(BeEmpty())
+				testhelper.ExpectRegexp(logfile, "[WARNING]:-No tables match the following excluded pattern(s): *.baz")
+			})
+		})
+		Context("AppendToFlag", func() {
+			It("appends values to a string array flag after those already given", func() {
+				_ = flagSet.Set(utils.INCLUDE_RELATION, "public.foo")
+				err := utils.AppendToFlag(flagSet, utils.INCLUDE_RELATION, []string{"public.foo", "sch1.foo,bar"})
+				Expect(err).ToNot(HaveOccurred())
+				includes, _ := flagSet.GetStringArray(utils.INCLUDE_RELATION)
+				Expect(includes).To(Equal([]string{"public.foo", "sch1.foo,bar"}))
+			})
+			It("preserves quotes and commas when appending to a string slice flag", func() {
+				_ = flagSet.Set(utils.EXCLUDE_RELATION, "public.foo")
+				err := utils.AppendToFlag(flagSet, utils.EXCLUDE_RELATION, []string{`public."Foo, ""Bar"""`, "public.foo"})
+				Expect(err).ToNot(HaveOccurred())
+				excludes, _ := flagSet.GetStringSlice(utils.EXCLUDE_RELATION)
+				Expect(excludes).To(Equal([]string{"public.foo", `public."Foo, ""Bar"""`}))
+			})
+		})
+	})
+})
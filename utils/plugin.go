@@ -81,6 +81,15 @@ func (plugin *PluginConfig) MustRestoreFile(filenamePath string) {
 	gplog.FatalOnError(err, string(output))
 }
 
+func (plugin *PluginConfig) DeleteBackup(timestamp string) error {
+	command := fmt.Sprintf("%s delete_backup %s %s", plugin.ExecutablePath, plugin.ConfigPath, timestamp)
+	output, err := exec.Command("bash", "-c", command).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Plugin failed to delete backup %s. %s", timestamp, string(output))
+	}
+	return nil
+}
+
 func (plugin *PluginConfig) CheckPluginExistsOnAllHosts(c *cluster.Cluster) string {
 	plugin.checkPluginAPIVersion(c)
 
@@ -210,13 +219,27 @@ func (plugin *PluginConfig) CleanupPluginForRestore(c *cluster.Cluster, fpInfo b
 
 func (plugin *PluginConfig) executeHook(c *cluster.Cluster, verboseCommandMsg string,
 	command string, fpInfo backup_filepath.FilePathInfo, noFatal bool) {
+	buildCommand := func(scope PluginScope, contentID int) string {
+		return plugin.buildHookString(command, fpInfo, scope, contentID)
+	}
+	buildErrorMsg := func(scope PluginScope) string {
+		errorMsg, _ := plugin.buildHookErrorMsgAndFunc(command, scope)
+		return errorMsg
+	}
+	executeOnScopes(c, verboseCommandMsg, []PluginScope{MASTER, SEGMENT_HOST, SEGMENT}, buildCommand, buildErrorMsg, noFatal)
+}
+
+/*
+ * The command is always executed once on master, and then once on each segment
+ * host and once for each segment if those scopes are given.  If noFatal is set,
+ * failures are logged as errors, and a failure on master skips the remaining
+ * scopes.
+ */
+func executeOnScopes(c *cluster.Cluster, verboseCommandMsg string, scopes []PluginScope,
+	buildCommand func(PluginScope, int) string, buildErrorMsg func(PluginScope) string, noFatal bool) {
 	// Execute command once on master
-	scope := MASTER
-	hookFunc := plugin.buildHookFunc(command, fpInfo, scope)
-	verboseErrorMsg, errorMsgFunc := plugin.buildHookErrorMsgAndFunc(command, scope)
 	masterContentID := -1
-	masterOutput, masterErr := c.ExecuteLocalCommand(plugin.buildHookString(command,
-		fpInfo, scope, masterContentID))
+	masterOutput, masterErr := c.ExecuteLocalCommand(buildCommand(MASTER, masterContentID))
 	if masterErr != nil {
 		if noFatal {
 			gplog.Error(masterOutput)
@@ -225,28 +248,37 @@ func (plugin *PluginConfig) executeHook(c *cluster.Cluster, verboseCommandMsg st
 		gplog.Fatal(masterErr, masterOutput)
 	}
 
-	// Execute command once on each segment host
-	scope = SEGMENT_HOST
-	hookFunc = plugin.buildHookFunc(command, fpInfo, scope)
-	verboseErrorMsg, errorMsgFunc = plugin.buildHookErrorMsgAndFunc(command, scope)
-	verboseCommandHostMasterMsg := fmt.Sprintf(verboseCommandMsg, "segment hosts")
-	remoteOutput := c.GenerateAndExecuteCommand(verboseCommandHostMasterMsg, hookFunc, cluster.ON_HOSTS)
-	c.CheckClusterError(remoteOutput, verboseErrorMsg, errorMsgFunc, noFatal)
-
-	// Execute command once for each segment
-	scope = SEGMENT
-	hookFunc = plugin.buildHookFunc(command, fpInfo, scope)
-	verboseErrorMsg, errorMsgFunc = plugin.buildHookErrorMsgAndFunc(command, scope)
-	verboseCommandSegMsg := fmt.Sprintf(verboseCommandMsg, "segments")
-	remoteOutput = c.GenerateAndExecuteCommand(verboseCommandSegMsg, hookFunc, cluster.ON_SEGMENTS)
-	c.CheckClusterError(remoteOutput, verboseErrorMsg, errorMsgFunc, noFatal)
+	for _, scope := range []PluginScope{SEGMENT_HOST, SEGMENT} {
+		if !scopesInclude(scopes, scope) {
+			continue
+		}
+		scope := scope
+		hookFunc := func(contentID int) string {
+			return buildCommand(scope, contentID)
+		}
+		verboseErrorMsg := buildErrorMsg(scope)
+		errorMsgFunc := func(contentID int) string {
+			return verboseErrorMsg
+		}
+		// Execute command once on each segment host, or once for each segment
+		executeScope := cluster.ON_HOSTS
+		scopeMsg := "segment hosts"
+		if scope == SEGMENT {
+			executeScope = cluster.ON_SEGMENTS
+			scopeMsg = "segments"
+		}
+		remoteOutput := c.GenerateAndExecuteCommand(fmt.Sprintf(verboseCommandMsg, scopeMsg), hookFunc, executeScope)
+		c.CheckClusterError(remoteOutput, verboseErrorMsg, errorMsgFunc, noFatal)
+	}
 }
 
-func (plugin *PluginConfig) buildHookFunc(command string,
-	fpInfo backup_filepath.FilePathInfo, scope PluginScope) func(int) string {
-	return func(contentID int) string {
-		return plugin.buildHookString(command, fpInfo, scope, contentID)
+func scopesInclude(scopes []PluginScope, scope PluginScope) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
 	}
+	return false
 }
 
 func (plugin *PluginConfig) buildHookString(command string,
@@ -370,17 +402,9 @@ func GetSecretKey(pluginName string, mdd string) (string, error) {
 
 }
 
+// The segment TOC files must already have been written, see WaitForSegmentTOCs
 func (plugin *PluginConfig) BackupSegmentTOCs(c *cluster.Cluster, fpInfo backup_filepath.FilePathInfo) {
-	remoteOutput := c.GenerateAndExecuteCommand("Waiting for remaining data to be uploaded to plugin destination", func(contentID int) string {
-		tocFile := fpInfo.GetSegmentTOCFilePath(contentID)
-		errorFile := fmt.Sprintf("%s_error", fpInfo.GetSegmentPipeFilePath(contentID))
-		return fmt.Sprintf(`while [[ ! -f "%s" && ! -f "%s" ]]; do sleep 1; done; ls "%s"`, tocFile, errorFile, tocFile)
-	}, cluster.ON_SEGMENTS)
-	c.CheckClusterError(remoteOutput, "Error occurred in gpbackup_helper", func(contentID int) string {
-		return "See gpAdminLog for gpbackup_helper on segment host for details: Error occurred with plugin"
-	})
-
-	remoteOutput = c.GenerateAndExecuteCommand("Processing segment TOC files with plugin", func(contentID int) string {
+	remoteOutput := c.GenerateAndExecuteCommand("Processing segment TOC files with plugin", func(contentID int) string {
 		tocFile := fpInfo.GetSegmentTOCFilePath(contentID)
 		return fmt.Sprintf("source %s/greenplum_path.sh && %s backup_file %s %s && chmod 0755 %s", operating.System.Getenv("GPHOME"), plugin.ExecutablePath, plugin.ConfigPath, tocFile, tocFile)
 	}, cluster.ON_SEGMENTS)
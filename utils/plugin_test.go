@@ -293,6 +293,31 @@ options:
 			Expect(err.Error()).To(Equal(fmt.Sprintf("Cannot find encryption key for plugin %s. Please re-encrypt password(s) so that key becomes available.", pluginName)))
 		})
 	})
+	Describe("DeleteBackup", func() {
+		It("calls delete_backup with the plugin config and timestamp", func() {
+			pluginPath := filepath.Join(tempDir, "delete_plugin.bash")
+			outputPath := filepath.Join(tempDir, "delete_plugin_out.txt")
+			script := fmt.Sprintf("#!/bin/bash\necho \"$@\" > %s\n", outputPath)
+			_ = ioutil.WriteFile(pluginPath, []byte(script), 0777)
+			subject.ExecutablePath = pluginPath
+
+			err := subject.DeleteBackup("20170101010101")
+
+			Expect(err).ToNot(HaveOccurred())
+			contents, _ := ioutil.ReadFile(outputPath)
+			Expect(string(contents)).To(Equal("delete_backup /tmp/my_plugin_config.yaml 20170101010101\n"))
+		})
+		It("returns an error containing the plugin output when the plugin fails", func() {
+			pluginPath := filepath.Join(tempDir, "delete_plugin.bash")
+			_ = ioutil.WriteFile(pluginPath, []byte("#!/bin/bash\necho \"backup not found\"\nexit 1\n"), 0777)
+			subject.ExecutablePath = pluginPath
+
+			err := subject.DeleteBackup("20170101010101")
+
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("Plugin failed to delete backup 20170101010101. backup not found\n"))
+		})
+	})
 	Describe("DeleteConfigFileOnSegments", func() {
 		When("config has encryption", func() {
 			It("sends the correct cluster command to delete config file", func() {
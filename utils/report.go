@@ -1,18 +1,18 @@
 package utils
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"gopkg.in/yaml.v2"
-
 	"github.com/blang/semver"
-	"github.com/greenplum-db/gp-common-go-libs/cluster"
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
 	"github.com/greenplum-db/gp-common-go-libs/iohelper"
@@ -30,6 +30,7 @@ type Report struct {
 	BackupParamsString string
 	DatabaseSize       string
 	backup_history.BackupConfig
+	SectionTimer
 }
 
 func ParseErrorMessage(errStr string) string {
@@ -104,12 +105,18 @@ func (report *Report) constructIncrementalSection() string {
 	for _, restorePlanEntry := range report.RestorePlan {
 		backupTimestamps = append(backupTimestamps, restorePlanEntry.Timestamp)
 	}
+	if report.Differential {
+		return fmt.Sprintf(`Incremental: True
+Differential: True
+Incremental Backup Set:
+%s`, strings.Join(backupTimestamps, "\n"))
+	}
 	return fmt.Sprintf(`Incremental: True
 Incremental Backup Set:
 %s`, strings.Join(backupTimestamps, "\n"))
 }
 
-func (report *Report) WriteBackupReportFile(reportFilename string, timestamp string, objectCounts map[string]int, errMsg string) {
+func (report *Report) WriteBackupReportFile(reportFilename string, timestamp string, objectCounts map[string]int, dataEntries []MasterDataEntry, errMsg string) {
 	reportFile, err := iohelper.OpenFileForWriting(reportFilename)
 	if err != nil {
 		gplog.Error("Unable to open backup report file %s", reportFilename)
@@ -154,6 +161,7 @@ Backup Status: %s
 	}
 
 	PrintObjectCounts(reportFile, objectCounts)
+	PrintTopTables(reportFile, dataEntries)
 	_ = operating.System.Chmod(reportFilename, 0444)
 }
 
@@ -200,6 +208,172 @@ Restore Status: %s`
 	_ = operating.System.Chmod(reportFilename, 0444)
 }
 
+/*
+ * The JSON report holds the same information as the text report, along with
+ * the timing of each section and the tables backed up or restored, for
+ * schedulers and monitoring tools to read.  Times are formatted as in the text
+ * report, and Status is one of the statuses returned by GetExitStatus.
+ */
+type JSONReport struct {
+	Utility         string
+	Version         string
+	Timestamp       string
+	DatabaseName    string
+	DatabaseVersion string
+	CommandLine     string
+	StartTime       string
+	EndTime         string
+	DurationSeconds float64
+	Status          string
+	ErrorMessage    string
+	ErrorCount      int
+	ExitCode        int
+	DatabaseSize    string         `json:",omitempty"`
+	ObjectCounts    map[string]int `json:",omitempty"`
+	BackupConfig    backup_history.BackupConfig
+	Sections        []SectionTiming
+	Tables          []TableReport
+}
+
+/*
+ * SizeBytes is the size of the table on disk when it was backed up, and the
+ * remaining fields are those recorded in the table's TOC entry; none of them
+ * are recorded for restores.
+ */
+type TableReport struct {
+	Schema            string
+	Name              string
+	Oid               uint32
+	RowsCopied        int64
+	SizeBytes         int64   `json:",omitempty"`
+	ElapsedSeconds    float64 `json:",omitempty"`
+	CompressedBytes   uint64  `json:",omitempty"`
+	UncompressedBytes uint64  `json:",omitempty"`
+	SegmentSkew       float64 `json:",omitempty"`
+}
+
+// A section that did not finish, such as one in progress when the utility failed, has no EndTime
+type SectionTiming struct {
+	Section         string
+	StartTime       string
+	EndTime         string
+	DurationSeconds float64
+}
+
+/*
+ * Sections are timed one at a time, in the order in which they run, so each
+ * call to FinishSection ends the section most recently started.
+ */
+type SectionTimer struct {
+	Timings      []SectionTiming
+	sectionStart time.Time
+}
+
+func (timer *SectionTimer) StartSection(section string) {
+	timer.sectionStart = operating.System.Now()
+	timer.Timings = append(timer.Timings, SectionTiming{Section: section, StartTime: timer.sectionStart.Format("2006-01-02 15:04:05")})
+}
+
+func (timer *SectionTimer) FinishSection() {
+	if len(timer.Timings) == 0 {
+		return
+	}
+	end := operating.System.Now()
+	timing := &timer.Timings[len(timer.Timings)-1]
+	timing.EndTime = end.Format("2006-01-02 15:04:05")
+	timing.DurationSeconds = end.Sub(timer.sectionStart).Seconds()
+}
+
+// Tables are restored on several connections at once, so tables may be added concurrently
+type TableReportList struct {
+	tables []TableReport
+	mutex  sync.Mutex
+}
+
+func (list *TableReportList) Add(table TableReport) {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	list.tables = append(list.tables, table)
+}
+
+func (list *TableReportList) Tables() []TableReport {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	return append([]TableReport{}, list.tables...)
+}
+
+func NewJSONReport(utility string, version string, timestamp string, startTimestamp string, errMsg string) *JSONReport {
+	startTime, _ := time.ParseInLocation("20060102150405", startTimestamp, operating.System.Local)
+	endTime := operating.System.Now()
+	return &JSONReport{
+		Utility:         utility,
+		Version:         version,
+		Timestamp:       timestamp,
+		CommandLine:     strings.Join(os.Args, " "),
+		StartTime:       startTime.Format("2006-01-02 15:04:05"),
+		EndTime:         endTime.Format("2006-01-02 15:04:05"),
+		DurationSeconds: endTime.Sub(startTime).Seconds(),
+		Status:          GetExitStatus(),
+		ErrorMessage:    errMsg,
+		ErrorCount:      GetErrorCount(errMsg),
+		ExitCode:        gplog.GetErrorCode(),
+		Sections:        make([]SectionTiming, 0),
+		Tables:          make([]TableReport, 0),
+	}
+}
+
+func (report *Report) GetBackupJSONReport(timestamp string, objectCounts map[string]int, tables []TableReport, errMsg string) *JSONReport {
+	jsonReport := NewJSONReport("gpbackup", report.BackupVersion, timestamp, timestamp, errMsg)
+	jsonReport.DatabaseName = report.DatabaseName
+	jsonReport.DatabaseVersion = report.DatabaseVersion
+	jsonReport.DatabaseSize = report.DatabaseSize
+	jsonReport.ObjectCounts = objectCounts
+	jsonReport.BackupConfig = report.BackupConfig
+	if report.Timings != nil {
+		jsonReport.Sections = report.Timings
+	}
+	if tables != nil {
+		jsonReport.Tables = tables
+	}
+	return jsonReport
+}
+
+func GetRestoreJSONReport(backupTimestamp string, startTimestamp string, connectionPool *dbconn.DBConn, restoreVersion string, backupConfig *backup_history.BackupConfig, timer *SectionTimer, tables []TableReport, errMsg string) *JSONReport {
+	jsonReport := NewJSONReport("gprestore", restoreVersion, backupTimestamp, startTimestamp, errMsg)
+	jsonReport.DatabaseName = connectionPool.DBName
+	jsonReport.DatabaseVersion = connectionPool.Version.VersionString
+	if backupConfig != nil {
+		jsonReport.BackupConfig = *backupConfig
+	}
+	if timer != nil && timer.Timings != nil {
+		jsonReport.Sections = timer.Timings
+	}
+	if tables != nil {
+		jsonReport.Tables = tables
+	}
+	return jsonReport
+}
+
+func WriteJSONReportFile(reportFilename string, report *JSONReport) {
+	contents, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		gplog.Error("Unable to create JSON report for %s: %v", reportFilename, err)
+		return
+	}
+	reportFile, err := iohelper.OpenFileForWriting(reportFilename)
+	if err != nil {
+		gplog.Error("Unable to open JSON report file %s", reportFilename)
+		return
+	}
+	_, err = fmt.Fprintf(reportFile, "%s\n", contents)
+	if err != nil {
+		gplog.Error("Unable to write JSON report file %s", reportFilename)
+		return
+	}
+	_ = reportFile.Close()
+	_ = operating.System.Chmod(reportFilename, 0444)
+}
+
 func GetDurationInfo(timestamp string, endTime time.Time) (string, string, string) {
 	startTime, _ := time.ParseInLocation("20060102150405", timestamp, operating.System.Local)
 	duration := reformatDuration(endTime.Sub(startTime))
@@ -232,6 +406,62 @@ func PrintObjectCounts(reportFile io.WriteCloser, objectCounts map[string]int) {
 	MustPrintf(reportFile, objectStr)
 }
 
+// The number of tables listed in each ranking in the backup report
+const numTopTables = 10
+
+/*
+ * Lists the tables that took longest to back up and the tables with the most
+ * data written, to help with tuning --jobs and finding skewed tables.  Tables
+ * for which no time or size was recorded are not listed.
+ */
+func PrintTopTables(reportFile io.Writer, dataEntries []MasterDataEntry) {
+	slowest := make([]MasterDataEntry, 0)
+	largest := make([]MasterDataEntry, 0)
+	for _, entry := range dataEntries {
+		if entry.ElapsedSeconds > 0 {
+			slowest = append(slowest, entry)
+		}
+		if size, _ := getDataEntrySize(entry); size > 0 {
+			largest = append(largest, entry)
+		}
+	}
+	sort.SliceStable(slowest, func(i, j int) bool { return slowest[i].ElapsedSeconds > slowest[j].ElapsedSeconds })
+	sort.SliceStable(largest, func(i, j int) bool {
+		sizeI, _ := getDataEntrySize(largest[i])
+		sizeJ, _ := getDataEntrySize(largest[j])
+		return sizeI > sizeJ
+	})
+	if len(slowest) > numTopTables {
+		slowest = slowest[:numTopTables]
+	}
+	if len(largest) > numTopTables {
+		largest = largest[:numTopTables]
+	}
+
+	if len(slowest) > 0 {
+		MustPrintf(reportFile, "\nSlowest Tables:\n")
+		for _, entry := range slowest {
+			duration := time.Duration(entry.ElapsedSeconds * float64(time.Second))
+			MustPrintf(reportFile, "%-40s %s  %d rows\n", MakeFQN(entry.Schema, entry.Name), reformatDuration(duration), entry.RowsCopied)
+		}
+	}
+	if len(largest) > 0 {
+		MustPrintf(reportFile, "\nLargest Tables:\n")
+		for _, entry := range largest {
+			size, unit := getDataEntrySize(entry)
+			MustPrintf(reportFile, "%-40s %d %s  skew %.2f\n", MakeFQN(entry.Schema, entry.Name), size, unit, entry.SegmentSkew)
+		}
+	}
+}
+
+// Only the uncompressed size of a table is recorded for a single data file backup
+func getDataEntrySize(entry MasterDataEntry) (uint64, string) {
+	if entry.UncompressedBytes > 0 {
+		return entry.UncompressedBytes, "bytes uncompressed"
+	}
+	return entry.CompressedBytes, "bytes"
+}
+
 /*
  * This function will not error out if the user has gprestore X.Y.Z
  * and gpbackup X.Y.Z+dev, when technically the uncommitted code changes
@@ -263,86 +493,29 @@ func EnsureDatabaseVersionCompatibility(backupGPDBVersion string, restoreGPDBVer
 	}
 }
 
-type ContactFile struct {
-	Contacts map[string][]EmailContact
-}
+// Statements and tables that failed to restore with --on-error-continue are counted here
+var nonFatalErrorCount int32
 
-type EmailContact struct {
-	Address string
-	Status  map[string]bool
+func AddNonFatalErrors(count int32) {
+	atomic.AddInt32(&nonFatalErrorCount, count)
 }
 
-func GetContacts(filename string, utility string) string {
-	contactFile := &ContactFile{}
-	contents, err := operating.System.ReadFile(filename)
-	gplog.FatalOnError(err)
-	err = yaml.Unmarshal(contents, contactFile)
-	if err != nil {
-		gplog.Warn("Unable to send email report: Error reading email contacts file.")
-		gplog.Warn("Please ensure that the email contacts file is in valid YAML format.")
-		return ""
-	}
-
-	errorCode := gplog.GetErrorCode()
-	exitStatus := "success"
-	if errorCode == 1 {
-		exitStatus = "success_with_errors"
-	} else if errorCode == 2 {
-		exitStatus = "failure"
-	}
-
-	contactList := make([]string, 0)
-	for _, contact := range contactFile.Contacts[utility] {
-		if contact.Status[exitStatus] {
-			contactList = append(contactList, contact.Address)
-		}
+// The error that ended the utility, if any, is counted along with the non-fatal errors
+func GetErrorCount(errMsg string) int {
+	count := int(atomic.LoadInt32(&nonFatalErrorCount))
+	if errMsg != "" {
+		count++
 	}
-	return strings.Join(contactList, " ")
+	return count
 }
 
-func ConstructEmailMessage(timestamp string, contactList string, reportFilePath string, utility string) string {
-	hostname, _ := operating.System.Hostname()
-	emailHeader := fmt.Sprintf(`To: %s
-Subject: %s %s on %s completed
-Content-Type: text/html
-Content-Disposition: inline
-<html>
-<body>
-<pre style=\"font: monospace\">
-`, contactList, utility, timestamp, hostname)
-	emailFooter := `
-</pre>
-</body>
-</html>`
-	fileContents := strings.Join(iohelper.MustReadLinesFromFile(reportFilePath), "\n")
-	return emailHeader + fileContents + emailFooter
-}
-
-func EmailReport(c *cluster.Cluster, timestamp string, reportFilePath string, utility string) {
-	contactsFilename := "gp_email_contacts.yaml"
-	gphomeFile := fmt.Sprintf("%s/bin/%s", operating.System.Getenv("GPHOME"), contactsFilename)
-	homeFile := fmt.Sprintf("%s/%s", operating.System.Getenv("HOME"), contactsFilename)
-	_, homeErr := c.ExecuteLocalCommand(fmt.Sprintf("test -f %s", homeFile))
-	if homeErr != nil {
-		_, gphomeErr := c.ExecuteLocalCommand(fmt.Sprintf("test -f %s", gphomeFile))
-		if gphomeErr != nil {
-			gplog.Info("Found neither %s nor %s", gphomeFile, homeFile)
-			gplog.Info("Email containing %s report %s will not be sent", utility, reportFilePath)
-			return
-		}
-		contactsFilename = gphomeFile
-	} else {
-		contactsFilename = homeFile
-	}
-	gplog.Info("%s list found, %s will be sent", contactsFilename, reportFilePath)
-	contactList := GetContacts(contactsFilename, utility)
-	if contactList == "" {
-		return
-	}
-	message := ConstructEmailMessage(timestamp, contactList, reportFilePath, utility)
-	gplog.Verbose("Sending email report to the following addresses: %s", contactList)
-	output, sendErr := c.ExecuteLocalCommand(fmt.Sprintf(`echo "%s" | sendmail -t`, message))
-	if sendErr != nil {
-		gplog.Warn("Unable to send email report: %s", output)
+// Returns success, success_with_errors, or failure, depending on the current error code
+func GetExitStatus() string {
+	switch gplog.GetErrorCode() {
+	case 1:
+		return "success_with_errors"
+	case 2:
+		return "failure"
 	}
+	return "success"
 }
@@ -1,32 +1,27 @@
 package utils_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"time"
 
 	"github.com/greenplum-db/gpbackup/options"
 
 	"github.com/blang/semver"
-	"github.com/greenplum-db/gp-common-go-libs/cluster"
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
 	"github.com/greenplum-db/gp-common-go-libs/operating"
 	"github.com/greenplum-db/gp-common-go-libs/structmatcher"
 	"github.com/greenplum-db/gp-common-go-libs/testhelper"
 	"github.com/greenplum-db/gpbackup/backup"
-	"github.com/greenplum-db/gpbackup/backup_filepath"
 	"github.com/greenplum-db/gpbackup/backup_history"
-	"github.com/greenplum-db/gpbackup/testutils"
 	"github.com/greenplum-db/gpbackup/utils"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/gbytes"
-	"github.com/pkg/errors"
 	"github.com/spf13/pflag"
-	"gopkg.in/yaml.v2"
 )
 
 var _ = Describe("utils/report tests", func() {
@@ -73,7 +68,7 @@ Data File Format: Single Data File Per Segment`,
 		})
 
 		It("writes a report for a successful backup", func() {
-			backupReport.WriteBackupReportFile("filename", timestamp, objectCounts, "")
+			backupReport.WriteBackupReportFile("filename", timestamp, objectCounts, nil, "")
 			Expect(buffer).To(gbytes.Say(`Greenplum Database Backup Report
 
 Timestamp Key: 20170101010101
@@ -101,7 +96,7 @@ tables                       42
 types                        1000`))
 		})
 		It("writes a report for a failed backup", func() {
-			backupReport.WriteBackupReportFile("filename", timestamp, objectCounts, "Cannot access /tmp/backups: Permission denied")
+			backupReport.WriteBackupReportFile("filename", timestamp, objectCounts, nil, "Cannot access /tmp/backups: Permission denied")
 			Expect(buffer).To(gbytes.Say(`Greenplum Database Backup Report
 
 Timestamp Key: 20170101010101
@@ -131,7 +126,7 @@ types                        1000`))
 		})
 		It("writes a report without database size information", func() {
 			backupReport.DatabaseSize = ""
-			backupReport.WriteBackupReportFile("filename", timestamp, objectCounts, "")
+			backupReport.WriteBackupReportFile("filename", timestamp, objectCounts, nil, "")
 			Expect(buffer).To(gbytes.Say(`Greenplum Database Backup Report
 
 Timestamp Key: 20170101010101
@@ -158,6 +153,54 @@ tables                       42
 types                        1000`))
 		})
 	})
+	Describe("PrintTopTables", func() {
+		It("lists the slowest and largest tables", func() {
+			dataEntries := []utils.MasterDataEntry{
+				{Schema: "public", Name: "small", RowsCopied: 10, ElapsedSeconds: 0.5, CompressedBytes: 100, SegmentSkew: 1},
+				{Schema: "public", Name: "slow", RowsCopied: 1000, ElapsedSeconds: 3723, CompressedBytes: 2000, SegmentSkew: 1.5},
+				{Schema: "public", Name: "large", RowsCopied: 500, ElapsedSeconds: 62, CompressedBytes: 100000, SegmentSkew: 2.25},
+				{Schema: "public", Name: "unknown", RowsCopied: 5},
+			}
+			utils.PrintTopTables(buffer, dataEntries)
+			Expect(string(buffer.Contents())).To(Equal(`
+Slowest Tables:
+public.slow                              1:02:03  1000 rows
+public.large                             0:01:02  500 rows
+public.small                             0:00:00  10 rows
+
+Largest Tables:
+public.large                             100000 bytes  skew 2.25
+public.slow                              2000 bytes  skew 1.50
+public.small                             100 bytes  skew 1.00
+`))
+		})
+		It("lists the uncompressed sizes of the tables of a single data file backup", func() {
+			dataEntries := []utils.MasterDataEntry{
+				{Schema: "public", Name: "small", UncompressedBytes: 100, SegmentSkew: 1},
+				{Schema: "public", Name: "large", UncompressedBytes: 100000, SegmentSkew: 2.25},
+			}
+			utils.PrintTopTables(buffer, dataEntries)
+			Expect(string(buffer.Contents())).To(Equal(`
+Largest Tables:
+public.large                             100000 bytes uncompressed  skew 2.25
+public.small                             100 bytes uncompressed  skew 1.00
+`))
+		})
+		It("lists at most ten tables in each ranking", func() {
+			dataEntries := make([]utils.MasterDataEntry, 0)
+			for i := 1; i <= 12; i++ {
+				dataEntries = append(dataEntries, utils.MasterDataEntry{Schema: "public", Name: fmt.Sprintf("table%d", i), ElapsedSeconds: float64(i)})
+			}
+			utils.PrintTopTables(buffer, dataEntries)
+			Expect(string(buffer.Contents())).To(ContainSubstring("public.table3 "))
+			Expect(string(buffer.Contents())).ToNot(ContainSubstring("public.table2 "))
+			Expect(string(buffer.Contents())).ToNot(ContainSubstring("Largest Tables"))
+		})
+		It("prints nothing if no times or sizes were recorded", func() {
+			utils.PrintTopTables(buffer, []utils.MasterDataEntry{{Schema: "public", Name: "foo"}})
+			Expect(buffer.Contents()).To(BeEmpty())
+		})
+	})
 	Describe("WriteRestoreReportFile", func() {
 		timestamp := "20170101010101"
 		restoreStartTime := "20170101010102"
@@ -240,12 +283,115 @@ Duration: 4:03:01
 Restore Status: Success but non-fatal errors occurred. See log file .+ for details.`))
 		})
 	})
+	Describe("JSON report files", func() {
+		timestamp := "20170101010101"
+		BeforeEach(func() {
+			operating.System.OpenFileWrite = func(name string, flag int, perm os.FileMode) (io.WriteCloser, error) {
+				return buffer, nil
+			}
+			operating.System.Now = func() time.Time {
+				return time.Date(2017, 1, 1, 5, 4, 3, 2, time.Local)
+			}
+			operating.System.Chmod = func(name string, mode os.FileMode) error {
+				return nil
+			}
+		})
+		AfterEach(func() {
+			gplog.SetErrorCode(0)
+		})
+		readJSONReport := func() utils.JSONReport {
+			report := utils.JSONReport{}
+			err := json.Unmarshal(buffer.Contents(), &report)
+			Expect(err).ToNot(HaveOccurred())
+			return report
+		}
+		It("writes a JSON report for a successful backup", func() {
+			backupReport := &utils.Report{
+				DatabaseSize: "42 MB",
+				BackupConfig: backup_history.BackupConfig{BackupVersion: "0.1.0", DatabaseName: "testdb", DatabaseVersion: "5.0.0 build test", Compressed: true},
+				SectionTimer: utils.SectionTimer{Timings: []utils.SectionTiming{{Section: "predata", StartTime: "2017-01-01 01:01:01", EndTime: "2017-01-01 01:01:03", DurationSeconds: 2}}},
+			}
+			tables := []utils.TableReport{{Schema: "public", Name: "foo", Oid: 1, RowsCopied: 10, SizeBytes: 32768}}
+			utils.WriteJSONReportFile("filename", backupReport.GetBackupJSONReport(timestamp, map[string]int{"tables": 42}, tables, ""))
+
+			report := readJSONReport()
+			Expect(report.Utility).To(Equal("gpbackup"))
+			Expect(report.Version).To(Equal("0.1.0"))
+			Expect(report.Timestamp).To(Equal(timestamp))
+			Expect(report.DatabaseName).To(Equal("testdb"))
+			Expect(report.DatabaseVersion).To(Equal("5.0.0 build test"))
+			Expect(report.StartTime).To(Equal("2017-01-01 01:01:01"))
+			Expect(report.EndTime).To(Equal("2017-01-01 05:04:03"))
+			Expect(report.DurationSeconds).To(BeNumerically("~", 14582, 1))
+			Expect(report.Status).To(Equal("success"))
+			Expect(report.ErrorMessage).To(Equal(""))
+			Expect(report.ExitCode).To(Equal(0))
+			Expect(report.DatabaseSize).To(Equal("42 MB"))
+			Expect(report.ObjectCounts).To(Equal(map[string]int{"tables": 42}))
+			Expect(report.BackupConfig).To(Equal(backupReport.BackupConfig))
+			Expect(report.Sections).To(Equal(backupReport.Timings))
+			Expect(report.Tables).To(Equal(tables))
+		})
+		It("writes a JSON report for a failed backup", func() {
+			gplog.SetErrorCode(2)
+			backupReport := &utils.Report{BackupConfig: backup_history.BackupConfig{BackupVersion: "0.1.0", DatabaseName: "testdb"}}
+			utils.WriteJSONReportFile("filename", backupReport.GetBackupJSONReport(timestamp, map[string]int{}, nil, "Cannot access /tmp/backups: Permission denied"))
+
+			report := readJSONReport()
+			Expect(report.Status).To(Equal("failure"))
+			Expect(report.ErrorMessage).To(Equal("Cannot access /tmp/backups: Permission denied"))
+			Expect(report.ErrorCount).To(Equal(1))
+			Expect(report.ExitCode).To(Equal(2))
+			Expect(report.Sections).To(BeEmpty())
+			Expect(report.Tables).To(BeEmpty())
+		})
+		It("writes a JSON report for a restore with errors", func() {
+			gplog.SetErrorCode(1)
+			connectionPool := &dbconn.DBConn{DBName: "restoredb", Version: dbconn.GPDBVersion{VersionString: "5.0.0 build test"}}
+			backupConfig := &backup_history.BackupConfig{BackupVersion: "0.1.0", DatabaseName: "testdb"}
+			timer := &utils.SectionTimer{}
+			timer.StartSection("data")
+			timer.FinishSection()
+			tables := []utils.TableReport{{Schema: "public", Name: "foo", Oid: 1, RowsCopied: 10}}
+			utils.WriteJSONReportFile("filename", utils.GetRestoreJSONReport(timestamp, "20170101010102", connectionPool, "0.2.0", backupConfig, timer, tables, ""))
+
+			report := readJSONReport()
+			Expect(report.Utility).To(Equal("gprestore"))
+			Expect(report.Version).To(Equal("0.2.0"))
+			Expect(report.Timestamp).To(Equal(timestamp))
+			Expect(report.DatabaseName).To(Equal("restoredb"))
+			Expect(report.StartTime).To(Equal("2017-01-01 01:01:02"))
+			Expect(report.Status).To(Equal("success_with_errors"))
+			Expect(report.ExitCode).To(Equal(1))
+			Expect(report.BackupConfig).To(Equal(*backupConfig))
+			Expect(report.Sections).To(Equal([]utils.SectionTiming{{Section: "data", StartTime: "2017-01-01 05:04:03", EndTime: "2017-01-01 05:04:03"}}))
+			Expect(report.Tables).To(Equal(tables))
+			Expect(string(buffer.Contents())).ToNot(ContainSubstring("SizeBytes"))
+		})
+	})
+	Describe("SectionTimer", func() {
+		It("records the start and end of each section", func() {
+			now := time.Date(2017, 1, 1, 1, 1, 1, 0, time.Local)
+			operating.System.Now = func() time.Time {
+				now = now.Add(90 * time.Second)
+				return now
+			}
+			timer := utils.SectionTimer{}
+			timer.StartSection("predata")
+			timer.FinishSection()
+			timer.StartSection("data")
+			Expect(timer.Timings).To(Equal([]utils.SectionTiming{
+				{Section: "predata", StartTime: "2017-01-01 01:02:31", EndTime: "2017-01-01 01:04:01", DurationSeconds: 90},
+				{Section: "data", StartTime: "2017-01-01 01:05:31"},
+			}))
+		})
+	})
 	Describe("SetBackupParamFromFlags", func() {
 		AfterEach(func() {
-			utils.InitializePipeThroughParameters(false, 0)
+			utils.InitializePipeThroughParameters(false, "", 0)
 		})
 		It("configures the Report struct correctly", func() {
-			utils.InitializePipeThroughParameters(true, 0)
+			utils.InitializePipeThroughParameters(true, "gzip", 0)
 			backupCmdFlags := pflag.NewFlagSet("gpbackup", pflag.ExitOnError)
 			backup.SetFlagDefaults(backupCmdFlags)
 			backup.SetCmdFlags(backupCmdFlags)
@@ -261,17 +407,23 @@ Restore Status: Success but non-fatal errors occurred. See log file .+ for detai
 				"5.0.0 build test", "0.1.0",
 				"/tmp/plugin.sh", "timestamp1", *opts)
 			structmatcher.ExpectStructsToMatch(backup_history.BackupConfig{
-				BackupVersion:        "0.1.0",
-				Compressed:           true,
-				DatabaseName:         "testdb",
-				DatabaseVersion:      "5.0.0 build test",
-				IncludeSchemas:       []string{},
-				IncludeRelations:     []string{"public.foobar"},
-				ExcludeSchemas:       []string{},
-				ExcludeRelations:     []string{},
-				Plugin:               "/tmp/plugin.sh",
-				Timestamp:            "timestamp1",
-				IncludeTableFiltered: true,
+				BackupVersion:           "0.1.0",
+				Checksummed:             true,
+				Compressed:              true,
+				CompressionType:         "gzip",
+				DatabaseName:            "testdb",
+				DatabaseVersion:         "5.0.0 build test",
+				IncludeSchemas:          []string{},
+				IncludeSchemaPatterns:   []string{},
+				IncludeRelations:        []string{"public.foobar"},
+				IncludeRelationPatterns: []string{},
+				ExcludeSchemas:          []string{},
+				ExcludeSchemaPatterns:   []string{},
+				ExcludeRelations:        []string{},
+				ExcludeRelationPatterns: []string{},
+				Plugin:                  "/tmp/plugin.sh",
+				Timestamp:               "timestamp1",
+				IncludeTableFiltered:    true,
 			}, backupConfig)
 		})
 	})
@@ -359,185 +511,4 @@ Restore Status: Success but non-fatal errors occurred. See log file .+ for detai
 			utils.EnsureDatabaseVersionCompatibility("5.0.6-beta.9+dev.129.g4bd4e41 build dev", restoreVersion)
 		})
 	})
-
-	Describe("Email-related functions", func() {
-		reportFileContents := []byte(`Greenplum Database Backup Report
-
-Timestamp Key: 20170101010101`)
-		contactsFileContents, _ := yaml.Marshal(utils.ContactFile{
-			Contacts: map[string][]utils.EmailContact{
-				"gpbackup": {
-					{Address: "contact1@example.com",
-						Status: map[string]bool{
-							"success":             true,
-							"success_with_errors": true,
-							"failure":             false,
-						}},
-					{Address: "contact2@example.org",
-						Status: map[string]bool{
-							"success":             false,
-							"success_with_errors": true,
-							"failure":             true,
-						}},
-				},
-				"gprestore": {
-					{Address: "contact3@example.com"},
-					{Address: "contact4@example.org",
-						Status: map[string]bool{
-							"success":             true,
-							"success_with_errors": true,
-							"failure":             true,
-						}},
-				},
-			}})
-		contactsList := "contact1@example.com contact2@example.org"
-
-		var (
-			testExecutor *testhelper.TestExecutor
-			testCluster  *cluster.Cluster
-			testFPInfo   backup_filepath.FilePathInfo
-			w            *os.File
-			r            *os.File
-		)
-		BeforeEach(func() {
-			r, w, _ = os.Pipe()
-			testCluster = testutils.SetDefaultSegmentConfiguration()
-			testFPInfo = backup_filepath.NewFilePathInfo(testCluster, "", "20170101010101", "gpseg")
-			operating.System.OpenFileRead = func(name string, flag int, perm os.FileMode) (operating.ReadCloserAt, error) { return r, nil }
-			operating.System.ReadFile = func(filename string) ([]byte, error) { return ioutil.ReadAll(r) }
-			operating.System.Hostname = func() (string, error) { return "localhost", nil }
-			operating.System.Getenv = func(key string) string {
-				if key == "HOME" {
-					return "home"
-				} else {
-					return "gphome"
-				}
-			}
-			testExecutor = &testhelper.TestExecutor{}
-			testCluster.Executor = testExecutor
-			gplog.SetErrorCode(0)
-		})
-		AfterEach(func() {
-			operating.InitializeSystemFunctions()
-			gplog.SetErrorCode(0)
-		})
-		Context("GetContacts", func() {
-			contactsFilename := fmt.Sprintf("%s/bin/gp_email_contacts.yaml", operating.System.Getenv("GPHOME"))
-			It("Gets a list of gpbackup contacts on success", func() {
-				gplog.SetErrorCode(0)
-				w.Write(contactsFileContents)
-				w.Close()
-
-				contacts := utils.GetContacts(contactsFilename, "gpbackup")
-				Expect(contacts).To(Equal("contact1@example.com"))
-			})
-			It("Gets a list of gpbackup contacts on success with errors", func() {
-				gplog.SetErrorCode(1)
-				w.Write(contactsFileContents)
-				w.Close()
-
-				contacts := utils.GetContacts(contactsFilename, "gpbackup")
-				Expect(contacts).To(Equal("contact1@example.com contact2@example.org"))
-			})
-			It("Gets a list of gpbackup contacts on failure", func() {
-				gplog.SetErrorCode(2)
-				w.Write(contactsFileContents)
-				w.Close()
-
-				contacts := utils.GetContacts(contactsFilename, "gpbackup")
-				Expect(contacts).To(Equal("contact2@example.org"))
-			})
-			It("Gets a list of gprestore contacts and doesn't fail when no status specified", func() {
-				gplog.SetErrorCode(0)
-				w.Write(contactsFileContents)
-				w.Close()
-
-				contacts := utils.GetContacts(contactsFilename, "gprestore")
-				Expect(contacts).To(Equal("contact4@example.org"))
-			})
-		})
-		Context("ConstructEmailMessage", func() {
-			It("adds HTML formatting to the contents of the report file", func() {
-				w.Write(reportFileContents)
-				w.Close()
-
-				message := utils.ConstructEmailMessage(testFPInfo.Timestamp, contactsList, "report_file", "gpbackup")
-				expectedMessage := `To: contact1@example.com contact2@example.org
-Subject: gpbackup 20170101010101 on localhost completed
-Content-Type: text/html
-Content-Disposition: inline
-<html>
-<body>
-<pre style=\"font: monospace\">
-Greenplum Database Backup Report
-
-Timestamp Key: 20170101010101
-</pre>
-</body>
-</html>`
-				Expect(message).To(Equal(expectedMessage))
-			})
-		})
-		Context("EmailReport", func() {
-			var (
-				expectedHomeCmd   = "test -f home/gp_email_contacts.yaml"
-				expectedGpHomeCmd = "test -f gphome/bin/gp_email_contacts.yaml"
-				expectedMessage   = `echo "To: contact1@example.com
-Subject: gpbackup 20170101010101 on localhost completed
-Content-Type: text/html
-Content-Disposition: inline
-<html>
-<body>
-<pre style=\"font: monospace\">
-
-</pre>
-</body>
-</html>" | sendmail -t`
-			)
-			It("sends no email and raises a warning if no gp_email_contacts.yaml file is found", func() {
-				w.Write(contactsFileContents)
-				w.Close()
-
-				testExecutor.LocalError = errors.Errorf("exit status 2")
-
-				utils.EmailReport(testCluster, testFPInfo.Timestamp, "report_file", "gpbackup")
-				Expect(testExecutor.NumExecutions).To(Equal(2))
-				Expect(testExecutor.LocalCommands).To(Equal([]string{expectedHomeCmd, expectedGpHomeCmd}))
-				Expect(stdout).To(gbytes.Say("Found neither gphome/bin/gp_email_contacts.yaml nor home/gp_email_contacts.yaml"))
-			})
-			It("sends an email to contacts in $HOME/gp_email_contacts.yaml if only that file is found", func() {
-				w.Write(contactsFileContents)
-				w.Close()
-
-				testExecutor.ErrorOnExecNum = 2 // Shouldn't hit this case, as it shouldn't be executed a second time
-				testExecutor.LocalError = errors.Errorf("exit status 2")
-
-				utils.EmailReport(testCluster, testFPInfo.Timestamp, "report_file", "gpbackup")
-				Expect(testExecutor.NumExecutions).To(Equal(2))
-				Expect(testExecutor.LocalCommands).To(Equal([]string{expectedHomeCmd, expectedMessage}))
-				Expect(logfile).To(gbytes.Say("Sending email report to the following addresses: contact1@example.com"))
-			})
-			It("sends an email to contacts in $GPHOME/bin/gp_email_contacts.yaml if only that file is found", func() {
-				w.Write(contactsFileContents)
-				w.Close()
-
-				testExecutor.ErrorOnExecNum = 1
-				testExecutor.LocalError = errors.Errorf("exit status 2")
-
-				utils.EmailReport(testCluster, testFPInfo.Timestamp, "report_file", "gpbackup")
-				Expect(testExecutor.NumExecutions).To(Equal(3))
-				Expect(testExecutor.LocalCommands).To(Equal([]string{expectedHomeCmd, expectedGpHomeCmd, expectedMessage}))
-				Expect(logfile).To(gbytes.Say("Sending email report to the following addresses: contact1@example.com"))
-			})
-			It("sends an email to contacts in $HOME/gp_email_contacts.yaml if a file exists in both $HOME and $GPHOME/bin", func() {
-				w.Write(contactsFileContents)
-				w.Close()
-
-				utils.EmailReport(testCluster, testFPInfo.Timestamp, "report_file", "gpbackup")
-				Expect(testExecutor.NumExecutions).To(Equal(2))
-				Expect(testExecutor.LocalCommands).To(Equal([]string{expectedHomeCmd, expectedMessage}))
-				Expect(logfile).To(gbytes.Say("Sending email report to the following addresses: contact1@example.com"))
-			})
-		})
-	})
 })
@@ -0,0 +1,142 @@
+package utils
+
+/*
+ * This file contains structs and functions for limiting the rate at which
+ * data is read from or written to backup files.
+ */
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+)
+
+var maxBandwidth int64
+
+// A maximum bandwidth of 0 means that data is not throttled
+func InitializeMaxBandwidth(bytesPerSecond int64) {
+	maxBandwidth = bytesPerSecond
+}
+
+func GetMaxBandwidth() int64 {
+	return maxBandwidth
+}
+
+/*
+ * A Throttle limits the total rate of the bytes passing through all of the
+ * readers and writers wrapped with it.  Time spent not transferring data,
+ * such as while waiting for the next table, is made up for with a burst of
+ * at most one second's worth of bytes.
+ */
+type Throttle struct {
+	bytesPerSecond int64
+	bytes          int64
+	start          time.Time
+	mutex          sync.Mutex
+}
+
+func NewThrottle(bytesPerSecond int64) *Throttle {
+	return &Throttle{bytesPerSecond: bytesPerSecond}
+}
+
+func (throttle *Throttle) durationOf(numBytes int64) time.Duration {
+	return time.Duration(float64(numBytes) / float64(throttle.bytesPerSecond) * float64(time.Second))
+}
+
+// Returns how long to wait before numBytes more bytes may be transferred
+func (throttle *Throttle) Delay(numBytes int) time.Duration {
+	throttle.mutex.Lock()
+	defer throttle.mutex.Unlock()
+	now := operating.System.Now()
+	expected := throttle.durationOf(throttle.bytes)
+	if throttle.start.IsZero() || now.Sub(throttle.start) > expected+time.Second {
+		throttle.start = now.Add(-expected)
+		if throttle.bytes > 0 {
+			throttle.start = throttle.start.Add(-time.Second)
+		}
+	}
+	throttle.bytes += int64(numBytes)
+	delay := throttle.start.Add(throttle.durationOf(throttle.bytes)).Sub(now)
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+/*
+ * Reads and writes are limited to a tenth of a second's worth of bytes, so
+ * that data is not sent in bursts when transferring large buffers.
+ */
+func (throttle *Throttle) chunkSize() int {
+	chunkSize := throttle.bytesPerSecond / 10
+	if chunkSize < 1 {
+		return 1
+	}
+	return int(chunkSize)
+}
+
+type throttledReader struct {
+	reader   io.Reader
+	throttle *Throttle
+}
+
+func (throttle *Throttle) Reader(reader io.Reader) io.Reader {
+	return &throttledReader{reader: reader, throttle: throttle}
+}
+
+func (reader *throttledReader) Read(p []byte) (int, error) {
+	if chunkSize := reader.throttle.chunkSize(); len(p) > chunkSize {
+		p = p[:chunkSize]
+	}
+	n, err := reader.reader.Read(p)
+	time.Sleep(reader.throttle.Delay(n))
+	return n, err
+}
+
+type throttledWriter struct {
+	writer   io.Writer
+	throttle *Throttle
+}
+
+func (throttle *Throttle) Writer(writer io.Writer) io.Writer {
+	return &throttledWriter{writer: writer, throttle: throttle}
+}
+
+func (writer *throttledWriter) Write(p []byte) (int, error) {
+	chunkSize := writer.throttle.chunkSize()
+	written := 0
+	for written < len(p) {
+		end := written + chunkSize
+		if end > len(p) {
+			end = len(p)
+		}
+		time.Sleep(writer.throttle.Delay(end - written))
+		n, err := writer.writer.Write(p[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Each of the numShares processes that may run at once on a segment is given an equal share of the maximum bandwidth
+func GetMaxBandwidthShare(numShares int) int64 {
+	bytesPerSecond := maxBandwidth / int64(numShares)
+	if bytesPerSecond < 1 {
+		bytesPerSecond = 1
+	}
+	return bytesPerSecond
+}
+
+/*
+ * Returns a command that copies stdin to stdout at no more than the maximum
+ * bandwidth, for use in COPY commands.  Each of the numConnections COPY
+ * commands that may run at once on a segment is given an equal share.
+ */
+func GetThrottleFilterCommand(numConnections int) string {
+	return fmt.Sprintf("%s/bin/gpbackup_helper --throttle --max-bandwidth %d", operating.System.Getenv("GPHOME"), GetMaxBandwidthShare(numConnections))
+}
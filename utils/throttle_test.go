@@ -0,0 +1,92 @@
+package utils_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"time"
+
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/throttle tests", func() {
+	Describe("Throttle.Delay", func() {
+		var now time.Time
+		BeforeEach(func() {
+			now = time.Date(2017, 1, 1, 1, 1, 1, 0, time.Local)
+			operating.System.Now = func() time.Time { return now }
+		})
+		AfterEach(func() {
+			operating.System.Now = time.Now
+		})
+		It("waits for as long as the bytes take to transfer at the maximum bandwidth", func() {
+			throttle := utils.NewThrottle(1000)
+			Expect(throttle.Delay(500)).To(Equal(500 * time.Millisecond))
+			now = now.Add(200 * time.Millisecond)
+			Expect(throttle.Delay(500)).To(Equal(800 * time.Millisecond))
+		})
+		It("does not wait if the bytes were transferred more slowly than the maximum bandwidth", func() {
+			throttle := utils.NewThrottle(1000)
+			Expect(throttle.Delay(500)).To(Equal(500 * time.Millisecond))
+			now = now.Add(time.Second)
+			Expect(throttle.Delay(400)).To(Equal(time.Duration(0)))
+		})
+		It("allows a burst of at most one second's worth of bytes after a pause", func() {
+			throttle := utils.NewThrottle(1000)
+			Expect(throttle.Delay(1000)).To(Equal(time.Second))
+			now = now.Add(time.Minute)
+			Expect(throttle.Delay(1000)).To(Equal(time.Duration(0)))
+			Expect(throttle.Delay(1000)).To(Equal(time.Second))
+		})
+	})
+	Describe("Throttle.Writer", func() {
+		It("writes all of the data at no more than the maximum bandwidth", func() {
+			var buffer bytes.Buffer
+			data := bytes.Repeat([]byte("a"), 2000)
+			start := time.Now()
+			n, err := utils.NewThrottle(10000).Writer(&buffer).Write(data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(2000))
+			Expect(buffer.Bytes()).To(Equal(data))
+			Expect(time.Since(start)).To(BeNumerically(">=", 190*time.Millisecond))
+		})
+	})
+	Describe("Throttle.Reader", func() {
+		It("reads all of the data at no more than the maximum bandwidth", func() {
+			data := bytes.Repeat([]byte("a"), 2000)
+			start := time.Now()
+			contents, err := ioutil.ReadAll(utils.NewThrottle(10000).Reader(bytes.NewReader(data)))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(contents).To(Equal(data))
+			Expect(time.Since(start)).To(BeNumerically(">=", 190*time.Millisecond))
+		})
+	})
+	Describe("GetThrottleFilterCommand", func() {
+		BeforeEach(func() {
+			operating.System.Getenv = func(key string) string { return "/usr/local/gpdb" }
+			utils.InitializeMaxBandwidth(1000000)
+		})
+		AfterEach(func() {
+			utils.InitializeMaxBandwidth(0)
+		})
+		It("divides the maximum bandwidth among the connections", func() {
+			Expect(utils.GetThrottleFilterCommand(4)).To(Equal("/usr/local/gpdb/bin/gpbackup_helper --throttle --max-bandwidth 250000"))
+		})
+	})
+	Describe("GetMaxBandwidthShare", func() {
+		AfterEach(func() {
+			utils.InitializeMaxBandwidth(0)
+		})
+		It("divides the maximum bandwidth into equal shares", func() {
+			utils.InitializeMaxBandwidth(1000000)
+			Expect(utils.GetMaxBandwidthShare(3)).To(Equal(int64(333333)))
+		})
+		It("gives each share at least one byte per second", func() {
+			utils.InitializeMaxBandwidth(2)
+			Expect(utils.GetMaxBandwidthShare(4)).To(Equal(int64(1)))
+		})
+	})
+})
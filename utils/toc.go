@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"regexp"
+	"strings"
 
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
 	"github.com/greenplum-db/gp-common-go-libs/iohelper"
@@ -22,7 +23,8 @@ type TOC struct {
 }
 
 type SegmentTOC struct {
-	DataEntries map[uint]SegmentDataEntry
+	DataEntries      map[uint]SegmentDataEntry
+	DataFileChecksum string
 }
 
 type MetadataEntry struct {
@@ -34,22 +36,48 @@ type MetadataEntry struct {
 	EndByte         uint64
 }
 
+/*
+ * Shard is the index of the segment data file holding the table's data in a
+ * single data file backup, which is always 0 unless the backup was taken with
+ * multiple jobs.
+ *
+ * ElapsedSeconds is the time taken to copy the table's data, CompressedBytes
+ * the size of the data as written to the data files summed across segments,
+ * and SegmentSkew the ratio of the bytes on the largest segment to the average
+ * bytes per segment, so a table spread evenly has a skew of 1.  They are 0 if
+ * they could not be recorded, as for backups taken before they were added.
+ *
+ * The tables of a single data file backup are compressed together, so the
+ * compressed size of one table is not known.  UncompressedBytes is recorded for
+ * them instead of CompressedBytes, and SegmentSkew is computed from it.
+ */
 type MasterDataEntry struct {
-	Schema          string
-	Name            string
-	Oid             uint32
-	AttributeString string
-	RowsCopied      int64
-	PartitionRoot   string
+	Schema            string
+	Name              string
+	Oid               uint32
+	AttributeString   string
+	RowsCopied        int64
+	PartitionRoot     string
+	Shard             int
+	ElapsedSeconds    float64
+	CompressedBytes   uint64
+	UncompressedBytes uint64
+	SegmentSkew       float64
 }
 
+/*
+ * StartByte and EndByte are offsets into the uncompressed data stream, and
+ * Checksum is the SHA-256 checksum of the bytes in that range.
+ */
 type SegmentDataEntry struct {
 	StartByte uint64
 	EndByte   uint64
+	Checksum  string
 }
 
 type IncrementalEntries struct {
-	AO map[string]AOEntry
+	AO   map[string]AOEntry
+	Heap map[string]HeapEntry
 }
 
 type AOEntry struct {
@@ -57,9 +85,31 @@ type AOEntry struct {
 	LastDDLTimestamp string
 }
 
+/*
+ * ChangedDuringBackup is set if the table was modified while its data was
+ * being backed up, so the next incremental backup must include it regardless.
+ */
+type HeapEntry struct {
+	LastDDLTimestamp    string
+	Segments            map[int]HeapSegmentEntry
+	ChangedDuringBackup bool `yaml:",omitempty"`
+}
+
+/*
+ * TuplesModified is the cumulative number of tuples inserted, updated, and
+ * deleted according to the statistics collector on the segment, which is only
+ * comparable between backups if StatsResetTimestamp has not changed.
+ */
+type HeapSegmentEntry struct {
+	Relfilenode         uint32
+	Size                int64
+	TuplesModified      int64
+	StatsResetTimestamp string
+}
+
 func NewTOC(filename string) *TOC {
 	toc := &TOC{}
-	contents, err := operating.System.ReadFile(filename)
+	contents, err := ReadMetadataFile(filename)
 	gplog.FatalOnError(err)
 	err = yaml.Unmarshal(contents, toc)
 	gplog.FatalOnError(err)
@@ -76,10 +126,12 @@ func NewSegmentTOC(filename string) *SegmentTOC {
 }
 
 func (toc *TOC) WriteToFileAndMakeReadOnly(filename string) {
-	tocFile := iohelper.MustOpenFileForWriting(filename)
+	tocFile := MustOpenMetadataFileForWriting(filename)
 	tocContents, err := yaml.Marshal(toc)
 	gplog.FatalOnError(err)
 	MustPrintBytes(tocFile, tocContents)
+	err = tocFile.Close()
+	gplog.FatalOnError(err)
 	err = operating.System.Chmod(filename, 0444)
 	gplog.FatalOnError(err)
 }
@@ -136,9 +188,10 @@ func (toc *TOC) GetSQLStatementForObjectTypes(section string, metadataFile io.Re
 	entries := *toc.metadataEntryMap[section]
 
 	objectSet, schemaSet, relationSet := constructFilterSets(includeObjectTypes, excludeObjectTypes, includeSchemas, excludeSchemas, includeRelations, excludeRelations)
+	referenceTypes := toc.getReferenceObjectTypes()
 	statements := make([]StatementWithType, 0)
 	for _, entry := range entries {
-		if shouldIncludeStatement(entry, objectSet, schemaSet, relationSet) {
+		if shouldIncludeStatement(entry, objectSet, schemaSet, relationSet, referenceTypes) {
 			contents := make([]byte, entry.EndByte-entry.StartByte)
 			_, err := metadataFile.ReadAt(contents, int64(entry.StartByte))
 			gplog.FatalOnError(err)
@@ -148,6 +201,21 @@ func (toc *TOC) GetSQLStatementForObjectTypes(section string, metadataFile io.Re
 	return statements
 }
 
+// Returns the distinct object types of the entries in the given sections, in the order they first appear
+func (toc *TOC) GetObjectTypes(sections ...string) []string {
+	objectTypes := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, section := range sections {
+		for _, entry := range *toc.metadataEntryMap[section] {
+			if !seen[entry.ObjectType] {
+				seen[entry.ObjectType] = true
+				objectTypes = append(objectTypes, entry.ObjectType)
+			}
+		}
+	}
+	return objectTypes
+}
+
 func constructFilterSets(includeObjectTypes []string, excludeObjectTypes []string, includeSchemas []string, excludeSchemas []string, includeRelations []string, excludeRelations []string) (*FilterSet, *FilterSet, *FilterSet) {
 	var objectSet, schemaSet, relationSet *FilterSet
 	if len(includeObjectTypes) > 0 {
@@ -168,8 +236,32 @@ func constructFilterSets(includeObjectTypes []string, excludeObjectTypes []strin
 	return objectSet, schemaSet, relationSet
 }
 
-func shouldIncludeStatement(entry MetadataEntry, objectSet *FilterSet, schemaSet *FilterSet, relationSet *FilterSet) bool {
+// Maps the FQN of each pre-data object that others can depend on to its type, so that dependent
+// objects such as indexes and constraints can be skipped when the object they reference is not restored
+func (toc *TOC) getReferenceObjectTypes() map[string]string {
+	referenceTypes := make(map[string]string)
+	for _, entry := range *toc.metadataEntryMap["predata"] {
+		switch entry.ObjectType {
+		case "TABLE", "FOREIGN TABLE", "VIEW", "DOMAIN":
+			referenceTypes[MakeFQN(entry.Schema, entry.Name)] = entry.ObjectType
+		}
+	}
+	return referenceTypes
+}
+
+func shouldIncludeStatement(entry MetadataEntry, objectSet *FilterSet, schemaSet *FilterSet, relationSet *FilterSet, referenceTypes map[string]string) bool {
 	shouldIncludeObject := objectSet.MatchesFilter(entry.ObjectType)
+	if entry.ObjectType == "SEQUENCE OWNER" {
+		// Sequence owners are included along with both the sequence and its owning table, unless excluded themselves
+		shouldIncludeObject = (shouldIncludeObject || !objectSet.IsExclude) && objectSet.MatchesFilter("SEQUENCE") && objectSet.MatchesFilter("TABLE")
+	} else if entry.ReferenceObject != "" {
+		// Objects that are not in the table of contents themselves, such as leaf partitions, are tables
+		referenceType, ok := referenceTypes[entry.ReferenceObject]
+		if !ok {
+			referenceType = "TABLE"
+		}
+		shouldIncludeObject = shouldIncludeObject && objectSet.MatchesFilter(referenceType)
+	}
 	shouldIncludeSchema := schemaSet.MatchesFilter(entry.Schema)
 	relationFQN := MakeFQN(entry.Schema, entry.Name)
 	shouldIncludeRelation := (relationSet.IsExclude && entry.ObjectType != "TABLE" && entry.ObjectType != "VIEW" && entry.ObjectType != "SEQUENCE" && entry.ReferenceObject == "") ||
@@ -243,6 +335,132 @@ func SubstituteRedirectDatabaseInStatements(statements []StatementWithType, oldQ
 	return statements
 }
 
+/*
+ * Each object is moved by replacing its schema wherever it qualifies a name in
+ * the statements for the object, which also redirects references to other
+ * objects in the same schema, such as the table of an index or the sequence
+ * used by a column default.  String literals, dollar-quoted strings such as
+ * function bodies, and comments are left unchanged, except for literals cast to
+ * an object identifier type such as regclass, which hold object names.
+ */
+func SubstituteRedirectSchemaInStatements(statements []StatementWithType, newQuotedSchema string) []StatementWithType {
+	patterns := make(map[string]*regexp.Regexp, 0)
+	replaceQualifiers := func(text string, oldQuotedSchema string, newQuotedSchema string) string {
+		pattern, ok := patterns[oldQuotedSchema]
+		if !ok {
+			pattern = regexp.MustCompile(fmt.Sprintf(`(^|[^\w$".])%s\.`, regexp.QuoteMeta(oldQuotedSchema)))
+			patterns[oldQuotedSchema] = pattern
+		}
+		return pattern.ReplaceAllString(text, fmt.Sprintf("${1}%s.", strings.Replace(newQuotedSchema, "$", "$$", -1)))
+	}
+	for i := range statements {
+		oldQuotedSchema := statements[i].Schema
+		if oldQuotedSchema == "" || statements[i].ObjectType == "SCHEMA" {
+			continue
+		}
+		statement := statements[i].Statement
+		var newStatement strings.Builder
+		for pos := 0; pos < len(statement); {
+			start, end := nextQuotedSection(statement, pos)
+			if start == -1 {
+				start, end = len(statement), len(statement)
+			}
+			newStatement.WriteString(replaceQualifiers(statement[pos:start], oldQuotedSchema, newQuotedSchema))
+			section := statement[start:end]
+			if strings.HasPrefix(section, "'") && strings.HasPrefix(statement[end:], "::reg") {
+				section = replaceQualifiers(section, strings.Replace(oldQuotedSchema, "'", "''", -1), strings.Replace(newQuotedSchema, "'", "''", -1))
+			}
+			newStatement.WriteString(section)
+			pos = end
+		}
+		statements[i].Statement = newStatement.String()
+		statements[i].ReferenceObject = replaceQualifiers(statements[i].ReferenceObject, oldQuotedSchema, newQuotedSchema)
+		statements[i].Schema = newQuotedSchema
+	}
+	return statements
+}
+
+var dollarQuoteTag = regexp.MustCompile(`^\$([A-Za-z_]\w*)?\$`)
+
+/*
+ * Returns the start and end of the first string literal, dollar-quoted string
+ * or comment in the statement at or after from, or -1 and -1 if there is none.
+ * Quoted identifiers are skipped, so that quotes within them are ignored.  A
+ * section that is not terminated ends with the statement.
+ */
+func nextQuotedSection(statement string, from int) (int, int) {
+	for i := from; i < len(statement); i++ {
+		switch {
+		case statement[i] == '"':
+			closing := strings.IndexByte(statement[i+1:], '"')
+			if closing == -1 {
+				return -1, -1
+			}
+			i += closing + 1
+		case statement[i] == '\'':
+			return i, stringLiteralEnd(statement, i)
+		case strings.HasPrefix(statement[i:], "--"):
+			newline := strings.IndexByte(statement[i:], '\n')
+			if newline == -1 {
+				return i, len(statement)
+			}
+			return i, i + newline
+		case strings.HasPrefix(statement[i:], "/*"):
+			return i, blockCommentEnd(statement, i)
+		case statement[i] == '$' && (i == 0 || !isIdentifierByte(statement[i-1])):
+			tag := dollarQuoteTag.FindString(statement[i:])
+			if tag == "" {
+				continue
+			}
+			closing := strings.Index(statement[i+len(tag):], tag)
+			if closing == -1 {
+				return i, len(statement)
+			}
+			return i, i + len(tag) + closing + len(tag)
+		}
+	}
+	return -1, -1
+}
+
+// Backslashes only escape characters in literals with an E prefix
+func stringLiteralEnd(statement string, start int) int {
+	hasEscapes := start > 0 && (statement[start-1] == 'E' || statement[start-1] == 'e') && (start < 2 || !isIdentifierByte(statement[start-2]))
+	for i := start + 1; i < len(statement); i++ {
+		if hasEscapes && statement[i] == '\\' {
+			i++
+		} else if statement[i] == '\'' {
+			if i+1 < len(statement) && statement[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(statement)
+}
+
+// Block comments may be nested
+func blockCommentEnd(statement string, start int) int {
+	depth := 0
+	for i := start; i < len(statement)-1; i++ {
+		if strings.HasPrefix(statement[i:], "/*") {
+			depth++
+			i++
+		} else if strings.HasPrefix(statement[i:], "*/") {
+			depth--
+			i++
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(statement)
+}
+
+func isIdentifierByte(b byte) bool {
+	return b == '_' || b == '$' || b >= 0x80 || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9')
+}
+
 func RemoveActiveRole(activeUser string, statements []StatementWithType) []StatementWithType {
 	newStatements := make([]StatementWithType, 0)
 	for _, statement := range statements {
@@ -277,11 +495,36 @@ func (toc *TOC) AddMetadataEntry(section string, entry MetadataEntry, start, end
 	*toc.metadataEntryMap[section] = append(*toc.metadataEntryMap[section], entry)
 }
 
-func (toc *TOC) AddMasterDataEntry(schema string, name string, oid uint32, attributeString string, rowsCopied int64, PartitionRoot string) {
-	toc.DataEntries = append(toc.DataEntries, MasterDataEntry{schema, name, oid, attributeString, rowsCopied, PartitionRoot})
+func (toc *TOC) AddMasterDataEntry(schema string, name string, oid uint32, attributeString string, rowsCopied int64, PartitionRoot string, shard int) {
+	toc.DataEntries = append(toc.DataEntries, MasterDataEntry{Schema: schema, Name: name, Oid: oid, AttributeString: attributeString,
+		RowsCopied: rowsCopied, PartitionRoot: PartitionRoot, Shard: shard})
+}
+
+/*
+ * Returns the data entries for each shard, indexed by shard, keeping the order
+ * of the entries within each shard.  Shards with no entries are empty.
+ */
+func GetDataEntriesByShard(dataEntries []MasterDataEntry) [][]MasterDataEntry {
+	shardEntries := make([][]MasterDataEntry, 0)
+	for _, entry := range dataEntries {
+		for len(shardEntries) <= entry.Shard {
+			shardEntries = append(shardEntries, make([]MasterDataEntry, 0))
+		}
+		shardEntries[entry.Shard] = append(shardEntries[entry.Shard], entry)
+	}
+	return shardEntries
+}
+
+// Every single data file backup has at least one data file on each segment
+func GetNumDataFileShards(dataEntries []MasterDataEntry) int {
+	numShards := len(GetDataEntriesByShard(dataEntries))
+	if numShards == 0 {
+		return 1
+	}
+	return numShards
 }
 
-func (toc *SegmentTOC) AddSegmentDataEntry(oid uint, startByte uint64, endByte uint64) {
+func (toc *SegmentTOC) AddSegmentDataEntry(oid uint, startByte uint64, endByte uint64, checksum string) {
 	// We use uint for oid since the flags package does not have a uint32 flag
-	toc.DataEntries[oid] = SegmentDataEntry{startByte, endByte}
+	toc.DataEntries[oid] = SegmentDataEntry{startByte, endByte, checksum}
 }
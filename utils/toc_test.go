@@ -70,7 +70,7 @@ var _ = Describe("utils/toc tests", func() {
 		It("does not return a statement type listed in the exclude list", func() {
 			statements := toc.GetSQLStatementForObjectTypes("predata", metadataFile, noInObj, []string{"TABLE"}, noInSchema, noExSchema, noInRelation, noExRelation)
 
-			Expect(statements).To(Equal([]utils.StatementWithType{view, sequence, index}))
+			Expect(statements).To(Equal([]utils.StatementWithType{view, sequence}))
 		})
 		It("returns empty statement when no object types are found", func() {
 			statements := toc.GetSQLStatementForObjectTypes("predata", metadataFile, []string{"FUNCTION"}, noExObj, noInSchema, noExSchema, noInRelation, noExRelation)
@@ -162,6 +162,40 @@ var _ = Describe("utils/toc tests", func() {
 
 				Expect(statements).To(Equal([]utils.StatementWithType{capsTable, table2, view, sequence, index, sequenceTable, sequenceOwner}))
 			})
+			It("returns sequence owner statement when owning table and sequence types are both included", func() {
+				statements := toc.GetSQLStatementForObjectTypes("predata", metadataFile, []string{"TABLE", "SEQUENCE"}, noExObj, noInSchema, noExSchema, noInRelation, noExRelation)
+
+				Expect(statements).To(Equal([]utils.StatementWithType{table1, capsTable, table2, sequence, sequenceTable, sequenceOwner}))
+			})
+			It("does not return sequence owner or other statements with a reference object when the table type is excluded", func() {
+				statements := toc.GetSQLStatementForObjectTypes("predata", metadataFile, noInObj, []string{"TABLE"}, noInSchema, noExSchema, noInRelation, noExRelation)
+
+				Expect(statements).To(Equal([]utils.StatementWithType{view, sequence}))
+			})
+			It("does not return sequence owner statement when the sequence owner type is excluded", func() {
+				statements := toc.GetSQLStatementForObjectTypes("predata", metadataFile, noInObj, []string{"SEQUENCE OWNER"}, noInSchema, noExSchema, noInRelation, noExRelation)
+
+				Expect(statements).To(Equal([]utils.StatementWithType{table1, capsTable, table2, view, sequence, index, sequenceTable}))
+			})
+			It("returns statements of an included type with reference object matching an included table", func() {
+				statements := toc.GetSQLStatementForObjectTypes("predata", metadataFile, []string{"TABLE", "INDEX"}, noExObj, noInSchema, noExSchema, []string{"schema2.table2"}, noExRelation)
+
+				Expect(statements).To(Equal([]utils.StatementWithType{table2, index}))
+			})
+			It("does not return statements with a reference object of a type that is not included", func() {
+				statements := toc.GetSQLStatementForObjectTypes("predata", metadataFile, []string{"INDEX"}, noExObj, noInSchema, noExSchema, noInRelation, noExRelation)
+
+				Expect(statements).To(Equal([]utils.StatementWithType{}))
+			})
+			It("returns postdata statements with a reference object of an included pre-data type", func() {
+				trigger := utils.StatementWithType{Schema: "schema2", Name: "sometrigger", ObjectType: "TRIGGER", Statement: "CREATE TRIGGER sometrigger AFTER INSERT ON schema2.table2 FOR EACH ROW EXECUTE PROCEDURE somefunc()", ReferenceObject: "schema2.table2"}
+				toc.AddMetadataEntry("postdata", utils.MetadataEntry{Schema: "schema2", Name: "sometrigger", ObjectType: "TRIGGER", ReferenceObject: "schema2.table2"}, 0, uint64(len(trigger.Statement)))
+				postdataFile := bytes.NewReader([]byte(trigger.Statement))
+
+				Expect(toc.GetSQLStatementForObjectTypes("postdata", postdataFile, []string{"TRIGGER"}, noExObj, noInSchema, noExSchema, noInRelation, noExRelation)).To(Equal([]utils.StatementWithType{}))
+				Expect(toc.GetSQLStatementForObjectTypes("postdata", postdataFile, []string{"TABLE", "TRIGGER"}, noExObj, noInSchema, noExSchema, noInRelation, noExRelation)).To(Equal([]utils.StatementWithType{trigger}))
+				Expect(toc.GetSQLStatementForObjectTypes("postdata", postdataFile, noInObj, []string{"TABLE"}, noInSchema, noExSchema, noInRelation, noExRelation)).To(Equal([]utils.StatementWithType{}))
+			})
 			It("returns no statements for any object type with reference object matching an excluded table", func() {
 				statements := toc.GetSQLStatementForObjectTypes("predata", metadataFile, noInObj, noExObj, noInSchema, noExSchema, noInRelation, []string{"schema2.table2"})
 
@@ -169,13 +203,24 @@ var _ = Describe("utils/toc tests", func() {
 			})
 		})
 	})
+	Describe("GetObjectTypes", func() {
+		It("returns the distinct object types of the given sections in the order they appear", func() {
+			toc.AddMetadataEntry("global", utils.MetadataEntry{Name: "role1", ObjectType: "ROLE"}, 0, 0)
+			toc.AddMetadataEntry("predata", utils.MetadataEntry{Schema: "schema", Name: "view1", ObjectType: "VIEW"}, 0, 0)
+			toc.AddMetadataEntry("predata", utils.MetadataEntry{Schema: "schema", Name: "table1", ObjectType: "TABLE"}, 0, 0)
+			toc.AddMetadataEntry("predata", utils.MetadataEntry{Schema: "schema", Name: "view2", ObjectType: "VIEW"}, 0, 0)
+			toc.AddMetadataEntry("postdata", utils.MetadataEntry{Schema: "schema", Name: "index1", ObjectType: "INDEX", ReferenceObject: "schema.table1"}, 0, 0)
+
+			Expect(toc.GetObjectTypes("predata", "postdata")).To(Equal([]string{"VIEW", "TABLE", "INDEX"}))
+		})
+	})
 	Describe("GetDataEntriesMatching", func() {
 		BeforeEach(func() {
-			toc.AddMasterDataEntry("schema1", "table1", 1, "(i)", 0, "")
-			toc.AddMasterDataEntry("schema2", "table2", 1, "(i)", 0, "")
-			toc.AddMasterDataEntry("schema3", "table3", 1, "(i)", 0, "")
-			toc.AddMasterDataEntry("schema3", "table3_partition1", 1, "(i)", 0, "table3")
-			toc.AddMasterDataEntry("schema3", "table3_partition2", 1, "(i)", 0, "table3")
+			toc.AddMasterDataEntry("schema1", "table1", 1, "(i)", 0, "", 0)
+			toc.AddMasterDataEntry("schema2", "table2", 1, "(i)", 0, "", 0)
+			toc.AddMasterDataEntry("schema3", "table3", 1, "(i)", 0, "", 0)
+			toc.AddMasterDataEntry("schema3", "table3_partition1", 1, "(i)", 0, "table3", 0)
+			toc.AddMasterDataEntry("schema3", "table3_partition2", 1, "(i)", 0, "table3", 0)
 		})
 		Context("Non-empty restore plan", func() {
 			restorePlanTableFQNs := []string{"schema1.table1", "schema2.table2", "schema3.table3", "schema3.table3_partition1", "schema3.table3_partition2"}
@@ -360,6 +405,42 @@ COMMENT ON DATABASE "db-special-chär$" IS 'this is a database comment';`}
 `))
 		})
 	})
+	Describe("SubstituteRedirectSchemaInStatements", func() {
+		It("substitutes the schema of an object wherever it qualifies a name", func() {
+			table := utils.StatementWithType{Schema: "prod", Name: "orders", ObjectType: "TABLE", Statement: "CREATE TABLE prod.orders (\n\ti integer DEFAULT nextval('prod.orders_seq'::regclass)\n) DISTRIBUTED BY (i);\n\nALTER TABLE prod.orders OWNER TO testrole;"}
+			statements := utils.SubstituteRedirectSchemaInStatements([]utils.StatementWithType{table}, "restore_check")
+			Expect(statements[0].Schema).To(Equal("restore_check"))
+			Expect(statements[0].Statement).To(Equal("CREATE TABLE restore_check.orders (\n\ti integer DEFAULT nextval('restore_check.orders_seq'::regclass)\n) DISTRIBUTED BY (i);\n\nALTER TABLE restore_check.orders OWNER TO testrole;"))
+		})
+		It("substitutes the schema of the object an index refers to", func() {
+			index := utils.StatementWithType{Schema: "prod", Name: "orders_idx", ObjectType: "INDEX", ReferenceObject: "prod.orders", Statement: "CREATE INDEX orders_idx ON prod.orders USING btree (i);"}
+			statements := utils.SubstituteRedirectSchemaInStatements([]utils.StatementWithType{index}, `"Restore$1"`)
+			Expect(statements[0].ReferenceObject).To(Equal(`"Restore$1".orders`))
+			Expect(statements[0].Statement).To(Equal(`CREATE INDEX orders_idx ON "Restore$1".orders USING btree (i);`))
+		})
+		It("does not substitute a schema whose name ends with the name of the schema", func() {
+			table := utils.StatementWithType{Schema: "prod", Name: "orders", ObjectType: "TABLE", Statement: "CREATE TABLE prod.orders (i integer) INHERITS (preprod.orders, prod.parent);"}
+			statements := utils.SubstituteRedirectSchemaInStatements([]utils.StatementWithType{table}, "restore_check")
+			Expect(statements[0].Statement).To(Equal("CREATE TABLE restore_check.orders (i integer) INHERITS (preprod.orders, restore_check.parent);"))
+		})
+		It("does not substitute the schema in a function body or the text of a comment", func() {
+			function := utils.StatementWithType{Schema: "prod", Name: "total", ObjectType: "FUNCTION", Statement: "CREATE FUNCTION prod.total() RETURNS bigint AS $_$SELECT count(*) FROM prod.orders$_$\nLANGUAGE sql;\n\nCOMMENT ON FUNCTION prod.total() IS 'Counts the rows of prod.orders';"}
+			statements := utils.SubstituteRedirectSchemaInStatements([]utils.StatementWithType{function}, "restore_check")
+			Expect(statements[0].Statement).To(Equal("CREATE FUNCTION restore_check.total() RETURNS bigint AS $_$SELECT count(*) FROM prod.orders$_$\nLANGUAGE sql;\n\nCOMMENT ON FUNCTION restore_check.total() IS 'Counts the rows of prod.orders';"))
+		})
+		It("does not substitute the schema in SQL comments or escaped literals, and ignores quotes in quoted identifiers", func() {
+			view := utils.StatementWithType{Schema: "prod", Name: "v", ObjectType: "VIEW", Statement: `CREATE VIEW prod.v AS SELECT 'it''s prod.x' AS a, E'\' prod.y' AS b, "col'" /* prod.z /* nested */ prod.z */ FROM prod.t -- prod.u
+WHERE "q$1".c = $1;`}
+			statements := utils.SubstituteRedirectSchemaInStatements([]utils.StatementWithType{view}, "restore_check")
+			Expect(statements[0].Statement).To(Equal(`CREATE VIEW restore_check.v AS SELECT 'it''s prod.x' AS a, E'\' prod.y' AS b, "col'" /* prod.z /* nested */ prod.z */ FROM restore_check.t -- prod.u
+WHERE "q$1".c = $1;`))
+		})
+		It("does not substitute schema statements", func() {
+			schema := utils.StatementWithType{Schema: "prod", Name: "prod", ObjectType: "SCHEMA", Statement: "CREATE SCHEMA prod;"}
+			statements := utils.SubstituteRedirectSchemaInStatements([]utils.StatementWithType{schema}, "restore_check")
+			Expect(statements[0]).To(Equal(schema))
+		})
+	})
 	Describe("RemoveActiveRoles", func() {
 		user1 := utils.StatementWithType{Name: "user1", ObjectType: "ROLE", Statement: "CREATE ROLE user1 SUPERUSER;\n"}
 		user2 := utils.StatementWithType{Name: "user2", ObjectType: "ROLE", Statement: "CREATE ROLE user2;\n"}
@@ -374,24 +455,49 @@ COMMENT ON DATABASE "db-special-chär$" IS 'this is a database comment';`}
 			Expect(resultStatements).To(Equal([]utils.StatementWithType{user1, user2}))
 		})
 	})
+	Describe("GetDataEntriesByShard", func() {
+		It("groups data entries by shard, keeping their order", func() {
+			toc.AddMasterDataEntry("schema0", "name0", 1, "attribute0", 1, "", 0)
+			toc.AddMasterDataEntry("schema1", "name1", 2, "attribute0", 1, "", 2)
+			toc.AddMasterDataEntry("schema2", "name2", 3, "attribute0", 1, "", 0)
+			shardEntries := utils.GetDataEntriesByShard(toc.DataEntries)
+			Expect(shardEntries).To(HaveLen(3))
+			Expect(shardEntries[0]).To(Equal([]utils.MasterDataEntry{toc.DataEntries[0], toc.DataEntries[2]}))
+			Expect(shardEntries[1]).To(BeEmpty())
+			Expect(shardEntries[2]).To(Equal([]utils.MasterDataEntry{toc.DataEntries[1]}))
+		})
+		It("returns no shards if there are no data entries", func() {
+			Expect(utils.GetDataEntriesByShard(toc.DataEntries)).To(BeEmpty())
+		})
+	})
+	Describe("GetNumDataFileShards", func() {
+		It("returns the number of shards used by the data entries", func() {
+			toc.AddMasterDataEntry("schema0", "name0", 1, "attribute0", 1, "", 0)
+			toc.AddMasterDataEntry("schema1", "name1", 2, "attribute0", 1, "", 1)
+			Expect(utils.GetNumDataFileShards(toc.DataEntries)).To(Equal(2))
+		})
+		It("returns 1 if there are no data entries", func() {
+			Expect(utils.GetNumDataFileShards(toc.DataEntries)).To(Equal(1))
+		})
+	})
 	Describe("GetIncludedPartitionRoots", func() {
 		It("does not return anything if relations are not leaf partitions", func() {
-			toc.AddMasterDataEntry("schema0", "name0", 0, "attribute0", 1, "")
-			toc.AddMasterDataEntry("schema1", "name1", 1, "attribute0", 1, "")
+			toc.AddMasterDataEntry("schema0", "name0", 0, "attribute0", 1, "", 0)
+			toc.AddMasterDataEntry("schema1", "name1", 1, "attribute0", 1, "", 0)
 			roots := utils.GetIncludedPartitionRoots(toc.DataEntries, []string{"schema0.name0", "schema1.name1"})
 			Expect(roots).To(BeEmpty())
 		})
 		It("returns root parition of leaf partitions", func() {
-			toc.AddMasterDataEntry("schema0", "name0", 2, "attribute0", 1, "root0")
-			toc.AddMasterDataEntry("schema1", "name1", 3, "attribute0", 1, "root1")
+			toc.AddMasterDataEntry("schema0", "name0", 2, "attribute0", 1, "root0", 0)
+			toc.AddMasterDataEntry("schema1", "name1", 3, "attribute0", 1, "root1", 0)
 			roots := utils.GetIncludedPartitionRoots(toc.DataEntries, []string{"schema0.name0", "schema1.name1"})
 			Expect(roots).To(ConsistOf("schema0.root0", "schema1.root1"))
 		})
 		It("only returns root partitions of leaf partitions", func() {
-			toc.AddMasterDataEntry("schema0", "name0", 0, "attribute0", 1, "")
-			toc.AddMasterDataEntry("schema1", "name1", 1, "attribute0", 1, "")
-			toc.AddMasterDataEntry("schema2", "name2", 2, "attribute0", 1, "root2")
-			toc.AddMasterDataEntry("schema3", "name3", 3, "attribute0", 1, "root3")
+			toc.AddMasterDataEntry("schema0", "name0", 0, "attribute0", 1, "", 0)
+			toc.AddMasterDataEntry("schema1", "name1", 1, "attribute0", 1, "", 0)
+			toc.AddMasterDataEntry("schema2", "name2", 2, "attribute0", 1, "root2", 0)
+			toc.AddMasterDataEntry("schema3", "name3", 3, "attribute0", 1, "root3", 0)
 			roots := utils.GetIncludedPartitionRoots(toc.DataEntries, []string{"schema2.name2", "schema3.name3"})
 			Expect(roots).To(ConsistOf("schema2.root2", "schema3.root3"))
 		})
@@ -400,18 +506,18 @@ COMMENT ON DATABASE "db-special-chär$" IS 'this is a database comment';`}
 			Expect(roots).To(BeEmpty())
 		})
 		It("returns nothing if relation is not part of TOC data entries", func() {
-			toc.AddMasterDataEntry("schema0", "name0", 0, "attribute0", 1, "")
-			toc.AddMasterDataEntry("schema1", "name1", 1, "attribute0", 1, "")
-			toc.AddMasterDataEntry("schema2", "name2", 2, "attribute0", 1, "root2")
-			toc.AddMasterDataEntry("schema3", "name3", 3, "attribute0", 1, "root3")
+			toc.AddMasterDataEntry("schema0", "name0", 0, "attribute0", 1, "", 0)
+			toc.AddMasterDataEntry("schema1", "name1", 1, "attribute0", 1, "", 0)
+			toc.AddMasterDataEntry("schema2", "name2", 2, "attribute0", 1, "root2", 0)
+			toc.AddMasterDataEntry("schema3", "name3", 3, "attribute0", 1, "root3", 0)
 			roots := utils.GetIncludedPartitionRoots(toc.DataEntries, []string{"schema4.name4", "schema5.name5"})
 			Expect(roots).To(BeEmpty())
 		})
 		It("returns empty if no relations are passed in", func() {
-			toc.AddMasterDataEntry("schema0", "name0", 0, "attribute0", 1, "")
-			toc.AddMasterDataEntry("schema1", "name1", 1, "attribute0", 1, "")
-			toc.AddMasterDataEntry("schema2", "name2", 2, "attribute0", 1, "root2")
-			toc.AddMasterDataEntry("schema3", "name3", 3, "attribute0", 1, "root3")
+			toc.AddMasterDataEntry("schema0", "name0", 0, "attribute0", 1, "", 0)
+			toc.AddMasterDataEntry("schema1", "name1", 1, "attribute0", 1, "", 0)
+			toc.AddMasterDataEntry("schema2", "name2", 2, "attribute0", 1, "root2", 0)
+			toc.AddMasterDataEntry("schema3", "name3", 3, "attribute0", 1, "root3", 0)
 			roots := utils.GetIncludedPartitionRoots(toc.DataEntries, []string{})
 			Expect(roots).To(BeEmpty())
 		})